@@ -0,0 +1,297 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEmailVerificationGatesSensitiveActions(t *testing.T) {
+	suffix := uuid.NewString()[:8]
+	email := fmt.Sprintf("owner-%s@unverified.test", suffix)
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/tenants", "", map[string]any{
+		"name":        "Lavadero sin verificar",
+		"slug":        "unverified-" + suffix,
+		"owner_name":  "Owner unverified",
+		"owner_email": email,
+		"password":    "integration-password",
+	}, nil)
+	token := login(t, email, "integration-password")
+
+	connect := func() int {
+		resp, err := call(http.MethodGet, "/api/v1/mercadopago/connect", token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	if status := connect(); status != http.StatusForbidden {
+		t.Fatalf("connect before verifying: status %d, want 403", status)
+	}
+
+	// Resending voids the link sent at sign-up.
+	signup := mailToken(t, email, "/verify-email")
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/verify-email/resend", token, nil, nil)
+	resent := mailToken(t, email, "/verify-email")
+	if resent == signup {
+		t.Fatal("resend mailed the same token")
+	}
+	resp, err := call(http.MethodPost, "/api/v1/auth/verify-email", "", map[string]any{"token": signup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("verifying with the replaced token: status %d, want 400", resp.Status)
+	}
+
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/verify-email", "", map[string]any{"token": resent}, nil)
+	if status := connect(); status != http.StatusOK {
+		t.Errorf("connect after verifying: status %d, want 200", status)
+	}
+
+	resp, err = call(http.MethodPost, "/api/v1/auth/verify-email/resend", token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusConflict {
+		t.Errorf("resend once verified: status %d, want 409", resp.Status)
+	}
+}
+
+func TestPasswordResetIsSingleUse(t *testing.T) {
+	s := seedTenant(t, "reset")
+
+	var pair struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":    s.Email,
+		"password": s.Password,
+	}, &pair)
+
+	// Unknown emails get the same answer.
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/forgot-password", "", map[string]any{
+		"email": "nobody-" + uuid.NewString()[:8] + "@reset.test",
+	}, nil)
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/forgot-password", "", map[string]any{
+		"email": s.Email,
+	}, nil)
+	reset := map[string]any{
+		"token":    mailToken(t, s.Email, "/reset-password"),
+		"password": "brand-new-password",
+	}
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/reset-password", "", reset, nil)
+
+	resp, err := call(http.MethodPost, "/api/v1/auth/reset-password", "", reset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("second reset with the same token: status %d, want 400", resp.Status)
+	}
+
+	// Sessions opened before the reset are closed.
+	resp, err = call(http.MethodPost, "/api/v1/auth/refresh", "", map[string]any{"refresh_token": pair.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusUnauthorized {
+		t.Errorf("refresh after reset: status %d, want 401", resp.Status)
+	}
+
+	resp, err = call(http.MethodPost, "/api/v1/auth/login", "", map[string]any{"email": s.Email, "password": s.Password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d, want 401", resp.Status)
+	}
+	login(t, s.Email, "brand-new-password")
+}
+
+type sessionPair struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	SessionID    uuid.UUID `json:"session_id"`
+}
+
+func TestSessionsPerDeviceWithReuseDetection(t *testing.T) {
+	s := seedTenant(t, "sessions")
+
+	loginOn := func(device string) sessionPair {
+		var pair sessionPair
+		mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+			"email":       s.Email,
+			"password":    s.Password,
+			"device_name": device,
+		}, &pair)
+		return pair
+	}
+	refresh := func(token string) (sessionPair, int) {
+		resp, err := call(http.MethodPost, "/api/v1/auth/refresh", "", map[string]any{"refresh_token": token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pair sessionPair
+		if resp.Status == http.StatusOK {
+			if err := json.Unmarshal(resp.Data, &pair); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return pair, resp.Status
+	}
+
+	// Logging in on the tablet keeps the phone logged in.
+	phone := loginOn("Celular")
+	tablet := loginOn("Tablet mostrador")
+	phone2, status := refresh(phone.RefreshToken)
+	if status != http.StatusOK || phone2.SessionID != phone.SessionID {
+		t.Fatalf("phone refresh after tablet login: status %d, session %s", status, phone2.SessionID)
+	}
+
+	var sessions []struct {
+		ID         uuid.UUID `json:"id"`
+		DeviceName string    `json:"device_name"`
+		Current    bool      `json:"current"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/auth/sessions", tablet.AccessToken, nil, &sessions)
+	if len(sessions) != 3 { // seed login, phone and tablet
+		t.Fatalf("got %d sessions, want 3", len(sessions))
+	}
+	for _, sess := range sessions {
+		if sess.Current != (sess.ID == tablet.SessionID) {
+			t.Errorf("session %s (%s): current = %v", sess.ID, sess.DeviceName, sess.Current)
+		}
+		if sess.ID == tablet.SessionID && sess.DeviceName != "Tablet mostrador" {
+			t.Errorf("tablet session device %q", sess.DeviceName)
+		}
+	}
+
+	// Presenting an exchanged refresh token revokes the whole session.
+	if _, status := refresh(phone.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: status %d, want 401", status)
+	}
+	if _, status := refresh(phone2.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("latest refresh token of a revoked session: status %d, want 401", status)
+	}
+
+	// Remote logout of the tablet from another device.
+	mustCall(t, http.StatusNoContent, http.MethodDelete, "/api/v1/auth/sessions/"+tablet.SessionID.String(), s.Token, nil, nil)
+	if _, status := refresh(tablet.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh of a remotely closed session: status %d, want 401", status)
+	}
+
+	// Another tenant's owner cannot close this tenant's sessions.
+	other := seedTenant(t, "sessions-other")
+	counter := loginOn("Mostrador")
+	resp, err := call(http.MethodDelete, "/api/v1/auth/sessions/"+counter.SessionID.String(), other.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusNotFound {
+		t.Errorf("closing another user's session: status %d, want 404", resp.Status)
+	}
+
+	mustCall(t, http.StatusNoContent, http.MethodDelete, "/api/v1/auth/sessions", s.Token, nil, nil)
+	if _, status := refresh(counter.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh after logging out everywhere: status %d, want 401", status)
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/auth/sessions", s.Token, nil, &sessions)
+	if len(sessions) != 0 {
+		t.Errorf("got %d sessions after logging out everywhere, want 0", len(sessions))
+	}
+}
+
+func TestLoginWithAccountsInSeveralTenants(t *testing.T) {
+	home := seedTenant(t, "franchise-home")
+	second := seedTenant(t, "franchise-second")
+	other := seedTenant(t, "franchise-other")
+
+	// The owner of home also works at second (same password) and at other
+	// (a different one).
+	join := func(s *seededTenant, password string) {
+		mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/users/invitations", s.Token, map[string]any{
+			"email": home.Email,
+			"role":  "manager",
+		}, nil)
+		mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/invitations/accept", "", map[string]any{
+			"token":     mailToken(t, home.Email, "/accept-invitation"),
+			"full_name": "Franquiciado",
+			"password":  password,
+		}, nil)
+	}
+	join(second, home.Password)
+	join(other, "another-password")
+
+	type loginResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Tenant       *struct {
+			TenantSlug string `json:"tenant_slug"`
+			Role       string `json:"role"`
+		} `json:"tenant"`
+		Tenants []struct {
+			TenantSlug string `json:"tenant_slug"`
+		} `json:"tenants"`
+	}
+
+	// Without tenant_slug: the tenants the password opens, and no tokens.
+	var choice loginResponse
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":    home.Email,
+		"password": home.Password,
+	}, &choice)
+	if choice.AccessToken != "" || len(choice.Tenants) != 2 {
+		t.Fatalf("login without tenant_slug: token %q, %d tenants, want none and 2", choice.AccessToken, len(choice.Tenants))
+	}
+
+	var atSecond loginResponse
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":       home.Email,
+		"password":    home.Password,
+		"tenant_slug": second.Slug,
+	}, &atSecond)
+	if atSecond.Tenant == nil || atSecond.Tenant.TenantSlug != second.Slug || atSecond.Tenant.Role != "manager" {
+		t.Fatalf("login with tenant_slug: tenant %+v", atSecond.Tenant)
+	}
+
+	// A tenant whose account has another password stays out of reach.
+	resp, err := call(http.MethodPost, "/api/v1/auth/switch-tenant", atSecond.AccessToken, map[string]any{"tenant_slug": other.Slug})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusNotFound {
+		t.Errorf("switch to a tenant the password does not open: status %d, want 404", resp.Status)
+	}
+
+	var atHome loginResponse
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/switch-tenant", atSecond.AccessToken,
+		map[string]any{"tenant_slug": home.Slug}, &atHome)
+	if atHome.Tenant == nil || atHome.Tenant.TenantSlug != home.Slug || atHome.Tenant.Role != "owner" {
+		t.Fatalf("switch-tenant: tenant %+v", atHome.Tenant)
+	}
+
+	// The new token is scoped to home: its staff includes home's owner.
+	var staff []struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/users", atHome.AccessToken, nil, &staff)
+	if len(staff) != 1 || staff[0].ID != home.OwnerID {
+		t.Errorf("staff after switching to home: %+v", staff)
+	}
+
+	// Switching replaced the session of second.
+	resp, err = call(http.MethodPost, "/api/v1/auth/refresh", "", map[string]any{"refresh_token": atSecond.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusUnauthorized {
+		t.Errorf("refresh of the session switched away from: status %d, want 401", resp.Status)
+	}
+}
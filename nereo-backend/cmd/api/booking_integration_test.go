@@ -0,0 +1,203 @@
+//go:build integration
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBookingOverlapIncludesBuffer(t *testing.T) {
+	s := seedTenant(t, "buffer")
+	start := openAt(t, 5, 10, 0)
+
+	book := func(at time.Time) int {
+		resp, err := call(http.MethodPost, "/api/v1/bookings", s.Token, map[string]any{
+			"customer_id": s.CustomerID,
+			"box_id":      s.BoxID,
+			"service_id":  s.ServiceID,
+			"starts_at":   at,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	// 45 min service + 10 min default buffer = 55 min occupied.
+	if got := book(start); got != http.StatusCreated {
+		t.Fatalf("first booking: status %d", got)
+	}
+	if got := book(start.Add(50 * time.Minute)); got != http.StatusConflict {
+		t.Errorf("booking inside the buffer: status %d, want 409", got)
+	}
+	if got := book(start.Add(55 * time.Minute)); got != http.StatusCreated {
+		t.Errorf("booking right after the buffer: status %d, want 201", got)
+	}
+}
+
+func TestConcurrentBookingsSameSlot(t *testing.T) {
+	s := seedTenant(t, "race")
+	start := openAt(t, 6, 10, 0)
+
+	const attempts = 10
+	statuses := make(chan int, attempts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Different start minutes so the Redis lock does not serialize
+			// them: only the exclusion constraint can reject the overlap.
+			resp, err := call(http.MethodPost, "/api/v1/bookings", s.Token, map[string]any{
+				"customer_id": s.CustomerID,
+				"box_id":      s.BoxID,
+				"service_id":  s.ServiceID,
+				"starts_at":   start.Add(time.Duration(i) * time.Minute),
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- resp.Status
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d overlapping bookings created, want exactly 1", created)
+	}
+}
+
+func TestBookingMustFitOpeningHours(t *testing.T) {
+	s := seedTenant(t, "hours")
+	book := func(at time.Time) int {
+		resp, err := call(http.MethodPost, "/api/v1/bookings", s.Token, map[string]any{
+			"customer_id": s.CustomerID,
+			"box_id":      s.BoxID,
+			"service_id":  s.ServiceID,
+			"starts_at":   at,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	// The 45 min service fits from 08:00 to 19:15.
+	if got := book(openAt(t, 7, 7, 45)); got != http.StatusBadRequest {
+		t.Errorf("booking before opening: status %d, want 400", got)
+	}
+	if got := book(openAt(t, 7, 19, 30)); got != http.StatusBadRequest {
+		t.Errorf("booking ending after closing: status %d, want 400", got)
+	}
+	if got := book(openAt(t, 7, 3, 0)); got != http.StatusBadRequest {
+		t.Errorf("booking at night: status %d, want 400", got)
+	}
+	if got := book(openAt(t, 7, 19, 15)); got != http.StatusCreated {
+		t.Errorf("booking ending at closing: status %d, want 201", got)
+	}
+}
+
+func TestAvailabilitySkipsOccupiedRanges(t *testing.T) {
+	s := seedTenant(t, "avail")
+
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().In(loc).AddDate(0, 0, 10)
+	at := func(hour, minute int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	}
+
+	// Occupies 10:00-10:55 (45 min service + 10 min buffer).
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/bookings", s.Token, map[string]any{
+		"customer_id": s.CustomerID,
+		"box_id":      s.BoxID,
+		"service_id":  s.ServiceID,
+		"starts_at":   at(10, 0),
+	}, nil)
+
+	var availability struct {
+		Days []struct {
+			Date  string `json:"date"`
+			Boxes []struct {
+				Slots []time.Time `json:"slots"`
+			} `json:"boxes"`
+		} `json:"days"`
+	}
+	path := "/api/v1/bookings/availability?service_id=" + s.ServiceID.String() +
+		"&box_id=" + s.BoxID.String() + "&date=" + day.Format("2006-01-02") + "&days=2"
+	mustCall(t, http.StatusOK, http.MethodGet, path, s.Token, nil, &availability)
+
+	if len(availability.Days) != 2 || len(availability.Days[0].Boxes) != 1 {
+		t.Fatalf("unexpected shape: %+v", availability)
+	}
+
+	free := map[time.Time]bool{}
+	for _, slot := range availability.Days[0].Boxes[0].Slots {
+		free[slot.UTC()] = true
+	}
+
+	cases := []struct {
+		slot time.Time
+		want bool
+	}{
+		{at(8, 0), true},   // opening time
+		{at(9, 0), true},   // ends with its buffer exactly at 10:00
+		{at(9, 15), false}, // its buffer overlaps the 10:00 booking
+		{at(10, 0), false},
+		{at(10, 45), false},
+		{at(11, 0), true},
+		{at(19, 15), true},  // service ends exactly at closing time
+		{at(19, 30), false}, // would end after closing time
+	}
+	for _, tc := range cases {
+		if free[tc.slot.UTC()] != tc.want {
+			t.Errorf("slot %s free = %v, want %v", tc.slot.Format("15:04"), !tc.want, tc.want)
+		}
+	}
+}
+
+func TestBookingCanBeCancelledRightBeforeItStarts(t *testing.T) {
+	s := seedTenant(t, "late")
+	// Open all day, so the booking can start an hour from now. Near
+	// midnight it starts at 00:00 instead, still less than two hours ahead.
+	mustCall(t, http.StatusOK, http.MethodPut, "/api/v1/tenants/settings", s.Token, map[string]any{
+		"settings": map[string]any{"open_time": "00:00", "close_time": "23:59"},
+	}, nil)
+	start := time.Now().Add(time.Hour).Truncate(time.Minute)
+	if midnight := openAt(t, 1, 0, 0); start.Add(45 * time.Minute).After(midnight.Add(-time.Minute)) {
+		start = midnight
+	}
+	body := map[string]any{
+		"customer_id": s.CustomerID,
+		"box_id":      s.BoxID,
+		"service_id":  s.ServiceID,
+		"starts_at":   start,
+	}
+
+	// A customer calling an hour before: staff cancel it and the slot is
+	// free again.
+	var booking struct {
+		ID string `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/bookings", s.Token, body, &booking)
+	mustCall(t, http.StatusOK, http.MethodDelete, "/api/v1/bookings/"+booking.ID, s.Token, nil, nil)
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/bookings", s.Token, body, nil)
+}
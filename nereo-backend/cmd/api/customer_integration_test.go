@@ -0,0 +1,28 @@
+//go:build integration
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeletedCustomerPhoneCanBeRegisteredAgain(t *testing.T) {
+	s := seedTenant(t, "phones")
+
+	// The seeded customer has this phone, written another way.
+	customer := map[string]any{"full_name": "Otro cliente", "phone": "+54 9 11 5566 7788"}
+	mustCall(t, http.StatusConflict, http.MethodPost, "/api/v1/customers", s.Token, customer, nil)
+
+	mustCall(t, http.StatusNoContent, http.MethodDelete, "/api/v1/customers/"+s.CustomerID.String(), s.Token, nil, nil)
+	var created struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/customers", s.Token, customer, &created)
+	if created.ID == s.CustomerID {
+		t.Error("the deleted customer was returned instead of a new one")
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, "/api/v1/customers", s.Token, customer, nil)
+}
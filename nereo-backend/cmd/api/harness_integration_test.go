@@ -0,0 +1,421 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/config"
+	"github.com/nereo-ar/backend/pkg/database"
+	redisPkg "github.com/nereo-ar/backend/pkg/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Integration tests boot the whole API against a real PostgreSQL and Redis:
+//
+//	TEST_DATABASE_URL      owner role: runs migrations and inspects rows
+//	TEST_APP_DATABASE_URL  member of nereo_app: used by requests, RLS enforced
+//	TEST_REDIS_URL
+//
+// Run them with `make test-integration` (starts from docker-compose.yml).
+type testEnv struct {
+	cfg      *config.Config
+	systemDB *pgxpool.Pool
+	appDB    *pgxpool.Pool
+	redis    *goredis.Client
+	router   *gin.Engine
+	server   *httptest.Server
+	mailDir  string // one .eml file per mail sent by the API
+}
+
+var env *testEnv
+
+func TestMain(m *testing.M) {
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	appURL := os.Getenv("TEST_APP_DATABASE_URL")
+	redisURL := os.Getenv("TEST_REDIS_URL")
+	if dbURL == "" || appURL == "" || redisURL == "" {
+		fmt.Println("skipping integration tests: TEST_DATABASE_URL, TEST_APP_DATABASE_URL and TEST_REDIS_URL are required")
+		os.Exit(0)
+	}
+
+	var err error
+	env, err = newTestEnv(dbURL, appURL, redisURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration setup:", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	env.close()
+	os.Exit(code)
+}
+
+func newTestEnv(dbURL, appURL, redisURL string) (*testEnv, error) {
+	ctx := context.Background()
+
+	if err := database.RunMigrations(dbURL, "../../migrations"); err != nil {
+		return nil, err
+	}
+
+	systemDB, err := database.NewPool(ctx, dbURL)
+	if err != nil {
+		return nil, err
+	}
+	appDB, err := database.NewPool(ctx, appURL)
+	if err != nil {
+		return nil, err
+	}
+	redisClient, err := redisPkg.NewClient(ctx, redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mailDir, err := os.MkdirTemp("", "nereo-mail-")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config.Config{
+		Server:   config.ServerConfig{Mode: gin.TestMode},
+		Database: config.DatabaseConfig{URL: dbURL, AppURL: appURL},
+		Redis:    config.RedisConfig{URL: redisURL},
+		JWT: config.JWTConfig{
+			Secret:     "integration-test-secret",
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: time.Hour,
+		},
+		// Unroutable on purpose: isolation tests must never reach Mercado Pago.
+		MercadoPago: config.MercadoPagoConfig{
+			BaseURL:            "http://127.0.0.1:1",
+			AccessToken:        "integration-platform-token",
+			ClientID:           "integration-client",
+			ClientSecret:       "integration-client-secret",
+			OAuthRedirectURL:   "http://localhost/api/v1/mercadopago/oauth/callback",
+			TokenEncryptionKey: "integration-mp-token-key",
+			CheckoutTTL:        48 * time.Hour,
+		},
+		Admin: config.AdminConfig{Token: "integration-admin-token"},
+		Mail: config.MailConfig{
+			From:   "Nereo <no-reply@nereo.test>",
+			Dir:    mailDir,
+			AppURL: "http://app.nereo.test",
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := newRouter(cfg, appDB, systemDB, redisClient)
+
+	return &testEnv{
+		cfg:      cfg,
+		systemDB: systemDB,
+		appDB:    appDB,
+		redis:    redisClient,
+		router:   router,
+		server:   httptest.NewServer(router),
+		mailDir:  mailDir,
+	}, nil
+}
+
+func (e *testEnv) close() {
+	e.server.Close()
+	e.redis.Close()
+	e.appDB.Close()
+	e.systemDB.Close()
+	os.RemoveAll(e.mailDir)
+}
+
+// apiResponse is the envelope written by pkg/httputil.
+type apiResponse struct {
+	Status int
+	Raw    []byte
+	Data   json.RawMessage `json:"data"`
+}
+
+// call performs an HTTP request against the test server.
+func call(method, path, token string, body any) (*apiResponse, error) {
+	return callWithHeaders(method, path, token, nil, body)
+}
+
+// callWithHeaders is call with extra request headers.
+func callWithHeaders(method, path, token string, headers map[string]string, body any) (*apiResponse, error) {
+	return callURL(env.server.URL, method, path, token, headers, body)
+}
+
+// callURL is callWithHeaders against another server, e.g. one built with a
+// different config.
+func callURL(baseURL, method, path, token string, headers map[string]string, body any) (*apiResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &apiResponse{Status: resp.StatusCode, Raw: raw}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, out)
+	}
+	return out, nil
+}
+
+// mustCall is call for setup steps that must succeed with the given status.
+func mustCall(t *testing.T, want int, method, path, token string, body any, out any) {
+	t.Helper()
+	resp, err := call(method, path, token, body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if resp.Status != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.Status, want, resp.Raw)
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatalf("%s %s: decode data: %v", method, path, err)
+		}
+	}
+}
+
+// seededTenant is a tenant created through the public API with one of each
+// tenant-scoped resource.
+type seededTenant struct {
+	ID             uuid.UUID
+	OwnerID        uuid.UUID // user created with the tenant
+	Slug           string
+	Email          string
+	Password       string
+	Token          string
+	PlanID         uuid.UUID
+	CustomerID     uuid.UUID
+	SubscriptionID uuid.UUID
+	PaymentID      uuid.UUID // manual payment of the subscription
+	ServiceID      uuid.UUID
+	BoxID          uuid.UUID
+	BookingID      uuid.UUID
+	UsageID        uuid.UUID
+	QRToken        string // unused membership card token of the subscription
+}
+
+// IDs lists every resource ID owned by the tenant; none of them may ever
+// appear in a response served to another tenant.
+func (s *seededTenant) IDs() []uuid.UUID {
+	return []uuid.UUID{s.ID, s.OwnerID, s.PlanID, s.CustomerID, s.SubscriptionID, s.PaymentID, s.ServiceID, s.BoxID, s.BookingID, s.UsageID}
+}
+
+func seedTenant(t *testing.T, name string) *seededTenant {
+	t.Helper()
+
+	suffix := uuid.NewString()[:8]
+	s := &seededTenant{
+		Slug:     fmt.Sprintf("%s-%s", name, suffix),
+		Email:    fmt.Sprintf("owner-%s@%s.test", suffix, name),
+		Password: "integration-password",
+	}
+
+	var created struct {
+		Tenant struct {
+			ID uuid.UUID `json:"id"`
+		} `json:"tenant"`
+		UserID uuid.UUID `json:"user_id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/tenants", "", map[string]any{
+		"name":        "Lavadero " + name,
+		"slug":        s.Slug,
+		"owner_name":  "Owner " + name,
+		"owner_email": s.Email,
+		"password":    s.Password,
+	}, &created)
+	s.ID = created.Tenant.ID
+	s.OwnerID = created.UserID
+
+	s.Token = login(t, s.Email, s.Password)
+
+	// Sensitive owner actions need a verified email.
+	mustCall(t, http.StatusNoContent, http.MethodPost, "/api/v1/auth/verify-email", "", map[string]any{
+		"token": mailToken(t, s.Email, "/verify-email"),
+	}, nil)
+
+	var plan struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/plans", s.Token, map[string]any{
+		"name":        "Plan " + name,
+		"price_cents": 1500000,
+		"wash_limit":  4,
+	}, &plan)
+	s.PlanID = plan.ID
+
+	var customer struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/customers", s.Token, map[string]any{
+		"full_name":     "Cliente " + name,
+		"phone":         "11 15 5566-7788",
+		"email":         fmt.Sprintf("cliente-%s@%s.test", suffix, name),
+		"vehicle_plate": "AB123CD",
+	}, &customer)
+	s.CustomerID = customer.ID
+
+	var sub struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/subscriptions", s.Token, map[string]any{
+		"customer_id":    s.CustomerID,
+		"plan_id":        s.PlanID,
+		"payment_method": "manual",
+	}, &sub)
+	s.SubscriptionID = sub.ID
+
+	var payment struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/payments/manual", s.Token, map[string]any{
+		"subscription_id": s.SubscriptionID,
+		"amount_cents":    1500000,
+	}, &payment)
+	s.PaymentID = payment.ID
+
+	var service struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/services", s.Token, map[string]any{
+		"name":             "Lavado " + name,
+		"duration_minutes": 45,
+		"base_price_cents": 800000,
+	}, &service)
+	s.ServiceID = service.ID
+
+	var box struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/boxes", s.Token, map[string]any{
+		"name":        "Box " + name,
+		"service_ids": []uuid.UUID{s.ServiceID},
+	}, &box)
+	s.BoxID = box.ID
+
+	var booking struct {
+		ID uuid.UUID `json:"id"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/bookings", s.Token, map[string]any{
+		"customer_id": s.CustomerID,
+		"box_id":      s.BoxID,
+		"service_id":  s.ServiceID,
+		"starts_at":   openAt(t, 3, 10, 0),
+	}, &booking)
+	s.BookingID = booking.ID
+
+	var checkIn struct {
+		Usage struct {
+			ID uuid.UUID `json:"id"`
+		} `json:"usage"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/subscriptions/"+s.SubscriptionID.String()+"/washes", s.Token,
+		map[string]any{"booking_id": s.BookingID}, &checkIn)
+	s.UsageID = checkIn.Usage.ID
+
+	var qr struct {
+		Token string `json:"token"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/subscriptions/"+s.SubscriptionID.String()+"/qr", s.Token, nil, &qr)
+	s.QRToken = qr.Token
+
+	return s
+}
+
+// openAt is hour:minute days from today in the tenants' default timezone.
+// Bookings must fit within the default opening hours, 08:00 to 20:00.
+func openAt(t *testing.T, days, hour, minute int) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().In(loc).AddDate(0, 0, days)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+}
+
+func login(t *testing.T, email, password string) string {
+	t.Helper()
+	var pair struct {
+		AccessToken string `json:"access_token"`
+	}
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":    email,
+		"password": password,
+	}, &pair)
+	return pair.AccessToken
+}
+
+var mailTokenRe = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// mailToken waits for the newest mail to `to` linking to path and returns
+// the token of the link. Some mails are sent in the background.
+func mailToken(t *testing.T, to, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		names, err := filepath.Glob(filepath.Join(env.mailDir, "*-"+to+".eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+		for _, name := range names {
+			raw, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := string(raw)
+			if i := strings.Index(body, path+"?"); i >= 0 {
+				if m := mailTokenRe.FindStringSubmatch(body[i:]); m != nil {
+					return m[1]
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no mail to %s linking to %s", to, path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
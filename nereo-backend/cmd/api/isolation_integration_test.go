@@ -0,0 +1,644 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+// crossTenantCase sends a request authenticated as tenant A that targets
+// tenant B's resources. The request must answer with one of the allowed
+// statuses, must not leak any of B's IDs and must leave B's rows untouched.
+type crossTenantCase struct {
+	method string
+	route  string // as registered in gin, e.g. /api/v1/plans/:id
+	path   func(a, b *seededTenant) string
+	body   func(a, b *seededTenant) any
+	want   []int
+}
+
+// crossTenantCases must cover every authenticated route. TestRouteCoverage
+// fails when a route is added without a case here or in isolationExempt.
+var crossTenantCases = []crossTenantCase{
+	// Tenant settings always act on the caller's tenant.
+	{
+		method: http.MethodPut, route: "/api/v1/tenants/settings",
+		path: func(a, b *seededTenant) string { return "/api/v1/tenants/settings" },
+		body: func(a, b *seededTenant) any { return map[string]any{"buffer_between_slots": 10} },
+		want: []int{http.StatusOK},
+	},
+
+	// Staff users
+	{
+		method: http.MethodGet, route: "/api/v1/users",
+		path: func(a, b *seededTenant) string { return "/api/v1/users?include_inactive=true" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/users/invitations",
+		path: func(a, b *seededTenant) string { return "/api/v1/users/invitations" },
+		// B's owner email is free in A: the invitation belongs to A only.
+		body: func(a, b *seededTenant) any { return map[string]any{"email": b.Email, "role": "employee"} },
+		want: []int{http.StatusCreated},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/users/:id/role",
+		path: func(a, b *seededTenant) string { return "/api/v1/users/" + b.OwnerID.String() + "/role" },
+		body: func(a, b *seededTenant) any { return map[string]any{"role": "employee"} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/users/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/users/" + b.OwnerID.String() },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Customers
+	{
+		method: http.MethodGet, route: "/api/v1/customers",
+		path: func(a, b *seededTenant) string { return "/api/v1/customers?q=Cliente" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/customers/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/customers/" + b.CustomerID.String() },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/customers",
+		path: func(a, b *seededTenant) string { return "/api/v1/customers" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"full_name": "Otro Cliente", "phone": "11 4000-0001"}
+		},
+		want: []int{http.StatusCreated},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/customers/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/customers/" + b.CustomerID.String() },
+		body: func(a, b *seededTenant) any { return map[string]any{"full_name": "Hijacked"} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/customers/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/customers/" + b.CustomerID.String() },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Plans
+	{
+		method: http.MethodGet, route: "/api/v1/plans",
+		path: func(a, b *seededTenant) string { return "/api/v1/plans" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/plans/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/plans/" + b.PlanID.String() },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/plans",
+		path: func(a, b *seededTenant) string { return "/api/v1/plans" },
+		body: func(a, b *seededTenant) any { return map[string]any{"name": "Otro Plan", "price_cents": 100000} },
+		want: []int{http.StatusCreated},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/plans/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/plans/" + b.PlanID.String() },
+		body: func(a, b *seededTenant) any { return map[string]any{"price_cents": 1} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/plans/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/plans/" + b.PlanID.String() },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Subscriptions
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions",
+		path: func(a, b *seededTenant) string { return "/api/v1/subscriptions" },
+		body: func(a, b *seededTenant) any {
+			// A's own plan with B's customer: the FK alone would accept it.
+			return map[string]any{"customer_id": b.CustomerID, "plan_id": a.PlanID, "payment_method": "manual"}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions",
+		path: func(a, b *seededTenant) string { return "/api/v1/subscriptions?customer_id=" + b.CustomerID.String() },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/cancel",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/cancel"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions/:id/history",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/history"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/pause",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/pause"
+		},
+		body: func(a, b *seededTenant) any { return map[string]any{"days": 3} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/resume",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/resume"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions/:id/pauses",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/pauses"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions/:id/validate",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/validate"
+		},
+		want: []int{http.StatusNotFound},
+	},
+
+	// Wash usage
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/washes",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/washes"
+		},
+		body: func(a, b *seededTenant) any { return map[string]any{} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions/:id/washes",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/washes"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/washes/:usage_id/void",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/washes/" + b.UsageID.String() + "/void"
+		},
+		body: func(a, b *seededTenant) any { return map[string]any{"reason": "cross-tenant"} },
+		want: []int{http.StatusNotFound},
+	},
+
+	// QR membership cards
+	{
+		method: http.MethodGet, route: "/api/v1/subscriptions/:id/qr",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/qr"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		// B's card scanned at A's counter: valid signature, wrong tenant.
+		method: http.MethodPost, route: "/api/v1/qr/check-in",
+		path: func(a, b *seededTenant) string { return "/api/v1/qr/check-in" },
+		body: func(a, b *seededTenant) any { return map[string]any{"token": b.QRToken} },
+		want: []int{http.StatusBadRequest},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/qr/keys",
+		path: func(a, b *seededTenant) string { return "/api/v1/qr/keys" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/qr/keys/rotate",
+		path: func(a, b *seededTenant) string { return "/api/v1/qr/keys/rotate" },
+		want: []int{http.StatusCreated},
+	},
+
+	// Payments
+	{
+		method: http.MethodPost, route: "/api/v1/payments/preference",
+		path: func(a, b *seededTenant) string { return "/api/v1/payments/preference" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"plan_id": a.PlanID, "customer_id": b.CustomerID}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/payments/subscription",
+		path: func(a, b *seededTenant) string { return "/api/v1/payments/subscription" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"plan_id": a.PlanID, "customer_id": b.CustomerID, "payer_email": "x@example.com"}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/payments/manual",
+		path: func(a, b *seededTenant) string { return "/api/v1/payments/manual" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"subscription_id": b.SubscriptionID, "amount_cents": 100}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/renew-manual",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/renew-manual"
+		},
+		body: func(a, b *seededTenant) any { return map[string]any{} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/payments",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/payments?customer_id=" + b.CustomerID.String()
+		},
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/payments/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/payments/" + b.PaymentID.String() },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/dunning/policy",
+		path: func(a, b *seededTenant) string { return "/api/v1/dunning/policy" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/dunning/policy",
+		path: func(a, b *seededTenant) string { return "/api/v1/dunning/policy" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"grace_days": 5, "allow_washes_past_due": true, "reminder_days": []int{1, 2}}
+		},
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/dunning/report",
+		path: func(a, b *seededTenant) string { return "/api/v1/dunning/report?from=2000-01-01&to=2100-12-31" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/subscriptions/:id/recovery-link",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/subscriptions/" + b.SubscriptionID.String() + "/recovery-link"
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/reconciliation/issues",
+		path: func(a, b *seededTenant) string { return "/api/v1/reconciliation/issues" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/mercadopago/connect",
+		path: func(a, b *seededTenant) string { return "/api/v1/mercadopago/connect" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/mercadopago/account",
+		path: func(a, b *seededTenant) string { return "/api/v1/mercadopago/account" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/mercadopago/account",
+		path: func(a, b *seededTenant) string { return "/api/v1/mercadopago/account" },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/payments/:id/refund",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/payments/" + b.PaymentID.String() + "/refund"
+		},
+		body: func(a, b *seededTenant) any { return map[string]any{"amount_cents": 100} },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Services catalog
+	{
+		method: http.MethodGet, route: "/api/v1/services",
+		path: func(a, b *seededTenant) string { return "/api/v1/services" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/services/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/services/" + b.ServiceID.String() },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/services",
+		path: func(a, b *seededTenant) string { return "/api/v1/services" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"name": "Encerado", "duration_minutes": 30, "base_price_cents": 500000}
+		},
+		want: []int{http.StatusCreated},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/services/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/services/" + b.ServiceID.String() },
+		body: func(a, b *seededTenant) any { return map[string]any{"duration_minutes": 5} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/services/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/services/" + b.ServiceID.String() },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Wash boxes
+	{
+		method: http.MethodGet, route: "/api/v1/boxes",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes" },
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/boxes/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes/" + b.BoxID.String() },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPost, route: "/api/v1/boxes",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{"name": "Box Intruso", "service_ids": []uuid.UUID{b.ServiceID}}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/boxes/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes/" + b.BoxID.String() },
+		body: func(a, b *seededTenant) any { return map[string]any{"active": false} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPut, route: "/api/v1/boxes/:id/services",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes/" + a.BoxID.String() + "/services" },
+		body: func(a, b *seededTenant) any {
+			// A's own box with B's service: the FK alone would accept it.
+			return map[string]any{"service_ids": []uuid.UUID{a.ServiceID, b.ServiceID}}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/boxes/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/boxes/" + b.BoxID.String() },
+		want: []int{http.StatusNotFound},
+	},
+
+	// Bookings
+	{
+		method: http.MethodPost, route: "/api/v1/bookings",
+		path: func(a, b *seededTenant) string { return "/api/v1/bookings" },
+		body: func(a, b *seededTenant) any {
+			return map[string]any{
+				"customer_id": b.CustomerID,
+				"box_id":      a.BoxID,
+				"service_id":  a.ServiceID,
+				"starts_at":   time.Now().Add(96 * time.Hour).Truncate(time.Hour),
+			}
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/bookings",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/bookings?date=" + time.Now().Add(72*time.Hour).Format("2006-01-02")
+		},
+		want: []int{http.StatusOK},
+	},
+	{
+		method: http.MethodGet, route: "/api/v1/bookings/availability",
+		path: func(a, b *seededTenant) string {
+			return "/api/v1/bookings/availability?service_id=" + b.ServiceID.String() + "&box_id=" + b.BoxID.String()
+		},
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodPatch, route: "/api/v1/bookings/:id/status",
+		path: func(a, b *seededTenant) string { return "/api/v1/bookings/" + b.BookingID.String() + "/status" },
+		body: func(a, b *seededTenant) any { return map[string]any{"status": "no_show"} },
+		want: []int{http.StatusNotFound},
+	},
+	{
+		method: http.MethodDelete, route: "/api/v1/bookings/:id",
+		path: func(a, b *seededTenant) string { return "/api/v1/bookings/" + b.BookingID.String() },
+		want: []int{http.StatusNotFound},
+	},
+}
+
+// isolationExempt lists routes that cannot reach another tenant's data,
+// with the reason.
+var isolationExempt = map[string]string{
+	"GET /health":                            "public, no data",
+	"GET /readyz":                            "public, no data",
+	"POST /api/v1/tenants":                   "public sign-up, creates a new tenant",
+	"POST /api/v1/auth/login":                "public, resolves the tenant from the credentials",
+	"POST /api/v1/auth/refresh":              "public, resolves the tenant from the refresh token",
+	"POST /api/v1/auth/forgot-password":      "public, answers the same for any email",
+	"POST /api/v1/auth/reset-password":       "public, the user comes from the reset token",
+	"POST /api/v1/auth/verify-email":         "public, the user comes from the verification token",
+	"POST /api/v1/invitations/accept":        "public, the tenant comes from the invitation token",
+	"POST /api/v1/webhooks/mercadopago":      "public, HMAC-verified, tenant comes from the stored payment",
+	"GET /api/v1/mercadopago/oauth/callback": "public, tenant comes from the sealed OAuth state",
+	"POST /api/v1/auth/logout":               "only revokes the caller's own token",
+	"POST /api/v1/auth/verify-email/resend":  "only mails the caller",
+	"GET /api/v1/auth/sessions":              "only the caller's own sessions",
+	"POST /api/v1/auth/switch-tenant":        "only to tenants whose account the login's password opened",
+	"DELETE /api/v1/auth/sessions":           "only the caller's own sessions",
+	"DELETE /api/v1/auth/sessions/:id":       "only the caller's own sessions, checked against the session's user",
+	"GET /api/v1/admin/webhooks":             "platform admin token, not a tenant user",
+	"GET /api/v1/admin/webhooks/:id":         "platform admin token, not a tenant user",
+	"POST /api/v1/admin/webhooks/:id/replay": "platform admin token, not a tenant user",
+	"POST /api/v1/admin/reconciliation":      "platform admin token, not a tenant user",
+}
+
+// tenantTables are snapshotted for tenant B before and after the
+// cross-tenant requests; every tenant-scoped table belongs here.
+var tenantTables = []string{
+	"users",
+	"customers",
+	"membership_plans",
+	"subscriptions",
+	"payment_events",
+	"services",
+	"wash_boxes",
+	"wash_box_services",
+	"bookings",
+	"wash_usages",
+	"qr_signing_keys",
+	"subscription_pauses",
+	"subscription_status_history",
+	"mp_requests",
+	"reconciliation_issues",
+	"mp_accounts",
+	"dunning_policies",
+	"dunning_notifications",
+	"user_invitations",
+}
+
+func TestRouteCoverage(t *testing.T) {
+	covered := make(map[string]bool, len(crossTenantCases))
+	for _, tc := range crossTenantCases {
+		covered[tc.method+" "+tc.route] = true
+	}
+
+	for _, r := range env.router.Routes() {
+		key := r.Method + " " + r.Path
+		if covered[key] {
+			continue
+		}
+		if _, ok := isolationExempt[key]; ok {
+			continue
+		}
+		t.Errorf("route %s has no cross-tenant case: add it to crossTenantCases or isolationExempt", key)
+	}
+}
+
+func TestCrossTenantAccess(t *testing.T) {
+	a := seedTenant(t, "alpha")
+	b := seedTenant(t, "beta")
+
+	before := snapshotTenant(t, b.ID)
+
+	for _, tc := range crossTenantCases {
+		t.Run(tc.method+" "+tc.route, func(t *testing.T) {
+			var body any
+			if tc.body != nil {
+				body = tc.body(a, b)
+			}
+
+			resp, err := call(tc.method, tc.path(a, b), a.Token, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !containsStatus(tc.want, resp.Status) {
+				t.Errorf("status %d, want one of %v: %s", resp.Status, tc.want, resp.Raw)
+			}
+			for _, id := range b.IDs() {
+				if bytes.Contains(resp.Raw, []byte(id.String())) {
+					t.Errorf("response leaks tenant B id %s: %s", id, resp.Raw)
+				}
+			}
+		})
+	}
+
+	after := snapshotTenant(t, b.ID)
+	for table, rows := range before {
+		if after[table] != rows {
+			t.Errorf("tenant B %s changed:\nbefore: %s\nafter:  %s", table, rows, after[table])
+		}
+	}
+}
+
+// TestRLSPolicies checks the database layer on its own, without the
+// application's tenant_id filters.
+func TestRLSPolicies(t *testing.T) {
+	a := seedTenant(t, "gamma")
+	b := seedTenant(t, "delta")
+	ctx := context.Background()
+
+	for _, table := range tenantTables {
+		t.Run(table, func(t *testing.T) {
+			err := database.RunInTenantTx(ctx, env.appDB, a.ID, func(ctx context.Context) error {
+				tx, _ := database.TxFromContext(ctx)
+
+				var foreign int
+				query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE tenant_id = $1", table)
+				if err := tx.QueryRow(ctx, query, b.ID).Scan(&foreign); err != nil {
+					return err
+				}
+				if foreign != 0 {
+					t.Errorf("app role sees %d rows of another tenant", foreign)
+				}
+
+				var foreignTotal int
+				query = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE tenant_id <> $1", table)
+				if err := tx.QueryRow(ctx, query, a.ID).Scan(&foreignTotal); err != nil {
+					return err
+				}
+				if foreignTotal != 0 {
+					t.Errorf("app role sees %d rows outside its tenant", foreignTotal)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("writes into another tenant are rejected", func(t *testing.T) {
+		err := database.RunInTenantTx(ctx, env.appDB, a.ID, func(ctx context.Context) error {
+			tx, _ := database.TxFromContext(ctx)
+			_, err := tx.Exec(ctx,
+				`INSERT INTO customers (id, tenant_id, full_name, phone) VALUES ($1, $2, 'Intruso', '+5491100000000')`,
+				uuid.New(), b.ID)
+			return err
+		})
+		if err == nil {
+			t.Fatal("insert with another tenant_id succeeded")
+		}
+	})
+
+	t.Run("queries without tenant context fail", func(t *testing.T) {
+		err := database.RunInTx(ctx, env.appDB, func(ctx context.Context) error {
+			tx, _ := database.TxFromContext(ctx)
+			var n int
+			return tx.QueryRow(ctx, "SELECT COUNT(*) FROM customers").Scan(&n)
+		})
+		if err == nil {
+			t.Fatal("query without app.current_tenant succeeded")
+		}
+	})
+}
+
+// snapshotTenant renders every row of the tenant's tables as text, using the
+// owner pool so RLS does not hide anything.
+func snapshotTenant(t *testing.T, tenantID uuid.UUID) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+
+	snapshot := make(map[string]string, len(tenantTables)+1)
+	for _, table := range append([]string{"tenants"}, tenantTables...) {
+		column := "tenant_id"
+		if table == "tenants" {
+			column = "id"
+		}
+		query := fmt.Sprintf(
+			"SELECT COALESCE(string_agg(t::text, E'\\n' ORDER BY t.id), '') FROM %s t WHERE %s = $1",
+			table, column)
+
+		var rows string
+		if err := env.systemDB.QueryRow(ctx, query, tenantID).Scan(&rows); err != nil {
+			t.Fatalf("snapshot %s: %v", table, err)
+		}
+		snapshot[table] = rows
+	}
+	return snapshot
+}
+
+func containsStatus(want []int, got int) bool {
+	for _, w := range want {
+		if w == got {
+			return true
+		}
+	}
+	return false
+}
@@ -14,12 +14,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nereo-ar/backend/internal/auth"
+	"github.com/nereo-ar/backend/internal/booking"
 	"github.com/nereo-ar/backend/internal/config"
+	"github.com/nereo-ar/backend/internal/customer"
 	"github.com/nereo-ar/backend/internal/membership"
 	mw "github.com/nereo-ar/backend/internal/middleware"
 	"github.com/nereo-ar/backend/internal/payment"
 	"github.com/nereo-ar/backend/internal/tenant"
+	"github.com/nereo-ar/backend/internal/user"
 	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/mail"
 	redisPkg "github.com/nereo-ar/backend/pkg/redis"
 	goredis "github.com/redis/go-redis/v9"
 )
@@ -27,6 +31,8 @@ import (
 func main() {
 	migrateUp := flag.Bool("migrate-up", false, "Run database migrations up")
 	migrateDown := flag.Bool("migrate-down", false, "Rollback last database migration")
+	normalizePhones := flag.Bool("normalize-phones", false, "Normalize stored customer/user phones to E.164 and exit")
+	dryRun := flag.Bool("dry-run", false, "With -normalize-phones, report changes without writing them")
 	flag.Parse()
 
 	// Structured JSON logging
@@ -65,15 +71,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Connect to PostgreSQL
-	db, err := database.NewPool(ctx, cfg.Database.URL)
+	// Connect to PostgreSQL. systemDB uses the owner role (bypasses RLS) and is
+	// reserved for pre-auth lookups, webhooks and crons; db is used by HTTP
+	// requests through TenantMiddleware.
+	systemDB, err := database.NewPool(ctx, cfg.Database.URL)
 	if err != nil {
 		slog.Error("failed to connect to database", "error", err)
 		os.Exit(1)
 	}
-	defer db.Close()
+	defer systemDB.Close()
+
+	db := systemDB
+	if cfg.Database.AppURL != "" {
+		db, err = database.NewPool(ctx, cfg.Database.AppURL)
+		if err != nil {
+			slog.Error("failed to connect to database with app role", "error", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+	} else {
+		slog.Warn("APP_DATABASE_URL not set: requests run as table owner and RLS is not enforced")
+	}
 	slog.Info("connected to PostgreSQL")
 
+	if *normalizePhones {
+		if err := backfillPhones(ctx, systemDB, os.Stdout, *dryRun); err != nil {
+			slog.Error("phone backfill failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Connect to Redis
 	redisClient, err := redisPkg.NewClient(ctx, cfg.Redis.URL)
 	if err != nil {
@@ -83,31 +111,28 @@ func main() {
 	defer redisClient.Close()
 	slog.Info("connected to Redis")
 
-	// Setup router
 	gin.SetMode(cfg.Server.Mode)
-	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(mw.LoggingMiddleware())
-	router.Use(mw.CORSMiddleware(cfg.Server.CORSOrigins))
+	router := newRouter(cfg, db, systemDB, redisClient)
 
-	// Initialize services
-	jwtManager := auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL)
-	authHandler := auth.NewHandler(db, jwtManager, redisClient)
-	tenantService := tenant.NewService(db)
-	tenantHandler := tenant.NewHandler(tenantService)
-	membershipService := membership.NewService(db)
-	membershipHandler := membership.NewHandler(membershipService)
+	// Process Mercado Pago notifications stored by the webhook endpoint
+	mpClients := payment.NewClients(cfg.MercadoPago, systemDB)
+	webhookProcessor := payment.NewWebhookProcessor(mpClients, payment.NewRepository(systemDB))
+	payment.StartWebhookWorkers(webhookProcessor, cfg.MercadoPago.WebhookWorkers)
 
-	// Mercado Pago
-	mpClient := payment.NewMercadoPagoClient(cfg.MercadoPago)
-	paymentRepo := payment.NewRepository(db)
-	paymentHandler := payment.NewHandler(mpClient, paymentRepo, cfg.MercadoPago.WebhookSecret)
+	// Nightly comparison of the ledger with Mercado Pago's payment search
+	payment.StartReconciliationCron(payment.NewReconciler(webhookProcessor))
 
-	// Start background cron for past_due subscriptions
-	payment.StartPastDueCron(paymentRepo)
+	// Resume subscriptions whose pause reached the plan's max_pause_days, and
+	// cancel Checkout Pro subscriptions nobody paid
+	systemMemberships := membership.NewService(systemDB, redisClient, cfg.QR, mpClients)
+	membership.StartPauseCron(systemMemberships)
+	membership.StartCheckoutCron(systemMemberships, cfg.MercadoPago.CheckoutTTL)
 
-	// Register routes
-	registerRoutes(router, db, jwtManager, redisClient, authHandler, tenantHandler, membershipHandler, paymentHandler)
+	// Keep the tokens of connected Mercado Pago accounts fresh
+	payment.StartTokenRefreshCron(mpClients)
+
+	// Remind past_due customers to pay and cancel them after the grace period
+	payment.StartDunningCron(payment.NewDunning(payment.NewRepository(systemDB), mpClients, redisClient))
 
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.Server.Port)
@@ -143,15 +168,68 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// newRouter wires every module on top of the given connections. db is the
+// RLS-enforced pool used by requests, systemDB the owner pool. It is shared by
+// main and the integration tests.
+func newRouter(cfg *config.Config, db, systemDB *pgxpool.Pool, redisClient *goredis.Client) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(mw.LoggingMiddleware())
+	router.Use(mw.CORSMiddleware(cfg.Server.CORSOrigins))
+
+	// Initialize services
+	jwtManager := auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL)
+	mailSender := newMailSender(cfg.Mail)
+	authHandler := auth.NewHandler(systemDB, jwtManager, redisClient, mailSender, cfg.Mail.AppURL)
+	tenantService := tenant.NewService(db)
+	tenantHandler := tenant.NewHandler(tenantService, authHandler)
+	userHandler := user.NewHandler(user.NewService(db, systemDB, redisClient, mailSender, cfg.Mail.AppURL))
+	customerService := customer.NewService(db)
+	customerHandler := customer.NewHandler(customerService)
+	bookingService := booking.NewService(db, redisClient)
+	bookingHandler := booking.NewHandler(bookingService)
+
+	// Mercado Pago: each tenant's connected account, or the platform one
+	mpClients := payment.NewClients(cfg.MercadoPago, systemDB)
+	membershipService := membership.NewService(db, redisClient, cfg.QR, mpClients)
+	membershipHandler := membership.NewHandler(membershipService)
+	paymentRepo := payment.NewRepository(systemDB)
+	paymentHandler := payment.NewHandler(mpClients, paymentRepo, membershipService)
+
+	// Register routes
+	registerRoutes(router, db, cfg.Admin.Token, jwtManager, redisClient, authHandler, tenantHandler, userHandler, customerHandler, membershipHandler, paymentHandler, bookingHandler)
+
+	return router
+}
+
+// newMailSender delivers through SMTP when a host is configured, and writes
+// or logs the mails otherwise.
+func newMailSender(cfg config.MailConfig) mail.Sender {
+	if cfg.SMTPHost == "" {
+		slog.Warn("SMTP_HOST not set: mails are not delivered", "dir", cfg.Dir)
+		return mail.NewFileSender(cfg.Dir, cfg.From)
+	}
+	sender, err := mail.NewSMTPSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	if err != nil {
+		slog.Error("invalid mail config", "error", err)
+		os.Exit(1)
+	}
+	return sender
+}
+
 func registerRoutes(
 	router *gin.Engine,
 	db *pgxpool.Pool,
+	adminToken string,
 	jwtManager *auth.JWTManager,
 	redisClient *goredis.Client,
 	authHandler *auth.Handler,
 	tenantHandler *tenant.Handler,
+	userHandler *user.Handler,
+	customerHandler *customer.Handler,
 	membershipHandler *membership.Handler,
 	paymentHandler *payment.Handler,
+	bookingHandler *booking.Handler,
 ) {
 	// Health checks
 	router.GET("/health", func(c *gin.Context) {
@@ -175,10 +253,25 @@ func registerRoutes(
 	api.POST("/tenants", tenantHandler.Register)
 	api.POST("/auth/login", authHandler.Login)
 	api.POST("/auth/refresh", authHandler.Refresh)
+	api.POST("/auth/forgot-password", authHandler.ForgotPassword)
+	api.POST("/auth/reset-password", authHandler.ResetPassword)
+	api.POST("/auth/verify-email", authHandler.VerifyEmail)
+	api.POST("/invitations/accept", userHandler.AcceptInvitation)
 
 	// Webhook (public, verified by HMAC signature)
 	api.POST("/webhooks/mercadopago", paymentHandler.HandleWebhook)
 
+	// Mercado Pago OAuth redirect (public, the tenant comes in the sealed state)
+	api.GET("/mercadopago/oauth/callback", paymentHandler.MPOAuthCallback)
+
+	// Platform admin (static X-Admin-Token, not tied to a tenant)
+	admin := api.Group("/admin")
+	admin.Use(mw.AdminMiddleware(adminToken))
+	admin.GET("/webhooks", paymentHandler.ListWebhooks)
+	admin.GET("/webhooks/:id", paymentHandler.GetWebhook)
+	admin.POST("/webhooks/:id/replay", paymentHandler.ReplayWebhook)
+	admin.POST("/reconciliation", paymentHandler.Reconcile)
+
 	// Authenticated routes
 	authenticated := api.Group("")
 	authenticated.Use(mw.AuthMiddleware(jwtManager))
@@ -186,6 +279,14 @@ func registerRoutes(
 
 	// Auth
 	authenticated.POST("/auth/logout", authHandler.Logout)
+	authenticated.POST("/auth/switch-tenant", authHandler.SwitchTenant)
+	authenticated.GET("/auth/sessions", authHandler.ListSessions)
+	authenticated.DELETE("/auth/sessions", authHandler.LogoutEverywhere)
+	authenticated.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+	authenticated.POST("/auth/verify-email/resend", authHandler.ResendVerification)
+
+	// Sensitive actions (money, staff) need a verified email
+	verified := mw.RequireVerifiedEmail(db)
 
 	// Tenant settings (owner only)
 	authenticated.PUT("/tenants/settings",
@@ -193,6 +294,49 @@ func registerRoutes(
 		tenantHandler.UpdateSettings,
 	)
 
+	// Staff users (owner only)
+	authenticated.GET("/users",
+		mw.RequireRole("owner"),
+		userHandler.List,
+	)
+	authenticated.POST("/users/invitations",
+		mw.RequireRole("owner"),
+		verified,
+		userHandler.Invite,
+	)
+	authenticated.PUT("/users/:id/role",
+		mw.RequireRole("owner"),
+		verified,
+		userHandler.ChangeRole,
+	)
+	authenticated.DELETE("/users/:id",
+		mw.RequireRole("owner"),
+		verified,
+		userHandler.Deactivate,
+	)
+
+	// Customers
+	authenticated.GET("/customers",
+		mw.RequireRole("owner", "manager", "employee"),
+		customerHandler.List,
+	)
+	authenticated.GET("/customers/:id",
+		mw.RequireRole("owner", "manager", "employee"),
+		customerHandler.Get,
+	)
+	authenticated.POST("/customers",
+		mw.RequireRole("owner", "manager", "employee"),
+		customerHandler.Create,
+	)
+	authenticated.PUT("/customers/:id",
+		mw.RequireRole("owner", "manager"),
+		customerHandler.Update,
+	)
+	authenticated.DELETE("/customers/:id",
+		mw.RequireRole("owner", "manager"),
+		customerHandler.Delete,
+	)
+
 	// Plans
 	authenticated.GET("/plans",
 		mw.RequireRole("owner", "manager", "employee"),
@@ -228,11 +372,59 @@ func registerRoutes(
 		mw.RequireRole("owner", "manager"),
 		membershipHandler.CancelSubscription,
 	)
+	authenticated.GET("/subscriptions/:id/history",
+		mw.RequireRole("owner", "manager"),
+		membershipHandler.ListStatusHistory,
+	)
+	authenticated.POST("/subscriptions/:id/pause",
+		mw.RequireRole("owner", "manager"),
+		membershipHandler.PauseSubscription,
+	)
+	authenticated.POST("/subscriptions/:id/resume",
+		mw.RequireRole("owner", "manager"),
+		membershipHandler.ResumeSubscription,
+	)
+	authenticated.GET("/subscriptions/:id/pauses",
+		mw.RequireRole("owner", "manager"),
+		membershipHandler.ListPauses,
+	)
 	authenticated.GET("/subscriptions/:id/validate",
 		mw.RequireRole("owner", "manager", "employee"),
 		membershipHandler.ValidateSubscription,
 	)
 
+	// Wash usage (check-in)
+	authenticated.POST("/subscriptions/:id/washes",
+		mw.RequireRole("owner", "manager", "employee"),
+		membershipHandler.RecordWash,
+	)
+	authenticated.GET("/subscriptions/:id/washes",
+		mw.RequireRole("owner", "manager", "employee"),
+		membershipHandler.ListWashUsages,
+	)
+	authenticated.POST("/subscriptions/:id/washes/:usage_id/void",
+		mw.RequireRole("owner", "manager"),
+		membershipHandler.VoidWash,
+	)
+
+	// QR membership cards
+	authenticated.GET("/subscriptions/:id/qr",
+		mw.RequireRole("owner", "manager", "employee"),
+		membershipHandler.IssueQRToken,
+	)
+	authenticated.POST("/qr/check-in",
+		mw.RequireRole("owner", "manager", "employee"),
+		membershipHandler.CheckInWithQR,
+	)
+	authenticated.GET("/qr/keys",
+		mw.RequireRole("owner", "manager", "employee"),
+		membershipHandler.ListQRKeys,
+	)
+	authenticated.POST("/qr/keys/rotate",
+		mw.RequireRole("owner"),
+		membershipHandler.RotateQRKey,
+	)
+
 	// Payments - Mercado Pago
 	authenticated.POST("/payments/preference",
 		mw.RequireRole("owner", "manager"),
@@ -252,4 +444,131 @@ func registerRoutes(
 		mw.RequireRole("owner", "manager"),
 		paymentHandler.RenewManual,
 	)
+
+	// Payments - History
+	authenticated.GET("/payments",
+		mw.RequireRole("owner", "manager"),
+		paymentHandler.ListPayments,
+	)
+	authenticated.GET("/payments/:id",
+		mw.RequireRole("owner", "manager"),
+		paymentHandler.GetPayment,
+	)
+
+	// Payments - Dunning of past_due subscriptions
+	authenticated.GET("/dunning/policy",
+		mw.RequireRole("owner", "manager"),
+		paymentHandler.GetDunningPolicy,
+	)
+	authenticated.PUT("/dunning/policy",
+		mw.RequireRole("owner"),
+		paymentHandler.UpdateDunningPolicy,
+	)
+	authenticated.GET("/dunning/report",
+		mw.RequireRole("owner"),
+		paymentHandler.GetDunningReport,
+	)
+	authenticated.POST("/subscriptions/:id/recovery-link",
+		mw.RequireRole("owner", "manager"),
+		paymentHandler.CreateRecoveryLink,
+	)
+
+	// Payments - Reconciliation report
+	authenticated.GET("/reconciliation/issues",
+		mw.RequireRole("owner"),
+		paymentHandler.ListReconciliationIssues,
+	)
+
+	// Payments - Mercado Pago account of the tenant (OAuth)
+	authenticated.GET("/mercadopago/connect",
+		mw.RequireRole("owner"),
+		verified,
+		paymentHandler.ConnectMPAccount,
+	)
+	authenticated.GET("/mercadopago/account",
+		mw.RequireRole("owner", "manager"),
+		paymentHandler.GetMPAccount,
+	)
+	authenticated.DELETE("/mercadopago/account",
+		mw.RequireRole("owner"),
+		verified,
+		paymentHandler.DisconnectMPAccount,
+	)
+
+	// Payments - Refunds (money goes back out: owner only)
+	authenticated.POST("/payments/:id/refund",
+		mw.RequireRole("owner"),
+		verified,
+		paymentHandler.RefundPayment,
+	)
+
+	// Services catalog
+	authenticated.GET("/services",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.ListServices,
+	)
+	authenticated.GET("/services/:id",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.GetService,
+	)
+	authenticated.POST("/services",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.CreateService,
+	)
+	authenticated.PUT("/services/:id",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.UpdateService,
+	)
+	authenticated.DELETE("/services/:id",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.DeactivateService,
+	)
+
+	// Wash boxes
+	authenticated.GET("/boxes",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.ListBoxes,
+	)
+	authenticated.GET("/boxes/:id",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.GetBox,
+	)
+	authenticated.POST("/boxes",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.CreateBox,
+	)
+	authenticated.PUT("/boxes/:id",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.UpdateBox,
+	)
+	authenticated.PUT("/boxes/:id/services",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.SetBoxServices,
+	)
+	authenticated.DELETE("/boxes/:id",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.DeactivateBox,
+	)
+
+	// Bookings
+	authenticated.GET("/bookings/availability",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.Availability,
+	)
+	authenticated.POST("/bookings",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.CreateBooking,
+	)
+	authenticated.GET("/bookings",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.ListBookings,
+	)
+	authenticated.PATCH("/bookings/:id/status",
+		mw.RequireRole("owner", "manager", "employee"),
+		bookingHandler.UpdateStatus,
+	)
+	authenticated.DELETE("/bookings/:id",
+		mw.RequireRole("owner", "manager"),
+		bookingHandler.CancelBooking,
+	)
 }
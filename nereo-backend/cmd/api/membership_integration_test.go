@@ -0,0 +1,319 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/membership"
+)
+
+func TestWashLimitIsEnforced(t *testing.T) {
+	s := seedTenant(t, "washes")
+	path := "/api/v1/subscriptions/" + s.SubscriptionID.String() + "/washes"
+
+	// The plan allows 4 washes and seedTenant already checked in once.
+	for i := 0; i < 3; i++ {
+		mustCall(t, http.StatusCreated, http.MethodPost, path, s.Token, map[string]any{}, nil)
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, path, s.Token, map[string]any{}, nil)
+
+	// Voiding a check-in gives the wash back.
+	mustCall(t, http.StatusOK, http.MethodPost, path+"/"+s.UsageID.String()+"/void", s.Token,
+		map[string]any{"reason": "checked in by mistake"}, nil)
+	mustCall(t, http.StatusConflict, http.MethodPost, path+"/"+s.UsageID.String()+"/void", s.Token,
+		map[string]any{"reason": "checked in by mistake"}, nil)
+
+	var result struct {
+		WashesUsed      int  `json:"washes_used"`
+		WashesRemaining *int `json:"washes_remaining"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, path, s.Token, map[string]any{}, &result)
+	if result.WashesUsed != 4 || result.WashesRemaining == nil || *result.WashesRemaining != 0 {
+		t.Errorf("after void and check-in: used %d, remaining %v", result.WashesUsed, result.WashesRemaining)
+	}
+
+	var history []struct {
+		VoidedAt *string `json:"voided_at"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, path, s.Token, nil, &history)
+	if len(history) != 5 {
+		t.Errorf("history has %d entries, want 5 (voided included)", len(history))
+	}
+}
+
+func TestConcurrentCheckInsRespectLimit(t *testing.T) {
+	s := seedTenant(t, "checkins")
+	path := "/api/v1/subscriptions/" + s.SubscriptionID.String() + "/washes"
+
+	// 3 washes left; fire more check-ins than that at once.
+	const attempts = 10
+	statuses := make(chan int, attempts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := call(http.MethodPost, path, s.Token, map[string]any{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- resp.Status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if created != 3 {
+		t.Errorf("%d check-ins accepted, want 3", created)
+	}
+}
+
+func TestQRCheckInRejectsReplay(t *testing.T) {
+	s := seedTenant(t, "qr")
+
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/qr/check-in", s.Token,
+		map[string]any{"token": s.QRToken}, nil)
+	// Same code again, e.g. a screenshot shown later that day.
+	mustCall(t, http.StatusConflict, http.MethodPost, "/api/v1/qr/check-in", s.Token,
+		map[string]any{"token": s.QRToken}, nil)
+
+	var fresh struct {
+		Token string `json:"token"`
+	}
+	qrPath := "/api/v1/subscriptions/" + s.SubscriptionID.String() + "/qr"
+	mustCall(t, http.StatusOK, http.MethodGet, qrPath, s.Token, nil, &fresh)
+
+	// Pointing the payload at another subscription breaks the signature.
+	parts := strings.Split(fresh.Token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(payload), s.SubscriptionID.String(), s.CustomerID.String(), 1)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[2]
+	mustCall(t, http.StatusBadRequest, http.MethodPost, "/api/v1/qr/check-in", s.Token,
+		map[string]any{"token": tampered}, nil)
+
+	// A token issued before a rotation keeps working until it expires, and
+	// both keys are published for offline verification.
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/qr/keys/rotate", s.Token, nil, nil)
+
+	var keys []struct {
+		KeyID     int    `json:"key_id"`
+		PublicKey string `json:"public_key"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/qr/keys", s.Token, nil, &keys)
+	if len(keys) != 2 {
+		t.Fatalf("%d keys published, want the active and the retired one", len(keys))
+	}
+	verified := false
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	for _, k := range keys {
+		pub, _ := base64.RawURLEncoding.DecodeString(k.PublicKey)
+		if ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), signature) {
+			verified = true
+		}
+	}
+	if !verified {
+		t.Error("token does not verify offline with the published keys")
+	}
+
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/qr/check-in", s.Token,
+		map[string]any{"token": fresh.Token}, nil)
+}
+
+func TestPauseExtendsPeriodUpToCap(t *testing.T) {
+	s := seedTenant(t, "pause")
+	subPath := "/api/v1/subscriptions/" + s.SubscriptionID.String()
+
+	var subs []struct {
+		ID               string    `json:"id"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/subscriptions?customer_id="+s.CustomerID.String(), s.Token, nil, &subs)
+	if len(subs) != 1 {
+		t.Fatalf("%d subscriptions, want 1", len(subs))
+	}
+	periodEnd := subs[0].CurrentPeriodEnd
+
+	// The seeded plan allows the default 30 days.
+	mustCall(t, http.StatusBadRequest, http.MethodPost, subPath+"/pause", s.Token, map[string]any{"days": 31}, nil)
+	mustCall(t, http.StatusCreated, http.MethodPost, subPath+"/pause", s.Token, map[string]any{"days": 2}, nil)
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/pause", s.Token, map[string]any{}, nil)
+
+	var validation struct {
+		Valid       bool       `json:"valid"`
+		Reason      string     `json:"reason"`
+		PausedUntil *time.Time `json:"paused_until"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/validate", s.Token, nil, &validation)
+	if validation.Valid || validation.Reason != "paused" || validation.PausedUntil == nil {
+		t.Errorf("validate while paused: %+v", validation)
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/washes", s.Token, map[string]any{}, nil)
+
+	// Pretend the pause started 3 days ago: resuming now must only extend
+	// the period by the 2 days allowed.
+	_, err := env.systemDB.Exec(context.Background(), `
+		UPDATE subscription_pauses
+		SET paused_at = paused_at - INTERVAL '3 days', resume_by = resume_by - INTERVAL '3 days'
+		WHERE subscription_id = $1`, s.SubscriptionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resumed struct {
+		Status           string    `json:"status"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	}
+	mustCall(t, http.StatusOK, http.MethodPost, subPath+"/resume", s.Token, nil, &resumed)
+	if resumed.Status != "active" {
+		t.Errorf("status after resume %q", resumed.Status)
+	}
+	if got := resumed.CurrentPeriodEnd.Sub(periodEnd); got != 48*time.Hour {
+		t.Errorf("period extended by %s, want 48h", got)
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/resume", s.Token, nil, nil)
+
+	var pauses []struct {
+		ResumedAt *time.Time `json:"resumed_at"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/pauses", s.Token, nil, &pauses)
+	if len(pauses) != 1 || pauses[0].ResumedAt == nil {
+		t.Errorf("pause history: %+v", pauses)
+	}
+}
+
+func TestCancelledSubscriptionStaysCancelled(t *testing.T) {
+	s := seedTenant(t, "states")
+	subPath := "/api/v1/subscriptions/" + s.SubscriptionID.String()
+
+	mustCall(t, http.StatusCreated, http.MethodPost, subPath+"/pause", s.Token, map[string]any{"days": 5}, nil)
+	mustCall(t, http.StatusOK, http.MethodPost, subPath+"/cancel", s.Token, nil, nil)
+
+	// Neither a manual renewal nor a late MP approval can revive it.
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/renew-manual", s.Token, map[string]any{}, nil)
+	_, err := membership.TransitionStatus(context.Background(), env.systemDB, &membership.StatusChange{
+		TenantID:       s.ID,
+		SubscriptionID: s.SubscriptionID,
+		ToStatus:       membership.StatusActive,
+		Reason:         membership.ReasonPaymentApproved,
+		ActorType:      membership.ActorMercadoPago,
+	})
+	if !errors.Is(err, membership.ErrInvalidTransition) {
+		t.Errorf("late approval on a cancelled subscription: err = %v", err)
+	}
+
+	var history []struct {
+		FromStatus *string `json:"from_status"`
+		ToStatus   string  `json:"to_status"`
+		Reason     string  `json:"reason"`
+		ActorType  string  `json:"actor_type"`
+		ActorID    *string `json:"actor_id"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/history", s.Token, nil, &history)
+
+	want := []struct{ to, reason string }{
+		{"cancelled", "cancelled"},
+		{"paused", "paused"},
+		{"active", "created"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("%d history entries, want %d: %+v", len(history), len(want), history)
+	}
+	for i, w := range want {
+		h := history[i]
+		if h.ToStatus != w.to || h.Reason != w.reason || h.ActorType != "user" || h.ActorID == nil {
+			t.Errorf("history[%d] = %+v, want to=%s reason=%s by a user", i, h, w.to, w.reason)
+		}
+	}
+	if history[2].FromStatus != nil {
+		t.Errorf("creation entry has from_status %q", *history[2].FromStatus)
+	}
+}
+
+func TestPendingSubscriptionFollowsPreapproval(t *testing.T) {
+	s := seedTenant(t, "pending")
+	ctx := context.Background()
+
+	var ownerID uuid.UUID
+	if err := env.systemDB.QueryRow(ctx, "SELECT id FROM users WHERE email = $1", s.Email).Scan(&ownerID); err != nil {
+		t.Fatal(err)
+	}
+
+	// What POST /payments/subscription creates before redirecting the payer.
+	service := membership.NewService(env.systemDB, env.redis, env.cfg.QR, nil)
+	sub, err := service.CreatePendingSubscription(ctx, s.ID, ownerID, uuid.New(), membership.CreateSubscriptionRequest{
+		CustomerID: s.CustomerID,
+		PlanID:     s.PlanID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subPath := "/api/v1/subscriptions/" + sub.ID.String()
+
+	var validation struct {
+		Valid  bool   `json:"valid"`
+		Reason string `json:"reason"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/validate", s.Token, nil, &validation)
+	if validation.Valid || validation.Reason != "pending" {
+		t.Errorf("pending subscription validation: %+v", validation)
+	}
+	// Only Mercado Pago can activate it.
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/renew-manual", s.Token, map[string]any{}, nil)
+
+	fromMP := func(to, reason string) {
+		t.Helper()
+		_, err := membership.TransitionStatus(ctx, env.systemDB, &membership.StatusChange{
+			TenantID:       s.ID,
+			SubscriptionID: sub.ID,
+			ToStatus:       to,
+			Reason:         reason,
+			ActorType:      membership.ActorMercadoPago,
+		})
+		if err != nil {
+			t.Fatalf("%s (%s): %v", to, reason, err)
+		}
+	}
+	fromMP(membership.StatusActive, membership.ReasonPreapprovalAuthorized)
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/validate", s.Token, nil, &validation)
+	if !validation.Valid {
+		t.Errorf("authorized subscription is not valid: %+v", validation)
+	}
+
+	// Paused and resumed by the payer in Mercado Pago, without a pause of ours.
+	fromMP(membership.StatusPaused, membership.ReasonPreapprovalPaused)
+	fromMP(membership.StatusActive, membership.ReasonPreapprovalAuthorized)
+	fromMP(membership.StatusCancelled, membership.ReasonPreapprovalCancelled)
+
+	var history []struct {
+		ToStatus string `json:"to_status"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, subPath+"/history", s.Token, nil, &history)
+	if len(history) != 5 || history[len(history)-1].ToStatus != membership.StatusPending {
+		t.Errorf("history: %+v", history)
+	}
+}
@@ -0,0 +1,1109 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nereo-ar/backend/internal/membership"
+	"github.com/nereo-ar/backend/internal/payment"
+	"github.com/nereo-ar/backend/internal/payment/mptest"
+	"github.com/nereo-ar/backend/pkg/money"
+	"github.com/nereo-ar/backend/pkg/sealbox"
+)
+
+// postWebhook delivers a Mercado Pago notification the way MP does, without
+// a bearer token.
+func postWebhook(t *testing.T, requestID, body string) int {
+	t.Helper()
+	resp, err := callWithHeaders(http.MethodPost, "/api/v1/webhooks/mercadopago", "",
+		map[string]string{"x-request-id": requestID}, json.RawMessage(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+// adminCall performs a request against the platform admin endpoints.
+func adminCall(t *testing.T, method, path, adminToken string) *apiResponse {
+	t.Helper()
+	headers := map[string]string{}
+	if adminToken != "" {
+		headers["X-Admin-Token"] = adminToken
+	}
+	resp, err := callWithHeaders(method, path, "", headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// postRefund asks to refund a payment of the tenant.
+func postRefund(t *testing.T, s *seededTenant, paymentID uuid.UUID, body any) *apiResponse {
+	t.Helper()
+	resp, err := call(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/refund", s.Token, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestWebhookInboxStoresAndReplays(t *testing.T) {
+	ctx := context.Background()
+	requestID := uuid.NewString()
+	body := `{"action":"payment.created","type":"payment","data":{"id":"` + requestID[:8] + `"}}`
+
+	// A redelivery of the same notification is acknowledged but stored once.
+	for range 2 {
+		if status := postWebhook(t, requestID, body); status != http.StatusOK {
+			t.Fatalf("webhook status %d, want 200", status)
+		}
+	}
+	var count int
+	err := env.systemDB.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_inbox WHERE request_id = $1`, requestID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d inbox rows for one notification, want 1", count)
+	}
+	var id uuid.UUID
+	err = env.systemDB.QueryRow(ctx, `SELECT id FROM webhook_inbox WHERE request_id = $1`, requestID).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/admin/webhooks/" + id.String()
+
+	if resp := adminCall(t, http.MethodGet, path, ""); resp.Status != http.StatusUnauthorized {
+		t.Errorf("without admin token: status %d, want 401", resp.Status)
+	}
+
+	resp := adminCall(t, http.MethodGet, path, env.cfg.Admin.Token)
+	var delivery payment.WebhookDelivery
+	if err := json.Unmarshal(resp.Data, &delivery); resp.Status != http.StatusOK || err != nil {
+		t.Fatalf("get delivery: status %d: %s", resp.Status, resp.Raw)
+	}
+	if delivery.Status != payment.WebhookPending || delivery.Topic != "payment" || delivery.ResourceID != requestID[:8] {
+		t.Errorf("stored delivery: %+v", delivery)
+	}
+
+	// Mercado Pago is unreachable in tests: processing must report the
+	// failure so the worker retries instead of dropping the notification.
+	processor := payment.NewWebhookProcessor(payment.NewClients(env.cfg.MercadoPago, env.systemDB), payment.NewRepository(env.systemDB))
+	if err := processor.Process(ctx, &delivery); err == nil {
+		t.Error("processing with MP down succeeded")
+	}
+
+	if resp := adminCall(t, http.MethodPost, path+"/replay", env.cfg.Admin.Token); resp.Status != http.StatusConflict {
+		t.Errorf("replay of a pending delivery: status %d, want 409", resp.Status)
+	}
+
+	_, err = env.systemDB.Exec(ctx,
+		`UPDATE webhook_inbox SET status = 'dead', attempts = 10, last_error = 'gave up' WHERE id = $1`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp = adminCall(t, http.MethodGet, "/api/v1/admin/webhooks?status=dead&per_page=100", env.cfg.Admin.Token)
+	if resp.Status != http.StatusOK || !strings.Contains(string(resp.Raw), id.String()) {
+		t.Errorf("dead letter list does not include %s: %s", id, resp.Raw)
+	}
+
+	resp = adminCall(t, http.MethodPost, path+"/replay", env.cfg.Admin.Token)
+	if err := json.Unmarshal(resp.Data, &delivery); resp.Status != http.StatusOK || err != nil {
+		t.Fatalf("replay: status %d: %s", resp.Status, resp.Raw)
+	}
+	if delivery.Status != payment.WebhookPending || delivery.Attempts != 0 {
+		t.Errorf("replayed delivery: status %s, %d attempts", delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestIdempotencyKeyReplaysCheckout(t *testing.T) {
+	s := seedTenant(t, "idem")
+	ctx := context.Background()
+	key := uuid.NewString()
+
+	body := payment.CreateSubscriptionMPRequest{
+		PlanID:     s.PlanID,
+		CustomerID: s.CustomerID,
+		PayerEmail: "payer@idem.test",
+	}
+	raw, _ := json.Marshal(body)
+	sum := sha256.Sum256(raw)
+
+	// A first request that reached MP, as stored by the handler.
+	stored := `{"subscription_id":"` + s.SubscriptionID.String() + `","preapproval_id":"pre-123","init_point":"https://mp.test/pre-123","sandbox_init_point":"","status":"pending"}`
+	_, err := env.systemDB.Exec(ctx, `
+		INSERT INTO mp_requests (tenant_id, operation, idempotency_key, request_hash, mp_idempotency_key, subscription_id, mp_id, response)
+		VALUES ($1, 'preapproval', $2, $3, $4, $5, 'pre-123', $6)`,
+		s.ID, key, hex.EncodeToString(sum[:]), "preapproval-"+s.SubscriptionID.String(), s.SubscriptionID, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before int
+	if err := env.systemDB.QueryRow(ctx, "SELECT COUNT(*) FROM subscriptions WHERE tenant_id = $1", s.ID).Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	// MP is unreachable: only a replay can answer 201.
+	headers := map[string]string{"Idempotency-Key": key}
+	resp, err := callWithHeaders(http.MethodPost, "/api/v1/payments/subscription", s.Token, headers, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed struct {
+		PreapprovalID string `json:"preapproval_id"`
+	}
+	if err := json.Unmarshal(resp.Data, &replayed); resp.Status != http.StatusCreated || err != nil || replayed.PreapprovalID != "pre-123" {
+		t.Fatalf("replay: status %d: %s", resp.Status, resp.Raw)
+	}
+
+	var after int
+	if err := env.systemDB.QueryRow(ctx, "SELECT COUNT(*) FROM subscriptions WHERE tenant_id = $1", s.ID).Scan(&after); err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("replay created %d subscriptions", after-before)
+	}
+
+	body.PayerEmail = "other@idem.test"
+	resp, err = callWithHeaders(http.MethodPost, "/api/v1/payments/subscription", s.Token, headers, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusConflict {
+		t.Errorf("same key with another body: status %d, want 409", resp.Status)
+	}
+}
+
+func TestIdempotencyKeyRetriesAfterProviderFailure(t *testing.T) {
+	s := seedTenant(t, "idemretry")
+	ctx := context.Background()
+
+	provider := mptest.NewProvider("integration-webhook-secret")
+	mp := mptest.NewServer(provider)
+	defer mp.Close()
+	cfg := *env.cfg
+	cfg.MercadoPago.BaseURL = mp.URL
+	api := httptest.NewServer(newRouter(&cfg, env.appDB, env.systemDB, env.redis))
+	defer api.Close()
+
+	// MP creates the object but its response never arrives: the request
+	// fails and is rolled back, and the front end retries with the same key.
+	checkout := func(path string, body any, out any) {
+		t.Helper()
+		headers := map[string]string{"Idempotency-Key": uuid.NewString()}
+		mp.LoseResponses(true)
+		resp, err := callURL(api.URL, http.MethodPost, path, s.Token, headers, body)
+		mp.LoseResponses(false)
+		if err != nil || resp.Status != http.StatusBadGateway {
+			t.Fatalf("%s with MP timing out: %v %+v", path, err, resp)
+		}
+		resp, err = callURL(api.URL, http.MethodPost, path, s.Token, headers, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(resp.Data, out); resp.Status != http.StatusCreated || err != nil {
+			t.Fatalf("%s retry: status %d: %s", path, resp.Status, resp.Raw)
+		}
+	}
+
+	var pref payment.CreatePreferenceResponse
+	checkout("/api/v1/payments/preference", payment.CreatePreferenceRequest{PlanID: s.PlanID, CustomerID: s.CustomerID}, &pref)
+	var pre payment.CreateSubscriptionMPResponse
+	checkout("/api/v1/payments/subscription", payment.CreateSubscriptionMPRequest{
+		PlanID: s.PlanID, CustomerID: s.CustomerID, PayerEmail: "payer@idemretry.test",
+	}, &pre)
+
+	if preferences, preapprovals := provider.Objects(); preferences != 1 || preapprovals != 1 {
+		t.Errorf("MP has %d preferences and %d preapprovals, want one of each", preferences, preapprovals)
+	}
+
+	// The object MP kept points at the subscription of the retry.
+	paid, err := provider.PayPreference(pref.PreferenceID, "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.ExternalReference != pref.SubscriptionID.String() {
+		t.Errorf("preference pays subscription %s, want %s", paid.ExternalReference, pref.SubscriptionID)
+	}
+	processor := payment.NewWebhookProcessor(
+		payment.NewClientsWithProvider(cfg.MercadoPago, env.systemDB, provider), payment.NewRepository(env.systemDB))
+	n := provider.Notify("payment", fmt.Sprint(paid.ID))
+	if err := processor.Process(ctx, &payment.WebhookDelivery{Topic: "payment", ResourceID: n.Query.Get("data.id"), Payload: n.Body}); err != nil {
+		t.Fatalf("process payment: %v", err)
+	}
+	var status string
+	if err := env.systemDB.QueryRow(ctx, `SELECT status FROM subscriptions WHERE id = $1`, pref.SubscriptionID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != membership.StatusActive {
+		t.Errorf("subscription %s after the payment, want active", status)
+	}
+
+	info, err := provider.GetPreapproval(ctx, pre.PreapprovalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ExternalReference != pre.SubscriptionID.String() {
+		t.Errorf("preapproval is for subscription %s, want %s", info.ExternalReference, pre.SubscriptionID)
+	}
+}
+
+func TestRefundChecksRefundableAmount(t *testing.T) {
+	s := seedTenant(t, "refund")
+	ctx := context.Background()
+
+	if resp := postRefund(t, s, s.PaymentID, map[string]any{}); resp.Status != http.StatusConflict {
+		t.Errorf("refund of a manual payment: status %d, want 409", resp.Status)
+	}
+
+	// An approved MP charge of 15000.00, 5000.00 of it already refunded.
+	var chargeID uuid.UUID
+	err := env.systemDB.QueryRow(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', $3, 'partially_refunded', 1500000, '{}')
+		RETURNING id`, s.ID, s.SubscriptionID, uuid.NewString()).Scan(&chargeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, event_type, parent_event_id, mp_refund_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', 'refund', $3, $4, 'approved', 500000, '{}')`,
+		s.ID, s.SubscriptionID, chargeID, uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := postRefund(t, s, chargeID, map[string]any{"amount_cents": 1000001}); resp.Status != http.StatusBadRequest {
+		t.Errorf("refund above what is left: status %d, want 400: %s", resp.Status, resp.Raw)
+	}
+
+	// Mercado Pago is unreachable in tests: nothing may be recorded.
+	if resp := postRefund(t, s, chargeID, map[string]any{"amount_cents": 1000000}); resp.Status != http.StatusBadGateway {
+		t.Errorf("refund with MP down: status %d, want 502: %s", resp.Status, resp.Raw)
+	}
+	var refunds int
+	var status string
+	err = env.systemDB.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM payment_events WHERE parent_event_id = $1), status
+		FROM payment_events WHERE id = $1`, chargeID).Scan(&refunds, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunds != 1 || status != "partially_refunded" {
+		t.Errorf("after a failed refund: %d refunds, charge %s", refunds, status)
+	}
+
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, event_type, parent_event_id, mp_refund_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', 'refund', $3, $4, 'approved', 1000000, '{}')`,
+		s.ID, s.SubscriptionID, chargeID, uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := postRefund(t, s, chargeID, nil); resp.Status != http.StatusConflict {
+		t.Errorf("refund of a fully refunded charge: status %d, want 409", resp.Status)
+	}
+}
+
+func TestPaymentHistoryFiltersAndTotals(t *testing.T) {
+	s := seedTenant(t, "ledger")
+	ctx := context.Background()
+
+	// Besides the seeded manual payment: an MP charge with a partial refund.
+	var chargeID uuid.UUID
+	err := env.systemDB.QueryRow(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', $3, 'partially_refunded', 1000000, '{"id": 42, "status": "approved"}')
+		RETURNING id`, s.ID, s.SubscriptionID, uuid.NewString()).Scan(&chargeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, event_type, parent_event_id, mp_refund_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', 'refund', $3, $4, 'approved', 200000, '{}')`,
+		s.ID, s.SubscriptionID, chargeID, uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list payment.PaymentList
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/payments?customer_id="+s.CustomerID.String(), s.Token, nil, &list)
+	if len(list.Payments) != 3 {
+		t.Fatalf("%d payments, want 3", len(list.Payments))
+	}
+	want := map[string]payment.PaymentTotal{
+		"charge/approved":           {Count: 1, AmountCents: 1500000},
+		"charge/partially_refunded": {Count: 1, AmountCents: 1000000},
+		"refund/approved":           {Count: 1, AmountCents: 200000},
+	}
+	for _, total := range list.Totals {
+		w := want[total.EventType+"/"+total.Status]
+		if total.Count != w.Count || total.AmountCents != w.AmountCents {
+			t.Errorf("total %s/%s: %d events, %d cents", total.EventType, total.Status, total.Count, total.AmountCents)
+		}
+	}
+	if len(list.Totals) != len(want) {
+		t.Errorf("%d totals, want %d", len(list.Totals), len(want))
+	}
+
+	var ownerID uuid.UUID
+	if err := env.systemDB.QueryRow(ctx, "SELECT id FROM users WHERE email = $1", s.Email).Scan(&ownerID); err != nil {
+		t.Fatal(err)
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/payments?source=manual&recorded_by="+ownerID.String(), s.Token, nil, &list)
+	if len(list.Payments) != 1 || list.Payments[0].ID != s.PaymentID {
+		t.Errorf("manual payments by the owner: %+v", list.Payments)
+	}
+
+	// Dates are days in the tenant's timezone; two days of margin either way.
+	later := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/payments?from="+later, s.Token, nil, &list)
+	if len(list.Payments) != 0 {
+		t.Errorf("%d payments from %s", len(list.Payments), later)
+	}
+	earlier := time.Now().AddDate(0, 0, -2).Format("2006-01-02")
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/payments?type=charge&from="+earlier+"&to="+later, s.Token, nil, &list)
+	if len(list.Payments) != 2 {
+		t.Errorf("%d charges between %s and %s, want 2", len(list.Payments), earlier, later)
+	}
+
+	mustCall(t, http.StatusBadRequest, http.MethodGet, "/api/v1/payments?from=31-12-2026", s.Token, nil, nil)
+	mustCall(t, http.StatusBadRequest, http.MethodGet, "/api/v1/payments?type=bonus", s.Token, nil, nil)
+
+	var detail struct {
+		payment.PaymentDetail
+		Payload struct {
+			ID int `json:"id"`
+		} `json:"payload"`
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/payments/"+chargeID.String(), s.Token, nil, &detail)
+	if detail.Payload.ID != 42 || len(detail.Reversals) != 1 || detail.CustomerName == nil {
+		t.Errorf("payment detail: %+v", detail)
+	}
+}
+
+func TestReconciliationReport(t *testing.T) {
+	s := seedTenant(t, "recon")
+	ctx := context.Background()
+
+	// An MP charge recorded a cent short (the old float truncation) and one
+	// the reconciliation had to insert.
+	var chargeID uuid.UUID
+	mpPaymentID := uuid.NewString()
+	err := env.systemDB.QueryRow(ctx, `
+		INSERT INTO payment_events (tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, raw_payload)
+		VALUES ($1, $2, 'mercadopago', $3, 'approved', 1499999, '{}')
+		RETURNING id`, s.ID, s.SubscriptionID, mpPaymentID).Scan(&chargeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO reconciliation_issues (tenant_id, kind, mp_payment_id, payment_event_id, payment_date, mp_status,
+			recorded_status, mp_amount_cents, recorded_amount_cents)
+		VALUES ($1, 'amount_mismatch', $2, $3, CURRENT_DATE - 1, 'approved', 'approved', 1500000, 1499999)`,
+		s.ID, mpPaymentID, chargeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO reconciliation_issues (tenant_id, kind, mp_payment_id, payment_date, mp_status, mp_amount_cents, resolved_at)
+		VALUES ($1, 'missing_event', $2, CURRENT_DATE - 1, 'approved', 1500000, NOW())`,
+		s.ID, uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issues []payment.ReconciliationIssue
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/reconciliation/issues?status=open", s.Token, nil, &issues)
+	if len(issues) != 1 || issues[0].Kind != payment.IssueAmountMismatch || issues[0].PaymentEventID == nil || *issues[0].PaymentEventID != chargeID {
+		t.Errorf("open issues: %+v", issues)
+	}
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/reconciliation/issues", s.Token, nil, &issues)
+	if len(issues) != 2 {
+		t.Errorf("%d issues, want 2", len(issues))
+	}
+	mustCall(t, http.StatusBadRequest, http.MethodGet, "/api/v1/reconciliation/issues?kind=typo", s.Token, nil, nil)
+
+	reconcile := func(token string, date string) int {
+		headers := map[string]string{}
+		if token != "" {
+			headers["X-Admin-Token"] = token
+		}
+		resp, err := callWithHeaders(http.MethodPost, "/api/v1/admin/reconciliation", "", headers, map[string]string{"date": date})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	if status := reconcile("", yesterday); status != http.StatusUnauthorized {
+		t.Errorf("reconcile without admin token: status %d, want 401", status)
+	}
+	if status := reconcile(env.cfg.Admin.Token, time.Now().AddDate(0, 0, 2).Format("2006-01-02")); status != http.StatusBadRequest {
+		t.Errorf("reconcile a future day: status %d, want 400", status)
+	}
+	// Mercado Pago is unreachable in tests.
+	if status := reconcile(env.cfg.Admin.Token, yesterday); status != http.StatusBadGateway {
+		t.Errorf("reconcile with MP down: status %d, want 502", status)
+	}
+}
+
+func TestMPAccountConnection(t *testing.T) {
+	s := seedTenant(t, "mpoauth")
+	ctx := context.Background()
+
+	var connect payment.MPConnectResponse
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/mercadopago/connect", s.Token, nil, &connect)
+	authURL, err := url.Parse(connect.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := authURL.Query().Get("state")
+	if authURL.Host != "auth.mercadopago.com" || authURL.Query().Get("client_id") != env.cfg.MercadoPago.ClientID || state == "" {
+		t.Fatalf("authorization url: %s", connect.AuthorizationURL)
+	}
+	if strings.Contains(state, s.ID.String()) {
+		t.Errorf("state exposes the tenant id: %s", state)
+	}
+
+	callback := func(query string) int {
+		resp, err := call(http.MethodGet, "/api/v1/mercadopago/oauth/callback?"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	if status := callback("code=abc&state=" + url.QueryEscape(state[:len(state)-2]+"xx")); status != http.StatusBadRequest {
+		t.Errorf("callback with a forged state: status %d, want 400", status)
+	}
+	if status := callback("state=" + url.QueryEscape(state)); status != http.StatusBadRequest {
+		t.Errorf("callback without code: status %d, want 400", status)
+	}
+	// Mercado Pago is unreachable in tests: the code cannot be exchanged.
+	if status := callback("code=abc&state=" + url.QueryEscape(state)); status != http.StatusBadGateway {
+		t.Errorf("callback with MP down: status %d, want 502", status)
+	}
+
+	var status payment.MPAccountStatus
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/mercadopago/account", s.Token, nil, &status)
+	if status.Connected {
+		t.Errorf("account connected after a failed exchange: %+v", status.Account)
+	}
+
+	// A connected account, as the callback stores it.
+	box, err := sealbox.New(env.cfg.MercadoPago.TokenEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessEnc, _ := box.Seal([]byte("APP_USR-tenant-access"))
+	refreshEnc, _ := box.Seal([]byte("TG-tenant-refresh"))
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO mp_accounts (tenant_id, mp_user_id, access_token_enc, refresh_token_enc, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + INTERVAL '180 days')`,
+		s.ID, time.Now().UnixNano(), accessEnc, refreshEnc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := call(http.MethodGet, "/api/v1/mercadopago/account", s.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(resp.Data, &status); err != nil || !status.Connected || status.Account == nil {
+		t.Errorf("account status: %s", resp.Raw)
+	}
+	if strings.Contains(string(resp.Raw), "token") {
+		t.Errorf("account status exposes the tokens: %s", resp.Raw)
+	}
+
+	// A live preapproval charges on the account, so it cannot be disconnected.
+	if _, err := env.systemDB.Exec(ctx, "UPDATE subscriptions SET mp_subscription_id = $1 WHERE id = $2",
+		uuid.NewString(), s.SubscriptionID); err != nil {
+		t.Fatal(err)
+	}
+	mustCall(t, http.StatusConflict, http.MethodDelete, "/api/v1/mercadopago/account", s.Token, nil, nil)
+	if _, err := env.systemDB.Exec(ctx, "UPDATE subscriptions SET status = 'cancelled' WHERE id = $1", s.SubscriptionID); err != nil {
+		t.Fatal(err)
+	}
+
+	mustCall(t, http.StatusNoContent, http.MethodDelete, "/api/v1/mercadopago/account", s.Token, nil, nil)
+	mustCall(t, http.StatusNotFound, http.MethodDelete, "/api/v1/mercadopago/account", s.Token, nil, nil)
+}
+
+func TestConnectedAccountWebhooksStayInTheirTenant(t *testing.T) {
+	owner := seedTenant(t, "mpowner")
+	victim := seedTenant(t, "mpvictim")
+	ctx := context.Background()
+
+	// The owner tenant's connected account, served by the mock.
+	provider := mptest.NewProvider("")
+	provider.UserID = time.Now().UnixNano()
+	mp := mptest.NewServer(provider)
+	defer mp.Close()
+	cfg := *env.cfg
+	cfg.MercadoPago.BaseURL = mp.URL
+	box, err := sealbox.New(cfg.MercadoPago.TokenEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessEnc, _ := box.Seal([]byte("APP_USR-owner-access"))
+	refreshEnc, _ := box.Seal([]byte("TG-owner-refresh"))
+	_, err = env.systemDB.Exec(ctx, `
+		INSERT INTO mp_accounts (tenant_id, mp_user_id, access_token_enc, refresh_token_enc, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + INTERVAL '180 days')`,
+		owner.ID, provider.UserID, accessEnc, refreshEnc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor := payment.NewWebhookProcessor(payment.NewClients(cfg.MercadoPago, env.systemDB), payment.NewRepository(env.systemDB))
+	process := func(topic, resourceID string) error {
+		n := provider.Notify(topic, resourceID)
+		return processor.Process(ctx, &payment.WebhookDelivery{Topic: topic, ResourceID: resourceID, Payload: n.Body})
+	}
+	pay := func(subID uuid.UUID) *payment.PaymentInfo {
+		t.Helper()
+		pref, err := provider.CreatePreference(ctx, &payment.PreferenceRequest{
+			Items:             []payment.PreferenceItem{{Title: "Plan", Quantity: 1, UnitPrice: money.FromCents(1500000), CurrencyID: money.ARS}},
+			ExternalReference: subID.String(),
+		}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paid, err := provider.PayPreference(pref.ID, "approved")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paid
+	}
+	recordedTenant := func(paymentID int) (tenantID uuid.UUID) {
+		t.Helper()
+		err := env.systemDB.QueryRow(ctx, `SELECT tenant_id FROM payment_events WHERE mp_payment_id = $1`, fmt.Sprint(paymentID)).Scan(&tenantID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			t.Fatal(err)
+		}
+		return tenantID
+	}
+
+	// A payment on the owner's account for the victim's subscription.
+	forged := pay(victim.SubscriptionID)
+	if err := process("payment", fmt.Sprint(forged.ID)); err == nil {
+		t.Error("payment for another tenant's subscription was processed")
+	}
+	if tenantID := recordedTenant(forged.ID); tenantID != uuid.Nil {
+		t.Errorf("payment for another tenant's subscription recorded for tenant %s", tenantID)
+	}
+
+	// A preapproval pointing at the victim's subscription does not take it over.
+	pre, err := provider.CreatePreapproval(ctx, &payment.PreapprovalRequest{
+		Reason:            "Plan",
+		AutoRecurring:     payment.AutoRecurring{Frequency: 1, FrequencyType: "months", TransactionAmount: money.FromCents(1500000), CurrencyID: money.ARS},
+		ExternalReference: victim.SubscriptionID.String(),
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.AuthorizePreapproval(pre.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := process("subscription_preapproval", pre.ID); err == nil {
+		t.Error("preapproval for another tenant's subscription was processed")
+	}
+	var mpSubscriptionID *string
+	if err := env.systemDB.QueryRow(ctx, `SELECT mp_subscription_id FROM subscriptions WHERE id = $1`, victim.SubscriptionID).Scan(&mpSubscriptionID); err != nil {
+		t.Fatal(err)
+	}
+	if mpSubscriptionID != nil {
+		t.Errorf("victim's subscription linked to preapproval %s", *mpSubscriptionID)
+	}
+
+	// The account's own subscriptions are still paid through it.
+	own := pay(owner.SubscriptionID)
+	if err := process("payment", fmt.Sprint(own.ID)); err != nil {
+		t.Fatalf("process own payment: %v", err)
+	}
+	if tenantID := recordedTenant(own.ID); tenantID != owner.ID {
+		t.Errorf("own payment recorded for tenant %s, want %s", tenantID, owner.ID)
+	}
+}
+
+func TestCheckoutWebhookActivatesSubscription(t *testing.T) {
+	s := seedTenant(t, "mpflow")
+	ctx := context.Background()
+
+	// The API against an in-memory Mercado Pago, with signed webhooks.
+	provider := mptest.NewProvider("integration-webhook-secret")
+	mp := mptest.NewServer(provider)
+	defer mp.Close()
+
+	cfg := *env.cfg
+	cfg.MercadoPago.BaseURL = mp.URL
+	cfg.MercadoPago.WebhookSecret = provider.WebhookSecret
+	api := httptest.NewServer(newRouter(&cfg, env.appDB, env.systemDB, env.redis))
+	defer api.Close()
+
+	resp, err := callURL(api.URL, http.MethodPost, "/api/v1/payments/subscription", s.Token, nil, payment.CreateSubscriptionMPRequest{
+		PlanID:     s.PlanID,
+		CustomerID: s.CustomerID,
+		PayerEmail: "payer@mpflow.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checkout payment.CreateSubscriptionMPResponse
+	if err := json.Unmarshal(resp.Data, &checkout); resp.Status != http.StatusCreated || err != nil {
+		t.Fatalf("checkout: status %d: %s", resp.Status, resp.Raw)
+	}
+	if tokens := mp.Tokens(); len(tokens) != 1 || tokens[0] != cfg.MercadoPago.AccessToken {
+		t.Errorf("checkout called MP with tokens %v, want the platform one", tokens)
+	}
+
+	// The payer authorizes the preapproval and MP charges the first period.
+	if err := provider.AuthorizePreapproval(checkout.PreapprovalID); err != nil {
+		t.Fatal(err)
+	}
+	charge, err := provider.ChargePreapproval(checkout.PreapprovalID, "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor := payment.NewWebhookProcessor(
+		payment.NewClientsWithProvider(cfg.MercadoPago, env.systemDB, provider), payment.NewRepository(env.systemDB))
+	deliver := func(topic, resourceID string) {
+		t.Helper()
+		n := provider.Notify(topic, resourceID)
+		path := n.Path("/api/v1/webhooks/mercadopago")
+
+		unsigned := map[string]string{"x-request-id": n.Headers["x-request-id"]}
+		resp, err := callURL(api.URL, http.MethodPost, path, "", unsigned, json.RawMessage(n.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusUnauthorized {
+			t.Errorf("unsigned %s notification: status %d, want 401", topic, resp.Status)
+		}
+
+		resp, err = callURL(api.URL, http.MethodPost, path, "", n.Headers, json.RawMessage(n.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusOK {
+			t.Fatalf("signed %s notification: status %d: %s", topic, resp.Status, resp.Raw)
+		}
+
+		var id uuid.UUID
+		err = env.systemDB.QueryRow(ctx, `SELECT id FROM webhook_inbox WHERE request_id = $1`, n.Headers["x-request-id"]).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp = adminCall(t, http.MethodGet, "/api/v1/admin/webhooks/"+id.String(), env.cfg.Admin.Token)
+		var delivery payment.WebhookDelivery
+		if err := json.Unmarshal(resp.Data, &delivery); resp.Status != http.StatusOK || err != nil {
+			t.Fatalf("get delivery: status %d: %s", resp.Status, resp.Raw)
+		}
+		if err := processor.Process(ctx, &delivery); err != nil {
+			t.Fatalf("process %s notification: %v", topic, err)
+		}
+	}
+
+	deliver("subscription_preapproval", checkout.PreapprovalID)
+	var status string
+	err = env.systemDB.QueryRow(ctx, `SELECT status FROM subscriptions WHERE id = $1`, checkout.SubscriptionID).Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "active" {
+		t.Errorf("subscription %s after the preapproval was authorized, want active", status)
+	}
+
+	deliver("subscription_authorized_payment", fmt.Sprint(charge.ID))
+	var amount int
+	err = env.systemDB.QueryRow(ctx, `
+		SELECT amount_cents FROM payment_events
+		WHERE subscription_id = $1 AND event_type = 'charge' AND status = 'approved' AND mp_payment_id = $2`,
+		checkout.SubscriptionID, fmt.Sprint(charge.Payment.ID)).Scan(&amount)
+	if err != nil {
+		t.Fatalf("approved charge not recorded: %v", err)
+	}
+	if amount != 1500000 {
+		t.Errorf("charge of %d cents, want the plan price 1500000", amount)
+	}
+}
+
+func TestDunningFollowsTenantPolicy(t *testing.T) {
+	s := seedTenant(t, "dunning")
+	ctx := context.Background()
+	subPath := "/api/v1/subscriptions/" + s.SubscriptionID.String()
+
+	_, err := membership.TransitionStatus(ctx, env.systemDB, &membership.StatusChange{
+		TenantID:       s.ID,
+		SubscriptionID: s.SubscriptionID,
+		ToStatus:       membership.StatusPastDue,
+		Reason:         membership.ReasonPaymentRejected,
+		ActorType:      membership.ActorMercadoPago,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default policy keeps past_due subscriptions from washing.
+	var policy payment.DunningPolicy
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/dunning/policy", s.Token, nil, &policy)
+	if policy.GraceDays != 7 || policy.AllowWashesPastDue || policy.UpdatedAt != nil {
+		t.Errorf("default policy: %+v", policy)
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/washes", s.Token, map[string]any{}, nil)
+
+	mustCall(t, http.StatusBadRequest, http.MethodPut, "/api/v1/dunning/policy", s.Token, map[string]any{
+		"grace_days": 5, "reminder_days": []int{5},
+	}, nil)
+	mustCall(t, http.StatusOK, http.MethodPut, "/api/v1/dunning/policy", s.Token, map[string]any{
+		"grace_days": 5, "allow_washes_past_due": true, "reminder_days": []int{3, 1, 3},
+	}, &policy)
+	if len(policy.ReminderDays) != 2 || policy.ReminderDays[0] != 1 || policy.ReminderDays[1] != 3 {
+		t.Errorf("reminder days %v, want [1 3]", policy.ReminderDays)
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, subPath+"/washes", s.Token, map[string]any{}, nil)
+
+	// Mercado Pago is unreachable in tests.
+	if resp, err := call(http.MethodPost, subPath+"/recovery-link", s.Token, nil); err != nil || resp.Status != http.StatusBadGateway {
+		t.Errorf("recovery link with MP down: %v %+v", err, resp)
+	}
+
+	messages := env.redis.Subscribe(ctx, "subscription:past_due:"+s.ID.String())
+	defer messages.Close()
+	if _, err := messages.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := mptest.NewProvider("")
+	dunning := payment.NewDunning(payment.NewRepository(env.systemDB),
+		payment.NewClientsWithProvider(env.cfg.MercadoPago, env.systemDB, provider), env.redis)
+
+	// Other tests leave past_due subscriptions behind: only this tenant's
+	// notifications are checked.
+	now := time.Now()
+	var link string // recovery link of the payment failed notification
+	steps := []struct {
+		after time.Duration
+		want  string // kind of the notification published, "" for none
+	}{
+		{0, payment.DunningPaymentFailed},
+		{time.Hour, ""},
+		{25 * time.Hour, payment.DunningReminder},
+		{49 * time.Hour, ""},
+		{73 * time.Hour, payment.DunningReminder},
+		{5*24*time.Hour + time.Hour, payment.DunningCancelled},
+	}
+	for _, step := range steps {
+		if err := dunning.Run(ctx, now.Add(step.after)); err != nil {
+			t.Logf("dunning run at +%s: %v", step.after, err)
+		}
+
+		var n payment.DunningNotification
+		select {
+		case msg := <-messages.Channel():
+			if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+		}
+		if n.Kind != step.want {
+			t.Errorf("at +%s: notification %q, want %q", step.after, n.Kind, step.want)
+		}
+		if n.Kind == payment.DunningPaymentFailed {
+			if n.CheckoutURL == nil || n.AmountCents != 1500000 {
+				t.Fatalf("payment failed notification without checkout link or amount: %+v", n)
+			}
+			link = *n.CheckoutURL
+		}
+	}
+
+	var status string
+	if err := env.systemDB.QueryRow(ctx, `SELECT status FROM subscriptions WHERE id = $1`, s.SubscriptionID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "cancelled" {
+		t.Errorf("subscription %s after the grace period, want cancelled", status)
+	}
+	mustCall(t, http.StatusConflict, http.MethodPost, subPath+"/recovery-link", s.Token, nil, nil)
+
+	// The recovery link expires with the grace period: a cancelled
+	// subscription cannot be paid.
+	provider.Now = func() time.Time { return now.Add(5*24*time.Hour + time.Hour) }
+	if _, err := provider.PayPreference(strings.TrimPrefix(link, "https://mptest.local/checkout/"), "approved"); err == nil {
+		t.Error("recovery link paid after the grace period")
+	}
+	provider.Now = nil
+
+	from, to := now.AddDate(0, 0, -1).Format("2006-01-02"), now.AddDate(0, 0, 1).Format("2006-01-02")
+	var report payment.DunningReport
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/dunning/report?from="+from+"&to="+to, s.Token, nil, &report)
+	if report.Lost.Count != 1 || report.Lost.AmountCents != 1500000 || report.Recovered.Count != 0 || report.RecoveryRate != 0 {
+		t.Errorf("dunning report: %+v", report)
+	}
+	mustCall(t, http.StatusBadRequest, http.MethodGet, "/api/v1/dunning/report?from="+to+"&to="+from, s.Token, nil, nil)
+
+	// Paying the recovery link within the grace period reactivates a
+	// subscription without a preapproval with a new period, even when the
+	// one it was in has ended.
+	var recovered membership.Subscription
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/subscriptions", s.Token, map[string]any{
+		"customer_id": s.CustomerID, "plan_id": s.PlanID, "payment_method": "manual",
+	}, &recovered)
+	_, err = membership.TransitionStatus(ctx, env.systemDB, &membership.StatusChange{
+		TenantID:       s.ID,
+		SubscriptionID: recovered.ID,
+		ToStatus:       membership.StatusPastDue,
+		Reason:         membership.ReasonPaymentRejected,
+		ActorType:      membership.ActorMercadoPago,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = env.systemDB.Exec(ctx, `UPDATE subscriptions SET current_period_end = now() - interval '1 day' WHERE id = $1`, recovered.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dunning.Run(ctx, time.Now()); err != nil {
+		t.Logf("dunning run: %v", err)
+	}
+	var n payment.DunningNotification
+	select {
+	case msg := <-messages.Channel():
+		if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+	}
+	if n.SubscriptionID != recovered.ID || n.CheckoutURL == nil {
+		t.Fatalf("payment failed notification of the second subscription: %+v", n)
+	}
+	paid, err := provider.PayPreference(strings.TrimPrefix(*n.CheckoutURL, "https://mptest.local/checkout/"), "approved")
+	if err != nil {
+		t.Fatalf("pay recovery link: %v", err)
+	}
+	processor := payment.NewWebhookProcessor(
+		payment.NewClientsWithProvider(env.cfg.MercadoPago, env.systemDB, provider), payment.NewRepository(env.systemDB))
+	notification := provider.Notify("payment", fmt.Sprint(paid.ID))
+	if err := processor.Process(ctx, &payment.WebhookDelivery{Topic: "payment", ResourceID: notification.Query.Get("data.id"), Payload: notification.Body}); err != nil {
+		t.Fatalf("process recovery payment: %v", err)
+	}
+
+	var periodEnd time.Time
+	err = env.systemDB.QueryRow(ctx, `SELECT status, current_period_end FROM subscriptions WHERE id = $1`, recovered.ID).Scan(&status, &periodEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "active" || !periodEnd.After(time.Now()) {
+		t.Errorf("recovered subscription %s until %s, want active with a new period", status, periodEnd)
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/subscriptions/"+recovered.ID.String()+"/washes", s.Token, map[string]any{}, nil)
+
+	mustCall(t, http.StatusOK, http.MethodGet, "/api/v1/dunning/report?from="+from+"&to="+to, s.Token, nil, &report)
+	if report.Recovered.Count != 1 || report.Lost.Count != 1 || report.RecoveryRate != 0.5 {
+		t.Errorf("dunning report after the recovery: %+v", report)
+	}
+}
+
+func TestAmountsKeepCurrencyAndCents(t *testing.T) {
+	s := seedTenant(t, "money")
+	ctx := context.Background()
+
+	mustCall(t, http.StatusBadRequest, http.MethodPost, "/api/v1/plans", s.Token, map[string]any{
+		"name": "Plan XYZ", "price_cents": 19999, "currency": "XYZ",
+	}, nil)
+	var plan membership.Plan
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/plans", s.Token, map[string]any{
+		"name": "Plan USD", "price_cents": 19999, "currency": "usd",
+	}, &plan)
+	if plan.Currency != money.USD {
+		t.Errorf("plan currency %q, want USD", plan.Currency)
+	}
+
+	// Manual payments are in the plan's currency.
+	mustCall(t, http.StatusBadRequest, http.MethodPost, "/api/v1/payments/manual", s.Token, map[string]any{
+		"subscription_id": s.SubscriptionID, "amount_cents": 1500000, "currency": "USD",
+	}, nil)
+	var manual payment.PaymentEvent
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/payments/manual", s.Token, map[string]any{
+		"subscription_id": s.SubscriptionID, "amount_cents": 1500000, "currency": "ars",
+	}, &manual)
+	if manual.Currency != money.ARS {
+		t.Errorf("manual payment currency %q, want ARS", manual.Currency)
+	}
+
+	provider := mptest.NewProvider("integration-webhook-secret")
+	mp := mptest.NewServer(provider)
+	defer mp.Close()
+	cfg := *env.cfg
+	cfg.MercadoPago.BaseURL = mp.URL
+	api := httptest.NewServer(newRouter(&cfg, env.appDB, env.systemDB, env.redis))
+	defer api.Close()
+	processor := payment.NewWebhookProcessor(
+		payment.NewClientsWithProvider(cfg.MercadoPago, env.systemDB, provider), payment.NewRepository(env.systemDB))
+
+	// 199.99 goes to MP and comes back as 19999 cents, not 19998.
+	resp, err := callURL(api.URL, http.MethodPost, "/api/v1/payments/subscription", s.Token, nil, payment.CreateSubscriptionMPRequest{
+		PlanID:     plan.ID,
+		CustomerID: s.CustomerID,
+		PayerEmail: "payer@money.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checkout payment.CreateSubscriptionMPResponse
+	if err := json.Unmarshal(resp.Data, &checkout); resp.Status != http.StatusCreated || err != nil {
+		t.Fatalf("checkout: status %d: %s", resp.Status, resp.Raw)
+	}
+	if err := provider.AuthorizePreapproval(checkout.PreapprovalID); err != nil {
+		t.Fatal(err)
+	}
+	charge, err := provider.ChargePreapproval(checkout.PreapprovalID, "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	charged, err := provider.GetPayment(ctx, fmt.Sprint(charge.Payment.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charged.TransactionAmount.String() != "199.99" || charged.CurrencyID != money.USD {
+		t.Errorf("MP charged %s %s, want USD 199.99", charged.CurrencyID, charged.TransactionAmount)
+	}
+	n := provider.Notify("payment", fmt.Sprint(charge.Payment.ID))
+	if err := processor.Process(ctx, &payment.WebhookDelivery{Topic: "payment", ResourceID: n.Query.Get("data.id"), Payload: n.Body}); err != nil {
+		t.Fatalf("process payment: %v", err)
+	}
+	var cents int
+	var currency string
+	err = env.systemDB.QueryRow(ctx, `SELECT amount_cents, currency FROM payment_events WHERE mp_payment_id = $1`,
+		fmt.Sprint(charge.Payment.ID)).Scan(&cents, &currency)
+	if err != nil {
+		t.Fatalf("charge not recorded: %v", err)
+	}
+	if cents != 19999 || currency != "USD" {
+		t.Errorf("charge recorded as %s %d cents, want USD 19999", currency, cents)
+	}
+
+	// A payment in dollars cannot pay for a plan in pesos.
+	pref, err := provider.CreatePreference(ctx, &payment.PreferenceRequest{
+		Items:             []payment.PreferenceItem{{Title: "USD", Quantity: 1, UnitPrice: money.FromCents(1500000), CurrencyID: money.USD}},
+		ExternalReference: s.SubscriptionID.String(),
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := provider.PayPreference(pref.ID, "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = provider.Notify("payment", fmt.Sprint(foreign.ID))
+	if err := processor.Process(ctx, &payment.WebhookDelivery{Topic: "payment", ResourceID: n.Query.Get("data.id"), Payload: n.Body}); err == nil {
+		t.Error("payment in USD for a plan in ARS was processed")
+	}
+	var recorded bool
+	err = env.systemDB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM payment_events WHERE mp_payment_id = $1)`,
+		fmt.Sprint(foreign.ID)).Scan(&recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded {
+		t.Error("payment in USD for a plan in ARS was recorded")
+	}
+}
+
+func TestCheckoutProActivatesOnApprovedPayment(t *testing.T) {
+	s := seedTenant(t, "checkout")
+	ctx := context.Background()
+
+	// Mercado Pago subscriptions need a checkout to be paid.
+	mustCall(t, http.StatusBadRequest, http.MethodPost, "/api/v1/subscriptions", s.Token, map[string]any{
+		"customer_id": s.CustomerID, "plan_id": s.PlanID, "payment_method": "mercadopago",
+	}, nil)
+
+	provider := mptest.NewProvider("integration-webhook-secret")
+	mp := mptest.NewServer(provider)
+	defer mp.Close()
+	cfg := *env.cfg
+	cfg.MercadoPago.BaseURL = mp.URL
+	api := httptest.NewServer(newRouter(&cfg, env.appDB, env.systemDB, env.redis))
+	defer api.Close()
+	processor := payment.NewWebhookProcessor(
+		payment.NewClientsWithProvider(cfg.MercadoPago, env.systemDB, provider), payment.NewRepository(env.systemDB))
+
+	checkout := func() payment.CreatePreferenceResponse {
+		t.Helper()
+		resp, err := callURL(api.URL, http.MethodPost, "/api/v1/payments/preference", s.Token, nil, payment.CreatePreferenceRequest{
+			PlanID:     s.PlanID,
+			CustomerID: s.CustomerID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created payment.CreatePreferenceResponse
+		if err := json.Unmarshal(resp.Data, &created); resp.Status != http.StatusCreated || err != nil {
+			t.Fatalf("checkout: status %d: %s", resp.Status, resp.Raw)
+		}
+		return created
+	}
+	pay := func(preferenceID, status string) {
+		t.Helper()
+		paid, err := provider.PayPreference(preferenceID, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := provider.Notify("payment", fmt.Sprint(paid.ID))
+		if err := processor.Process(ctx, &payment.WebhookDelivery{Topic: "payment", ResourceID: n.Query.Get("data.id"), Payload: n.Body}); err != nil {
+			t.Fatalf("process %s payment: %v", status, err)
+		}
+	}
+	statusOf := func(subID uuid.UUID) string {
+		t.Helper()
+		var status string
+		if err := env.systemDB.QueryRow(ctx, `SELECT status FROM subscriptions WHERE id = $1`, subID).Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	paid := checkout()
+	if status := statusOf(paid.SubscriptionID); status != membership.StatusPendingPayment {
+		t.Fatalf("subscription %s after the checkout, want pending_payment", status)
+	}
+	// A rejected card leaves the checkout open for another try.
+	pay(paid.PreferenceID, "rejected")
+	if status := statusOf(paid.SubscriptionID); status != membership.StatusPendingPayment {
+		t.Errorf("subscription %s after a rejected payment, want pending_payment", status)
+	}
+	pay(paid.PreferenceID, "approved")
+	if status := statusOf(paid.SubscriptionID); status != membership.StatusActive {
+		t.Errorf("subscription %s after an approved payment, want active", status)
+	}
+
+	// The cron cancels checkouts nobody paid, and only those.
+	abandoned := checkout()
+	memberships := membership.NewService(env.systemDB, env.redis, env.cfg.QR, nil)
+	memberships.ExpireAbandonedCheckouts(ctx, time.Now().Add(time.Minute))
+	if status := statusOf(abandoned.SubscriptionID); status != membership.StatusCancelled {
+		t.Errorf("abandoned checkout %s, want cancelled", status)
+	}
+	if status := statusOf(paid.SubscriptionID); status != membership.StatusActive {
+		t.Errorf("paid checkout %s after the cron, want active", status)
+	}
+}
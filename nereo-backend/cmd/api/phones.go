@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/phone"
+)
+
+// phoneRow is a stored phone that may still be in a free-form format.
+type phoneRow struct {
+	Table    string
+	ID       string
+	TenantID string
+	Phone    string
+	Unique   bool // covered by a unique index (phones of active customers)
+}
+
+// phoneFailure is a row the backfill leaves untouched.
+type phoneFailure struct {
+	phoneRow
+	Normalized string // empty when the phone cannot be normalized
+}
+
+// backfillPhones normalizes every customers.phone and users.phone to E.164.
+// Rows that cannot be normalized (or that would collide with another row of
+// the same tenant once normalized) are left untouched and reported to out.
+// A dry run applies the updates to an in-memory copy of the unique phones,
+// so it reports the same collisions as the real run.
+func backfillPhones(ctx context.Context, db *pgxpool.Pool, out io.Writer, dryRun bool) error {
+	var updated, unchanged int
+	var failed []phoneFailure
+
+	for _, table := range []string{"customers", "users"} {
+		rows, err := loadPhones(ctx, db, table)
+		if err != nil {
+			return err
+		}
+
+		// taken holds the unique phones per tenant as the updates go.
+		taken := make(map[string]bool)
+		key := func(tenantID, p string) string { return tenantID + " " + p }
+		for _, row := range rows {
+			if row.Unique {
+				taken[key(row.TenantID, row.Phone)] = true
+			}
+		}
+
+		for _, row := range rows {
+			normalized, err := phone.NormalizePhoneAR(row.Phone)
+			if err != nil {
+				failed = append(failed, phoneFailure{phoneRow: row})
+				slog.Warn("phone backfill: cannot normalize",
+					"table", row.Table, "id", row.ID, "tenant_id", row.TenantID, "phone", row.Phone)
+				continue
+			}
+			if normalized == row.Phone {
+				unchanged++
+				continue
+			}
+			if row.Unique && taken[key(row.TenantID, normalized)] {
+				failed = append(failed, phoneFailure{phoneRow: row, Normalized: normalized})
+				slog.Warn("phone backfill: normalized phone collides with an existing row",
+					"table", row.Table, "id", row.ID, "tenant_id", row.TenantID, "phone", row.Phone, "normalized", normalized)
+				continue
+			}
+
+			if dryRun {
+				slog.Info("phone backfill: would update",
+					"table", row.Table, "id", row.ID, "from", row.Phone, "to", normalized)
+			} else {
+				query := fmt.Sprintf("UPDATE %s SET phone = $1, updated_at = NOW() WHERE id = $2", table)
+				if _, err := db.Exec(ctx, query, normalized, row.ID); err != nil {
+					var pgErr *pgconn.PgError
+					if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+						// Written concurrently by the API.
+						failed = append(failed, phoneFailure{phoneRow: row, Normalized: normalized})
+						slog.Warn("phone backfill: normalized phone collides with an existing row",
+							"table", row.Table, "id", row.ID, "tenant_id", row.TenantID, "phone", row.Phone, "normalized", normalized)
+						continue
+					}
+					return fmt.Errorf("update %s phone: %w", table, err)
+				}
+			}
+			if row.Unique {
+				delete(taken, key(row.TenantID, row.Phone))
+				taken[key(row.TenantID, normalized)] = true
+			}
+			updated++
+		}
+	}
+
+	slog.Info("phone backfill finished",
+		"dry_run", dryRun, "updated", updated, "unchanged", unchanged, "failed", len(failed))
+
+	return writePhoneReport(out, failed)
+}
+
+// writePhoneReport lists the rows left for a person to fix.
+func writePhoneReport(out io.Writer, failed []phoneFailure) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tID\tTENANT\tPHONE\tPROBLEM")
+	for _, f := range failed {
+		problem := "not a valid Argentine number"
+		if f.Normalized != "" {
+			problem = "another row of the tenant has " + f.Normalized
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Table, f.ID, f.TenantID, f.Phone, problem)
+	}
+	return w.Flush()
+}
+
+func loadPhones(ctx context.Context, db *pgxpool.Pool, table string) ([]phoneRow, error) {
+	unique := "false"
+	if table == "customers" {
+		unique = "deleted_at IS NULL"
+	}
+	query := fmt.Sprintf("SELECT id::text, tenant_id::text, phone, %s FROM %s WHERE phone IS NOT NULL AND phone <> '' ORDER BY created_at", unique, table)
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("load %s phones: %w", table, err)
+	}
+	defer rows.Close()
+
+	var result []phoneRow
+	for rows.Next() {
+		r := phoneRow{Table: table}
+		if err := rows.Scan(&r.ID, &r.TenantID, &r.Phone, &r.Unique); err != nil {
+			return nil, fmt.Errorf("scan %s phone: %w", table, err)
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
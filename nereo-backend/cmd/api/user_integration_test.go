@@ -0,0 +1,115 @@
+//go:build integration
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStaffInvitationRoleChangeAndDeactivation(t *testing.T) {
+	s := seedTenant(t, "staff")
+	email := fmt.Sprintf("manager-%s@staff.test", uuid.NewString()[:8])
+
+	resp, err := call(http.MethodPost, "/api/v1/users/invitations", s.Token, map[string]any{
+		"email": email,
+		"role":  "manager",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusCreated || strings.Contains(string(resp.Raw), "token") {
+		t.Fatalf("invitation: status %d, want 201 without the token: %s", resp.Status, resp.Raw)
+	}
+
+	// The link goes straight to the invitee.
+	accept := map[string]any{
+		"token":     mailToken(t, email, "/accept-invitation"),
+		"full_name": "Manager Staff",
+		"password":  "manager-password",
+	}
+	var manager struct {
+		ID              uuid.UUID  `json:"id"`
+		Role            string     `json:"role"`
+		EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	}
+	mustCall(t, http.StatusCreated, http.MethodPost, "/api/v1/invitations/accept", "", accept, &manager)
+	if manager.Role != "manager" {
+		t.Fatalf("accepted user role %q, want manager", manager.Role)
+	}
+	// Only the invitee could read the token.
+	if manager.EmailVerifiedAt == nil {
+		t.Error("accepted user's email is not verified")
+	}
+
+	// The token is single-use.
+	resp, err = call(http.MethodPost, "/api/v1/invitations/accept", "", accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusConflict {
+		t.Errorf("second acceptance: status %d, want 409: %s", resp.Status, resp.Raw)
+	}
+
+	var pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	mustCall(t, http.StatusOK, http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":    email,
+		"password": "manager-password",
+	}, &pair)
+
+	// Staff management is owner only.
+	resp, err = call(http.MethodGet, "/api/v1/users", pair.AccessToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusForbidden {
+		t.Errorf("manager listing users: status %d, want 403", resp.Status)
+	}
+
+	// A role change revokes the refresh token, which carries the old role.
+	mustCall(t, http.StatusOK, http.MethodPut, "/api/v1/users/"+manager.ID.String()+"/role", s.Token,
+		map[string]any{"role": "employee"}, nil)
+	resp, err = call(http.MethodPost, "/api/v1/auth/refresh", "", map[string]any{"refresh_token": pair.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusUnauthorized {
+		t.Errorf("refresh after role change: status %d, want 401", resp.Status)
+	}
+
+	mustCall(t, http.StatusNoContent, http.MethodDelete, "/api/v1/users/"+manager.ID.String(), s.Token, nil, nil)
+	resp, err = call(http.MethodPost, "/api/v1/auth/login", "", map[string]any{
+		"email":    email,
+		"password": "manager-password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusUnauthorized {
+		t.Errorf("login after deactivation: status %d, want 401", resp.Status)
+	}
+
+	// The only owner can be neither demoted nor deactivated.
+	resp, err = call(http.MethodPut, "/api/v1/users/"+s.OwnerID.String()+"/role", s.Token, map[string]any{"role": "manager"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusConflict {
+		t.Errorf("demoting the last owner: status %d, want 409: %s", resp.Status, resp.Raw)
+	}
+	resp, err = call(http.MethodDelete, "/api/v1/users/"+s.OwnerID.String(), s.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusConflict {
+		t.Errorf("deactivating the last owner: status %d, want 409: %s", resp.Status, resp.Raw)
+	}
+}
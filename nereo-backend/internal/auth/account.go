@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/mail"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvalidEmailToken means a reset or verification token is unknown, expired
+// or already used.
+var ErrInvalidEmailToken = errors.New("invalid or expired email token")
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+
+	// sendTimeout bounds a mail sent after the response was written.
+	sendTimeout = 30 * time.Second
+)
+
+// Purposes of the single-use tokens sent by email, used as Redis key prefix.
+const (
+	purposePasswordReset     = "pwreset"
+	purposeEmailVerification = "verify_email"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ============================================================
+// Password reset
+// ============================================================
+
+// ForgotPassword mails a reset link to every active account with the email.
+// It answers 204 whether or not the email exists, and sends in the
+// background so the response time does not tell either.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+		if err := h.sendPasswordResets(ctx, req.Email); err != nil {
+			slog.Error("failed to send password reset", "error", err)
+		}
+	}()
+
+	httputil.NoContent(c)
+}
+
+func (h *Handler) sendPasswordResets(ctx context.Context, email string) error {
+	query := `
+		SELECT u.id, u.email, t.name
+		FROM users u
+		JOIN tenants t ON t.id = u.tenant_id
+		WHERE LOWER(u.email) = LOWER($1) AND u.active = true AND t.active = true`
+
+	rows, err := h.db.Query(ctx, query, email)
+	if err != nil {
+		return fmt.Errorf("find users by email: %w", err)
+	}
+	type account struct {
+		userID     uuid.UUID
+		email      string
+		tenantName string
+	}
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.userID, &a.email, &a.tenantName); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan user: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("find users by email: %w", err)
+	}
+
+	for _, a := range accounts {
+		token, err := h.issueToken(ctx, purposePasswordReset, a.userID, passwordResetTTL)
+		if err != nil {
+			return err
+		}
+		err = h.mail.Send(ctx, mail.Message{
+			To:      a.email,
+			Subject: "Restablecé tu contraseña de Nereo",
+			Body: fmt.Sprintf("Hola,\n\n"+
+				"Recibimos un pedido para restablecer la contraseña de tu cuenta en %s.\n\n"+
+				"Elegí una nueva desde este link, que vence en una hora:\n%s/reset-password?token=%s\n\n"+
+				"Si no lo pediste, ignorá este mail: tu contraseña no cambia.\n",
+				a.tenantName, h.appURL, token),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword. The
+// token works once; all the user's sessions are revoked so other devices
+// have to log in again.
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.consumeToken(ctx, purposePasswordReset, req.Token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidEmailToken) {
+			httputil.BadRequest(c, "INVALID_TOKEN", "the reset link is invalid or expired")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	// The link reached the inbox, so the email is verified as well.
+	tag, err := h.db.Exec(ctx, `
+		UPDATE users
+		SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE id = $2 AND active = true`,
+		hash, userID,
+	)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		httputil.BadRequest(c, "INVALID_TOKEN", "the reset link is invalid or expired")
+		return
+	}
+
+	if err := RevokeAllSessions(ctx, h.redis, userID); err != nil {
+		slog.Error("failed to revoke sessions after password reset", "error", err, "user_id", userID)
+	}
+
+	httputil.NoContent(c)
+}
+
+// ============================================================
+// Email verification
+// ============================================================
+
+// SendVerification mails userID the link that verifies email.
+func (h *Handler) SendVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	token, err := h.issueToken(ctx, purposeEmailVerification, userID, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return h.mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirmá tu email en Nereo",
+		Body: fmt.Sprintf("Hola,\n\n"+
+			"Confirmá tu email desde este link, que vence en 48 horas:\n%s/verify-email?token=%s\n\n"+
+			"Hasta entonces no vas a poder conectar Mercado Pago, hacer reembolsos ni gestionar tu equipo.\n",
+			h.appURL, token),
+	})
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.consumeToken(ctx, purposeEmailVerification, req.Token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidEmailToken) {
+			httputil.BadRequest(c, "INVALID_TOKEN", "the verification link is invalid or expired")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	_, err = h.db.Exec(ctx,
+		"UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1",
+		userID,
+	)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// ResendVerification mails the caller a new verification link; the previous
+// one stops working.
+func (h *Handler) ResendVerification(c *gin.Context) {
+	userIDVal, _ := c.Get("user_id")
+	userID := userIDVal.(uuid.UUID)
+	ctx := c.Request.Context()
+
+	var (
+		email    string
+		verified bool
+	)
+	err := h.db.QueryRow(ctx,
+		"SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+	).Scan(&email, &verified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			httputil.Unauthorized(c, "account not found or disabled")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+	if verified {
+		httputil.Conflict(c, "EMAIL_ALREADY_VERIFIED", "the email is already verified")
+		return
+	}
+
+	if err := h.SendVerification(ctx, userID, email); err != nil {
+		slog.Error("failed to send email verification", "error", err, "user_id", userID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// ============================================================
+// Single-use tokens
+// ============================================================
+
+// issueToken creates a random token for purpose and userID. Redis keeps only
+// its SHA-256 (<purpose>:<hash> -> user id) until ttl; <purpose>:user:<id>
+// points at the latest one, so issuing a token voids the previous.
+func (h *Handler) issueToken(ctx context.Context, purpose string, userID uuid.UUID, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate %s token: %w", purpose, err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	hash := hashToken(token)
+
+	userKey := fmt.Sprintf("%s:user:%s", purpose, userID.String())
+	previous, err := h.redis.Get(ctx, userKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("get previous %s token: %w", purpose, err)
+	}
+
+	_, err = h.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" {
+			pipe.Del(ctx, purpose+":"+previous)
+		}
+		pipe.Set(ctx, purpose+":"+hash, userID.String(), ttl)
+		pipe.Set(ctx, userKey, hash, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("store %s token: %w", purpose, err)
+	}
+	return token, nil
+}
+
+// consumeToken returns the user of a token and deletes it in the same
+// command, so it cannot be used twice.
+func (h *Handler) consumeToken(ctx context.Context, purpose, token string) (uuid.UUID, error) {
+	value, err := h.redis.GetDel(ctx, purpose+":"+hashToken(token)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return uuid.Nil, ErrInvalidEmailToken
+		}
+		return uuid.Nil, fmt.Errorf("consume %s token: %w", purpose, err)
+	}
+
+	userID, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("parse %s token user: %w", purpose, err)
+	}
+	h.redis.Del(ctx, fmt.Sprintf("%s:user:%s", purpose, userID.String()))
+	return userID, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,14 +3,14 @@ package auth
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/mail"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -18,33 +18,68 @@ type Handler struct {
 	db         *pgxpool.Pool
 	jwtManager *JWTManager
 	redis      *redis.Client
+	mail       mail.Sender
+	appURL     string // front-end base URL of the links sent by email
 }
 
-func NewHandler(db *pgxpool.Pool, jwtManager *JWTManager, redisClient *redis.Client) *Handler {
+func NewHandler(db *pgxpool.Pool, jwtManager *JWTManager, redisClient *redis.Client, sender mail.Sender, appURL string) *Handler {
 	return &Handler{
 		db:         db,
 		jwtManager: jwtManager,
 		redis:      redisClient,
+		mail:       sender,
+		appURL:     appURL,
 	}
 }
 
+const maxDeviceNameLength = 100
+
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	// TenantSlug picks the tenant when the email has accounts in several.
+	TenantSlug string `json:"tenant_slug" binding:"omitempty,max=100"`
+	// DeviceName labels the session in GET /auth/sessions ("Tablet
+	// mostrador"); the User-Agent is used when empty.
+	DeviceName string `json:"device_name" binding:"omitempty,max=100"`
 }
 
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+type SwitchTenantRequest struct {
+	TenantSlug string `json:"tenant_slug" binding:"required,max=100"`
+}
+
+// Membership is a tenant a login gives access to, through the user account
+// the email has there.
+type Membership struct {
+	UserID     uuid.UUID `json:"-"`
+	TenantID   uuid.UUID `json:"tenant_id"`
+	TenantSlug string    `json:"tenant_slug"`
+	TenantName string    `json:"tenant_name"`
+	Role       string    `json:"role"`
+}
+
+// LoginResponse carries the token pair of one tenant, or only the list of
+// tenants when the email has accounts in several and none was chosen.
+type LoginResponse struct {
+	*TokenPair
+	Tenant  *Membership  `json:"tenant,omitempty"` // the tenant of the tokens
+	Tenants []Membership `json:"tenants"`
+}
+
 type userRow struct {
-	ID           uuid.UUID
-	TenantID     uuid.UUID
+	Membership
 	PasswordHash string
-	Role         string
 	Active       bool
 }
 
+// Login checks the password against every account of the email and logs
+// into the one of tenant_slug, or the only one. With several accounts and
+// no tenant_slug it returns the tenants to choose from and no tokens; the
+// client repeats the login with the chosen slug.
 func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -52,49 +87,201 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
 	query := `
-		SELECT u.id, u.tenant_id, u.password_hash, u.role, u.active
+		SELECT u.id, u.tenant_id, t.slug, t.name, u.role, u.password_hash, u.active
 		FROM users u
 		JOIN tenants t ON t.id = u.tenant_id
-		WHERE u.email = $1 AND t.active = true
-		LIMIT 1`
+		WHERE LOWER(u.email) = LOWER($1) AND t.active = true
+		ORDER BY t.name ASC`
 
-	var user userRow
-	err := h.db.QueryRow(c.Request.Context(), query, req.Email).Scan(
-		&user.ID, &user.TenantID, &user.PasswordHash, &user.Role, &user.Active,
-	)
+	rows, err := h.db.Query(ctx, query, req.Email)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
+		httputil.InternalError(c)
+		return
+	}
+	var users []userRow
+	for rows.Next() {
+		var u userRow
+		if err := rows.Scan(&u.UserID, &u.TenantID, &u.TenantSlug, &u.TenantName, &u.Role, &u.PasswordHash, &u.Active); err != nil {
+			rows.Close()
+			httputil.InternalError(c)
+			return
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	// Accounts may have different passwords: only those it opens count.
+	var (
+		memberships []Membership
+		disabled    bool
+	)
+	for _, u := range users {
+		if !CheckPassword(req.Password, u.PasswordHash) {
+			continue
+		}
+		if !u.Active {
+			disabled = true
+			continue
+		}
+		memberships = append(memberships, u.Membership)
+	}
+	if len(memberships) == 0 {
+		if disabled {
+			httputil.Unauthorized(c, "account is disabled")
+			return
+		}
+		httputil.Unauthorized(c, "invalid credentials")
+		return
+	}
+
+	var chosen *Membership
+	switch {
+	case req.TenantSlug != "":
+		for i := range memberships {
+			if memberships[i].TenantSlug == req.TenantSlug {
+				chosen = &memberships[i]
+			}
+		}
+		if chosen == nil {
 			httputil.Unauthorized(c, "invalid credentials")
 			return
 		}
+	case len(memberships) == 1:
+		chosen = &memberships[0]
+	default:
+		httputil.OK(c, LoginResponse{Tenants: memberships})
+		return
+	}
+
+	resp, err := h.startSession(ctx, *chosen, memberships, deviceName(req.DeviceName, c.Request.UserAgent()), c.ClientIP())
+	if err != nil {
+		slog.Error("failed to create session", "error", err, "user_id", chosen.UserID)
 		httputil.InternalError(c)
 		return
 	}
 
-	if !user.Active {
-		httputil.Unauthorized(c, "account is disabled")
+	httputil.OK(c, resp)
+}
+
+// SwitchTenant moves the caller to another tenant their login gave access
+// to, without asking for the password again. The session is replaced by
+// one of the account in that tenant.
+func (h *Handler) SwitchTenant(c *gin.Context) {
+	userIDVal, _ := c.Get("user_id")
+	userID := userIDVal.(uuid.UUID)
+	sessionIDVal, _ := c.Get("session_id")
+	sessionID := sessionIDVal.(uuid.UUID)
+
+	var req SwitchTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
 		return
 	}
 
-	if !CheckPassword(req.Password, user.PasswordHash) {
-		httputil.Unauthorized(c, "invalid credentials")
+	ctx := c.Request.Context()
+	session, err := getSession(ctx, h.redis, sessionID)
+	if err != nil || session.UserID != userID {
+		if err == nil || errors.Is(err, ErrSessionNotFound) {
+			httputil.Unauthorized(c, "session revoked or expired")
+			return
+		}
+		httputil.InternalError(c)
 		return
 	}
 
-	pair, err := h.jwtManager.GenerateTokenPair(user.ID, user.TenantID, user.Role)
+	// Roles and active flags may have changed since the login.
+	query := `
+		SELECT u.id, u.tenant_id, t.slug, t.name, u.role
+		FROM users u
+		JOIN tenants t ON t.id = u.tenant_id
+		WHERE u.id = ANY($1) AND u.active = true AND t.active = true
+		ORDER BY t.name ASC`
+
+	rows, err := h.db.Query(ctx, query, session.Memberships)
 	if err != nil {
 		httputil.InternalError(c)
 		return
 	}
+	var (
+		memberships []Membership
+		target      *Membership
+	)
+	for rows.Next() {
+		var m Membership
+		if err := rows.Scan(&m.UserID, &m.TenantID, &m.TenantSlug, &m.TenantName, &m.Role); err != nil {
+			rows.Close()
+			httputil.InternalError(c)
+			return
+		}
+		memberships = append(memberships, m)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		httputil.InternalError(c)
+		return
+	}
+	for i := range memberships {
+		if memberships[i].TenantSlug == req.TenantSlug {
+			target = &memberships[i]
+		}
+	}
+	if target == nil {
+		httputil.NotFound(c, "tenant not found among your accounts")
+		return
+	}
 
-	// Store refresh token in Redis
-	refreshKey := fmt.Sprintf("refresh:%s", user.ID.String())
-	h.redis.Set(c.Request.Context(), refreshKey, pair.RefreshToken, h.jwtManager.refreshTTL)
+	resp, err := h.startSession(ctx, *target, memberships, session.DeviceName, c.ClientIP())
+	if err != nil {
+		slog.Error("failed to create session", "error", err, "user_id", target.UserID)
+		httputil.InternalError(c)
+		return
+	}
+	if err := revokeSession(ctx, h.redis, userID, sessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		slog.Error("failed to revoke session after switching tenant", "error", err, "session_id", sessionID)
+	}
 
-	httputil.OK(c, pair)
+	httputil.OK(c, resp)
+}
+
+// startSession opens a session of m's account and issues its token pair.
+// The session remembers every account the login opened, which are the ones
+// SwitchTenant can move to.
+func (h *Handler) startSession(ctx context.Context, m Membership, memberships []Membership, device, ip string) (*LoginResponse, error) {
+	now := time.Now()
+	session := &Session{
+		ID:         uuid.New(),
+		UserID:     m.UserID,
+		TenantID:   m.TenantID,
+		DeviceName: device,
+		IP:         ip,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	for _, other := range memberships {
+		session.Memberships = append(session.Memberships, other.UserID)
+	}
+
+	pair, err := h.jwtManager.GenerateTokenPair(m.UserID, m.TenantID, m.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := createSession(ctx, h.redis, session, pair.refreshID, h.jwtManager.refreshTTL); err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{TokenPair: pair, Tenant: &m, Tenants: memberships}, nil
 }
 
+// Refresh exchanges the refresh token of a session for a new pair. Each
+// refresh token works once: presenting one that was already exchanged
+// revokes the session, since either the client or an attacker holds a
+// stolen copy.
 func (h *Handler) Refresh(c *gin.Context) {
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -103,22 +290,16 @@ func (h *Handler) Refresh(c *gin.Context) {
 	}
 
 	claims, err := h.jwtManager.ValidateToken(req.RefreshToken)
-	if err != nil {
+	if err != nil || claims.ID == "" {
 		httputil.Unauthorized(c, "invalid refresh token")
 		return
 	}
 
-	// Verify refresh token matches what's stored in Redis
-	refreshKey := fmt.Sprintf("refresh:%s", claims.UserID.String())
-	stored, err := h.redis.Get(c.Request.Context(), refreshKey).Result()
-	if err != nil || stored != req.RefreshToken {
-		httputil.Unauthorized(c, "refresh token revoked or expired")
-		return
-	}
+	ctx := c.Request.Context()
 
 	// Verify user still exists and is active
 	var active bool
-	err = h.db.QueryRow(c.Request.Context(),
+	err = h.db.QueryRow(ctx,
 		"SELECT active FROM users WHERE id = $1", claims.UserID,
 	).Scan(&active)
 	if err != nil || !active {
@@ -126,24 +307,97 @@ func (h *Handler) Refresh(c *gin.Context) {
 		return
 	}
 
-	pair, err := h.jwtManager.GenerateTokenPair(claims.UserID, claims.TenantID, claims.Role)
+	pair, err := h.jwtManager.GenerateTokenPair(claims.UserID, claims.TenantID, claims.Role, claims.SessionID)
 	if err != nil {
 		httputil.InternalError(c)
 		return
 	}
 
-	// Rotate refresh token
-	h.redis.Set(c.Request.Context(), refreshKey, pair.RefreshToken, h.jwtManager.refreshTTL)
+	err = rotateSession(ctx, h.redis, claims.UserID, claims.SessionID, claims.ID, pair.refreshID, c.ClientIP(), h.jwtManager.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshReused):
+			slog.Warn("refresh token reused, session revoked", "user_id", claims.UserID, "session_id", claims.SessionID, "ip", c.ClientIP())
+			httputil.Unauthorized(c, "refresh token already used, please log in again")
+		case errors.Is(err, ErrSessionNotFound):
+			httputil.Unauthorized(c, "refresh token revoked or expired")
+		default:
+			slog.Error("failed to rotate session", "error", err, "user_id", claims.UserID)
+			httputil.InternalError(c)
+		}
+		return
+	}
 
 	httputil.OK(c, pair)
 }
 
+// Logout ends the caller's session; the other devices stay logged in.
 func (h *Handler) Logout(c *gin.Context) {
 	userIDVal, _ := c.Get("user_id")
 	userID := userIDVal.(uuid.UUID)
+	sessionIDVal, _ := c.Get("session_id")
+	sessionID := sessionIDVal.(uuid.UUID)
 
-	refreshKey := fmt.Sprintf("refresh:%s", userID.String())
-	h.redis.Del(context.Background(), refreshKey)
+	err := revokeSession(context.Background(), h.redis, userID, sessionID)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// LogoutEverywhere ends every session of the caller, this one included.
+func (h *Handler) LogoutEverywhere(c *gin.Context) {
+	userIDVal, _ := c.Get("user_id")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := RevokeAllSessions(context.Background(), h.redis, userID); err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// ListSessions returns the devices the caller is logged in on.
+func (h *Handler) ListSessions(c *gin.Context) {
+	userIDVal, _ := c.Get("user_id")
+	userID := userIDVal.(uuid.UUID)
+	sessionIDVal, _ := c.Get("session_id")
+	current := sessionIDVal.(uuid.UUID)
+
+	sessions, err := listSessions(c.Request.Context(), h.redis, userID)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	httputil.OK(c, sessions)
+}
+
+// RevokeSession logs one of the caller's devices out remotely.
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userIDVal, _ := c.Get("user_id")
+	userID := userIDVal.(uuid.UUID)
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid session id")
+		return
+	}
+
+	if err := revokeSession(c.Request.Context(), h.redis, userID, sessionID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			httputil.NotFound(c, "session not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
 
 	httputil.NoContent(c)
 }
@@ -153,8 +407,13 @@ func (h *Handler) GetJWTManager() *JWTManager {
 	return h.jwtManager
 }
 
-// StoreRefreshToken stores a refresh token in Redis (used during tenant onboarding)
-func (h *Handler) StoreRefreshToken(ctx context.Context, userID uuid.UUID, token string, ttl time.Duration) {
-	refreshKey := fmt.Sprintf("refresh:%s", userID.String())
-	h.redis.Set(ctx, refreshKey, token, ttl)
+// deviceName is the name the client gave the device, or its User-Agent.
+func deviceName(name, userAgent string) string {
+	if name == "" {
+		name = userAgent
+	}
+	if len(name) > maxDeviceNameLength {
+		name = name[:maxDeviceNameLength]
+	}
+	return name
 }
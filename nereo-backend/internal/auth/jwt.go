@@ -13,17 +13,23 @@ var (
 	ErrExpiredToken = errors.New("token expired")
 )
 
+// Claims of both tokens. SessionID is the login session they belong to;
+// refresh tokens also carry a unique ID (jti) so a reused one is detected.
 type Claims struct {
-	UserID   uuid.UUID `json:"sub"`
-	TenantID uuid.UUID `json:"tid"`
-	Role     string    `json:"role"`
+	UserID    uuid.UUID `json:"sub"`
+	TenantID  uuid.UUID `json:"tid"`
+	Role      string    `json:"role"`
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
 type TokenPair struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresAt    int64  `json:"expires_at"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    int64     `json:"expires_at"`
+	SessionID    uuid.UUID `json:"session_id"`
+
+	refreshID string // jti of RefreshToken
 }
 
 type JWTManager struct {
@@ -40,13 +46,14 @@ func NewJWTManager(secret string, accessTTL, refreshTTL time.Duration) *JWTManag
 	}
 }
 
-func (m *JWTManager) GenerateTokenPair(userID, tenantID uuid.UUID, role string) (*TokenPair, error) {
+func (m *JWTManager) GenerateTokenPair(userID, tenantID uuid.UUID, role string, sessionID uuid.UUID) (*TokenPair, error) {
 	now := time.Now()
 
 	accessClaims := &Claims{
-		UserID:   userID,
-		TenantID: tenantID,
-		Role:     role,
+		UserID:    userID,
+		TenantID:  tenantID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -61,14 +68,16 @@ func (m *JWTManager) GenerateTokenPair(userID, tenantID uuid.UUID, role string)
 	}
 
 	refreshClaims := &Claims{
-		UserID:   userID,
-		TenantID: tenantID,
-		Role:     role,
+		UserID:    userID,
+		TenantID:  tenantID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.refreshTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "nereo-api",
 			Subject:   "refresh",
+			ID:        uuid.NewString(),
 		},
 	}
 
@@ -82,6 +91,8 @@ func (m *JWTManager) GenerateTokenPair(userID, tenantID uuid.UUID, role string)
 		AccessToken:  accessStr,
 		RefreshToken: refreshStr,
 		ExpiresAt:    accessClaims.ExpiresAt.Unix(),
+		SessionID:    sessionID,
+		refreshID:    refreshClaims.ID,
 	}, nil
 }
 
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrRefreshReused   = errors.New("refresh token reused")
+)
+
+// Session is one login of a user: a device that holds a refresh token.
+//
+// It lives in Redis as the hash session:<id> (expiring with the refresh
+// token, extended on every refresh) and its ID is in the set
+// sessions:<user_id>. The hash keeps the jti of the only refresh token of
+// the session that may still be used: every refresh rotates it, and
+// presenting an older one means the token leaked, so the whole session is
+// revoked.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"-"`
+	TenantID   uuid.UUID `json:"-"`
+	DeviceName string    `json:"device_name"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` // the session of the caller's token
+
+	// Memberships are the accounts (user IDs, one per tenant) the login
+	// opened with the password; the session can switch to any of them.
+	Memberships []uuid.UUID `json:"-"`
+}
+
+func sessionKey(sessionID uuid.UUID) string {
+	return fmt.Sprintf("session:%s", sessionID.String())
+}
+
+func userSessionsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("sessions:%s", userID.String())
+}
+
+// createSession stores s with refreshID as its current refresh token.
+func createSession(ctx context.Context, rdb *redis.Client, s *Session, refreshID string, ttl time.Duration) error {
+	key := sessionKey(s.ID)
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, map[string]any{
+			"user_id":      s.UserID.String(),
+			"tenant_id":    s.TenantID.String(),
+			"device_name":  s.DeviceName,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt.Unix(),
+			"last_used_at": s.LastUsedAt.Unix(),
+			"jti":          refreshID,
+			"memberships":  joinIDs(s.Memberships),
+		})
+		pipe.Expire(ctx, key, ttl)
+		pipe.SAdd(ctx, userSessionsKey(s.UserID), s.ID.String())
+		pipe.Expire(ctx, userSessionsKey(s.UserID), ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("store session: %w", err)
+	}
+	return nil
+}
+
+// getSession loads a live session.
+func getSession(ctx context.Context, rdb *redis.Client, sessionID uuid.UUID) (*Session, error) {
+	fields, err := rdb.HGetAll(ctx, sessionKey(sessionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return parseSession(sessionID, fields), nil
+}
+
+// rotateScript swaps the session's refresh token for a new one, only if the
+// presented one is the current one.
+//
+//	KEYS[1] session:<id>
+//	ARGV    user id, presented jti, new jti, now (unix), ip, ttl (seconds)
+//
+// Returns 1 when rotated, 0 when the presented jti is an old one, -1 when
+// the session does not exist (or belongs to someone else).
+var rotateScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'user_id') ~= ARGV[1] then
+	return -1
+end
+if redis.call('HGET', KEYS[1], 'jti') ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'jti', ARGV[3], 'last_used_at', ARGV[4], 'ip', ARGV[5])
+redis.call('EXPIRE', KEYS[1], ARGV[6])
+return 1
+`)
+
+// rotateSession records that the refresh token presentedID of the session
+// was exchanged for newID. A reused token revokes the session and returns
+// ErrRefreshReused.
+func rotateSession(ctx context.Context, rdb *redis.Client, userID, sessionID uuid.UUID, presentedID, newID, ip string, ttl time.Duration) error {
+	result, err := rotateScript.Run(ctx, rdb, []string{sessionKey(sessionID)},
+		userID.String(), presentedID, newID, time.Now().Unix(), ip, int(ttl.Seconds()),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("rotate session: %w", err)
+	}
+
+	switch result {
+	case 1:
+		return rdb.Expire(ctx, userSessionsKey(userID), ttl).Err()
+	case 0:
+		if err := revokeSession(ctx, rdb, userID, sessionID); err != nil {
+			return err
+		}
+		return ErrRefreshReused
+	default:
+		return ErrSessionNotFound
+	}
+}
+
+// listSessions returns the live sessions of userID, most recently used
+// first, and forgets the expired ones.
+func listSessions(ctx context.Context, rdb *redis.Client, userID uuid.UUID) ([]Session, error) {
+	ids, err := rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, "session:"+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("get sessions: %w", err)
+	}
+
+	sessions := []Session{}
+	var expired []any
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		sessionID, err := uuid.Parse(ids[i])
+		if len(fields) == 0 || err != nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		sessions = append(sessions, *parseSession(sessionID, fields))
+	}
+	if len(expired) > 0 {
+		rdb.SRem(ctx, userSessionsKey(userID), expired...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// revokeSession deletes one session of userID. Its refresh token stops
+// working; its access tokens expire on their own.
+func revokeSession(ctx context.Context, rdb *redis.Client, userID, sessionID uuid.UUID) error {
+	owner, err := rdb.HGet(ctx, sessionKey(sessionID), "user_id").Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("get session: %w", err)
+	}
+	if owner != userID.String() {
+		return ErrSessionNotFound
+	}
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(sessionID))
+		pipe.SRem(ctx, userSessionsKey(userID), sessionID.String())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllSessions logs the user out of every device: no refresh token of
+// theirs works anymore (used on logout-everywhere, password reset,
+// deactivation and role changes).
+func RevokeAllSessions(ctx context.Context, redisClient *redis.Client, userID uuid.UUID) error {
+	ids, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("list sessions: %w", err)
+	}
+
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.Del(ctx, "session:"+id)
+		}
+		pipe.Del(ctx, userSessionsKey(userID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	return nil
+}
+
+func parseSession(sessionID uuid.UUID, fields map[string]string) *Session {
+	s := &Session{
+		ID:         sessionID,
+		DeviceName: fields["device_name"],
+		IP:         fields["ip"],
+		CreatedAt:  unixField(fields["created_at"]),
+		LastUsedAt: unixField(fields["last_used_at"]),
+	}
+	s.UserID, _ = uuid.Parse(fields["user_id"])
+	s.TenantID, _ = uuid.Parse(fields["tenant_id"])
+	for _, id := range strings.Split(fields["memberships"], ",") {
+		if memberID, err := uuid.Parse(id); err == nil {
+			s.Memberships = append(s.Memberships, memberID)
+		}
+	}
+	return s
+}
+
+func joinIDs(ids []uuid.UUID) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = id.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func unixField(v string) time.Time {
+	sec, _ := strconv.ParseInt(v, 10, 64)
+	return time.Unix(sec, 0).UTC()
+}
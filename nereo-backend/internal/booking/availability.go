@@ -0,0 +1,171 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidRange = errors.New("invalid availability range")
+
+const (
+	// slotStep is the granularity of the start times offered to customers.
+	slotStep = 15 * time.Minute
+	// maxAvailabilityDays bounds ?days= so a week view fits in one request.
+	maxAvailabilityDays = 14
+
+	defaultOpenTime  = "08:00"
+	defaultCloseTime = "20:00"
+)
+
+// Availability returns the free start times for a service on each requested
+// day, per box. A slot is free when the service fits before closing time and
+// [start, start+duration+buffer) does not overlap a booking that still holds
+// its slot; the same range CreateBooking reserves.
+func (s *Service) Availability(ctx context.Context, tenantID uuid.UUID, q AvailabilityQuery) (*Availability, error) {
+	if q.Days == 0 {
+		q.Days = 1
+	}
+	if q.Days < 0 || q.Days > maxAvailabilityDays {
+		return nil, ErrInvalidRange
+	}
+
+	svc, err := s.repo.GetServiceByID(ctx, tenantID, q.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if !svc.Active {
+		return nil, ErrServiceNotFound
+	}
+
+	if q.BoxID != nil {
+		box, err := s.repo.GetBoxByID(ctx, tenantID, *q.BoxID)
+		if err != nil {
+			return nil, err
+		}
+		if !box.Active {
+			return nil, ErrBoxNotFound
+		}
+	}
+
+	t, err := s.tenantRepo.GetByID(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	loc := tenantLocation(t)
+
+	first := time.Now().In(loc)
+	if q.Date != "" {
+		first, err = time.ParseInLocation("2006-01-02", q.Date, loc)
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+	}
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+
+	boxes, err := s.repo.ListBoxesOfferingService(ctx, tenantID, svc.ID, q.BoxID)
+	if err != nil {
+		return nil, err
+	}
+	if q.BoxID != nil && len(boxes) == 0 {
+		return nil, ErrServiceNotOffered
+	}
+
+	openHour, openMin := parseClock(t.Settings.OpenTime, defaultOpenTime)
+	closeHour, closeMin := parseClock(t.Settings.CloseTime, defaultCloseTime)
+	duration := time.Duration(svc.DurationMinutes) * time.Minute
+	occupiedFor := duration + time.Duration(t.BufferBetweenSlots)*time.Minute
+
+	// Occupied ranges of every box for the whole period in one query.
+	occupied := make(map[uuid.UUID][]Booking, len(boxes))
+	if len(boxes) > 0 {
+		boxIDs := make([]uuid.UUID, len(boxes))
+		for i, b := range boxes {
+			boxIDs[i] = b.ID
+		}
+		periodEnd := first.AddDate(0, 0, q.Days).Add(occupiedFor)
+		bookings, err := s.repo.ListOccupied(ctx, tenantID, boxIDs, first, periodEnd)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bookings {
+			occupied[b.BoxID] = append(occupied[b.BoxID], b)
+		}
+	}
+
+	now := time.Now()
+	result := &Availability{
+		ServiceID:       svc.ID,
+		DurationMinutes: svc.DurationMinutes,
+		BufferMinutes:   t.BufferBetweenSlots,
+		Timezone:        loc.String(),
+		Days:            make([]DayAvailability, 0, q.Days),
+	}
+
+	for d := 0; d < q.Days; d++ {
+		day := first.AddDate(0, 0, d)
+		opening := time.Date(day.Year(), day.Month(), day.Day(), openHour, openMin, 0, 0, loc)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), closeHour, closeMin, 0, 0, loc)
+
+		dayResult := DayAvailability{
+			Date:  day.Format("2006-01-02"),
+			Boxes: make([]BoxAvailability, 0, len(boxes)),
+		}
+		for _, b := range boxes {
+			dayResult.Boxes = append(dayResult.Boxes, BoxAvailability{
+				BoxID:   b.ID,
+				BoxName: b.Name,
+				Slots:   freeSlots(opening, closing, duration, occupiedFor, now, occupied[b.ID]),
+			})
+		}
+		result.Days = append(result.Days, dayResult)
+	}
+
+	return result, nil
+}
+
+// freeSlots walks from opening to closing in slotStep increments. bookings
+// must be sorted by StartsAt.
+func freeSlots(opening, closing time.Time, duration, occupiedFor time.Duration, now time.Time, bookings []Booking) []time.Time {
+	slots := []time.Time{}
+	next := 0
+
+	for start := opening; !start.Add(duration).After(closing); start = start.Add(slotStep) {
+		if !start.After(now) {
+			continue
+		}
+		end := start.Add(occupiedFor)
+
+		// Skip bookings that end before this slot; slots only move forward.
+		for next < len(bookings) && !bookings[next].EndsAt.After(start) {
+			next++
+		}
+
+		free := true
+		for _, b := range bookings[next:] {
+			if !b.StartsAt.Before(end) {
+				break
+			}
+			if b.EndsAt.After(start) {
+				free = false
+				break
+			}
+		}
+		if free {
+			slots = append(slots, start)
+		}
+	}
+	return slots
+}
+
+// parseClock splits "HH:MM" into hour and minute, falling back to def when
+// the tenant has not configured a valid value.
+func parseClock(value, def string) (hour, minute int) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		t, _ = time.Parse("15:04", def)
+	}
+	return t.Hour(), t.Minute()
+}
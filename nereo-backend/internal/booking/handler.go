@@ -0,0 +1,450 @@
+package booking
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ============================================================
+// Services
+// ============================================================
+
+func (h *Handler) CreateService(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var req CreateServiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	svc, err := h.service.CreateService(c.Request.Context(), tenantID, req)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.Created(c, svc)
+}
+
+func (h *Handler) GetService(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid service id")
+		return
+	}
+
+	svc, err := h.service.GetService(c.Request.Context(), tenantID, serviceID)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			httputil.NotFound(c, "service not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, svc)
+}
+
+func (h *Handler) ListServices(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	services, err := h.service.ListServices(c.Request.Context(), tenantID)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	if services == nil {
+		services = []WashService{}
+	}
+
+	httputil.OK(c, services)
+}
+
+func (h *Handler) UpdateService(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid service id")
+		return
+	}
+
+	var req UpdateServiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	svc, err := h.service.UpdateService(c.Request.Context(), tenantID, serviceID, req)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			httputil.NotFound(c, "service not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, svc)
+}
+
+func (h *Handler) DeactivateService(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid service id")
+		return
+	}
+
+	if err := h.service.DeactivateService(c.Request.Context(), tenantID, serviceID); err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			httputil.NotFound(c, "service not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// ============================================================
+// Boxes
+// ============================================================
+
+func (h *Handler) CreateBox(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var req CreateBoxRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	box, err := h.service.CreateBox(c.Request.Context(), tenantID, req)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			httputil.NotFound(c, "service not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.Created(c, box)
+}
+
+func (h *Handler) GetBox(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	boxID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid box id")
+		return
+	}
+
+	box, err := h.service.GetBox(c.Request.Context(), tenantID, boxID)
+	if err != nil {
+		if errors.Is(err, ErrBoxNotFound) {
+			httputil.NotFound(c, "box not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, box)
+}
+
+func (h *Handler) ListBoxes(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	boxes, err := h.service.ListBoxes(c.Request.Context(), tenantID)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	if boxes == nil {
+		boxes = []Box{}
+	}
+
+	httputil.OK(c, boxes)
+}
+
+func (h *Handler) UpdateBox(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	boxID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid box id")
+		return
+	}
+
+	var req UpdateBoxRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	box, err := h.service.UpdateBox(c.Request.Context(), tenantID, boxID, req)
+	if err != nil {
+		if errors.Is(err, ErrBoxNotFound) {
+			httputil.NotFound(c, "box not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, box)
+}
+
+func (h *Handler) DeactivateBox(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	boxID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid box id")
+		return
+	}
+
+	if err := h.service.DeactivateBox(c.Request.Context(), tenantID, boxID); err != nil {
+		if errors.Is(err, ErrBoxNotFound) {
+			httputil.NotFound(c, "box not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+func (h *Handler) SetBoxServices(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	boxID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid box id")
+		return
+	}
+
+	var req SetBoxServicesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	box, err := h.service.SetBoxServices(c.Request.Context(), tenantID, boxID, req)
+	if err != nil {
+		if errors.Is(err, ErrBoxNotFound) {
+			httputil.NotFound(c, "box not found")
+			return
+		}
+		if errors.Is(err, ErrServiceNotFound) {
+			httputil.NotFound(c, "service not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, box)
+}
+
+// ============================================================
+// Bookings
+// ============================================================
+
+func (h *Handler) CreateBooking(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var req CreateBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	booking, err := h.service.CreateBooking(c.Request.Context(), tenantID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrServiceNotFound):
+			httputil.NotFound(c, "service not found")
+		case errors.Is(err, ErrBoxNotFound):
+			httputil.NotFound(c, "box not found")
+		case errors.Is(err, ErrCustomerNotFound):
+			httputil.NotFound(c, "customer not found")
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrStartsInPast):
+			httputil.BadRequest(c, "STARTS_IN_PAST", "starts_at must be in the future")
+		case errors.Is(err, ErrOutsideOpeningHours):
+			httputil.BadRequest(c, "OUTSIDE_OPENING_HOURS", "the wash must fit within the opening hours")
+		case errors.Is(err, ErrServiceNotOffered):
+			httputil.BadRequest(c, "SERVICE_NOT_OFFERED", "the box does not offer this service")
+		case errors.Is(err, ErrSubscriptionInactive):
+			httputil.Conflict(c, "SUBSCRIPTION_INACTIVE", "subscription is not active")
+		case errors.Is(err, ErrSlotLocked):
+			httputil.Conflict(c, "SLOT_LOCKED", "the slot is being booked, try again")
+		case errors.Is(err, ErrSlotNotAvailable):
+			httputil.Conflict(c, "SLOT_TAKEN", "the slot overlaps another booking")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.Created(c, booking)
+}
+
+// ListBookings returns the bookings of one day. ?date=YYYY-MM-DD in the
+// tenant's timezone (default today), optional ?box_id= and ?status=.
+func (h *Handler) ListBookings(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var boxID *uuid.UUID
+	if boxStr := c.Query("box_id"); boxStr != "" {
+		id, err := uuid.Parse(boxStr)
+		if err != nil {
+			httputil.BadRequest(c, "INVALID_ID", "invalid box_id")
+			return
+		}
+		boxID = &id
+	}
+
+	bookings, err := h.service.ListBookings(c.Request.Context(), tenantID, c.Query("date"), boxID, c.Query("status"))
+	if err != nil {
+		if errors.Is(err, ErrInvalidDate) {
+			httputil.BadRequest(c, "INVALID_DATE", "date must be YYYY-MM-DD")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	if bookings == nil {
+		bookings = []Booking{}
+	}
+
+	httputil.OK(c, bookings)
+}
+
+// Availability returns free start times. ?service_id= is required; ?box_id=
+// restricts it to one box, ?date=YYYY-MM-DD (default today) and ?days=
+// (1-14, default 1) select the period.
+func (h *Handler) Availability(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	serviceID, err := uuid.Parse(c.Query("service_id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "service_id is required")
+		return
+	}
+
+	q := AvailabilityQuery{ServiceID: serviceID, Date: c.Query("date")}
+	if boxStr := c.Query("box_id"); boxStr != "" {
+		id, err := uuid.Parse(boxStr)
+		if err != nil {
+			httputil.BadRequest(c, "INVALID_ID", "invalid box_id")
+			return
+		}
+		q.BoxID = &id
+	}
+	if daysStr := c.Query("days"); daysStr != "" {
+		q.Days, err = strconv.Atoi(daysStr)
+		if err != nil || q.Days < 1 {
+			httputil.BadRequest(c, "INVALID_RANGE", "days must be between 1 and 14")
+			return
+		}
+	}
+
+	availability, err := h.service.Availability(c.Request.Context(), tenantID, q)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrServiceNotFound):
+			httputil.NotFound(c, "service not found")
+		case errors.Is(err, ErrBoxNotFound):
+			httputil.NotFound(c, "box not found")
+		case errors.Is(err, ErrServiceNotOffered):
+			httputil.BadRequest(c, "SERVICE_NOT_OFFERED", "the box does not offer this service")
+		case errors.Is(err, ErrInvalidDate):
+			httputil.BadRequest(c, "INVALID_DATE", "date must be YYYY-MM-DD")
+		case errors.Is(err, ErrInvalidRange):
+			httputil.BadRequest(c, "INVALID_RANGE", "days must be between 1 and 14")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.OK(c, availability)
+}
+
+func (h *Handler) UpdateStatus(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	bookingID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid booking id")
+		return
+	}
+
+	var req UpdateStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	booking, err := h.service.UpdateStatus(c.Request.Context(), tenantID, bookingID, req.Status)
+	if err != nil {
+		if errors.Is(err, ErrBookingNotFound) {
+			httputil.NotFound(c, "booking not found")
+			return
+		}
+		if errors.Is(err, ErrInvalidTransition) {
+			httputil.Conflict(c, "INVALID_STATUS_TRANSITION", "booking cannot move to this status")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, booking)
+}
+
+func (h *Handler) CancelBooking(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	bookingID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid booking id")
+		return
+	}
+
+	booking, err := h.service.CancelBooking(c.Request.Context(), tenantID, bookingID)
+	if err != nil {
+		if errors.Is(err, ErrBookingNotFound) {
+			httputil.NotFound(c, "booking not found")
+			return
+		}
+		if errors.Is(err, ErrInvalidTransition) {
+			httputil.Conflict(c, "INVALID_STATUS_TRANSITION", "only confirmed bookings can be cancelled")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, booking)
+}
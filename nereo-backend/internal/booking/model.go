@@ -0,0 +1,150 @@
+package booking
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================
+// Services (catalog)
+// ============================================================
+
+// WashService is an entry of the tenant's service catalog ("Lavado
+// Exterior", "Detailing Completo"). Its duration drives the booking length.
+type WashService struct {
+	ID              uuid.UUID `json:"id"`
+	TenantID        uuid.UUID `json:"tenant_id"`
+	Name            string    `json:"name"`
+	Description     *string   `json:"description,omitempty"`
+	DurationMinutes int       `json:"duration_minutes"`
+	BasePriceCents  int       `json:"base_price_cents"`
+	Active          bool      `json:"active"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type CreateServiceRequest struct {
+	Name            string  `json:"name" binding:"required,min=2,max=255"`
+	Description     *string `json:"description"`
+	DurationMinutes int     `json:"duration_minutes" binding:"required,min=5,max=480"`
+	BasePriceCents  int     `json:"base_price_cents" binding:"min=0"`
+}
+
+type UpdateServiceRequest struct {
+	Name            *string `json:"name" binding:"omitempty,min=2,max=255"`
+	Description     *string `json:"description"`
+	DurationMinutes *int    `json:"duration_minutes" binding:"omitempty,min=5,max=480"`
+	BasePriceCents  *int    `json:"base_price_cents" binding:"omitempty,min=0"`
+}
+
+// ============================================================
+// Boxes
+// ============================================================
+
+type Box struct {
+	ID         uuid.UUID   `json:"id"`
+	TenantID   uuid.UUID   `json:"tenant_id"`
+	Name       string      `json:"name"`
+	Active     bool        `json:"active"`
+	ServiceIDs []uuid.UUID `json:"service_ids"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+type CreateBoxRequest struct {
+	Name       string      `json:"name" binding:"required,min=1,max=100"`
+	ServiceIDs []uuid.UUID `json:"service_ids"`
+}
+
+type UpdateBoxRequest struct {
+	Name   *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Active *bool   `json:"active"`
+}
+
+type SetBoxServicesRequest struct {
+	ServiceIDs []uuid.UUID `json:"service_ids" binding:"required"`
+}
+
+// ============================================================
+// Bookings
+// ============================================================
+
+const (
+	StatusConfirmed  = "confirmed"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+	StatusNoShow     = "no_show"
+)
+
+// Booking occupies [StartsAt, EndsAt) on a box. EndsAt includes the tenant's
+// buffer_between_slots so the exclusion constraint also keeps the
+// maneuvering time free.
+type Booking struct {
+	ID             uuid.UUID  `json:"id"`
+	TenantID       uuid.UUID  `json:"tenant_id"`
+	CustomerID     uuid.UUID  `json:"customer_id"`
+	BoxID          uuid.UUID  `json:"box_id"`
+	ServiceID      uuid.UUID  `json:"service_id"`
+	SubscriptionID *uuid.UUID `json:"subscription_id,omitempty"`
+	StartsAt       time.Time  `json:"starts_at"`
+	EndsAt         time.Time  `json:"ends_at"`
+	Status         string     `json:"status"`
+	Notes          *string    `json:"notes,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type CreateBookingRequest struct {
+	CustomerID     uuid.UUID  `json:"customer_id" binding:"required"`
+	BoxID          uuid.UUID  `json:"box_id" binding:"required"`
+	ServiceID      uuid.UUID  `json:"service_id" binding:"required"`
+	SubscriptionID *uuid.UUID `json:"subscription_id"`
+	StartsAt       time.Time  `json:"starts_at" binding:"required"`
+	Notes          *string    `json:"notes"`
+}
+
+type UpdateStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=in_progress completed no_show"`
+}
+
+// ListFilter selects the bookings of one local day of the tenant.
+type ListFilter struct {
+	From   time.Time
+	To     time.Time
+	BoxID  *uuid.UUID
+	Status string
+}
+
+// ============================================================
+// Availability
+// ============================================================
+
+type AvailabilityQuery struct {
+	ServiceID uuid.UUID
+	BoxID     *uuid.UUID // nil means every box that offers the service
+	Date      string     // YYYY-MM-DD in the tenant's timezone; empty means today
+	Days      int
+}
+
+// Availability lists the free start times per day and box. Slots are
+// rendered in the tenant's timezone.
+type Availability struct {
+	ServiceID       uuid.UUID         `json:"service_id"`
+	DurationMinutes int               `json:"duration_minutes"`
+	BufferMinutes   int               `json:"buffer_minutes"`
+	Timezone        string            `json:"timezone"`
+	Days            []DayAvailability `json:"days"`
+}
+
+type DayAvailability struct {
+	Date  string            `json:"date"`
+	Boxes []BoxAvailability `json:"boxes"`
+}
+
+type BoxAvailability struct {
+	BoxID   uuid.UUID   `json:"box_id"`
+	BoxName string      `json:"box_name"`
+	Slots   []time.Time `json:"slots"`
+}
@@ -0,0 +1,536 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+var (
+	ErrServiceNotFound      = errors.New("service not found")
+	ErrBoxNotFound          = errors.New("box not found")
+	ErrBookingNotFound      = errors.New("booking not found")
+	ErrCustomerNotFound     = errors.New("customer not found")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrSlotNotAvailable     = errors.New("slot not available")
+)
+
+const (
+	serviceColumns = `id, tenant_id, name, description, duration_minutes, base_price_cents, active, created_at, updated_at`
+	boxColumns     = `id, tenant_id, name, active, created_at, updated_at`
+	bookingColumns = `id, tenant_id, customer_id, box_id, service_id, subscription_id, starts_at, ends_at, status, notes, created_at, updated_at`
+)
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
+// ============================================================
+// Services
+// ============================================================
+
+func (r *Repository) CreateService(ctx context.Context, s *WashService) error {
+	query := `
+		INSERT INTO services (id, tenant_id, name, description, duration_minutes, base_price_cents, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		s.ID, s.TenantID, s.Name, s.Description, s.DurationMinutes, s.BasePriceCents, s.Active,
+	).Scan(&s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert service: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetServiceByID(ctx context.Context, tenantID, serviceID uuid.UUID) (*WashService, error) {
+	query := `SELECT ` + serviceColumns + ` FROM services WHERE id = $1 AND tenant_id = $2`
+
+	s, err := scanService(r.conn(ctx).QueryRow(ctx, query, serviceID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrServiceNotFound
+		}
+		return nil, fmt.Errorf("get service: %w", err)
+	}
+	return s, nil
+}
+
+func (r *Repository) ListServices(ctx context.Context, tenantID uuid.UUID) ([]WashService, error) {
+	query := `SELECT ` + serviceColumns + ` FROM services WHERE tenant_id = $1 AND active = TRUE ORDER BY name ASC`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	defer rows.Close()
+
+	var services []WashService
+	for rows.Next() {
+		s, err := scanService(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan service: %w", err)
+		}
+		services = append(services, *s)
+	}
+	return services, rows.Err()
+}
+
+func (r *Repository) UpdateService(ctx context.Context, s *WashService) error {
+	query := `
+		UPDATE services
+		SET name = $1, description = $2, duration_minutes = $3, base_price_cents = $4, updated_at = NOW()
+		WHERE id = $5 AND tenant_id = $6
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		s.Name, s.Description, s.DurationMinutes, s.BasePriceCents, s.ID, s.TenantID,
+	).Scan(&s.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrServiceNotFound
+		}
+		return fmt.Errorf("update service: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeactivateService(ctx context.Context, tenantID, serviceID uuid.UUID) error {
+	query := `UPDATE services SET active = FALSE, updated_at = NOW() WHERE id = $1 AND tenant_id = $2`
+	tag, err := r.conn(ctx).Exec(ctx, query, serviceID, tenantID)
+	if err != nil {
+		return fmt.Errorf("deactivate service: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrServiceNotFound
+	}
+	return nil
+}
+
+// CountActiveServices returns how many of serviceIDs are active services of
+// the tenant, so callers can reject foreign or unknown IDs.
+func (r *Repository) CountActiveServices(ctx context.Context, tenantID uuid.UUID, serviceIDs []uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM services WHERE tenant_id = $1 AND active = TRUE AND id = ANY($2)`
+
+	var n int
+	if err := r.conn(ctx).QueryRow(ctx, query, tenantID, serviceIDs).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count services: %w", err)
+	}
+	return n, nil
+}
+
+// ============================================================
+// Boxes
+// ============================================================
+
+func (r *Repository) CreateBox(ctx context.Context, b *Box) error {
+	query := `
+		INSERT INTO wash_boxes (id, tenant_id, name, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at, updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query, b.ID, b.TenantID, b.Name, b.Active).Scan(&b.CreatedAt, &b.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert box: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetBoxByID(ctx context.Context, tenantID, boxID uuid.UUID) (*Box, error) {
+	query := `SELECT ` + boxColumns + ` FROM wash_boxes WHERE id = $1 AND tenant_id = $2`
+
+	b, err := scanBox(r.conn(ctx).QueryRow(ctx, query, boxID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBoxNotFound
+		}
+		return nil, fmt.Errorf("get box: %w", err)
+	}
+
+	serviceIDs, err := r.boxServiceIDs(ctx, tenantID, []uuid.UUID{b.ID})
+	if err != nil {
+		return nil, err
+	}
+	b.ServiceIDs = nonNil(serviceIDs[b.ID])
+
+	return b, nil
+}
+
+func (r *Repository) ListBoxes(ctx context.Context, tenantID uuid.UUID) ([]Box, error) {
+	query := `SELECT ` + boxColumns + ` FROM wash_boxes WHERE tenant_id = $1 ORDER BY name ASC`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list boxes: %w", err)
+	}
+	defer rows.Close()
+
+	var boxes []Box
+	var ids []uuid.UUID
+	for rows.Next() {
+		b, err := scanBox(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan box: %w", err)
+		}
+		boxes = append(boxes, *b)
+		ids = append(ids, b.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	serviceIDs, err := r.boxServiceIDs(ctx, tenantID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range boxes {
+		boxes[i].ServiceIDs = nonNil(serviceIDs[boxes[i].ID])
+	}
+
+	return boxes, nil
+}
+
+func (r *Repository) UpdateBox(ctx context.Context, b *Box) error {
+	query := `
+		UPDATE wash_boxes SET name = $1, active = $2, updated_at = NOW()
+		WHERE id = $3 AND tenant_id = $4
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query, b.Name, b.Active, b.ID, b.TenantID).Scan(&b.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrBoxNotFound
+		}
+		return fmt.Errorf("update box: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeactivateBox(ctx context.Context, tenantID, boxID uuid.UUID) error {
+	query := `UPDATE wash_boxes SET active = FALSE, updated_at = NOW() WHERE id = $1 AND tenant_id = $2`
+	tag, err := r.conn(ctx).Exec(ctx, query, boxID, tenantID)
+	if err != nil {
+		return fmt.Errorf("deactivate box: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBoxNotFound
+	}
+	return nil
+}
+
+// SetBoxServices replaces the set of services offered by a box.
+func (r *Repository) SetBoxServices(ctx context.Context, tenantID, boxID uuid.UUID, serviceIDs []uuid.UUID) error {
+	conn := r.conn(ctx)
+
+	if _, err := conn.Exec(ctx, `DELETE FROM wash_box_services WHERE box_id = $1 AND tenant_id = $2`, boxID, tenantID); err != nil {
+		return fmt.Errorf("clear box services: %w", err)
+	}
+
+	if len(serviceIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO wash_box_services (tenant_id, box_id, service_id)
+		SELECT $1, $2, unnest($3::uuid[])
+		ON CONFLICT DO NOTHING`
+	if _, err := conn.Exec(ctx, query, tenantID, boxID, serviceIDs); err != nil {
+		return fmt.Errorf("insert box services: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) BoxOffersService(ctx context.Context, tenantID, boxID, serviceID uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM wash_box_services WHERE tenant_id = $1 AND box_id = $2 AND service_id = $3)`
+
+	var offers bool
+	if err := r.conn(ctx).QueryRow(ctx, query, tenantID, boxID, serviceID).Scan(&offers); err != nil {
+		return false, fmt.Errorf("check box service: %w", err)
+	}
+	return offers, nil
+}
+
+func (r *Repository) boxServiceIDs(ctx context.Context, tenantID uuid.UUID, boxIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	result := make(map[uuid.UUID][]uuid.UUID, len(boxIDs))
+	if len(boxIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+		SELECT box_id, service_id FROM wash_box_services
+		WHERE tenant_id = $1 AND box_id = ANY($2)
+		ORDER BY service_id`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, boxIDs)
+	if err != nil {
+		return nil, fmt.Errorf("list box services: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var boxID, serviceID uuid.UUID
+		if err := rows.Scan(&boxID, &serviceID); err != nil {
+			return nil, fmt.Errorf("scan box service: %w", err)
+		}
+		result[boxID] = append(result[boxID], serviceID)
+	}
+	return result, rows.Err()
+}
+
+// ============================================================
+// Bookings
+// ============================================================
+
+func (r *Repository) CustomerExists(ctx context.Context, tenantID, customerID uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)`
+
+	var exists bool
+	if err := r.conn(ctx).QueryRow(ctx, query, customerID, tenantID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check customer: %w", err)
+	}
+	return exists, nil
+}
+
+// GetSubscriptionStatus returns the status of a subscription that belongs to
+// the given customer of the tenant.
+func (r *Repository) GetSubscriptionStatus(ctx context.Context, tenantID, customerID, subID uuid.UUID) (string, error) {
+	query := `SELECT status FROM subscriptions WHERE id = $1 AND tenant_id = $2 AND customer_id = $3`
+
+	var status string
+	err := r.conn(ctx).QueryRow(ctx, query, subID, tenantID, customerID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrSubscriptionNotFound
+		}
+		return "", fmt.Errorf("get subscription status: %w", err)
+	}
+	return status, nil
+}
+
+// IsSlotAvailable reports whether [startsAt, endsAt) is free on the box. It
+// locks the overlapping rows so concurrent transactions wait for each other;
+// the exclusion constraint remains the final guarantee.
+func (r *Repository) IsSlotAvailable(ctx context.Context, tenantID, boxID uuid.UUID, startsAt, endsAt time.Time) (bool, error) {
+	query := `
+		SELECT id FROM bookings
+		WHERE tenant_id = $1 AND box_id = $2
+			AND status NOT IN ('cancelled', 'no_show')
+			AND tstzrange(starts_at, ends_at) && tstzrange($3, $4)
+		LIMIT 1
+		FOR UPDATE`
+
+	var id uuid.UUID
+	err := r.conn(ctx).QueryRow(ctx, query, tenantID, boxID, startsAt, endsAt).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check slot: %w", err)
+	}
+	return false, nil
+}
+
+func (r *Repository) CreateBooking(ctx context.Context, b *Booking) error {
+	query := `
+		INSERT INTO bookings (id, tenant_id, customer_id, box_id, service_id, subscription_id, starts_at, ends_at, status, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at, updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		b.ID, b.TenantID, b.CustomerID, b.BoxID, b.ServiceID, b.SubscriptionID, b.StartsAt, b.EndsAt, b.Status, b.Notes,
+	).Scan(&b.CreatedAt, &b.UpdatedAt)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return ErrSlotNotAvailable
+		}
+		return fmt.Errorf("insert booking: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetBookingByID(ctx context.Context, tenantID, bookingID uuid.UUID) (*Booking, error) {
+	query := `SELECT ` + bookingColumns + ` FROM bookings WHERE id = $1 AND tenant_id = $2`
+
+	b, err := scanBooking(r.conn(ctx).QueryRow(ctx, query, bookingID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBookingNotFound
+		}
+		return nil, fmt.Errorf("get booking: %w", err)
+	}
+	return b, nil
+}
+
+func (r *Repository) ListBookings(ctx context.Context, tenantID uuid.UUID, f ListFilter) ([]Booking, error) {
+	query := `SELECT ` + bookingColumns + ` FROM bookings WHERE tenant_id = $1 AND starts_at >= $2 AND starts_at < $3`
+	args := []interface{}{tenantID, f.From, f.To}
+
+	if f.BoxID != nil {
+		args = append(args, *f.BoxID)
+		query += fmt.Sprintf(" AND box_id = $%d", len(args))
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	query += " ORDER BY starts_at ASC"
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list bookings: %w", err)
+	}
+	defer rows.Close()
+
+	var bookings []Booking
+	for rows.Next() {
+		b, err := scanBooking(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan booking: %w", err)
+		}
+		bookings = append(bookings, *b)
+	}
+	return bookings, rows.Err()
+}
+
+// UpdateBookingStatus moves a booking from one status to another. It only
+// matches rows still in from, so concurrent transitions cannot both win.
+func (r *Repository) UpdateBookingStatus(ctx context.Context, b *Booking, from string) error {
+	query := `
+		UPDATE bookings SET status = $1, updated_at = NOW()
+		WHERE id = $2 AND tenant_id = $3 AND status = $4
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query, b.Status, b.ID, b.TenantID, from).Scan(&b.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidTransition
+		}
+		return fmt.Errorf("update booking status: %w", err)
+	}
+	return nil
+}
+
+// ============================================================
+// Availability
+// ============================================================
+
+// ListBoxesOfferingService returns the active boxes of the tenant that offer
+// the service, optionally restricted to one box.
+func (r *Repository) ListBoxesOfferingService(ctx context.Context, tenantID, serviceID uuid.UUID, boxID *uuid.UUID) ([]Box, error) {
+	query := `
+		SELECT b.id, b.tenant_id, b.name, b.active, b.created_at, b.updated_at
+		FROM wash_boxes b
+		JOIN wash_box_services bs ON bs.box_id = b.id AND bs.tenant_id = b.tenant_id
+		WHERE b.tenant_id = $1 AND bs.service_id = $2 AND b.active = TRUE`
+	args := []interface{}{tenantID, serviceID}
+
+	if boxID != nil {
+		args = append(args, *boxID)
+		query += fmt.Sprintf(" AND b.id = $%d", len(args))
+	}
+	query += " ORDER BY b.name ASC"
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list boxes offering service: %w", err)
+	}
+	defer rows.Close()
+
+	var boxes []Box
+	for rows.Next() {
+		b, err := scanBox(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan box: %w", err)
+		}
+		boxes = append(boxes, *b)
+	}
+	return boxes, rows.Err()
+}
+
+// ListOccupied returns the bookings that still hold their slot on the given
+// boxes and overlap [from, to).
+func (r *Repository) ListOccupied(ctx context.Context, tenantID uuid.UUID, boxIDs []uuid.UUID, from, to time.Time) ([]Booking, error) {
+	query := `SELECT ` + bookingColumns + ` FROM bookings
+		WHERE tenant_id = $1 AND box_id = ANY($2)
+			AND status NOT IN ('cancelled', 'no_show')
+			AND tstzrange(starts_at, ends_at) && tstzrange($3, $4)
+		ORDER BY starts_at ASC`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, boxIDs, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("list occupied slots: %w", err)
+	}
+	defer rows.Close()
+
+	var bookings []Booking
+	for rows.Next() {
+		b, err := scanBooking(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan booking: %w", err)
+		}
+		bookings = append(bookings, *b)
+	}
+	return bookings, rows.Err()
+}
+
+func scanService(row pgx.Row) (*WashService, error) {
+	s := &WashService{}
+	err := row.Scan(
+		&s.ID, &s.TenantID, &s.Name, &s.Description, &s.DurationMinutes,
+		&s.BasePriceCents, &s.Active, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func scanBox(row pgx.Row) (*Box, error) {
+	b := &Box{}
+	if err := row.Scan(&b.ID, &b.TenantID, &b.Name, &b.Active, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func scanBooking(row pgx.Row) (*Booking, error) {
+	b := &Booking{}
+	err := row.Scan(
+		&b.ID, &b.TenantID, &b.CustomerID, &b.BoxID, &b.ServiceID, &b.SubscriptionID,
+		&b.StartsAt, &b.EndsAt, &b.Status, &b.Notes, &b.CreatedAt, &b.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// isExclusionViolation matches bookings_no_overlap (SQLSTATE 23P01).
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+func nonNil(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
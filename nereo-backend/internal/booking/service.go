@@ -0,0 +1,409 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/tenant"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrServiceNotOffered    = errors.New("service not offered by this box")
+	ErrStartsInPast         = errors.New("booking must start in the future")
+	ErrOutsideOpeningHours  = errors.New("booking outside opening hours")
+	ErrSlotLocked           = errors.New("slot is being booked by another request")
+	ErrSubscriptionInactive = errors.New("subscription is not active")
+	ErrInvalidTransition    = errors.New("invalid booking status transition")
+	ErrInvalidDate          = errors.New("invalid date")
+)
+
+const (
+	// bookingLockTTL is how long a slot stays locked. The lock is never
+	// released: the request's transaction commits after CreateBooking
+	// returns, so the booking only becomes visible to IsSlotAvailable later.
+	bookingLockTTL = 10 * time.Second
+	// defaultTimezone is used when a tenant has an unknown timezone.
+	defaultTimezone = "America/Argentina/Buenos_Aires"
+)
+
+// transitions lists the statuses a booking can move to from each status.
+var transitions = map[string][]string{
+	StatusConfirmed:  {StatusInProgress, StatusNoShow, StatusCancelled},
+	StatusInProgress: {StatusCompleted},
+}
+
+type Service struct {
+	repo       *Repository
+	tenantRepo *tenant.Repository
+	redis      *goredis.Client
+}
+
+func NewService(db *pgxpool.Pool, redis *goredis.Client) *Service {
+	return &Service{
+		repo:       NewRepository(db),
+		tenantRepo: tenant.NewRepository(db),
+		redis:      redis,
+	}
+}
+
+// ============================================================
+// Services
+// ============================================================
+
+func (s *Service) CreateService(ctx context.Context, tenantID uuid.UUID, req CreateServiceRequest) (*WashService, error) {
+	svc := &WashService{
+		ID:              uuid.New(),
+		TenantID:        tenantID,
+		Name:            req.Name,
+		Description:     req.Description,
+		DurationMinutes: req.DurationMinutes,
+		BasePriceCents:  req.BasePriceCents,
+		Active:          true,
+	}
+
+	if err := s.repo.CreateService(ctx, svc); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+func (s *Service) GetService(ctx context.Context, tenantID, serviceID uuid.UUID) (*WashService, error) {
+	return s.repo.GetServiceByID(ctx, tenantID, serviceID)
+}
+
+func (s *Service) ListServices(ctx context.Context, tenantID uuid.UUID) ([]WashService, error) {
+	return s.repo.ListServices(ctx, tenantID)
+}
+
+func (s *Service) UpdateService(ctx context.Context, tenantID, serviceID uuid.UUID, req UpdateServiceRequest) (*WashService, error) {
+	svc, err := s.repo.GetServiceByID(ctx, tenantID, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		svc.Name = *req.Name
+	}
+	if req.Description != nil {
+		svc.Description = req.Description
+	}
+	if req.DurationMinutes != nil {
+		svc.DurationMinutes = *req.DurationMinutes
+	}
+	if req.BasePriceCents != nil {
+		svc.BasePriceCents = *req.BasePriceCents
+	}
+
+	if err := s.repo.UpdateService(ctx, svc); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+func (s *Service) DeactivateService(ctx context.Context, tenantID, serviceID uuid.UUID) error {
+	return s.repo.DeactivateService(ctx, tenantID, serviceID)
+}
+
+// ============================================================
+// Boxes
+// ============================================================
+
+func (s *Service) CreateBox(ctx context.Context, tenantID uuid.UUID, req CreateBoxRequest) (*Box, error) {
+	serviceIDs := dedupe(req.ServiceIDs)
+	if err := s.checkServices(ctx, tenantID, serviceIDs); err != nil {
+		return nil, err
+	}
+
+	box := &Box{
+		ID:         uuid.New(),
+		TenantID:   tenantID,
+		Name:       req.Name,
+		Active:     true,
+		ServiceIDs: serviceIDs,
+	}
+
+	if err := s.repo.CreateBox(ctx, box); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetBoxServices(ctx, tenantID, box.ID, serviceIDs); err != nil {
+		return nil, err
+	}
+	return box, nil
+}
+
+func (s *Service) GetBox(ctx context.Context, tenantID, boxID uuid.UUID) (*Box, error) {
+	return s.repo.GetBoxByID(ctx, tenantID, boxID)
+}
+
+func (s *Service) ListBoxes(ctx context.Context, tenantID uuid.UUID) ([]Box, error) {
+	return s.repo.ListBoxes(ctx, tenantID)
+}
+
+func (s *Service) UpdateBox(ctx context.Context, tenantID, boxID uuid.UUID, req UpdateBoxRequest) (*Box, error) {
+	box, err := s.repo.GetBoxByID(ctx, tenantID, boxID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		box.Name = *req.Name
+	}
+	if req.Active != nil {
+		box.Active = *req.Active
+	}
+
+	if err := s.repo.UpdateBox(ctx, box); err != nil {
+		return nil, err
+	}
+	return box, nil
+}
+
+func (s *Service) DeactivateBox(ctx context.Context, tenantID, boxID uuid.UUID) error {
+	return s.repo.DeactivateBox(ctx, tenantID, boxID)
+}
+
+// SetBoxServices replaces the services offered by a box. Existing bookings
+// are not affected.
+func (s *Service) SetBoxServices(ctx context.Context, tenantID, boxID uuid.UUID, req SetBoxServicesRequest) (*Box, error) {
+	box, err := s.repo.GetBoxByID(ctx, tenantID, boxID)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceIDs := dedupe(req.ServiceIDs)
+	if err := s.checkServices(ctx, tenantID, serviceIDs); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SetBoxServices(ctx, tenantID, boxID, serviceIDs); err != nil {
+		return nil, err
+	}
+	box.ServiceIDs = serviceIDs
+	return box, nil
+}
+
+// checkServices verifies every ID is an active service of the tenant. The
+// foreign key alone would accept services of another tenant.
+func (s *Service) checkServices(ctx context.Context, tenantID uuid.UUID, serviceIDs []uuid.UUID) error {
+	if len(serviceIDs) == 0 {
+		return nil
+	}
+	n, err := s.repo.CountActiveServices(ctx, tenantID, serviceIDs)
+	if err != nil {
+		return err
+	}
+	if n != len(serviceIDs) {
+		return ErrServiceNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// Bookings
+// ============================================================
+
+// CreateBooking books a box for a service. Overbooking is prevented by a
+// Redis lock on the exact slot, a locking availability check and, as the
+// final guarantee, the bookings_no_overlap exclusion constraint.
+func (s *Service) CreateBooking(ctx context.Context, tenantID uuid.UUID, req CreateBookingRequest) (*Booking, error) {
+	startsAt := req.StartsAt.UTC()
+	if !startsAt.After(time.Now()) {
+		return nil, ErrStartsInPast
+	}
+
+	svc, err := s.repo.GetServiceByID(ctx, tenantID, req.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if !svc.Active {
+		return nil, ErrServiceNotFound
+	}
+
+	box, err := s.repo.GetBoxByID(ctx, tenantID, req.BoxID)
+	if err != nil {
+		return nil, err
+	}
+	if !box.Active {
+		return nil, ErrBoxNotFound
+	}
+
+	offers, err := s.repo.BoxOffersService(ctx, tenantID, box.ID, svc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !offers {
+		return nil, ErrServiceNotOffered
+	}
+
+	exists, err := s.repo.CustomerExists(ctx, tenantID, req.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrCustomerNotFound
+	}
+
+	if req.SubscriptionID != nil {
+		status, err := s.repo.GetSubscriptionStatus(ctx, tenantID, req.CustomerID, *req.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		if status != "active" {
+			return nil, ErrSubscriptionInactive
+		}
+	}
+
+	t, err := s.tenantRepo.GetByID(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !withinOpeningHours(t, startsAt, time.Duration(svc.DurationMinutes)*time.Minute) {
+		return nil, ErrOutsideOpeningHours
+	}
+	endsAt := calculateEndsAt(t, svc, startsAt)
+
+	lockKey := fmt.Sprintf("lock:booking:%s:%s:%d", tenantID, box.ID, startsAt.Unix())
+	acquired, err := s.redis.SetNX(ctx, lockKey, "1", bookingLockTTL).Result()
+	switch {
+	case err != nil:
+		// The exclusion constraint still protects the slot.
+		slog.Warn("booking lock unavailable", "error", err, "tenant_id", tenantID, "box_id", box.ID)
+	case !acquired:
+		return nil, ErrSlotLocked
+	}
+
+	available, err := s.repo.IsSlotAvailable(ctx, tenantID, box.ID, startsAt, endsAt)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, ErrSlotNotAvailable
+	}
+
+	booking := &Booking{
+		ID:             uuid.New(),
+		TenantID:       tenantID,
+		CustomerID:     req.CustomerID,
+		BoxID:          box.ID,
+		ServiceID:      svc.ID,
+		SubscriptionID: req.SubscriptionID,
+		StartsAt:       startsAt,
+		EndsAt:         endsAt,
+		Status:         StatusConfirmed,
+		Notes:          req.Notes,
+	}
+
+	if err := s.repo.CreateBooking(ctx, booking); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+// ListBookings returns the bookings that start on the given local date
+// (YYYY-MM-DD, tenant timezone). An empty date means today.
+func (s *Service) ListBookings(ctx context.Context, tenantID uuid.UUID, date string, boxID *uuid.UUID, status string) ([]Booking, error) {
+	t, err := s.tenantRepo.GetByID(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	loc := tenantLocation(t)
+
+	day := time.Now().In(loc)
+	if date != "" {
+		day, err = time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+
+	return s.repo.ListBookings(ctx, tenantID, ListFilter{
+		From:   from,
+		To:     from.AddDate(0, 0, 1),
+		BoxID:  boxID,
+		Status: status,
+	})
+}
+
+func (s *Service) UpdateStatus(ctx context.Context, tenantID, bookingID uuid.UUID, status string) (*Booking, error) {
+	booking, err := s.repo.GetBookingByID(ctx, tenantID, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	return booking, s.transition(ctx, booking, status)
+}
+
+// CancelBooking cancels a confirmed booking, even one about to start (e.g.
+// a customer calling an hour before). The slot is released immediately.
+func (s *Service) CancelBooking(ctx context.Context, tenantID, bookingID uuid.UUID) (*Booking, error) {
+	booking, err := s.repo.GetBookingByID(ctx, tenantID, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	return booking, s.transition(ctx, booking, StatusCancelled)
+}
+
+func (s *Service) transition(ctx context.Context, booking *Booking, to string) error {
+	from := booking.Status
+	allowed := false
+	for _, next := range transitions[from] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrInvalidTransition
+	}
+
+	booking.Status = to
+	return s.repo.UpdateBookingStatus(ctx, booking, from)
+}
+
+// calculateEndsAt adds the service duration and the tenant's buffer between
+// slots, so the persisted range also reserves the maneuvering time.
+func calculateEndsAt(t *tenant.Tenant, svc *WashService, startsAt time.Time) time.Time {
+	totalMinutes := svc.DurationMinutes + t.BufferBetweenSlots
+	return startsAt.Add(time.Duration(totalMinutes) * time.Minute)
+}
+
+// withinOpeningHours reports whether a wash of duration starting at startsAt
+// fits between the tenant's opening and closing time that day, the range
+// GetAvailability offers slots in.
+func withinOpeningHours(t *tenant.Tenant, startsAt time.Time, duration time.Duration) bool {
+	loc := tenantLocation(t)
+	day := startsAt.In(loc)
+	openHour, openMin := parseClock(t.Settings.OpenTime, defaultOpenTime)
+	closeHour, closeMin := parseClock(t.Settings.CloseTime, defaultCloseTime)
+	opening := time.Date(day.Year(), day.Month(), day.Day(), openHour, openMin, 0, 0, loc)
+	closing := time.Date(day.Year(), day.Month(), day.Day(), closeHour, closeMin, 0, 0, loc)
+	return !startsAt.Before(opening) && !startsAt.Add(duration).After(closing)
+}
+
+func tenantLocation(t *tenant.Tenant) *time.Location {
+	if loc, err := time.LoadLocation(t.Timezone); err == nil {
+		return loc
+	}
+	if loc, err := time.LoadLocation(defaultTimezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+func dedupe(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
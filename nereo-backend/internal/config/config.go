@@ -2,6 +2,7 @@ package config
 
 import (
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -13,6 +14,9 @@ type Config struct {
 	Redis       RedisConfig
 	JWT         JWTConfig
 	MercadoPago MercadoPagoConfig
+	QR          QRConfig
+	Admin       AdminConfig
+	Mail        MailConfig
 }
 
 type ServerConfig struct {
@@ -22,7 +26,8 @@ type ServerConfig struct {
 }
 
 type DatabaseConfig struct {
-	URL string
+	URL    string // owner role: migrations, webhooks and background jobs
+	AppURL string // non-owner role used by HTTP requests so RLS is enforced
 }
 
 type RedisConfig struct {
@@ -42,6 +47,42 @@ type MercadoPagoConfig struct {
 	BackURLSuccess string
 	BackURLFailure string
 	BackURLPending string
+	WebhookWorkers int // goroutines draining the webhook inbox
+	// CheckoutTTL is how long a Checkout Pro preference can be paid; its
+	// pending_payment subscription is cancelled afterwards.
+	CheckoutTTL time.Duration
+
+	// Per-tenant accounts (OAuth). AccessToken above is the platform account,
+	// used for tenants that did not connect one.
+	ClientID              string
+	ClientSecret          string
+	OAuthRedirectURL      string  // our /api/v1/mercadopago/oauth/callback, as registered in the MP app
+	OAuthReturnURL        string  // front-end page the callback redirects to; JSON response when empty
+	TokenEncryptionKey    string  // encrypts the stored tenant tokens
+	MarketplaceFeePercent float64 // platform fee on Checkout Pro payments of connected accounts; 0 = none
+}
+
+// QRConfig signs the membership card tokens scanned at the counter.
+type QRConfig struct {
+	Secret   string        // master secret the per-tenant Ed25519 keys are derived from
+	TokenTTL time.Duration // how long a displayed QR stays valid
+}
+
+// AdminConfig protects the platform admin endpoints (/api/v1/admin).
+type AdminConfig struct {
+	Token string // sent as X-Admin-Token; admin endpoints are disabled when empty
+}
+
+// MailConfig sends the password reset and email verification links. Without
+// an SMTP host mails are written to Dir, or only logged when Dir is empty.
+type MailConfig struct {
+	From         string // "Nereo <no-reply@nereo.ar>"
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	Dir          string // development: one .eml file per mail
+	AppURL       string // front-end base URL the links point to
 }
 
 func Load() (*Config, error) {
@@ -52,6 +93,12 @@ func Load() (*Config, error) {
 	viper.SetDefault("GIN_MODE", "debug")
 	viper.SetDefault("JWT_ACCESS_TTL", "15m")
 	viper.SetDefault("JWT_REFRESH_TTL", "168h")
+	viper.SetDefault("QR_TOKEN_TTL", "5m")
+	viper.SetDefault("MP_WEBHOOK_WORKERS", 4)
+	viper.SetDefault("MP_CHECKOUT_TTL", "48h")
+	viper.SetDefault("MAIL_FROM", "Nereo <no-reply@nereo.ar>")
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("APP_URL", "http://localhost:3000")
 
 	if err := viper.ReadInConfig(); err != nil {
 		// .env file is optional; env vars take precedence
@@ -68,6 +115,28 @@ func Load() (*Config, error) {
 		refreshTTL = 7 * 24 * time.Hour
 	}
 
+	qrTTL, err := time.ParseDuration(viper.GetString("QR_TOKEN_TTL"))
+	if err != nil {
+		qrTTL = 5 * time.Minute
+	}
+
+	qrSecret := viper.GetString("QR_SIGNING_SECRET")
+	if qrSecret == "" {
+		slog.Warn("config: QR_SIGNING_SECRET not set, deriving QR keys from JWT_SECRET")
+		qrSecret = viper.GetString("JWT_SECRET")
+	}
+
+	mpTokenKey := viper.GetString("MP_TOKEN_ENCRYPTION_KEY")
+	if mpTokenKey == "" {
+		slog.Warn("config: MP_TOKEN_ENCRYPTION_KEY not set, deriving it from JWT_SECRET")
+		mpTokenKey = viper.GetString("JWT_SECRET")
+	}
+
+	checkoutTTL, err := time.ParseDuration(viper.GetString("MP_CHECKOUT_TTL"))
+	if err != nil {
+		checkoutTTL = 48 * time.Hour
+	}
+
 	mpBaseURL := viper.GetString("MP_BASE_URL")
 	if mpBaseURL == "" {
 		mpBaseURL = "https://api.mercadopago.com"
@@ -89,7 +158,8 @@ func Load() (*Config, error) {
 			CORSOrigins: viper.GetString("CORS_ORIGINS"),
 		},
 		Database: DatabaseConfig{
-			URL: viper.GetString("DATABASE_URL"),
+			URL:    viper.GetString("DATABASE_URL"),
+			AppURL: viper.GetString("APP_DATABASE_URL"),
 		},
 		Redis: RedisConfig{
 			URL: viper.GetString("REDIS_URL"),
@@ -106,6 +176,31 @@ func Load() (*Config, error) {
 			BackURLSuccess: viper.GetString("MP_BACK_URL_SUCCESS"),
 			BackURLFailure: viper.GetString("MP_BACK_URL_FAILURE"),
 			BackURLPending: viper.GetString("MP_BACK_URL_PENDING"),
+			WebhookWorkers: viper.GetInt("MP_WEBHOOK_WORKERS"),
+			CheckoutTTL:    checkoutTTL,
+
+			ClientID:              viper.GetString("MP_CLIENT_ID"),
+			ClientSecret:          viper.GetString("MP_CLIENT_SECRET"),
+			OAuthRedirectURL:      viper.GetString("MP_OAUTH_REDIRECT_URL"),
+			OAuthReturnURL:        viper.GetString("MP_OAUTH_RETURN_URL"),
+			TokenEncryptionKey:    mpTokenKey,
+			MarketplaceFeePercent: viper.GetFloat64("MP_MARKETPLACE_FEE_PERCENT"),
+		},
+		QR: QRConfig{
+			Secret:   qrSecret,
+			TokenTTL: qrTTL,
+		},
+		Admin: AdminConfig{
+			Token: viper.GetString("ADMIN_API_TOKEN"),
+		},
+		Mail: MailConfig{
+			From:         viper.GetString("MAIL_FROM"),
+			SMTPHost:     viper.GetString("SMTP_HOST"),
+			SMTPPort:     viper.GetInt("SMTP_PORT"),
+			SMTPUsername: viper.GetString("SMTP_USERNAME"),
+			SMTPPassword: viper.GetString("SMTP_PASSWORD"),
+			Dir:          viper.GetString("MAIL_DIR"),
+			AppURL:       strings.TrimRight(viper.GetString("APP_URL"), "/"),
 		},
 	}
 
@@ -0,0 +1,143 @@
+package customer
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/phone"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var req CreateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	customer, err := h.service.Create(c.Request.Context(), tenantID, req)
+	if err != nil {
+		if errors.Is(err, phone.ErrInvalidPhone) {
+			httputil.BadRequest(c, "INVALID_PHONE", "phone must be a valid Argentine number")
+			return
+		}
+		if errors.Is(err, ErrPhoneTaken) {
+			httputil.Conflict(c, "PHONE_TAKEN", "a customer with this phone already exists")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.Created(c, customer)
+}
+
+func (h *Handler) Get(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	customerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid customer id")
+		return
+	}
+
+	customer, err := h.service.Get(c.Request.Context(), tenantID, customerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, customer)
+}
+
+// List returns a paginated list of customers. ?q= searches by name, phone or plate.
+func (h *Handler) List(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	page, perPage := httputil.ParsePagination(c)
+
+	customers, total, err := h.service.List(c.Request.Context(), tenantID, ListFilter{
+		Query:   c.Query("q"),
+		Page:    page,
+		PerPage: perPage,
+	})
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	if customers == nil {
+		customers = []Customer{}
+	}
+
+	httputil.Paginated(c, customers, page, perPage, total)
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	customerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid customer id")
+		return
+	}
+
+	var req UpdateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	customer, err := h.service.Update(c.Request.Context(), tenantID, customerID, req)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
+		if errors.Is(err, phone.ErrInvalidPhone) {
+			httputil.BadRequest(c, "INVALID_PHONE", "phone must be a valid Argentine number")
+			return
+		}
+		if errors.Is(err, ErrPhoneTaken) {
+			httputil.Conflict(c, "PHONE_TAKEN", "a customer with this phone already exists")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, customer)
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	customerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid customer id")
+		return
+	}
+
+	if err := h.service.Delete(c.Request.Context(), tenantID, customerID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
@@ -0,0 +1,45 @@
+package customer
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Customer struct {
+	ID           uuid.UUID `json:"id"`
+	TenantID     uuid.UUID `json:"tenant_id"`
+	FullName     string    `json:"full_name"`
+	Phone        string    `json:"phone"`
+	Email        *string   `json:"email,omitempty"`
+	VehiclePlate *string   `json:"vehicle_plate,omitempty"`
+	VehicleModel *string   `json:"vehicle_model,omitempty"`
+	Notes        *string   `json:"notes,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type CreateCustomerRequest struct {
+	FullName     string  `json:"full_name" binding:"required,min=2,max=255"`
+	Phone        string  `json:"phone" binding:"required,min=6,max=30"`
+	Email        *string `json:"email" binding:"omitempty,email,max=255"`
+	VehiclePlate *string `json:"vehicle_plate" binding:"omitempty,max=20"`
+	VehicleModel *string `json:"vehicle_model" binding:"omitempty,max=100"`
+	Notes        *string `json:"notes"`
+}
+
+type UpdateCustomerRequest struct {
+	FullName     *string `json:"full_name" binding:"omitempty,min=2,max=255"`
+	Phone        *string `json:"phone" binding:"omitempty,min=6,max=30"`
+	Email        *string `json:"email" binding:"omitempty,email,max=255"`
+	VehiclePlate *string `json:"vehicle_plate" binding:"omitempty,max=20"`
+	VehicleModel *string `json:"vehicle_model" binding:"omitempty,max=100"`
+	Notes        *string `json:"notes"`
+}
+
+// ListFilter narrows down GET /customers. Query matches name, phone or plate.
+type ListFilter struct {
+	Query   string
+	Page    int
+	PerPage int
+}
@@ -0,0 +1,168 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+var (
+	ErrNotFound   = errors.New("customer not found")
+	ErrPhoneTaken = errors.New("phone already registered for this tenant")
+)
+
+const customerColumns = `id, tenant_id, full_name, phone, email, vehicle_plate, vehicle_model, notes, created_at, updated_at`
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
+func (r *Repository) Create(ctx context.Context, c *Customer) error {
+	query := `
+		INSERT INTO customers (id, tenant_id, full_name, phone, email, vehicle_plate, vehicle_model, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at, updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		c.ID, c.TenantID, c.FullName, c.Phone, c.Email, c.VehiclePlate, c.VehicleModel, c.Notes,
+	).Scan(&c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err, phoneIndex) {
+			return ErrPhoneTaken
+		}
+		return fmt.Errorf("insert customer: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetByID(ctx context.Context, tenantID, customerID uuid.UUID) (*Customer, error) {
+	query := `SELECT ` + customerColumns + `
+		FROM customers
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`
+
+	c, err := scanCustomer(r.conn(ctx).QueryRow(ctx, query, customerID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get customer: %w", err)
+	}
+
+	return c, nil
+}
+
+func (r *Repository) List(ctx context.Context, tenantID uuid.UUID, f ListFilter) ([]Customer, int64, error) {
+	where := " WHERE tenant_id = $1 AND deleted_at IS NULL"
+	args := []interface{}{tenantID}
+
+	if q := strings.TrimSpace(f.Query); q != "" {
+		args = append(args, "%"+escapeLike(q)+"%", "%"+escapeLike(NormalizePlate(q))+"%")
+		where += fmt.Sprintf(` AND (full_name ILIKE $%d OR phone ILIKE $%d OR vehicle_plate ILIKE $%d)`,
+			len(args)-1, len(args)-1, len(args))
+	}
+
+	var total int64
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM customers"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count customers: %w", err)
+	}
+
+	args = append(args, f.PerPage, (f.Page-1)*f.PerPage)
+	query := `SELECT ` + customerColumns + ` FROM customers` + where +
+		fmt.Sprintf(" ORDER BY full_name ASC, created_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list customers: %w", err)
+	}
+	defer rows.Close()
+
+	var customers []Customer
+	for rows.Next() {
+		c, err := scanCustomer(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan customer: %w", err)
+		}
+		customers = append(customers, *c)
+	}
+
+	return customers, total, rows.Err()
+}
+
+func (r *Repository) Update(ctx context.Context, c *Customer) error {
+	query := `
+		UPDATE customers
+		SET full_name = $1, phone = $2, email = $3, vehicle_plate = $4, vehicle_model = $5, notes = $6, updated_at = NOW()
+		WHERE id = $7 AND tenant_id = $8 AND deleted_at IS NULL
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		c.FullName, c.Phone, c.Email, c.VehiclePlate, c.VehicleModel, c.Notes, c.ID, c.TenantID,
+	).Scan(&c.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if isUniqueViolation(err, phoneIndex) {
+			return ErrPhoneTaken
+		}
+		return fmt.Errorf("update customer: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SoftDelete(ctx context.Context, tenantID, customerID uuid.UUID) error {
+	query := `UPDATE customers SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`
+	tag, err := r.conn(ctx).Exec(ctx, query, customerID, tenantID)
+	if err != nil {
+		return fmt.Errorf("delete customer: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanCustomer(row pgx.Row) (*Customer, error) {
+	c := &Customer{}
+	err := row.Scan(
+		&c.ID, &c.TenantID, &c.FullName, &c.Phone, &c.Email,
+		&c.VehiclePlate, &c.VehicleModel, &c.Notes, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// phoneIndex keeps the phones of a tenant's customers unique, deleted
+// customers aside.
+const phoneIndex = "idx_customers_phone"
+
+// isUniqueViolation reports whether err violates the given unique constraint
+// or index; other violations are not ErrPhoneTaken.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,114 @@
+package customer
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/phone"
+)
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		repo: NewRepository(db),
+	}
+}
+
+func (s *Service) Create(ctx context.Context, tenantID uuid.UUID, req CreateCustomerRequest) (*Customer, error) {
+	normalized, err := phone.NormalizePhoneAR(req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Customer{
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		FullName:     strings.TrimSpace(req.FullName),
+		Phone:        normalized,
+		Email:        req.Email,
+		VehiclePlate: normalizePlatePtr(req.VehiclePlate),
+		VehicleModel: req.VehicleModel,
+		Notes:        req.Notes,
+	}
+
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *Service) Get(ctx context.Context, tenantID, customerID uuid.UUID) (*Customer, error) {
+	return s.repo.GetByID(ctx, tenantID, customerID)
+}
+
+func (s *Service) List(ctx context.Context, tenantID uuid.UUID, f ListFilter) ([]Customer, int64, error) {
+	// A full phone typed in any format should find the stored E.164 number
+	if normalized, err := phone.NormalizePhoneAR(f.Query); err == nil {
+		f.Query = normalized
+	}
+	return s.repo.List(ctx, tenantID, f)
+}
+
+func (s *Service) Update(ctx context.Context, tenantID, customerID uuid.UUID, req UpdateCustomerRequest) (*Customer, error) {
+	c, err := s.repo.GetByID(ctx, tenantID, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.FullName != nil {
+		c.FullName = strings.TrimSpace(*req.FullName)
+	}
+	if req.Phone != nil {
+		normalized, err := phone.NormalizePhoneAR(*req.Phone)
+		if err != nil {
+			return nil, err
+		}
+		c.Phone = normalized
+	}
+	if req.Email != nil {
+		c.Email = req.Email
+	}
+	if req.VehiclePlate != nil {
+		c.VehiclePlate = normalizePlatePtr(req.VehiclePlate)
+	}
+	if req.VehicleModel != nil {
+		c.VehicleModel = req.VehicleModel
+	}
+	if req.Notes != nil {
+		c.Notes = req.Notes
+	}
+
+	if err := s.repo.Update(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *Service) Delete(ctx context.Context, tenantID, customerID uuid.UUID) error {
+	return s.repo.SoftDelete(ctx, tenantID, customerID)
+}
+
+// NormalizePlate uppercases a plate and strips spaces and dashes so that
+// "ab 123 cd", "AB-123-CD" and "AB123CD" are stored and searched the same way.
+func NormalizePlate(p string) string {
+	p = strings.ToUpper(strings.TrimSpace(p))
+	return strings.NewReplacer(" ", "", "-", "").Replace(p)
+}
+
+func normalizePlatePtr(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	n := NormalizePlate(*p)
+	if n == "" {
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,119 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+// StartPauseCron runs a background goroutine that resumes the subscriptions
+// whose pause reached resume_by. service must be built on the owner pool: it
+// looks for expired pauses across tenants.
+func StartPauseCron(service *Service) {
+	ticker := time.NewTicker(15 * time.Minute)
+
+	go func() {
+		// Run once on startup after a short delay
+		time.Sleep(30 * time.Second)
+		service.resumeExpiredPauses()
+
+		for range ticker.C {
+			service.resumeExpiredPauses()
+		}
+	}()
+
+	slog.Info("pause cron started", "interval", "15m")
+}
+
+func (s *Service) resumeExpiredPauses() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	pauses, err := s.repo.ListExpiredPauses(ctx)
+	if err != nil {
+		slog.Error("cron: failed to get expired pauses", "error", err)
+		return
+	}
+
+	if len(pauses) == 0 {
+		return
+	}
+
+	slog.Info("cron: resuming paused subscriptions", "count", len(pauses))
+
+	for _, p := range pauses {
+		err := database.RunInTenantTx(ctx, s.db, p.TenantID, func(ctx context.Context) error {
+			sub, err := s.repo.GetSubscriptionForUpdate(ctx, p.TenantID, p.SubscriptionID)
+			if err != nil {
+				return err
+			}
+			if sub.Status != StatusPaused {
+				// Cancelled (or otherwise moved on) while paused: just close it.
+				return s.repo.EndOpenPause(ctx, p.TenantID, p.SubscriptionID)
+			}
+			_, err = s.resume(ctx, sub, nil)
+			return err
+		})
+		if err != nil {
+			slog.Error("cron: failed to resume subscription", "error", err, "subscription_id", p.SubscriptionID)
+			continue
+		}
+		slog.Info("cron: subscription resumed", "subscription_id", p.SubscriptionID)
+	}
+}
+
+// StartCheckoutCron runs a background goroutine that cancels the
+// pending_payment subscriptions whose Checkout Pro preference was not paid
+// within ttl. service must be built on the owner pool.
+func StartCheckoutCron(service *Service, ttl time.Duration) {
+	ticker := time.NewTicker(15 * time.Minute)
+
+	go func() {
+		time.Sleep(30 * time.Second)
+		service.ExpireAbandonedCheckouts(context.Background(), time.Now().Add(-ttl))
+
+		for range ticker.C {
+			service.ExpireAbandonedCheckouts(context.Background(), time.Now().Add(-ttl))
+		}
+	}()
+
+	slog.Info("checkout cron started", "interval", "15m", "ttl", ttl)
+}
+
+// ExpireAbandonedCheckouts cancels the pending_payment subscriptions created
+// before createdBefore. One paid meanwhile is left alone by the state
+// machine.
+func (s *Service) ExpireAbandonedCheckouts(ctx context.Context, createdBefore time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	subs, err := s.repo.ListAbandonedCheckouts(ctx, createdBefore)
+	if err != nil {
+		slog.Error("cron: failed to get abandoned checkouts", "error", err)
+		return
+	}
+
+	for _, sub := range subs {
+		err := database.RunInTenantTx(ctx, s.db, sub.TenantID, func(ctx context.Context) error {
+			_, err := s.repo.TransitionStatus(ctx, &StatusChange{
+				TenantID:       sub.TenantID,
+				SubscriptionID: sub.ID,
+				ToStatus:       StatusCancelled,
+				Reason:         ReasonCheckoutExpired,
+				ActorType:      ActorSystem,
+			})
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				continue // paid while we were looking
+			}
+			slog.Error("cron: failed to cancel abandoned checkout", "error", err, "subscription_id", sub.ID)
+			continue
+		}
+		slog.Info("cron: abandoned checkout cancelled", "subscription_id", sub.ID)
+	}
+}
@@ -2,11 +2,13 @@ package membership
 
 import (
 	"errors"
+	"log/slog"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/nereo-ar/backend/internal/middleware"
 	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/money"
 )
 
 type Handler struct {
@@ -32,6 +34,10 @@ func (h *Handler) CreatePlan(c *gin.Context) {
 
 	plan, err := h.service.CreatePlan(c.Request.Context(), tenantID, req)
 	if err != nil {
+		if errors.Is(err, money.ErrInvalidCurrency) {
+			httputil.BadRequest(c, "INVALID_CURRENCY", err.Error())
+			return
+		}
 		httputil.InternalError(c)
 		return
 	}
@@ -129,6 +135,7 @@ func (h *Handler) DeactivatePlan(c *gin.Context) {
 
 func (h *Handler) CreateSubscription(c *gin.Context) {
 	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
 
 	var req CreateSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -136,12 +143,20 @@ func (h *Handler) CreateSubscription(c *gin.Context) {
 		return
 	}
 
-	sub, err := h.service.CreateSubscription(c.Request.Context(), tenantID, req)
+	sub, err := h.service.CreateSubscription(c.Request.Context(), tenantID, userID, req)
 	if err != nil {
+		if errors.Is(err, ErrCheckoutRequired) {
+			httputil.BadRequest(c, "CHECKOUT_REQUIRED", "create Mercado Pago subscriptions through /payments/preference or /payments/subscription")
+			return
+		}
 		if errors.Is(err, ErrPlanNotFound) {
 			httputil.NotFound(c, "plan not found")
 			return
 		}
+		if errors.Is(err, ErrCustomerNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
 		httputil.InternalError(c)
 		return
 	}
@@ -176,6 +191,33 @@ func (h *Handler) ListSubscriptions(c *gin.Context) {
 }
 
 func (h *Handler) CancelSubscription(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	if err := h.service.CancelSubscription(c.Request.Context(), tenantID, subID, userID); err != nil {
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrPaymentProvider):
+			slog.Error("failed to cancel MP preapproval", "error", err, "subscription_id", subID)
+			httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not cancel the subscription in Mercado Pago")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.OK(c, gin.H{"status": "cancelled"})
+}
+
+// ListStatusHistory returns every status change of the subscription with
+// its reason and actor.
+func (h *Handler) ListStatusHistory(c *gin.Context) {
 	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
 	subID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -183,7 +225,8 @@ func (h *Handler) CancelSubscription(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.CancelSubscription(c.Request.Context(), tenantID, subID); err != nil {
+	history, err := h.service.ListStatusHistory(c.Request.Context(), tenantID, subID)
+	if err != nil {
 		if errors.Is(err, ErrSubscriptionNotFound) {
 			httputil.NotFound(c, "subscription not found")
 			return
@@ -192,7 +235,108 @@ func (h *Handler) CancelSubscription(c *gin.Context) {
 		return
 	}
 
-	httputil.OK(c, gin.H{"status": "cancelled"})
+	if history == nil {
+		history = []StatusChange{}
+	}
+
+	httputil.OK(c, history)
+}
+
+// PauseSubscription freezes the subscription (and its MP preapproval) for up
+// to the plan's max_pause_days.
+func (h *Handler) PauseSubscription(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	var req PauseSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	pause, err := h.service.PauseSubscription(c.Request.Context(), tenantID, subID, userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrAlreadyPaused):
+			httputil.Conflict(c, "ALREADY_PAUSED", "subscription is already paused")
+		case errors.Is(err, ErrSubscriptionInactive):
+			httputil.Conflict(c, "SUBSCRIPTION_INACTIVE", "only active subscriptions can be paused")
+		case errors.Is(err, ErrPauseNotAllowed):
+			httputil.Conflict(c, "PAUSE_NOT_ALLOWED", "the plan does not allow pausing")
+		case errors.Is(err, ErrPauseTooLong):
+			httputil.BadRequest(c, "PAUSE_TOO_LONG", "days exceeds the plan's max_pause_days")
+		case errors.Is(err, ErrPaymentProvider):
+			slog.Error("failed to pause MP preapproval", "error", err, "subscription_id", subID)
+			httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not pause the subscription in Mercado Pago")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.Created(c, pause)
+}
+
+// ResumeSubscription ends the pause early; the period is extended by the
+// time it was paused.
+func (h *Handler) ResumeSubscription(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	sub, err := h.service.ResumeSubscription(c.Request.Context(), tenantID, subID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrNotPaused):
+			httputil.Conflict(c, "NOT_PAUSED", "subscription is not paused")
+		case errors.Is(err, ErrPaymentProvider):
+			slog.Error("failed to resume MP preapproval", "error", err, "subscription_id", subID)
+			httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not resume the subscription in Mercado Pago")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.OK(c, sub)
+}
+
+func (h *Handler) ListPauses(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	pauses, err := h.service.ListPauses(c.Request.Context(), tenantID, subID)
+	if err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			httputil.NotFound(c, "subscription not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	if pauses == nil {
+		pauses = []SubscriptionPause{}
+	}
+
+	httputil.OK(c, pauses)
 }
 
 func (h *Handler) ValidateSubscription(c *gin.Context) {
@@ -215,3 +359,207 @@ func (h *Handler) ValidateSubscription(c *gin.Context) {
 
 	httputil.OK(c, result)
 }
+
+// ============================================================
+// Wash usage
+// ============================================================
+
+// RecordWash checks a customer in: it consumes one wash of the subscription.
+func (h *Handler) RecordWash(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	var req RecordWashRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	result, err := h.service.RecordWash(c.Request.Context(), tenantID, subID, userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrBookingNotFound):
+			httputil.NotFound(c, "booking not found")
+		case errors.Is(err, ErrBoxNotFound):
+			httputil.NotFound(c, "box not found")
+		case errors.Is(err, ErrSubscriptionPaused):
+			httputil.Conflict(c, "SUBSCRIPTION_PAUSED", "subscription is paused")
+		case errors.Is(err, ErrSubscriptionInactive):
+			httputil.Conflict(c, "SUBSCRIPTION_INACTIVE", "subscription is not active")
+		case errors.Is(err, ErrWashLimitReached):
+			httputil.Conflict(c, "WASH_LIMIT_REACHED", "no washes left in the current period")
+		case errors.Is(err, ErrBookingAlreadyCheckedIn):
+			httputil.Conflict(c, "ALREADY_CHECKED_IN", "this booking was already checked in")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.Created(c, result)
+}
+
+// ListWashUsages returns the paginated usage history of a subscription,
+// voided check-ins included.
+func (h *Handler) ListWashUsages(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+	page, perPage := httputil.ParsePagination(c)
+
+	usages, total, err := h.service.ListWashUsages(c.Request.Context(), tenantID, subID, page, perPage)
+	if err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			httputil.NotFound(c, "subscription not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	if usages == nil {
+		usages = []WashUsage{}
+	}
+
+	httputil.Paginated(c, usages, page, perPage, total)
+}
+
+func (h *Handler) VoidWash(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+	usageID, err := uuid.Parse(c.Param("usage_id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid usage id")
+		return
+	}
+
+	var req VoidWashRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	usage, err := h.service.VoidWash(c.Request.Context(), tenantID, subID, usageID, userID, req.Reason)
+	if err != nil {
+		if errors.Is(err, ErrUsageNotFound) {
+			httputil.NotFound(c, "wash usage not found")
+			return
+		}
+		if errors.Is(err, ErrUsageAlreadyVoided) {
+			httputil.Conflict(c, "ALREADY_VOIDED", "wash usage was already voided")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, usage)
+}
+
+// ============================================================
+// QR membership cards
+// ============================================================
+
+func (h *Handler) IssueQRToken(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	token, err := h.service.IssueQRToken(c.Request.Context(), tenantID, subID)
+	if err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			httputil.NotFound(c, "subscription not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, token)
+}
+
+// CheckInWithQR validates a scanned membership card and consumes a wash.
+func (h *Handler) CheckInWithQR(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+
+	var req QRCheckInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	result, err := h.service.CheckInWithQR(c.Request.Context(), tenantID, userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidQRToken):
+			httputil.BadRequest(c, "INVALID_QR", "qr code is not valid for this tenant")
+		case errors.Is(err, ErrQRTokenExpired):
+			httputil.BadRequest(c, "QR_EXPIRED", "qr code expired, ask the customer to refresh it")
+		case errors.Is(err, ErrQRTokenUsed):
+			httputil.Conflict(c, "QR_ALREADY_USED", "qr code was already used")
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrBookingNotFound):
+			httputil.NotFound(c, "booking not found")
+		case errors.Is(err, ErrBoxNotFound):
+			httputil.NotFound(c, "box not found")
+		case errors.Is(err, ErrSubscriptionPaused):
+			httputil.Conflict(c, "SUBSCRIPTION_PAUSED", "subscription is paused")
+		case errors.Is(err, ErrSubscriptionInactive):
+			httputil.Conflict(c, "SUBSCRIPTION_INACTIVE", "subscription is not active")
+		case errors.Is(err, ErrWashLimitReached):
+			httputil.Conflict(c, "WASH_LIMIT_REACHED", "no washes left in the current period")
+		case errors.Is(err, ErrBookingAlreadyCheckedIn):
+			httputil.Conflict(c, "ALREADY_CHECKED_IN", "this booking was already checked in")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.Created(c, result)
+}
+
+// ListQRKeys returns the tenant public keys for offline verification.
+func (h *Handler) ListQRKeys(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	keys, err := h.service.ListQRKeys(c.Request.Context(), tenantID)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, keys)
+}
+
+func (h *Handler) RotateQRKey(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	key, err := h.service.RotateQRKey(c.Request.Context(), tenantID)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.Created(c, key)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/pkg/money"
 )
 
 // ============================================================
@@ -11,37 +12,40 @@ import (
 // ============================================================
 
 type Plan struct {
-	ID          uuid.UUID `json:"id"`
-	TenantID    uuid.UUID `json:"tenant_id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description,omitempty"`
-	PriceCents  int       `json:"price_cents"`
-	Currency    string    `json:"currency"`
-	Interval    string    `json:"interval"`
-	WashLimit   *int      `json:"wash_limit,omitempty"`
-	Includes    []string  `json:"includes"`
-	Active      bool      `json:"active"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID           uuid.UUID      `json:"id"`
+	TenantID     uuid.UUID      `json:"tenant_id"`
+	Name         string         `json:"name"`
+	Description  *string        `json:"description,omitempty"`
+	PriceCents   int            `json:"price_cents"`
+	Currency     money.Currency `json:"currency"`
+	Interval     string         `json:"interval"`
+	WashLimit    *int           `json:"wash_limit,omitempty"`
+	Includes     []string       `json:"includes"`
+	MaxPauseDays int            `json:"max_pause_days"` // 0 disables pausing
+	Active       bool           `json:"active"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
 }
 
 type CreatePlanRequest struct {
-	Name        string   `json:"name" binding:"required,min=2,max=255"`
-	Description *string  `json:"description"`
-	PriceCents  int      `json:"price_cents" binding:"required,gt=0"`
-	Currency    string   `json:"currency" binding:"omitempty,len=3"`
-	Interval    string   `json:"interval" binding:"omitempty,oneof=monthly weekly"`
-	WashLimit   *int     `json:"wash_limit" binding:"omitempty,gt=0"`
-	Includes    []string `json:"includes"`
+	Name         string   `json:"name" binding:"required,min=2,max=255"`
+	Description  *string  `json:"description"`
+	PriceCents   int      `json:"price_cents" binding:"required,gt=0"`
+	Currency     string   `json:"currency" binding:"omitempty,len=3"`
+	Interval     string   `json:"interval" binding:"omitempty,oneof=monthly weekly"`
+	WashLimit    *int     `json:"wash_limit" binding:"omitempty,gt=0"`
+	Includes     []string `json:"includes"`
+	MaxPauseDays *int     `json:"max_pause_days" binding:"omitempty,min=0,max=365"`
 }
 
 type UpdatePlanRequest struct {
-	Name        *string  `json:"name" binding:"omitempty,min=2,max=255"`
-	Description *string  `json:"description"`
-	PriceCents  *int     `json:"price_cents" binding:"omitempty,gt=0"`
-	Interval    *string  `json:"interval" binding:"omitempty,oneof=monthly weekly"`
-	WashLimit   *int     `json:"wash_limit" binding:"omitempty,gt=0"`
-	Includes    []string `json:"includes"`
+	Name         *string  `json:"name" binding:"omitempty,min=2,max=255"`
+	Description  *string  `json:"description"`
+	PriceCents   *int     `json:"price_cents" binding:"omitempty,gt=0"`
+	Interval     *string  `json:"interval" binding:"omitempty,oneof=monthly weekly"`
+	WashLimit    *int     `json:"wash_limit" binding:"omitempty,gt=0"`
+	Includes     []string `json:"includes"`
+	MaxPauseDays *int     `json:"max_pause_days" binding:"omitempty,min=0,max=365"`
 }
 
 // ============================================================
@@ -70,8 +74,120 @@ type CreateSubscriptionRequest struct {
 }
 
 type ValidationResult struct {
-	Valid           bool    `json:"valid"`
-	WashesRemaining *int   `json:"washes_remaining"`
-	ExpiresAt       string `json:"expires_at"`
-	PaymentMethod   string `json:"payment_method"`
+	Valid           bool       `json:"valid"`
+	Status          string     `json:"status"`
+	Reason          string     `json:"reason,omitempty"` // why it is not valid: pending, pending_payment, paused, cancelled, past_due, expired, wash_limit_reached
+	PausedUntil     *time.Time `json:"paused_until,omitempty"`
+	WashesRemaining *int       `json:"washes_remaining"`
+	ExpiresAt       string     `json:"expires_at"`
+	PaymentMethod   string     `json:"payment_method"`
+}
+
+// ============================================================
+// Wash usage
+// ============================================================
+
+type WashUsage struct {
+	ID             uuid.UUID  `json:"id"`
+	TenantID       uuid.UUID  `json:"tenant_id"`
+	SubscriptionID uuid.UUID  `json:"subscription_id"`
+	CustomerID     uuid.UUID  `json:"customer_id"`
+	BookingID      *uuid.UUID `json:"booking_id,omitempty"`
+	BoxID          *uuid.UUID `json:"box_id,omitempty"`
+	VehiclePlate   *string    `json:"vehicle_plate,omitempty"`
+	Notes          *string    `json:"notes,omitempty"`
+	PeriodStart    time.Time  `json:"period_start"`
+	RecordedBy     *uuid.UUID `json:"recorded_by,omitempty"`
+	UsedAt         time.Time  `json:"used_at"`
+	VoidedAt       *time.Time `json:"voided_at,omitempty"`
+	VoidedBy       *uuid.UUID `json:"voided_by,omitempty"`
+	VoidReason     *string    `json:"void_reason,omitempty"`
+}
+
+type RecordWashRequest struct {
+	BookingID    *uuid.UUID `json:"booking_id"`
+	BoxID        *uuid.UUID `json:"box_id"`
+	VehiclePlate *string    `json:"vehicle_plate" binding:"omitempty,max=20"`
+	Notes        *string    `json:"notes"`
+}
+
+type VoidWashRequest struct {
+	Reason string `json:"reason" binding:"required,min=3,max=500"`
+}
+
+// CheckInResult is returned after a wash has been consumed.
+type CheckInResult struct {
+	Usage           WashUsage `json:"usage"`
+	WashesUsed      int       `json:"washes_used"`
+	WashesRemaining *int      `json:"washes_remaining"`
+}
+
+// ============================================================
+// QR membership cards
+// ============================================================
+
+// QRToken is the signed payload the customer's card renders as a QR code.
+type QRToken struct {
+	Token     string    `json:"token"`
+	KeyID     int       `json:"key_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// QRKey is a tenant public key. The employee app caches them to verify
+// tokens offline; retired keys are listed until their last tokens expire.
+type QRKey struct {
+	KeyID     int        `json:"key_id"`
+	Algorithm string     `json:"alg"`
+	PublicKey string     `json:"public_key"` // raw 32 bytes, base64url without padding
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+type QRCheckInRequest struct {
+	Token     string     `json:"token" binding:"required"`
+	BookingID *uuid.UUID `json:"booking_id"`
+	BoxID     *uuid.UUID `json:"box_id"`
+	Notes     *string    `json:"notes"`
+}
+
+// ============================================================
+// Pauses
+// ============================================================
+
+// SubscriptionPause is one pause of a subscription. ResumeBy is the latest
+// it can last, capped by the plan's max_pause_days; the pause cron resumes
+// it then.
+type SubscriptionPause struct {
+	ID             uuid.UUID  `json:"id"`
+	TenantID       uuid.UUID  `json:"tenant_id"`
+	SubscriptionID uuid.UUID  `json:"subscription_id"`
+	Reason         *string    `json:"reason,omitempty"`
+	PausedAt       time.Time  `json:"paused_at"`
+	ResumeBy       time.Time  `json:"resume_by"`
+	ResumedAt      *time.Time `json:"resumed_at,omitempty"`
+	PausedBy       *uuid.UUID `json:"paused_by,omitempty"`
+	ResumedBy      *uuid.UUID `json:"resumed_by,omitempty"`
+}
+
+type PauseSubscriptionRequest struct {
+	Days   *int    `json:"days" binding:"omitempty,min=1,max=365"` // defaults to the plan's max_pause_days
+	Reason *string `json:"reason" binding:"omitempty,max=500"`
+}
+
+// ============================================================
+// Status history
+// ============================================================
+
+// StatusChange is one entry of subscription_status_history. FromStatus is
+// nil for the entry written when the subscription is created.
+type StatusChange struct {
+	ID             uuid.UUID  `json:"id"`
+	TenantID       uuid.UUID  `json:"tenant_id"`
+	SubscriptionID uuid.UUID  `json:"subscription_id"`
+	FromStatus     *string    `json:"from_status"`
+	ToStatus       string     `json:"to_status"`
+	Reason         string     `json:"reason"`
+	ActorType      string     `json:"actor_type"`
+	ActorID        *uuid.UUID `json:"actor_id,omitempty"`
+	ChangedAt      time.Time  `json:"changed_at"`
 }
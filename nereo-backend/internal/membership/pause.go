@@ -0,0 +1,174 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotPaused          = errors.New("subscription is not paused")
+	ErrPauseNotAllowed    = errors.New("plan does not allow pausing")
+	ErrPauseTooLong       = errors.New("pause exceeds the plan's max_pause_days")
+	ErrPaymentProvider    = errors.New("payment provider request failed")
+	ErrSubscriptionPaused = errors.New("subscription is paused")
+)
+
+// defaultMaxPauseDays applies to plans created without max_pause_days.
+const defaultMaxPauseDays = 30
+
+// Mercado Pago preapproval statuses set on pause, resume and cancel.
+const (
+	preapprovalPaused     = "paused"
+	preapprovalAuthorized = "authorized"
+	preapprovalCancelled  = "cancelled"
+)
+
+// PreapprovalUpdater changes the status of the Mercado Pago preapproval
+// behind a subscription, on the account of its tenant. *payment.Clients
+// implements it.
+type PreapprovalUpdater interface {
+	UpdatePreapprovalStatus(ctx context.Context, tenantID uuid.UUID, preapprovalID, status string) error
+}
+
+// PauseSubscription freezes an active subscription. It cannot be used while
+// paused and its period is extended by the paused time on resume. Mercado
+// Pago is called last: if it fails the request transaction rolls back and
+// nothing is paused on our side either.
+func (s *Service) PauseSubscription(ctx context.Context, tenantID, subID, userID uuid.UUID, req PauseSubscriptionRequest) (*SubscriptionPause, error) {
+	sub, err := s.repo.GetSubscriptionForUpdate(ctx, tenantID, subID)
+	if err != nil {
+		return nil, err
+	}
+	switch sub.Status {
+	case StatusActive:
+	case StatusPaused:
+		return nil, ErrAlreadyPaused
+	default:
+		return nil, ErrSubscriptionInactive
+	}
+
+	plan, err := s.repo.GetPlanByID(ctx, tenantID, sub.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.MaxPauseDays == 0 {
+		return nil, ErrPauseNotAllowed
+	}
+	days := plan.MaxPauseDays
+	if req.Days != nil {
+		if *req.Days > plan.MaxPauseDays {
+			return nil, ErrPauseTooLong
+		}
+		days = *req.Days
+	}
+
+	now := time.Now()
+	pause := &SubscriptionPause{
+		ID:             uuid.New(),
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		Reason:         req.Reason,
+		PausedAt:       now,
+		ResumeBy:       now.AddDate(0, 0, days),
+		PausedBy:       &userID,
+	}
+	if err := s.repo.CreatePause(ctx, pause); err != nil {
+		return nil, err
+	}
+	_, err = s.repo.TransitionStatus(ctx, &StatusChange{
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		ToStatus:       StatusPaused,
+		Reason:         ReasonPaused,
+		ActorType:      ActorUser,
+		ActorID:        &userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updatePreapproval(ctx, sub, preapprovalPaused); err != nil {
+		return nil, err
+	}
+
+	return pause, nil
+}
+
+// ResumeSubscription ends the open pause before resume_by.
+func (s *Service) ResumeSubscription(ctx context.Context, tenantID, subID, userID uuid.UUID) (*Subscription, error) {
+	sub, err := s.repo.GetSubscriptionForUpdate(ctx, tenantID, subID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status != StatusPaused {
+		return nil, ErrNotPaused
+	}
+
+	return s.resume(ctx, sub, &userID)
+}
+
+func (s *Service) ListPauses(ctx context.Context, tenantID, subID uuid.UUID) ([]SubscriptionPause, error) {
+	if _, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListPauses(ctx, tenantID, subID)
+}
+
+// resume closes the open pause of a locked, paused subscription. resumedBy is
+// nil when the cron resumes it. A subscription paused from Mercado Pago has
+// no pause of ours: it is reactivated without extending the period.
+func (s *Service) resume(ctx context.Context, sub *Subscription, resumedBy *uuid.UUID) (*Subscription, error) {
+	resumed := sub
+	pause, err := s.repo.GetOpenPause(ctx, sub.TenantID, sub.ID)
+	switch {
+	case err == nil:
+		resumed, err = s.repo.ResumeFromPause(ctx, sub.TenantID, pause.ID, resumedBy)
+		if err != nil {
+			if errors.Is(err, ErrPauseNotFound) {
+				return nil, ErrNotPaused
+			}
+			return nil, err
+		}
+	case !errors.Is(err, ErrPauseNotFound):
+		return nil, err
+	}
+
+	change := &StatusChange{
+		TenantID:       sub.TenantID,
+		SubscriptionID: sub.ID,
+		ToStatus:       StatusActive,
+		Reason:         ReasonResumed,
+		ActorType:      ActorUser,
+		ActorID:        resumedBy,
+	}
+	if resumedBy == nil {
+		change.Reason = ReasonPauseExpired
+		change.ActorType = ActorSystem
+	}
+	if _, err := s.repo.TransitionStatus(ctx, change); err != nil {
+		return nil, err
+	}
+	resumed.Status = StatusActive
+
+	if err := s.updatePreapproval(ctx, sub, preapprovalAuthorized); err != nil {
+		return nil, err
+	}
+
+	return resumed, nil
+}
+
+// updatePreapproval mirrors a pause or resume on Mercado Pago for
+// subscriptions billed through a preapproval.
+func (s *Service) updatePreapproval(ctx context.Context, sub *Subscription, status string) error {
+	if sub.MpSubscriptionID == nil || *sub.MpSubscriptionID == "" {
+		return nil
+	}
+	if err := s.preapprovals.UpdatePreapprovalStatus(ctx, sub.TenantID, *sub.MpSubscriptionID, status); err != nil {
+		return fmt.Errorf("%w: %v", ErrPaymentProvider, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package membership
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+var (
+	ErrInvalidQRToken = errors.New("invalid qr token")
+	ErrQRTokenExpired = errors.New("qr token expired")
+	ErrQRTokenUsed    = errors.New("qr token already used")
+)
+
+const (
+	// qrTokenPrefix versions the token format: "nqr1.<payload>.<signature>".
+	qrTokenPrefix = "nqr1"
+	// qrClockSkew tolerates customer phones whose clock runs a bit ahead.
+	qrClockSkew = 30 * time.Second
+	qrAlgorithm = "Ed25519"
+)
+
+// qrClaims is the signed payload of a membership card token. Field names are
+// short to keep the QR code small.
+type qrClaims struct {
+	TenantID       uuid.UUID `json:"tid"`
+	SubscriptionID uuid.UUID `json:"sid"`
+	KeyID          int       `json:"kid"`
+	IssuedAt       int64     `json:"iat"`
+	ExpiresAt      int64     `json:"exp"`
+	Nonce          string    `json:"jti"`
+}
+
+// IssueQRToken signs a short-lived token for the subscription's card. The
+// customer app asks for a new one before the previous expires, so a
+// photographed code stops working within QR_TOKEN_TTL.
+func (s *Service) IssueQRToken(ctx context.Context, tenantID, subID uuid.UUID) (*QRToken, error) {
+	sub, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.activeQRKey(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate qr nonce: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.qr.TokenTTL)
+	claims := qrClaims{
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		KeyID:          key.KeyID,
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      expiresAt.Unix(),
+		Nonce:          base64.RawURLEncoding.EncodeToString(nonce),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("marshal qr claims: %w", err)
+	}
+	signed := qrTokenPrefix + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(s.qrPrivateKey(tenantID, key.KeyID), []byte(signed))
+
+	return &QRToken{
+		Token:     signed + "." + base64.RawURLEncoding.EncodeToString(signature),
+		KeyID:     key.KeyID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// ListQRKeys returns the public keys that can still verify a live token.
+func (s *Service) ListQRKeys(ctx context.Context, tenantID uuid.UUID) ([]QRKey, error) {
+	if _, err := s.activeQRKey(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.repo.ListQRKeys(ctx, tenantID, time.Now().Add(-s.qr.TokenTTL-qrClockSkew))
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		s.fillPublicKey(tenantID, &keys[i])
+	}
+	return keys, nil
+}
+
+// RotateQRKey switches the tenant to a new key version. Tokens signed with
+// the previous key keep working until they expire.
+func (s *Service) RotateQRKey(ctx context.Context, tenantID uuid.UUID) (*QRKey, error) {
+	key, err := s.repo.RotateQRKey(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	s.fillPublicKey(tenantID, key)
+	return key, nil
+}
+
+// CheckInWithQR validates a scanned token and consumes a wash in one call.
+// The token nonce is burned in Redis before the wash is recorded, so the same
+// code (or a screenshot of it) is accepted once. It is released if the wash
+// is not recorded, including when the request transaction rolls back.
+func (s *Service) CheckInWithQR(ctx context.Context, tenantID, userID uuid.UUID, req QRCheckInRequest) (*CheckInResult, error) {
+	claims, err := s.verifyQRToken(ctx, tenantID, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike the booking lock, replay protection fails closed: without Redis
+	// there is no way to tell a first scan from a replay.
+	usedKey := fmt.Sprintf("qr:used:%s:%s", tenantID, claims.Nonce)
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0)) + qrClockSkew
+	fresh, err := s.redis.SetNX(ctx, usedKey, claims.SubscriptionID.String(), ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("burn qr nonce: %w", err)
+	}
+	if !fresh {
+		return nil, ErrQRTokenUsed
+	}
+	// Nothing was consumed: let the customer retry with the same code, e.g.
+	// after the employee picks the right box.
+	release := func() {
+		if err := s.redis.Del(context.Background(), usedKey).Err(); err != nil {
+			slog.Warn("qr nonce release failed", "error", err)
+		}
+	}
+	database.OnRollback(ctx, release)
+
+	result, err := s.RecordWash(ctx, tenantID, claims.SubscriptionID, userID, RecordWashRequest{
+		BookingID: req.BookingID,
+		BoxID:     req.BoxID,
+		Notes:     req.Notes,
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyQRToken checks the signature, tenant and expiry of a token. It does
+// the same checks the employee app can run offline with ListQRKeys.
+func (s *Service) verifyQRToken(ctx context.Context, tenantID uuid.UUID, token string) (*qrClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != qrTokenPrefix {
+		return nil, ErrInvalidQRToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidQRToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidQRToken
+	}
+
+	var claims qrClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidQRToken
+	}
+	// Another tenant's token: same answer as a forged one.
+	if claims.TenantID != tenantID {
+		return nil, ErrInvalidQRToken
+	}
+
+	key, err := s.repo.GetQRKey(ctx, tenantID, claims.KeyID)
+	if err != nil {
+		if errors.Is(err, ErrQRKeyNotFound) {
+			return nil, ErrInvalidQRToken
+		}
+		return nil, err
+	}
+	if key.RetiredAt != nil && claims.IssuedAt > key.RetiredAt.Unix() {
+		return nil, ErrInvalidQRToken
+	}
+
+	publicKey := s.qrPrivateKey(tenantID, key.KeyID).Public().(ed25519.PublicKey)
+	if !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidQRToken
+	}
+
+	if time.Now().After(time.Unix(claims.ExpiresAt, 0).Add(qrClockSkew)) {
+		return nil, ErrQRTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// activeQRKey returns the tenant's signing key, creating the first one on
+// demand.
+func (s *Service) activeQRKey(ctx context.Context, tenantID uuid.UUID) (*QRKey, error) {
+	key, err := s.repo.GetActiveQRKey(ctx, tenantID)
+	if !errors.Is(err, ErrQRKeyNotFound) {
+		return key, err
+	}
+	if err := s.repo.EnsureQRKey(ctx, tenantID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetActiveQRKey(ctx, tenantID)
+}
+
+// qrPrivateKey derives the Ed25519 key of a tenant and version from the
+// master secret, so key material never needs to be stored.
+func (s *Service) qrPrivateKey(tenantID uuid.UUID, version int) ed25519.PrivateKey {
+	mac := hmac.New(sha256.New, []byte(s.qr.Secret))
+	fmt.Fprintf(mac, "nereo-qr:%s:%d", tenantID, version)
+	return ed25519.NewKeyFromSeed(mac.Sum(nil))
+}
+
+func (s *Service) fillPublicKey(tenantID uuid.UUID, key *QRKey) {
+	publicKey := s.qrPrivateKey(tenantID, key.KeyID).Public().(ed25519.PublicKey)
+	key.Algorithm = qrAlgorithm
+	key.PublicKey = base64.RawURLEncoding.EncodeToString(publicKey)
+}
@@ -5,17 +5,36 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
 )
 
 var (
-	ErrPlanNotFound         = errors.New("plan not found")
-	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrPlanNotFound            = errors.New("plan not found")
+	ErrSubscriptionNotFound    = errors.New("subscription not found")
+	ErrCustomerNotFound        = errors.New("customer not found")
+	ErrBookingNotFound         = errors.New("booking not found")
+	ErrBoxNotFound             = errors.New("box not found")
+	ErrUsageNotFound           = errors.New("wash usage not found")
+	ErrUsageAlreadyVoided      = errors.New("wash usage already voided")
+	ErrBookingAlreadyCheckedIn = errors.New("booking already checked in")
+	ErrSubscriptionInactive    = errors.New("subscription is not active")
+	ErrWashLimitReached        = errors.New("wash limit reached for the current period")
+	ErrQRKeyNotFound           = errors.New("qr signing key not found")
+	ErrPauseNotFound           = errors.New("subscription pause not found")
+	ErrAlreadyPaused           = errors.New("subscription is already paused")
 )
 
+const pauseColumns = `id, tenant_id, subscription_id, reason, paused_at, resume_by, resumed_at, paused_by, resumed_by`
+
+const washUsageColumns = `id, tenant_id, subscription_id, customer_id, booking_id, box_id, vehicle_plate, notes,
+	period_start, recorded_by, used_at, voided_at, voided_by, void_reason`
+
 type Repository struct {
 	db *pgxpool.Pool
 }
@@ -24,6 +43,11 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
 // ============================================================
 // Plans
 // ============================================================
@@ -32,26 +56,26 @@ func (r *Repository) CreatePlan(ctx context.Context, p *Plan) error {
 	includesJSON, _ := json.Marshal(p.Includes)
 
 	query := `
-		INSERT INTO membership_plans (id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO membership_plans (id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, max_pause_days, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING created_at, updated_at`
 
-	return r.db.QueryRow(ctx, query,
-		p.ID, p.TenantID, p.Name, p.Description, p.PriceCents, p.Currency, p.Interval, p.WashLimit, includesJSON, p.Active,
+	return r.conn(ctx).QueryRow(ctx, query,
+		p.ID, p.TenantID, p.Name, p.Description, p.PriceCents, p.Currency, p.Interval, p.WashLimit, includesJSON, p.MaxPauseDays, p.Active,
 	).Scan(&p.CreatedAt, &p.UpdatedAt)
 }
 
 func (r *Repository) GetPlanByID(ctx context.Context, tenantID, planID uuid.UUID) (*Plan, error) {
 	query := `
-		SELECT id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, active, created_at, updated_at
+		SELECT id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, max_pause_days, active, created_at, updated_at
 		FROM membership_plans
 		WHERE id = $1 AND tenant_id = $2`
 
 	p := &Plan{}
 	var includesJSON []byte
-	err := r.db.QueryRow(ctx, query, planID, tenantID).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, planID, tenantID).Scan(
 		&p.ID, &p.TenantID, &p.Name, &p.Description, &p.PriceCents, &p.Currency,
-		&p.Interval, &p.WashLimit, &includesJSON, &p.Active, &p.CreatedAt, &p.UpdatedAt,
+		&p.Interval, &p.WashLimit, &includesJSON, &p.MaxPauseDays, &p.Active, &p.CreatedAt, &p.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -69,7 +93,7 @@ func (r *Repository) GetPlanByID(ctx context.Context, tenantID, planID uuid.UUID
 
 func (r *Repository) ListPlans(ctx context.Context, tenantID uuid.UUID, activeOnly bool) ([]Plan, error) {
 	query := `
-		SELECT id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, active, created_at, updated_at
+		SELECT id, tenant_id, name, description, price_cents, currency, interval, wash_limit, includes, max_pause_days, active, created_at, updated_at
 		FROM membership_plans
 		WHERE tenant_id = $1`
 
@@ -78,7 +102,7 @@ func (r *Repository) ListPlans(ctx context.Context, tenantID uuid.UUID, activeOn
 	}
 	query += " ORDER BY created_at DESC"
 
-	rows, err := r.db.Query(ctx, query, tenantID)
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID)
 	if err != nil {
 		return nil, fmt.Errorf("list plans: %w", err)
 	}
@@ -90,7 +114,7 @@ func (r *Repository) ListPlans(ctx context.Context, tenantID uuid.UUID, activeOn
 		var includesJSON []byte
 		if err := rows.Scan(
 			&p.ID, &p.TenantID, &p.Name, &p.Description, &p.PriceCents, &p.Currency,
-			&p.Interval, &p.WashLimit, &includesJSON, &p.Active, &p.CreatedAt, &p.UpdatedAt,
+			&p.Interval, &p.WashLimit, &includesJSON, &p.MaxPauseDays, &p.Active, &p.CreatedAt, &p.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan plan: %w", err)
 		}
@@ -108,12 +132,12 @@ func (r *Repository) UpdatePlan(ctx context.Context, p *Plan) error {
 
 	query := `
 		UPDATE membership_plans
-		SET name = $1, description = $2, price_cents = $3, interval = $4, wash_limit = $5, includes = $6, updated_at = NOW()
-		WHERE id = $7 AND tenant_id = $8
+		SET name = $1, description = $2, price_cents = $3, interval = $4, wash_limit = $5, includes = $6, max_pause_days = $7, updated_at = NOW()
+		WHERE id = $8 AND tenant_id = $9
 		RETURNING updated_at`
 
-	err := r.db.QueryRow(ctx, query,
-		p.Name, p.Description, p.PriceCents, p.Interval, p.WashLimit, includesJSON, p.ID, p.TenantID,
+	err := r.conn(ctx).QueryRow(ctx, query,
+		p.Name, p.Description, p.PriceCents, p.Interval, p.WashLimit, includesJSON, p.MaxPauseDays, p.ID, p.TenantID,
 	).Scan(&p.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -127,7 +151,7 @@ func (r *Repository) UpdatePlan(ctx context.Context, p *Plan) error {
 
 func (r *Repository) DeactivatePlan(ctx context.Context, tenantID, planID uuid.UUID) error {
 	query := `UPDATE membership_plans SET active = false, updated_at = NOW() WHERE id = $1 AND tenant_id = $2`
-	tag, err := r.db.Exec(ctx, query, planID, tenantID)
+	tag, err := r.conn(ctx).Exec(ctx, query, planID, tenantID)
 	if err != nil {
 		return fmt.Errorf("deactivate plan: %w", err)
 	}
@@ -147,12 +171,27 @@ func (r *Repository) CreateSubscription(ctx context.Context, s *Subscription) er
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING created_at, updated_at`
 
-	return r.db.QueryRow(ctx, query,
+	return r.conn(ctx).QueryRow(ctx, query,
 		s.ID, s.TenantID, s.CustomerID, s.PlanID, s.PaymentMethod, s.MpSubscriptionID,
 		s.Status, s.CurrentPeriodStart, s.CurrentPeriodEnd, s.WashesUsed,
 	).Scan(&s.CreatedAt, &s.UpdatedAt)
 }
 
+// CustomerExists checks the customer belongs to the tenant. Foreign keys are
+// not subject to RLS, so the subscriptions FK alone would accept a customer
+// of another tenant.
+func (r *Repository) CustomerExists(ctx context.Context, tenantID, customerID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM customers WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)",
+		customerID, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check customer: %w", err)
+	}
+	return exists, nil
+}
+
 func (r *Repository) GetSubscriptionByID(ctx context.Context, tenantID, subID uuid.UUID) (*Subscription, error) {
 	query := `
 		SELECT id, tenant_id, customer_id, plan_id, payment_method, mp_subscription_id, status,
@@ -161,7 +200,7 @@ func (r *Repository) GetSubscriptionByID(ctx context.Context, tenantID, subID uu
 		WHERE id = $1 AND tenant_id = $2`
 
 	s := &Subscription{}
-	err := r.db.QueryRow(ctx, query, subID, tenantID).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, subID, tenantID).Scan(
 		&s.ID, &s.TenantID, &s.CustomerID, &s.PlanID, &s.PaymentMethod, &s.MpSubscriptionID,
 		&s.Status, &s.CurrentPeriodStart, &s.CurrentPeriodEnd, &s.WashesUsed, &s.CreatedAt, &s.UpdatedAt,
 	)
@@ -189,7 +228,7 @@ func (r *Repository) ListSubscriptions(ctx context.Context, tenantID uuid.UUID,
 	}
 	query += " ORDER BY created_at DESC"
 
-	rows, err := r.db.Query(ctx, query, args...)
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list subscriptions: %w", err)
 	}
@@ -210,25 +249,53 @@ func (r *Repository) ListSubscriptions(ctx context.Context, tenantID uuid.UUID,
 	return subs, nil
 }
 
-func (r *Repository) UpdateSubscriptionStatus(ctx context.Context, tenantID, subID uuid.UUID, status string) error {
-	query := `UPDATE subscriptions SET status = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3`
-	tag, err := r.db.Exec(ctx, query, status, subID, tenantID)
+// TransitionStatus moves the subscription through the state machine; see
+// the package-level TransitionStatus.
+func (r *Repository) TransitionStatus(ctx context.Context, change *StatusChange) (bool, error) {
+	return TransitionStatus(ctx, r.db, change)
+}
+
+// RecordStatusChange appends a history entry without touching the
+// subscription, for the initial status written by CreateSubscription.
+func (r *Repository) RecordStatusChange(ctx context.Context, change *StatusChange) error {
+	return recordStatusChange(ctx, r.conn(ctx), change)
+}
+
+func (r *Repository) ListStatusHistory(ctx context.Context, tenantID, subID uuid.UUID) ([]StatusChange, error) {
+	query := `
+		SELECT id, tenant_id, subscription_id, from_status, to_status, reason, actor_type, actor_id, changed_at
+		FROM subscription_status_history
+		WHERE subscription_id = $1 AND tenant_id = $2
+		ORDER BY changed_at DESC, id`
+
+	rows, err := r.conn(ctx).Query(ctx, query, subID, tenantID)
 	if err != nil {
-		return fmt.Errorf("update subscription status: %w", err)
+		return nil, fmt.Errorf("list status history: %w", err)
 	}
-	if tag.RowsAffected() == 0 {
-		return ErrSubscriptionNotFound
+	defer rows.Close()
+
+	var history []StatusChange
+	for rows.Next() {
+		var h StatusChange
+		if err := rows.Scan(
+			&h.ID, &h.TenantID, &h.SubscriptionID, &h.FromStatus, &h.ToStatus, &h.Reason, &h.ActorType, &h.ActorID, &h.ChangedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan status change: %w", err)
+		}
+		history = append(history, h)
 	}
-	return nil
+	return history, rows.Err()
 }
 
+// RenewSubscription starts a new period. The status is not touched here: a
+// renewal that reactivates the subscription goes through TransitionStatus.
 func (r *Repository) RenewSubscription(ctx context.Context, tenantID, subID uuid.UUID, periodStart, periodEnd interface{}) error {
 	query := `
 		UPDATE subscriptions
-		SET status = 'active', current_period_start = $1, current_period_end = $2, washes_used = 0, updated_at = NOW()
+		SET current_period_start = $1, current_period_end = $2, washes_used = 0, updated_at = NOW()
 		WHERE id = $3 AND tenant_id = $4`
 
-	tag, err := r.db.Exec(ctx, query, periodStart, periodEnd, subID, tenantID)
+	tag, err := r.conn(ctx).Exec(ctx, query, periodStart, periodEnd, subID, tenantID)
 	if err != nil {
 		return fmt.Errorf("renew subscription: %w", err)
 	}
@@ -237,3 +304,490 @@ func (r *Repository) RenewSubscription(ctx context.Context, tenantID, subID uuid
 	}
 	return nil
 }
+
+// ============================================================
+// Wash usage
+// ============================================================
+
+// ConsumeWash increments washes_used if the subscription is active, inside its
+// current period and under the plan's wash_limit. It is a single statement so
+// two concurrent check-ins cannot both take the last wash. ok is false when
+// no wash could be consumed; the caller decides why.
+//
+// A past_due subscription can wash too when the tenant's dunning policy
+// allows it, whatever its period: the grace period bounds it instead.
+func (r *Repository) ConsumeWash(ctx context.Context, tenantID, subID uuid.UUID) (sub *Subscription, ok bool, err error) {
+	query := `
+		UPDATE subscriptions s
+		SET washes_used = s.washes_used + 1, updated_at = NOW()
+		FROM membership_plans p
+		WHERE s.id = $1 AND s.tenant_id = $2 AND p.id = s.plan_id
+			AND (s.status = 'active' AND s.current_period_end > NOW()
+				OR s.status = 'past_due' AND EXISTS (
+					SELECT 1 FROM dunning_policies d WHERE d.tenant_id = s.tenant_id AND d.allow_washes_past_due))
+			AND (p.wash_limit IS NULL OR s.washes_used < p.wash_limit)
+		RETURNING s.id, s.tenant_id, s.customer_id, s.plan_id, s.payment_method, s.mp_subscription_id, s.status,
+		          s.current_period_start, s.current_period_end, s.washes_used, s.created_at, s.updated_at`
+
+	sub = &Subscription{}
+	err = r.conn(ctx).QueryRow(ctx, query, subID, tenantID).Scan(
+		&sub.ID, &sub.TenantID, &sub.CustomerID, &sub.PlanID, &sub.PaymentMethod, &sub.MpSubscriptionID,
+		&sub.Status, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.WashesUsed, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("consume wash: %w", err)
+	}
+	return sub, true, nil
+}
+
+// PastDueWashesAllowed reports whether the tenant's dunning policy lets
+// past_due subscriptions keep washing.
+func (r *Repository) PastDueWashesAllowed(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	var allowed bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM dunning_policies WHERE tenant_id = $1 AND allow_washes_past_due)", tenantID,
+	).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("get dunning policy: %w", err)
+	}
+	return allowed, nil
+}
+
+// RefundWash gives a wash back, but only while the subscription is still in
+// the period the wash was counted against; a renewal already reset it.
+func (r *Repository) RefundWash(ctx context.Context, tenantID, subID uuid.UUID, periodStart time.Time) error {
+	query := `
+		UPDATE subscriptions SET washes_used = washes_used - 1, updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND current_period_start = $3 AND washes_used > 0`
+
+	if _, err := r.conn(ctx).Exec(ctx, query, subID, tenantID, periodStart); err != nil {
+		return fmt.Errorf("refund wash: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) CreateWashUsage(ctx context.Context, u *WashUsage) error {
+	query := `
+		INSERT INTO wash_usages (id, tenant_id, subscription_id, customer_id, booking_id, box_id, vehicle_plate, notes, period_start, recorded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING used_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		u.ID, u.TenantID, u.SubscriptionID, u.CustomerID, u.BookingID, u.BoxID, u.VehiclePlate, u.Notes, u.PeriodStart, u.RecordedBy,
+	).Scan(&u.UsedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrBookingAlreadyCheckedIn
+		}
+		return fmt.Errorf("insert wash usage: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) ListWashUsages(ctx context.Context, tenantID, subID uuid.UUID, page, perPage int) ([]WashUsage, int64, error) {
+	var total int64
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT COUNT(*) FROM wash_usages WHERE tenant_id = $1 AND subscription_id = $2",
+		tenantID, subID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count wash usages: %w", err)
+	}
+
+	query := `SELECT ` + washUsageColumns + `
+		FROM wash_usages
+		WHERE tenant_id = $1 AND subscription_id = $2
+		ORDER BY used_at DESC
+		LIMIT $3 OFFSET $4`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, subID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list wash usages: %w", err)
+	}
+	defer rows.Close()
+
+	var usages []WashUsage
+	for rows.Next() {
+		u, err := scanWashUsage(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan wash usage: %w", err)
+		}
+		usages = append(usages, *u)
+	}
+
+	return usages, total, rows.Err()
+}
+
+// VoidWashUsage marks a usage as voided. It only matches usages that are not
+// voided yet, so a wash cannot be given back twice.
+func (r *Repository) VoidWashUsage(ctx context.Context, tenantID, subID, usageID, userID uuid.UUID, reason string) (*WashUsage, error) {
+	query := `
+		UPDATE wash_usages SET voided_at = NOW(), voided_by = $1, void_reason = $2
+		WHERE id = $3 AND tenant_id = $4 AND subscription_id = $5 AND voided_at IS NULL
+		RETURNING ` + washUsageColumns
+
+	u, err := scanWashUsage(r.conn(ctx).QueryRow(ctx, query, userID, reason, usageID, tenantID, subID))
+	if err == nil {
+		return u, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("void wash usage: %w", err)
+	}
+
+	var exists bool
+	err = r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM wash_usages WHERE id = $1 AND tenant_id = $2 AND subscription_id = $3)",
+		usageID, tenantID, subID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("check wash usage: %w", err)
+	}
+	if exists {
+		return nil, ErrUsageAlreadyVoided
+	}
+	return nil, ErrUsageNotFound
+}
+
+// GetBookingForCheckIn returns the customer and box of a booking of the tenant.
+func (r *Repository) GetBookingForCheckIn(ctx context.Context, tenantID, bookingID uuid.UUID) (customerID, boxID uuid.UUID, err error) {
+	err = r.conn(ctx).QueryRow(ctx,
+		"SELECT customer_id, box_id FROM bookings WHERE id = $1 AND tenant_id = $2",
+		bookingID, tenantID,
+	).Scan(&customerID, &boxID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, uuid.Nil, ErrBookingNotFound
+		}
+		return uuid.Nil, uuid.Nil, fmt.Errorf("get booking: %w", err)
+	}
+	return customerID, boxID, nil
+}
+
+func (r *Repository) BoxExists(ctx context.Context, tenantID, boxID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM wash_boxes WHERE id = $1 AND tenant_id = $2)",
+		boxID, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check box: %w", err)
+	}
+	return exists, nil
+}
+
+// GetCustomerPlate returns the vehicle plate on file for the customer, if any.
+func (r *Repository) GetCustomerPlate(ctx context.Context, tenantID, customerID uuid.UUID) (*string, error) {
+	var plate *string
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT vehicle_plate FROM customers WHERE id = $1 AND tenant_id = $2",
+		customerID, tenantID,
+	).Scan(&plate)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get customer plate: %w", err)
+	}
+	return plate, nil
+}
+
+// ============================================================
+// Pauses
+// ============================================================
+
+// GetSubscriptionForUpdate locks the subscription row until the request
+// transaction ends, so concurrent pause/resume calls are serialized.
+func (r *Repository) GetSubscriptionForUpdate(ctx context.Context, tenantID, subID uuid.UUID) (*Subscription, error) {
+	query := `
+		SELECT id, tenant_id, customer_id, plan_id, payment_method, mp_subscription_id, status,
+		       current_period_start, current_period_end, washes_used, created_at, updated_at
+		FROM subscriptions
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE`
+
+	s := &Subscription{}
+	err := r.conn(ctx).QueryRow(ctx, query, subID, tenantID).Scan(
+		&s.ID, &s.TenantID, &s.CustomerID, &s.PlanID, &s.PaymentMethod, &s.MpSubscriptionID,
+		&s.Status, &s.CurrentPeriodStart, &s.CurrentPeriodEnd, &s.WashesUsed, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("lock subscription: %w", err)
+	}
+	return s, nil
+}
+
+func (r *Repository) CreatePause(ctx context.Context, p *SubscriptionPause) error {
+	query := `
+		INSERT INTO subscription_pauses (id, tenant_id, subscription_id, reason, paused_at, resume_by, paused_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := r.conn(ctx).Exec(ctx, query,
+		p.ID, p.TenantID, p.SubscriptionID, p.Reason, p.PausedAt, p.ResumeBy, p.PausedBy,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrAlreadyPaused
+		}
+		return fmt.Errorf("create pause: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetOpenPause(ctx context.Context, tenantID, subID uuid.UUID) (*SubscriptionPause, error) {
+	query := `SELECT ` + pauseColumns + `
+		FROM subscription_pauses
+		WHERE subscription_id = $1 AND tenant_id = $2 AND resumed_at IS NULL`
+
+	p, err := scanPause(r.conn(ctx).QueryRow(ctx, query, subID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPauseNotFound
+		}
+		return nil, fmt.Errorf("get open pause: %w", err)
+	}
+	return p, nil
+}
+
+func (r *Repository) ListPauses(ctx context.Context, tenantID, subID uuid.UUID) ([]SubscriptionPause, error) {
+	query := `SELECT ` + pauseColumns + `
+		FROM subscription_pauses
+		WHERE subscription_id = $1 AND tenant_id = $2
+		ORDER BY paused_at DESC`
+
+	rows, err := r.conn(ctx).Query(ctx, query, subID, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list pauses: %w", err)
+	}
+	defer rows.Close()
+
+	var pauses []SubscriptionPause
+	for rows.Next() {
+		p, err := scanPause(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan pause: %w", err)
+		}
+		pauses = append(pauses, *p)
+	}
+	return pauses, rows.Err()
+}
+
+// ResumeFromPause closes the pause and pushes current_period_end forward by
+// the time it was paused; the caller reactivates it. A pause resumed after
+// resume_by (the cron runs periodically) is recorded as ending at resume_by,
+// so the extension never exceeds the plan's cap.
+func (r *Repository) ResumeFromPause(ctx context.Context, tenantID, pauseID uuid.UUID, resumedBy *uuid.UUID) (*Subscription, error) {
+	query := `
+		WITH closed AS (
+			UPDATE subscription_pauses
+			SET resumed_at = LEAST(NOW(), resume_by), resumed_by = $3
+			WHERE id = $1 AND tenant_id = $2 AND resumed_at IS NULL
+			RETURNING subscription_id, paused_at, resumed_at
+		)
+		UPDATE subscriptions s
+		SET current_period_end = s.current_period_end + (c.resumed_at - c.paused_at),
+		    updated_at = NOW()
+		FROM closed c
+		WHERE s.id = c.subscription_id AND s.tenant_id = $2 AND s.status = 'paused'
+		RETURNING s.id, s.tenant_id, s.customer_id, s.plan_id, s.payment_method, s.mp_subscription_id, s.status,
+		          s.current_period_start, s.current_period_end, s.washes_used, s.created_at, s.updated_at`
+
+	sub := &Subscription{}
+	err := r.conn(ctx).QueryRow(ctx, query, pauseID, tenantID, resumedBy).Scan(
+		&sub.ID, &sub.TenantID, &sub.CustomerID, &sub.PlanID, &sub.PaymentMethod, &sub.MpSubscriptionID,
+		&sub.Status, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.WashesUsed, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPauseNotFound
+		}
+		return nil, fmt.Errorf("resume subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// EndOpenPause closes a pause without extending the period, e.g. when a
+// paused subscription is cancelled.
+func (r *Repository) EndOpenPause(ctx context.Context, tenantID, subID uuid.UUID) error {
+	query := `
+		UPDATE subscription_pauses SET resumed_at = NOW()
+		WHERE subscription_id = $1 AND tenant_id = $2 AND resumed_at IS NULL`
+
+	if _, err := r.conn(ctx).Exec(ctx, query, subID, tenantID); err != nil {
+		return fmt.Errorf("end open pause: %w", err)
+	}
+	return nil
+}
+
+// ListExpiredPauses returns the open pauses of every tenant that reached
+// resume_by. Used by the pause cron on the owner pool.
+func (r *Repository) ListExpiredPauses(ctx context.Context) ([]SubscriptionPause, error) {
+	query := `SELECT ` + pauseColumns + `
+		FROM subscription_pauses
+		WHERE resumed_at IS NULL AND resume_by <= NOW()
+		ORDER BY resume_by`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list expired pauses: %w", err)
+	}
+	defer rows.Close()
+
+	var pauses []SubscriptionPause
+	for rows.Next() {
+		p, err := scanPause(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan pause: %w", err)
+		}
+		pauses = append(pauses, *p)
+	}
+	return pauses, rows.Err()
+}
+
+// ListAbandonedCheckouts returns the pending_payment subscriptions of every
+// tenant created before createdBefore. Used by the checkout cron on the owner
+// pool.
+func (r *Repository) ListAbandonedCheckouts(ctx context.Context, createdBefore time.Time) ([]Subscription, error) {
+	query := `
+		SELECT id, tenant_id, customer_id, plan_id, payment_method, mp_subscription_id, status,
+		       current_period_start, current_period_end, washes_used, created_at, updated_at
+		FROM subscriptions
+		WHERE status = 'pending_payment' AND created_at < $1
+		ORDER BY created_at`
+
+	rows, err := r.db.Query(ctx, query, createdBefore)
+	if err != nil {
+		return nil, fmt.Errorf("list abandoned checkouts: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var s Subscription
+		if err := rows.Scan(
+			&s.ID, &s.TenantID, &s.CustomerID, &s.PlanID, &s.PaymentMethod, &s.MpSubscriptionID,
+			&s.Status, &s.CurrentPeriodStart, &s.CurrentPeriodEnd, &s.WashesUsed, &s.CreatedAt, &s.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan subscription: %w", err)
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// ============================================================
+// QR signing keys
+// ============================================================
+
+// GetActiveQRKey returns the key new QR tokens are signed with. Only the
+// version is stored; the key pair itself is derived by the service.
+func (r *Repository) GetActiveQRKey(ctx context.Context, tenantID uuid.UUID) (*QRKey, error) {
+	return r.getQRKey(ctx,
+		"SELECT version, created_at, retired_at FROM qr_signing_keys WHERE tenant_id = $1 AND retired_at IS NULL",
+		tenantID,
+	)
+}
+
+func (r *Repository) GetQRKey(ctx context.Context, tenantID uuid.UUID, version int) (*QRKey, error) {
+	return r.getQRKey(ctx,
+		"SELECT version, created_at, retired_at FROM qr_signing_keys WHERE tenant_id = $1 AND version = $2",
+		tenantID, version,
+	)
+}
+
+// EnsureQRKey creates the first key of a tenant. Concurrent callers are
+// serialized by the one-active-key index and the loser is a no-op.
+func (r *Repository) EnsureQRKey(ctx context.Context, tenantID uuid.UUID) error {
+	query := `
+		INSERT INTO qr_signing_keys (tenant_id, version)
+		SELECT $1, COALESCE(MAX(version), 0) + 1 FROM qr_signing_keys WHERE tenant_id = $1
+		ON CONFLICT DO NOTHING`
+
+	if _, err := r.conn(ctx).Exec(ctx, query, tenantID); err != nil {
+		return fmt.Errorf("ensure qr key: %w", err)
+	}
+	return nil
+}
+
+// RotateQRKey retires the active key and creates the next version.
+func (r *Repository) RotateQRKey(ctx context.Context, tenantID uuid.UUID) (*QRKey, error) {
+	_, err := r.conn(ctx).Exec(ctx,
+		"UPDATE qr_signing_keys SET retired_at = NOW() WHERE tenant_id = $1 AND retired_at IS NULL",
+		tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("retire qr key: %w", err)
+	}
+
+	return r.getQRKey(ctx, `
+		INSERT INTO qr_signing_keys (tenant_id, version)
+		SELECT $1, COALESCE(MAX(version), 0) + 1 FROM qr_signing_keys WHERE tenant_id = $1
+		RETURNING version, created_at, retired_at`,
+		tenantID,
+	)
+}
+
+// ListQRKeys returns the active key and the keys retired after retiredSince,
+// newest first.
+func (r *Repository) ListQRKeys(ctx context.Context, tenantID uuid.UUID, retiredSince time.Time) ([]QRKey, error) {
+	query := `
+		SELECT version, created_at, retired_at
+		FROM qr_signing_keys
+		WHERE tenant_id = $1 AND (retired_at IS NULL OR retired_at > $2)
+		ORDER BY version DESC`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, retiredSince)
+	if err != nil {
+		return nil, fmt.Errorf("list qr keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []QRKey
+	for rows.Next() {
+		var k QRKey
+		if err := rows.Scan(&k.KeyID, &k.CreatedAt, &k.RetiredAt); err != nil {
+			return nil, fmt.Errorf("scan qr key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *Repository) getQRKey(ctx context.Context, query string, args ...any) (*QRKey, error) {
+	k := &QRKey{}
+	err := r.conn(ctx).QueryRow(ctx, query, args...).Scan(&k.KeyID, &k.CreatedAt, &k.RetiredAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrQRKeyNotFound
+		}
+		return nil, fmt.Errorf("get qr key: %w", err)
+	}
+	return k, nil
+}
+
+func scanPause(row pgx.Row) (*SubscriptionPause, error) {
+	p := &SubscriptionPause{}
+	err := row.Scan(
+		&p.ID, &p.TenantID, &p.SubscriptionID, &p.Reason, &p.PausedAt, &p.ResumeBy, &p.ResumedAt, &p.PausedBy, &p.ResumedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func scanWashUsage(row pgx.Row) (*WashUsage, error) {
+	u := &WashUsage{}
+	err := row.Scan(
+		&u.ID, &u.TenantID, &u.SubscriptionID, &u.CustomerID, &u.BookingID, &u.BoxID, &u.VehiclePlate, &u.Notes,
+		&u.PeriodStart, &u.RecordedBy, &u.UsedAt, &u.VoidedAt, &u.VoidedBy, &u.VoidReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
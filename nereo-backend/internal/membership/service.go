@@ -2,22 +2,34 @@ package membership
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/config"
+	"github.com/nereo-ar/backend/internal/customer"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/money"
+	goredis "github.com/redis/go-redis/v9"
 )
 
 type Service struct {
-	repo *Repository
-	db   *pgxpool.Pool
+	repo         *Repository
+	db           *pgxpool.Pool
+	redis        *goredis.Client
+	qr           config.QRConfig
+	preapprovals PreapprovalUpdater
 }
 
-func NewService(db *pgxpool.Pool) *Service {
+func NewService(db *pgxpool.Pool, redis *goredis.Client, qr config.QRConfig, preapprovals PreapprovalUpdater) *Service {
 	return &Service{
-		repo: NewRepository(db),
-		db:   db,
+		repo:         NewRepository(db),
+		db:           db,
+		redis:        redis,
+		qr:           qr,
+		preapprovals: preapprovals,
 	}
 }
 
@@ -26,9 +38,13 @@ func NewService(db *pgxpool.Pool) *Service {
 // ============================================================
 
 func (s *Service) CreatePlan(ctx context.Context, tenantID uuid.UUID, req CreatePlanRequest) (*Plan, error) {
-	currency := req.Currency
-	if currency == "" {
-		currency = "ARS"
+	currency := money.DefaultCurrency
+	if req.Currency != "" {
+		c, err := money.ParseCurrency(req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		currency = c
 	}
 	interval := req.Interval
 	if interval == "" {
@@ -38,18 +54,23 @@ func (s *Service) CreatePlan(ctx context.Context, tenantID uuid.UUID, req Create
 	if includes == nil {
 		includes = []string{}
 	}
+	maxPauseDays := defaultMaxPauseDays
+	if req.MaxPauseDays != nil {
+		maxPauseDays = *req.MaxPauseDays
+	}
 
 	plan := &Plan{
-		ID:          uuid.New(),
-		TenantID:    tenantID,
-		Name:        req.Name,
-		Description: req.Description,
-		PriceCents:  req.PriceCents,
-		Currency:    currency,
-		Interval:    interval,
-		WashLimit:   req.WashLimit,
-		Includes:    includes,
-		Active:      true,
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		Name:         req.Name,
+		Description:  req.Description,
+		PriceCents:   req.PriceCents,
+		Currency:     currency,
+		Interval:     interval,
+		WashLimit:    req.WashLimit,
+		Includes:     includes,
+		MaxPauseDays: maxPauseDays,
+		Active:       true,
 	}
 
 	if err := s.repo.CreatePlan(ctx, plan); err != nil {
@@ -91,6 +112,9 @@ func (s *Service) UpdatePlan(ctx context.Context, tenantID, planID uuid.UUID, re
 	if req.Includes != nil {
 		plan.Includes = req.Includes
 	}
+	if req.MaxPauseDays != nil {
+		plan.MaxPauseDays = *req.MaxPauseDays
+	}
 
 	if err := s.repo.UpdatePlan(ctx, plan); err != nil {
 		return nil, err
@@ -107,7 +131,35 @@ func (s *Service) DeactivatePlan(ctx context.Context, tenantID, planID uuid.UUID
 // Subscriptions
 // ============================================================
 
-func (s *Service) CreateSubscription(ctx context.Context, tenantID uuid.UUID, req CreateSubscriptionRequest) (*Subscription, error) {
+// ErrCheckoutRequired is returned for Mercado Pago subscriptions created
+// without a checkout: they would be active without anyone paying for them.
+var ErrCheckoutRequired = errors.New("mercado pago subscriptions are created through a checkout")
+
+func (s *Service) CreateSubscription(ctx context.Context, tenantID, userID uuid.UUID, req CreateSubscriptionRequest) (*Subscription, error) {
+	if req.PaymentMethod == "mercadopago" {
+		return nil, ErrCheckoutRequired
+	}
+	return s.createSubscription(ctx, tenantID, userID, uuid.New(), req, StatusActive)
+}
+
+// CreateCheckoutSubscription creates the subscription paid through a Checkout
+// Pro preference. It stays pending_payment until the payment webhook reports
+// an approved payment, or is cancelled when the checkout is abandoned. subID
+// is chosen by the caller: it is the preference's external_reference.
+func (s *Service) CreateCheckoutSubscription(ctx context.Context, tenantID, userID, subID uuid.UUID, req CreateSubscriptionRequest) (*Subscription, error) {
+	req.PaymentMethod = "mercadopago"
+	return s.createSubscription(ctx, tenantID, userID, subID, req, StatusPendingPayment)
+}
+
+// CreatePendingSubscription creates the subscription behind a Mercado Pago
+// preapproval. It stays pending until the preapproval webhook reports it
+// authorized. subID is chosen by the caller, as in CreateCheckoutSubscription.
+func (s *Service) CreatePendingSubscription(ctx context.Context, tenantID, userID, subID uuid.UUID, req CreateSubscriptionRequest) (*Subscription, error) {
+	req.PaymentMethod = "mercadopago"
+	return s.createSubscription(ctx, tenantID, userID, subID, req, StatusPending)
+}
+
+func (s *Service) createSubscription(ctx context.Context, tenantID, userID, subID uuid.UUID, req CreateSubscriptionRequest, status string) (*Subscription, error) {
 	// Verify plan exists and is active
 	plan, err := s.repo.GetPlanByID(ctx, tenantID, req.PlanID)
 	if err != nil {
@@ -117,11 +169,19 @@ func (s *Service) CreateSubscription(ctx context.Context, tenantID uuid.UUID, re
 		return nil, fmt.Errorf("plan is not active")
 	}
 
+	exists, err := s.repo.CustomerExists(ctx, tenantID, req.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrCustomerNotFound
+	}
+
 	now := time.Now()
 	periodEnd := calculatePeriodEnd(now, plan.Interval)
 
 	sub := &Subscription{
-		ID:                 uuid.New(),
+		ID:                 subID,
 		TenantID:           tenantID,
 		CustomerID:         req.CustomerID,
 		PlanID:             req.PlanID,
@@ -129,27 +189,31 @@ func (s *Service) CreateSubscription(ctx context.Context, tenantID uuid.UUID, re
 		CurrentPeriodStart: now,
 		CurrentPeriodEnd:   periodEnd,
 		WashesUsed:         0,
-	}
-
-	if req.PaymentMethod == "manual" {
-		// Manual payment: activate immediately
-		sub.Status = "active"
-	} else {
-		// Mercado Pago: set as pending until webhook confirms payment
-		sub.Status = "active" // For now, will be refined in Phase 2 with MP integration
+		Status:             status,
 	}
 
 	if err := s.repo.CreateSubscription(ctx, sub); err != nil {
 		return nil, fmt.Errorf("create subscription: %w", err)
 	}
 
+	if err := s.repo.RecordStatusChange(ctx, &StatusChange{
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		ToStatus:       sub.Status,
+		Reason:         ReasonCreated,
+		ActorType:      ActorUser,
+		ActorID:        &userID,
+	}); err != nil {
+		return nil, err
+	}
+
 	// If manual, record a payment event
 	if req.PaymentMethod == "manual" {
 		eventQuery := `
-			INSERT INTO payment_events (tenant_id, subscription_id, source, status, amount_cents, notes, raw_payload)
-			VALUES ($1, $2, 'manual', 'approved', $3, 'Activación manual', '{}')`
+			INSERT INTO payment_events (tenant_id, subscription_id, source, status, amount_cents, currency, notes, raw_payload)
+			VALUES ($1, $2, 'manual', 'approved', $3, $4, 'Activación manual', '{}')`
 
-		_, err = s.db.Exec(ctx, eventQuery, tenantID, sub.ID, plan.PriceCents)
+		_, err = database.Conn(ctx, s.db).Exec(ctx, eventQuery, tenantID, sub.ID, plan.PriceCents, plan.Currency)
 		if err != nil {
 			return nil, fmt.Errorf("record manual payment event: %w", err)
 		}
@@ -166,8 +230,42 @@ func (s *Service) ListSubscriptions(ctx context.Context, tenantID uuid.UUID, cus
 	return s.repo.ListSubscriptions(ctx, tenantID, customerID)
 }
 
-func (s *Service) CancelSubscription(ctx context.Context, tenantID, subID uuid.UUID) error {
-	return s.repo.UpdateSubscriptionStatus(ctx, tenantID, subID, "cancelled")
+func (s *Service) CancelSubscription(ctx context.Context, tenantID, subID, userID uuid.UUID) error {
+	sub, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID)
+	if err != nil {
+		return err
+	}
+
+	changed, err := s.repo.TransitionStatus(ctx, &StatusChange{
+		TenantID:       tenantID,
+		SubscriptionID: subID,
+		ToStatus:       StatusCancelled,
+		Reason:         ReasonCancelled,
+		ActorType:      ActorUser,
+		ActorID:        &userID,
+	})
+	if err != nil {
+		return err
+	}
+	// A paused subscription keeps no open pause once cancelled.
+	if err := s.repo.EndOpenPause(ctx, tenantID, subID); err != nil {
+		return err
+	}
+
+	// Stop the recurring charge too; called last so a failure rolls back.
+	if changed {
+		return s.updatePreapproval(ctx, sub, preapprovalCancelled)
+	}
+	return nil
+}
+
+// ListStatusHistory returns the status changes of a subscription, newest
+// first.
+func (s *Service) ListStatusHistory(ctx context.Context, tenantID, subID uuid.UUID) ([]StatusChange, error) {
+	if _, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListStatusHistory(ctx, tenantID, subID)
 }
 
 func (s *Service) ValidateSubscription(ctx context.Context, tenantID, subID uuid.UUID) (*ValidationResult, error) {
@@ -176,15 +274,41 @@ func (s *Service) ValidateSubscription(ctx context.Context, tenantID, subID uuid
 		return nil, err
 	}
 
-	now := time.Now()
-	valid := sub.Status == "active" && sub.CurrentPeriodEnd.After(now)
-
 	result := &ValidationResult{
-		Valid:         valid,
+		Valid:         true,
+		Status:        sub.Status,
 		ExpiresAt:     sub.CurrentPeriodEnd.Format(time.RFC3339),
 		PaymentMethod: sub.PaymentMethod,
 	}
 
+	// The reason tells the counter what to say: a paused membership is not
+	// lost, an expired one needs a payment.
+	switch {
+	case sub.Status == "paused":
+		result.Valid = false
+		result.Reason = "paused"
+		if pause, err := s.repo.GetOpenPause(ctx, tenantID, subID); err == nil {
+			result.PausedUntil = &pause.ResumeBy
+		}
+	case sub.Status == StatusPastDue:
+		// Still valid while in the grace period if the dunning policy allows
+		// washing; the status tells the counter a payment is owed.
+		allowed, err := s.repo.PastDueWashesAllowed(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			result.Valid = false
+			result.Reason = sub.Status
+		}
+	case sub.Status != "active":
+		result.Valid = false
+		result.Reason = sub.Status
+	case !sub.CurrentPeriodEnd.After(time.Now()):
+		result.Valid = false
+		result.Reason = "expired"
+	}
+
 	// Calculate washes remaining if plan has a limit
 	plan, err := s.repo.GetPlanByID(ctx, tenantID, sub.PlanID)
 	if err == nil && plan.WashLimit != nil {
@@ -194,14 +318,138 @@ func (s *Service) ValidateSubscription(ctx context.Context, tenantID, subID uuid
 		}
 		result.WashesRemaining = &remaining
 
-		if remaining == 0 {
+		if remaining == 0 && result.Valid {
 			result.Valid = false
+			result.Reason = "wash_limit_reached"
+		}
+	}
+
+	return result, nil
+}
+
+// ============================================================
+// Wash usage
+// ============================================================
+
+// RecordWash consumes one wash of the subscription (check-in) and stores it
+// in the usage ledger. The counter and the ledger row are written in the same
+// request transaction.
+func (s *Service) RecordWash(ctx context.Context, tenantID, subID, userID uuid.UUID, req RecordWashRequest) (*CheckInResult, error) {
+	sub, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &WashUsage{
+		ID:             uuid.New(),
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		CustomerID:     sub.CustomerID,
+		BookingID:      req.BookingID,
+		BoxID:          req.BoxID,
+		Notes:          req.Notes,
+		RecordedBy:     &userID,
+	}
+
+	if req.BookingID != nil {
+		customerID, boxID, err := s.repo.GetBookingForCheckIn(ctx, tenantID, *req.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		if customerID != sub.CustomerID {
+			return nil, ErrBookingNotFound
+		}
+		if usage.BoxID == nil {
+			usage.BoxID = &boxID
 		}
 	}
 
+	if usage.BoxID != nil {
+		exists, err := s.repo.BoxExists(ctx, tenantID, *usage.BoxID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrBoxNotFound
+		}
+	}
+
+	if req.VehiclePlate != nil && *req.VehiclePlate != "" {
+		plate := customer.NormalizePlate(*req.VehiclePlate)
+		usage.VehiclePlate = &plate
+	} else {
+		usage.VehiclePlate, err = s.repo.GetCustomerPlate(ctx, tenantID, sub.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	consumed, ok, err := s.repo.ConsumeWash(ctx, tenantID, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		switch sub.Status {
+		case StatusPaused:
+			return nil, ErrSubscriptionPaused
+		case StatusPastDue:
+			allowed, err := s.repo.PastDueWashesAllowed(ctx, tenantID)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				return nil, ErrSubscriptionInactive
+			}
+		case StatusActive:
+			if !sub.CurrentPeriodEnd.After(time.Now()) {
+				return nil, ErrSubscriptionInactive
+			}
+		default:
+			return nil, ErrSubscriptionInactive
+		}
+		return nil, ErrWashLimitReached
+	}
+
+	usage.PeriodStart = consumed.CurrentPeriodStart
+	if err := s.repo.CreateWashUsage(ctx, usage); err != nil {
+		return nil, err
+	}
+
+	result := &CheckInResult{Usage: *usage, WashesUsed: consumed.WashesUsed}
+	plan, err := s.repo.GetPlanByID(ctx, tenantID, consumed.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.WashLimit != nil {
+		remaining := *plan.WashLimit - consumed.WashesUsed
+		result.WashesRemaining = &remaining
+	}
+
 	return result, nil
 }
 
+func (s *Service) ListWashUsages(ctx context.Context, tenantID, subID uuid.UUID, page, perPage int) ([]WashUsage, int64, error) {
+	if _, err := s.repo.GetSubscriptionByID(ctx, tenantID, subID); err != nil {
+		return nil, 0, err
+	}
+	return s.repo.ListWashUsages(ctx, tenantID, subID, page, perPage)
+}
+
+// VoidWash cancels a mistaken check-in. The wash is given back only if the
+// subscription has not been renewed since.
+func (s *Service) VoidWash(ctx context.Context, tenantID, subID, usageID, userID uuid.UUID, reason string) (*WashUsage, error) {
+	usage, err := s.repo.VoidWashUsage(ctx, tenantID, subID, usageID, userID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.RefundWash(ctx, tenantID, subID, usage.PeriodStart); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
 func calculatePeriodEnd(start time.Time, interval string) time.Time {
 	switch interval {
 	case "weekly":
@@ -0,0 +1,149 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+var ErrInvalidTransition = errors.New("invalid subscription status transition")
+
+const (
+	StatusPending        = "pending"         // MP preapproval created, not authorized by the payer yet
+	StatusPendingPayment = "pending_payment" // Checkout Pro preference created, not paid yet
+	StatusActive         = "active"
+	StatusPaused         = "paused"
+	StatusCancelled      = "cancelled"
+	StatusPastDue        = "past_due"
+)
+
+// Reasons recorded with each status change.
+const (
+	ReasonCreated         = "created"
+	ReasonPaused          = "paused"
+	ReasonResumed         = "resumed"
+	ReasonPauseExpired    = "pause_expired"
+	ReasonCancelled       = "cancelled"
+	ReasonPaymentApproved = "payment_approved"
+	ReasonPaymentRejected = "payment_rejected"
+	ReasonManualPayment   = "manual_payment"
+	ReasonPastDueExpired  = "past_due_expired"
+	ReasonPaymentRefunded = "payment_refunded" // the charge of the period was fully refunded
+	ReasonChargeback      = "chargeback"
+	ReasonCheckoutExpired = "checkout_expired" // the Checkout Pro preference was not paid in time
+
+	// Mercado Pago preapproval status changes (subscription_preapproval webhooks)
+	ReasonPreapprovalAuthorized = "preapproval_authorized"
+	ReasonPreapprovalPaused     = "preapproval_paused"
+	ReasonPreapprovalCancelled  = "preapproval_cancelled"
+)
+
+// Who triggered a status change. ActorID is only set for ActorUser.
+const (
+	ActorUser        = "user"
+	ActorSystem      = "system"      // crons
+	ActorMercadoPago = "mercadopago" // webhooks
+)
+
+// subscriptionTransitions lists, for each status, the statuses it can move to
+// and the reasons allowed to move it there. cancelled is terminal: a late
+// webhook can no longer bring a subscription back.
+var subscriptionTransitions = map[string]map[string][]string{
+	StatusPending: {
+		StatusActive:    {ReasonPreapprovalAuthorized, ReasonPaymentApproved},
+		StatusCancelled: {ReasonCancelled, ReasonPreapprovalCancelled},
+	},
+	StatusPendingPayment: {
+		StatusActive:    {ReasonPaymentApproved},
+		StatusCancelled: {ReasonCancelled, ReasonCheckoutExpired},
+	},
+	StatusActive: {
+		StatusPaused:    {ReasonPaused, ReasonPreapprovalPaused},
+		StatusPastDue:   {ReasonPaymentRejected, ReasonPaymentRefunded, ReasonChargeback},
+		StatusCancelled: {ReasonCancelled, ReasonPreapprovalCancelled},
+	},
+	StatusPaused: {
+		StatusActive:    {ReasonResumed, ReasonPauseExpired, ReasonPreapprovalAuthorized},
+		StatusCancelled: {ReasonCancelled, ReasonPreapprovalCancelled},
+	},
+	StatusPastDue: {
+		StatusActive:    {ReasonPaymentApproved, ReasonManualPayment},
+		StatusCancelled: {ReasonCancelled, ReasonPastDueExpired, ReasonPreapprovalCancelled},
+	},
+	StatusCancelled: {},
+}
+
+// CanTransition reports whether reason may move a subscription from one
+// status to another.
+func CanTransition(from, to, reason string) bool {
+	return slices.Contains(subscriptionTransitions[from][to], reason)
+}
+
+// TransitionStatus is the only way a subscription changes status once
+// created. It locks the subscription, checks the state machine and records
+// the change in the history, all in one transaction (a savepoint when ctx
+// already carries one). Moving to the current status is a no-op and returns
+// changed = false, so redelivered webhooks are harmless.
+//
+// change.TenantID, SubscriptionID, ToStatus, Reason and ActorType must be
+// set; the rest is filled in.
+func TransitionStatus(ctx context.Context, db *pgxpool.Pool, change *StatusChange) (changed bool, err error) {
+	err = database.RunInTx(ctx, db, func(ctx context.Context) error {
+		conn := database.Conn(ctx, db)
+
+		var from string
+		err := conn.QueryRow(ctx,
+			"SELECT status FROM subscriptions WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+			change.SubscriptionID, change.TenantID,
+		).Scan(&from)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrSubscriptionNotFound
+			}
+			return fmt.Errorf("lock subscription: %w", err)
+		}
+
+		if from == change.ToStatus {
+			return nil
+		}
+		if !CanTransition(from, change.ToStatus, change.Reason) {
+			return fmt.Errorf("%w: %s -> %s (%s)", ErrInvalidTransition, from, change.ToStatus, change.Reason)
+		}
+
+		_, err = conn.Exec(ctx,
+			"UPDATE subscriptions SET status = $1, updated_at = NOW() WHERE id = $2 AND tenant_id = $3",
+			change.ToStatus, change.SubscriptionID, change.TenantID,
+		)
+		if err != nil {
+			return fmt.Errorf("update subscription status: %w", err)
+		}
+
+		change.FromStatus = &from
+		if err := recordStatusChange(ctx, conn, change); err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+func recordStatusChange(ctx context.Context, conn database.DBTX, change *StatusChange) error {
+	query := `
+		INSERT INTO subscription_status_history (tenant_id, subscription_id, from_status, to_status, reason, actor_type, actor_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, changed_at`
+
+	err := conn.QueryRow(ctx, query,
+		change.TenantID, change.SubscriptionID, change.FromStatus, change.ToStatus, change.Reason, change.ActorType, change.ActorID,
+	).Scan(&change.ID, &change.ChangedAt)
+	if err != nil {
+		return fmt.Errorf("record status change: %w", err)
+	}
+	return nil
+}
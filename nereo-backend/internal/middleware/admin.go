@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nereo-ar/backend/pkg/httputil"
+)
+
+// AdminMiddleware guards platform endpoints that are not tied to a tenant
+// with a static token sent in X-Admin-Token. With no token configured every
+// request is rejected, so the endpoints are off by default.
+func AdminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader("X-Admin-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			httputil.Unauthorized(c, "invalid admin token")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
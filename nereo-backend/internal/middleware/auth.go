@@ -9,9 +9,10 @@ import (
 )
 
 const (
-	ContextUserID   = "user_id"
-	ContextTenantID = "tenant_id"
-	ContextRole     = "role"
+	ContextUserID    = "user_id"
+	ContextTenantID  = "tenant_id"
+	ContextRole      = "role"
+	ContextSessionID = "session_id"
 )
 
 func AuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
@@ -40,6 +41,7 @@ func AuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
 		c.Set(ContextUserID, claims.UserID)
 		c.Set(ContextTenantID, claims.TenantID)
 		c.Set(ContextRole, claims.Role)
+		c.Set(ContextSessionID, claims.SessionID)
 
 		c.Next()
 	}
@@ -1,15 +1,26 @@
 package middleware
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"log/slog"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
 	"github.com/nereo-ar/backend/pkg/httputil"
 )
 
+// TenantMiddleware opens one transaction per request, scopes it to the
+// caller's tenant (app.current_tenant, read by the RLS policies) and stores
+// it in the request context so every repository runs on it.
+//
+// The response is buffered until the transaction is settled: 2xx/3xx commit,
+// anything else rolls back. If the commit fails the client gets a 500 instead
+// of a success for data that was never persisted. Either way a rollback runs
+// the actions registered with database.OnRollback.
 func TenantMiddleware(db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tenantIDVal, exists := c.Get(ContextTenantID)
@@ -26,17 +37,83 @@ func TenantMiddleware(db *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		// SET LOCAL doesn't support parameterized queries in PostgreSQL.
-		// tenant_id is a validated UUID so it's safe from injection.
-		query := fmt.Sprintf("SET LOCAL app.current_tenant = '%s'", tenantID.String())
-		_, err := db.Exec(c.Request.Context(), query)
+		ctx := c.Request.Context()
+		tx, err := db.Begin(ctx)
 		if err != nil {
+			slog.Error("failed to begin request transaction", "error", err, "tenant_id", tenantID)
+			httputil.InternalError(c)
+			c.Abort()
+			return
+		}
+		// Rollback is a no-op once the transaction has been committed.
+		// context.Background so a cancelled request still releases the conn.
+		defer tx.Rollback(context.Background())
+
+		if err := database.SetTenant(ctx, tx, tenantID); err != nil {
 			slog.Error("failed to set tenant context", "error", err, "tenant_id", tenantID)
 			httputil.InternalError(c)
 			c.Abort()
 			return
 		}
 
+		hooksCtx, hooks := database.WithRollbackHooks(ctx)
+		buffered := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = buffered
+		c.Request = c.Request.WithContext(database.WithTx(hooksCtx, tx))
+
 		c.Next()
+
+		c.Writer = buffered.ResponseWriter
+		status := buffered.Status()
+
+		if status >= http.StatusBadRequest || len(c.Errors) > 0 {
+			hooks.Run()
+			buffered.flush()
+			return
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			slog.Error("failed to commit request transaction", "error", err, "tenant_id", tenantID)
+			hooks.Run()
+			buffered.body.Reset()
+			httputil.InternalError(c)
+			return
+		}
+
+		buffered.flush()
+	}
+}
+
+// bufferedWriter holds the response body in memory until the request
+// transaction has been committed or rolled back.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// WriteHeaderNow is deferred to flush; gin calls it directly for bodiless
+// responses such as 204.
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0 || w.ResponseWriter.Written()
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeaderNow()
+	if w.body.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
 	}
 }
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/httputil"
+)
+
+// RequireVerifiedEmail rejects callers who have not verified their email
+// yet. It guards sensitive actions (money, staff) and must run after
+// TenantMiddleware, whose transaction it reads the user with.
+func RequireVerifiedEmail(db *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet(ContextUserID).(uuid.UUID)
+		ctx := c.Request.Context()
+
+		var verified bool
+		err := database.Conn(ctx, db).QueryRow(ctx,
+			"SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+		).Scan(&verified)
+		if errors.Is(err, pgx.ErrNoRows) {
+			httputil.Unauthorized(c, "account not found")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			slog.Error("failed to check email verification", "error", err, "user_id", userID)
+			httputil.InternalError(c)
+			c.Abort()
+			return
+		}
+
+		if !verified {
+			httputil.Forbidden(c, "verify your email address to perform this action")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
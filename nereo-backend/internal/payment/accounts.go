@@ -0,0 +1,488 @@
+package payment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/config"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/sealbox"
+)
+
+var (
+	ErrAccountNotConnected = errors.New("tenant has no mercado pago account")
+	ErrOAuthNotConfigured  = errors.New("mercado pago oauth is not configured")
+	ErrInvalidOAuthState   = errors.New("invalid or expired oauth state")
+)
+
+const (
+	mpAuthorizationURL = "https://auth.mercadopago.com/authorization"
+	oauthStateTTL      = 10 * time.Minute
+	// Access tokens last 180 days; they are refreshed in the last week.
+	tokenRefreshMargin   = 7 * 24 * time.Hour
+	tokenRefreshInterval = 24 * time.Hour
+)
+
+// Clients hands out the payment provider of each tenant: the MP account it
+// connected through OAuth or, when it has none, the platform account of
+// MP_ACCESS_TOKEN. It also implements membership.PreapprovalUpdater.
+type Clients struct {
+	mp       *MercadoPagoClient // OAuth and connected accounts
+	platform PaymentProvider    // nil without a platform account
+	webhooks PaymentProvider    // verifies notifications
+	repo     *Repository        // owner pool: webhooks and crons look accounts up across tenants
+	box      *sealbox.Box
+	cfg      config.MercadoPagoConfig
+}
+
+func NewClients(cfg config.MercadoPagoConfig, db *pgxpool.Pool) *Clients {
+	mp := NewMercadoPagoClient(cfg)
+	c := newClients(cfg, db, mp)
+	if cfg.AccessToken != "" {
+		c.platform = mp
+	}
+	return c
+}
+
+// NewClientsWithProvider uses provider as the platform account, e.g. an
+// mptest.Provider to run the payment flows offline. Connected accounts
+// still go to Mercado Pago.
+func NewClientsWithProvider(cfg config.MercadoPagoConfig, db *pgxpool.Pool, provider PaymentProvider) *Clients {
+	c := newClients(cfg, db, NewMercadoPagoClient(cfg))
+	c.platform = provider
+	c.webhooks = provider
+	return c
+}
+
+func newClients(cfg config.MercadoPagoConfig, db *pgxpool.Pool, mp *MercadoPagoClient) *Clients {
+	box, err := sealbox.New(cfg.TokenEncryptionKey)
+	if err != nil {
+		slog.Warn("mercado pago account connection disabled", "error", err)
+	}
+	return &Clients{
+		mp:       mp,
+		webhooks: mp,
+		repo:     NewRepository(db),
+		box:      box,
+		cfg:      cfg,
+	}
+}
+
+func (c *Clients) oauthConfigured() bool {
+	return c.cfg.ClientID != "" && c.cfg.ClientSecret != "" && c.box != nil
+}
+
+// ForTenant returns the client acting on the tenant's account, refreshing
+// its token first when it is about to expire.
+func (c *Clients) ForTenant(ctx context.Context, tenantID uuid.UUID) (PaymentProvider, error) {
+	account, err := c.repo.GetMPAccount(ctx, tenantID)
+	if err != nil {
+		if !errors.Is(err, ErrAccountNotFound) {
+			return nil, err
+		}
+		if c.platform == nil {
+			return nil, ErrAccountNotConnected
+		}
+		return c.platform, nil
+	}
+
+	if time.Until(account.ExpiresAt) < tokenRefreshMargin {
+		// MP invalidates the old refresh token: the new one must be stored
+		// even if the request rolls back.
+		refreshed, err := c.refresh(database.WithoutTx(ctx), tenantID)
+		if err != nil {
+			if time.Now().After(account.ExpiresAt) {
+				return nil, err
+			}
+			slog.Warn("failed to refresh mercado pago token, using the current one", "error", err, "tenant_id", tenantID)
+		} else {
+			account = refreshed
+		}
+	}
+	return c.accountClient(account)
+}
+
+// ForMPUser returns the client of the connected account with the given MP
+// user ID, as webhooks identify it, and the tenant the account belongs to.
+// Other users get the platform client and uuid.Nil.
+func (c *Clients) ForMPUser(ctx context.Context, mpUserID int64) (PaymentProvider, uuid.UUID, error) {
+	if mpUserID != 0 {
+		account, err := c.repo.GetMPAccountByUserID(ctx, mpUserID)
+		if err == nil {
+			client, err := c.ForTenant(ctx, account.TenantID)
+			return client, account.TenantID, err
+		}
+		if !errors.Is(err, ErrAccountNotFound) {
+			return nil, uuid.Nil, err
+		}
+	}
+	if c.platform == nil {
+		return nil, uuid.Nil, ErrAccountNotConnected
+	}
+	return c.platform, uuid.Nil, nil
+}
+
+// VerifyWebhook checks the signature of a notification, whatever account
+// it is for.
+func (c *Clients) VerifyWebhook(xSignature, xRequestID, dataID string) error {
+	return c.webhooks.VerifyWebhook(xSignature, xRequestID, dataID)
+}
+
+// UpdatePreapprovalStatus pauses, resumes or cancels a preapproval on the
+// account of the tenant that created it.
+func (c *Clients) UpdatePreapprovalStatus(ctx context.Context, tenantID uuid.UUID, preapprovalID, status string) error {
+	client, err := c.ForTenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	return client.UpdatePreapprovalStatus(ctx, preapprovalID, status)
+}
+
+// account is the client of an MP account and the tenant it belongs to,
+// uuid.Nil for the platform account.
+type account struct {
+	tenantID uuid.UUID
+	client   PaymentProvider
+}
+
+// accounts returns every account payments can go to: the platform one, when
+// configured, and each connected tenant account.
+func (c *Clients) accounts(ctx context.Context) ([]account, error) {
+	var accounts []account
+	if c.platform != nil {
+		accounts = append(accounts, account{client: c.platform})
+	}
+
+	tenants, err := c.repo.ListMPAccountTenants(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, tenantID := range tenants {
+		client, err := c.ForTenant(ctx, tenantID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenantID, err))
+			continue
+		}
+		accounts = append(accounts, account{tenantID: tenantID, client: client})
+	}
+	return accounts, errors.Join(errs...)
+}
+
+func (c *Clients) accountClient(account *MPAccount) (PaymentProvider, error) {
+	if c.box == nil {
+		return nil, ErrOAuthNotConfigured
+	}
+	token, err := c.box.Open(account.AccessTokenEnc)
+	if err != nil {
+		return nil, fmt.Errorf("open mp access token: %w", err)
+	}
+	return c.mp.withToken(string(token), c.cfg.MarketplaceFeePercent), nil
+}
+
+// refresh renews the tokens of the tenant's account. The account stays
+// locked meanwhile, and one refreshed by another instance is not refreshed
+// again.
+func (c *Clients) refresh(ctx context.Context, tenantID uuid.UUID) (*MPAccount, error) {
+	if !c.oauthConfigured() {
+		return nil, ErrOAuthNotConfigured
+	}
+
+	var account *MPAccount
+	err := database.RunInTx(ctx, c.repo.db, func(ctx context.Context) error {
+		var err error
+		account, err = c.repo.GetMPAccountForUpdate(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		if time.Until(account.ExpiresAt) >= tokenRefreshMargin {
+			return nil
+		}
+
+		refreshToken, err := c.box.Open(account.RefreshTokenEnc)
+		if err != nil {
+			return fmt.Errorf("open mp refresh token: %w", err)
+		}
+		token, err := c.mp.RefreshOAuthToken(ctx, c.cfg.ClientID, c.cfg.ClientSecret, string(refreshToken))
+		if err != nil {
+			return err
+		}
+		if err := c.sealTokens(account, token); err != nil {
+			return err
+		}
+		if err := c.repo.UpdateMPAccountTokens(ctx, account); err != nil {
+			return err
+		}
+		slog.Info("mercado pago token refreshed", "tenant_id", tenantID, "expires_at", account.ExpiresAt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// sealTokens sets the sealed tokens and expiry of account from token.
+func (c *Clients) sealTokens(account *MPAccount, token *OAuthToken) error {
+	accessEnc, err := c.box.Seal([]byte(token.AccessToken))
+	if err != nil {
+		return err
+	}
+	refreshEnc, err := c.box.Seal([]byte(token.RefreshToken))
+	if err != nil {
+		return err
+	}
+	account.AccessTokenEnc = accessEnc
+	account.RefreshTokenEnc = refreshEnc
+	account.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return nil
+}
+
+// StartTokenRefreshCron refreshes once a day the tokens about to expire, so
+// accounts of tenants without activity stay connected.
+func StartTokenRefreshCron(clients *Clients) {
+	ticker := time.NewTicker(tokenRefreshInterval)
+
+	go func() {
+		time.Sleep(time.Minute)
+		clients.refreshExpiring()
+
+		for range ticker.C {
+			clients.refreshExpiring()
+		}
+	}()
+
+	slog.Info("mercado pago token refresh cron started", "interval", tokenRefreshInterval)
+}
+
+func (c *Clients) refreshExpiring() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tenants, err := c.repo.ListMPAccountTenants(ctx, time.Now().Add(tokenRefreshMargin))
+	if err != nil {
+		slog.Error("cron: failed to list expiring mercado pago accounts", "error", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		if _, err := c.refresh(ctx, tenantID); err != nil {
+			slog.Error("cron: failed to refresh mercado pago token", "error", err, "tenant_id", tenantID)
+		}
+	}
+}
+
+// ============================================================
+// OAuth state
+// ============================================================
+
+// oauthState travels through MP's authorization page and back to the
+// callback, sealed so it cannot be forged to connect an account to another
+// tenant.
+type oauthState struct {
+	TenantID  uuid.UUID `json:"t"`
+	UserID    uuid.UUID `json:"u"`
+	ExpiresAt int64     `json:"e"`
+}
+
+func (c *Clients) sealState(tenantID, userID uuid.UUID) (string, error) {
+	plain, _ := json.Marshal(oauthState{TenantID: tenantID, UserID: userID, ExpiresAt: time.Now().Add(oauthStateTTL).Unix()})
+	sealed, err := c.box.Seal(plain)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Clients) openState(raw string) (*oauthState, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	plain, err := c.box.Open(sealed)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	var state oauthState
+	if err := json.Unmarshal(plain, &state); err != nil || time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrInvalidOAuthState
+	}
+	return &state, nil
+}
+
+// connect exchanges the authorization code and stores the account.
+func (c *Clients) connect(ctx context.Context, tenantID uuid.UUID, code string) (*MPAccount, error) {
+	token, err := c.mp.ExchangeOAuthCode(ctx, c.cfg.ClientID, c.cfg.ClientSecret, code, c.cfg.OAuthRedirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &MPAccount{TenantID: tenantID, MpUserID: token.UserID, LiveMode: token.LiveMode}
+	if token.PublicKey != "" {
+		account.PublicKey = &token.PublicKey
+	}
+	if token.Scope != "" {
+		account.Scope = &token.Scope
+	}
+	if err := c.sealTokens(account, token); err != nil {
+		return nil, err
+	}
+	if err := c.repo.UpsertMPAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ============================================================
+// Handlers
+// ============================================================
+
+// ConnectMPAccount returns the MP authorization page the owner must open
+// to connect the tenant's account.
+func (h *Handler) ConnectMPAccount(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+
+	if !h.clients.oauthConfigured() {
+		httputil.Conflict(c, "MP_OAUTH_NOT_CONFIGURED", "connecting Mercado Pago accounts is not enabled")
+		return
+	}
+
+	state, err := h.clients.sealState(tenantID, userID)
+	if err != nil {
+		slog.Error("failed to seal oauth state", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	q := url.Values{}
+	q.Set("client_id", h.clients.cfg.ClientID)
+	q.Set("response_type", "code")
+	q.Set("platform_id", "mp")
+	q.Set("state", state)
+	q.Set("redirect_uri", h.clients.cfg.OAuthRedirectURL)
+
+	httputil.OK(c, MPConnectResponse{AuthorizationURL: mpAuthorizationURL + "?" + q.Encode()})
+}
+
+// MPOAuthCallback is where MP redirects after the owner authorizes the app
+// (public: the tenant comes from the sealed state). It redirects to
+// MP_OAUTH_RETURN_URL with ?mp_connect=ok|error, or answers JSON without one.
+func (h *Handler) MPOAuthCallback(c *gin.Context) {
+	if !h.clients.oauthConfigured() {
+		httputil.Conflict(c, "MP_OAUTH_NOT_CONFIGURED", "connecting Mercado Pago accounts is not enabled")
+		return
+	}
+
+	state, err := h.clients.openState(c.Query("state"))
+	if err != nil {
+		h.oauthResult(c, http.StatusBadRequest, "INVALID_STATE", "the authorization link is invalid or expired")
+		return
+	}
+	if c.Query("error") != "" || c.Query("code") == "" {
+		h.oauthResult(c, http.StatusBadRequest, "AUTHORIZATION_DENIED", "the Mercado Pago account was not authorized")
+		return
+	}
+
+	account, err := h.clients.connect(c.Request.Context(), state.TenantID, c.Query("code"))
+	if err != nil {
+		if errors.Is(err, ErrAccountInUse) {
+			h.oauthResult(c, http.StatusConflict, "MP_ACCOUNT_IN_USE", "this Mercado Pago account is connected to another business")
+			return
+		}
+		slog.Error("failed to connect mercado pago account", "error", err, "tenant_id", state.TenantID)
+		h.oauthResult(c, http.StatusBadGateway, "PAYMENT_PROVIDER_ERROR", "could not connect the Mercado Pago account")
+		return
+	}
+	slog.Info("mercado pago account connected", "tenant_id", state.TenantID, "mp_user_id", account.MpUserID, "user_id", state.UserID)
+
+	if h.clients.cfg.OAuthReturnURL != "" {
+		c.Redirect(http.StatusFound, h.clients.cfg.OAuthReturnURL+"?mp_connect=ok")
+		return
+	}
+	httputil.OK(c, MPAccountStatus{Connected: true, Account: account})
+}
+
+// oauthResult reports a failed callback to the front-end, or as JSON.
+func (h *Handler) oauthResult(c *gin.Context, status int, code, message string) {
+	if h.clients.cfg.OAuthReturnURL != "" {
+		c.Redirect(http.StatusFound, h.clients.cfg.OAuthReturnURL+"?mp_connect=error&code="+url.QueryEscape(code))
+		return
+	}
+	switch status {
+	case http.StatusConflict:
+		httputil.Conflict(c, code, message)
+	case http.StatusBadGateway:
+		httputil.BadGateway(c, code, message)
+	default:
+		httputil.BadRequest(c, code, message)
+	}
+}
+
+// GetMPAccount tells whether the tenant connected its own account.
+func (h *Handler) GetMPAccount(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	account, err := h.repo.GetMPAccount(c.Request.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, ErrAccountNotFound) {
+			httputil.OK(c, MPAccountStatus{})
+			return
+		}
+		slog.Error("failed to get mercado pago account", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, MPAccountStatus{Connected: true, Account: account})
+}
+
+// DisconnectMPAccount forgets the tenant's account; new checkouts use the
+// platform account. It is refused while Mercado Pago subscriptions are live,
+// since they charge on the account.
+func (h *Handler) DisconnectMPAccount(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	if err := h.repo.DeleteMPAccount(c.Request.Context(), tenantID); err != nil {
+		if errors.Is(err, ErrAccountNotFound) {
+			httputil.NotFound(c, "no Mercado Pago account connected")
+			return
+		}
+		if errors.Is(err, ErrAccountHasSubscriptions) {
+			httputil.Conflict(c, "MP_ACCOUNT_HAS_SUBSCRIPTIONS", "cancel the Mercado Pago subscriptions before disconnecting the account")
+			return
+		}
+		slog.Error("failed to disconnect mercado pago account", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
+
+// tenantClient resolves the tenant's MP client for a handler. It writes the
+// error response and returns nil when there is none.
+func (h *Handler) tenantClient(c *gin.Context, tenantID uuid.UUID) PaymentProvider {
+	client, err := h.clients.ForTenant(c.Request.Context(), tenantID)
+	if err != nil {
+		if errors.Is(err, ErrAccountNotConnected) {
+			httputil.Conflict(c, "MP_NOT_CONNECTED", "connect a Mercado Pago account first")
+			return nil
+		}
+		slog.Error("failed to get mercado pago client", "error", err, "tenant_id", tenantID)
+		httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not use the Mercado Pago account")
+		return nil
+	}
+	return client
+}
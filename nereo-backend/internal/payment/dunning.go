@@ -0,0 +1,373 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/membership"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/money"
+	"github.com/nereo-ar/backend/pkg/phone"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const dunningInterval = time.Hour
+
+// dunningChannel is the Redis channel the notifications of a tenant's
+// past_due subscriptions are published on, for the notification workers.
+func dunningChannel(tenantID uuid.UUID) string {
+	return "subscription:past_due:" + tenantID.String()
+}
+
+// Dunning follows up past_due subscriptions with the tenant's policy: it
+// tells the customer the charge failed, with a link to pay, reminds them on
+// the policy's days and cancels the subscription once the grace period is
+// over.
+type Dunning struct {
+	repo    *Repository // owner pool: the cron goes through every tenant
+	clients *Clients
+	redis   *goredis.Client
+}
+
+func NewDunning(repo *Repository, clients *Clients, redisClient *goredis.Client) *Dunning {
+	return &Dunning{repo: repo, clients: clients, redis: redisClient}
+}
+
+// StartDunningCron runs the dunning follow-up every hour. It replaces the
+// fixed 7-day past_due cancellation.
+func StartDunningCron(d *Dunning) {
+	ticker := time.NewTicker(dunningInterval)
+
+	go func() {
+		// Run once on startup after a short delay
+		time.Sleep(30 * time.Second)
+		d.run()
+
+		for range ticker.C {
+			d.run()
+		}
+	}()
+
+	slog.Info("dunning cron started", "interval", dunningInterval)
+}
+
+func (d *Dunning) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := d.Run(ctx, time.Now()); err != nil {
+		slog.Error("cron: dunning failed", "error", err)
+	}
+}
+
+// Run follows up every past_due subscription as of now.
+func (d *Dunning) Run(ctx context.Context, now time.Time) error {
+	episodes, err := d.repo.ListPastDueEpisodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	policies := make(map[uuid.UUID]*DunningPolicy)
+	for i := range episodes {
+		ep := &episodes[i]
+		policy, ok := policies[ep.TenantID]
+		if !ok {
+			policy, err = d.repo.GetDunningPolicy(ctx, ep.TenantID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tenant %s: %w", ep.TenantID, err))
+				continue
+			}
+			policies[ep.TenantID] = policy
+		}
+
+		if err := d.followUp(ctx, ep, policy, now); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", ep.SubscriptionID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// followUp does what is due for a past_due episode at now: the cancellation
+// once the grace period is over, otherwise the latest notification due.
+// Steps missed while the cron was down are skipped, so a customer never
+// gets several reminders at once.
+func (d *Dunning) followUp(ctx context.Context, ep *PastDueEpisode, policy *DunningPolicy, now time.Time) error {
+	if !now.Before(graceEnd(ep, policy)) {
+		return d.expire(ctx, ep, policy.GraceDays)
+	}
+
+	day := int(now.Sub(ep.Since) / (24 * time.Hour))
+
+	kind, step := DunningPaymentFailed, 0
+	for _, reminder := range policy.ReminderDays {
+		if reminder <= day && reminder > step {
+			kind, step = DunningReminder, reminder
+		}
+	}
+
+	sent, err := d.repo.DunningNotificationSent(ctx, ep.ChangeID, kind, step)
+	if err != nil || sent {
+		return err
+	}
+
+	n := &DunningNotification{Kind: kind, Day: step}
+	client, err := d.clients.ForTenant(ctx, ep.TenantID)
+	switch {
+	case errors.Is(err, ErrAccountNotConnected):
+		// Manual payments only: the customer is reminded without a link.
+	case err != nil:
+		return fmt.Errorf("get MP client: %w", err)
+	default:
+		link, err := recoveryLink(ctx, client, d.repo, ep, graceEnd(ep, policy))
+		if err != nil {
+			return err
+		}
+		n.CheckoutURL = &link
+	}
+
+	return d.notify(ctx, ep, n)
+}
+
+// graceEnd is when the episode's subscription is cancelled unless paid.
+func graceEnd(ep *PastDueEpisode, policy *DunningPolicy) time.Time {
+	return ep.Since.Add(time.Duration(policy.GraceDays) * 24 * time.Hour)
+}
+
+// expire cancels a subscription whose grace period is over.
+func (d *Dunning) expire(ctx context.Context, ep *PastDueEpisode, graceDays int) error {
+	return database.RunInTx(ctx, d.repo.db, func(ctx context.Context) error {
+		changed, err := d.repo.TransitionSubscription(ctx, &membership.StatusChange{
+			TenantID:       ep.TenantID,
+			SubscriptionID: ep.SubscriptionID,
+			ToStatus:       membership.StatusCancelled,
+			Reason:         membership.ReasonPastDueExpired,
+			ActorType:      membership.ActorSystem,
+		})
+		if err != nil || !changed {
+			return err
+		}
+		slog.Info("cron: past_due subscription cancelled", "subscription_id", ep.SubscriptionID, "grace_days", graceDays)
+
+		return d.notify(ctx, ep, &DunningNotification{Kind: DunningCancelled, Day: graceDays})
+	})
+}
+
+// notify records a notification of the episode and publishes it, in one
+// transaction: one that cannot be published is tried again on the next run.
+func (d *Dunning) notify(ctx context.Context, ep *PastDueEpisode, n *DunningNotification) error {
+	n.TenantID = ep.TenantID
+	n.SubscriptionID = ep.SubscriptionID
+	n.CustomerID = ep.CustomerID
+	n.AmountCents = ep.AmountCents
+	n.Currency = ep.Currency
+
+	return database.RunInTx(ctx, d.repo.db, func(ctx context.Context) error {
+		recorded, err := d.repo.RecordDunningNotification(ctx, ep.ChangeID, n)
+		if err != nil || !recorded {
+			return err
+		}
+
+		payload, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		if err := d.redis.Publish(ctx, dunningChannel(ep.TenantID), payload).Err(); err != nil {
+			return fmt.Errorf("publish dunning notification: %w", err)
+		}
+		return nil
+	})
+}
+
+// recoveryLink is where the customer of a past_due subscription pays. A
+// preapproval has its own page, where the payer can change the card MP
+// retries the charge with. Other subscriptions get a Checkout Pro
+// preference for the plan price, one per episode; its payment reactivates
+// the subscription like any approved charge. The preference cannot be paid
+// after expiresAt, when the subscription is cancelled.
+func recoveryLink(ctx context.Context, client PaymentProvider, repo *Repository, ep *PastDueEpisode, expiresAt time.Time) (string, error) {
+	if ep.MpSubscriptionID != nil {
+		pre, err := client.GetPreapproval(ctx, *ep.MpSubscriptionID)
+		if err != nil {
+			return "", fmt.Errorf("get preapproval from MP: %w", err)
+		}
+		return pre.InitPoint, nil
+	}
+
+	customerName, customerPhone, err := repo.GetCustomerInfo(ctx, ep.TenantID, ep.CustomerID)
+	if err != nil {
+		return "", err
+	}
+	customerEmail, _ := repo.GetCustomerEmail(ctx, ep.TenantID, ep.CustomerID)
+	areaCode, phoneNumber := phone.SplitAR(customerPhone)
+
+	pref, err := client.CreatePreference(ctx, &PreferenceRequest{
+		Items: []PreferenceItem{
+			{
+				Title:      fmt.Sprintf("%s - %s", ep.PlanName, ep.TenantName),
+				Quantity:   1,
+				UnitPrice:  money.FromCents(ep.AmountCents),
+				CurrencyID: ep.Currency,
+				CategoryID: "services",
+			},
+		},
+		Payer: &PreferencePayer{
+			Email: customerEmail,
+			Name:  customerName,
+			Phone: &PreferencePhone{AreaCode: areaCode, Number: phoneNumber},
+		},
+		StatementDescriptor: ep.TenantName,
+		ExternalReference:   ep.SubscriptionID.String(),
+		Metadata: map[string]string{
+			"tenant_id":   ep.TenantID.String(),
+			"customer_id": ep.CustomerID.String(),
+			"plan_id":     ep.PlanID.String(),
+		},
+		Expires:          true,
+		ExpirationDateTo: expiresAt.Format(mpTimeFormat),
+	}, "recovery-"+ep.ChangeID.String())
+	if err != nil {
+		return "", fmt.Errorf("create recovery preference: %w", err)
+	}
+	return pref.InitPoint, nil
+}
+
+// ============================================================
+// Handlers
+// ============================================================
+
+// GetDunningPolicy returns the tenant's dunning policy, or the defaults.
+func (h *Handler) GetDunningPolicy(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	policy, err := h.repo.GetDunningPolicy(c.Request.Context(), tenantID)
+	if err != nil {
+		slog.Error("failed to get dunning policy", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, policy)
+}
+
+// UpdateDunningPolicy replaces the tenant's dunning policy. It applies to
+// subscriptions already past_due too, from the next cron run.
+func (h *Handler) UpdateDunningPolicy(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	var req UpdateDunningPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	days := slices.Clone(req.ReminderDays)
+	slices.Sort(days)
+	days = slices.Compact(days)
+	if len(days) > 0 && days[len(days)-1] >= req.GraceDays {
+		httputil.BadRequest(c, "INVALID_REMINDER_DAYS", "reminders must be sent before the grace period ends")
+		return
+	}
+	if days == nil {
+		days = []int{}
+	}
+
+	policy := &DunningPolicy{
+		TenantID:           tenantID,
+		GraceDays:          req.GraceDays,
+		AllowWashesPastDue: req.AllowWashesPastDue,
+		ReminderDays:       days,
+	}
+	if err := h.repo.UpsertDunningPolicy(c.Request.Context(), policy); err != nil {
+		slog.Error("failed to update dunning policy", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, policy)
+}
+
+// GetDunningReport reports the past_due episodes that started between
+// ?from= and ?to= (YYYY-MM-DD in the tenant's timezone, inclusive):
+// recovered and lost revenue, and what is still past_due.
+func (h *Handler) GetDunningReport(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	from, to := c.Query("from"), c.Query("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			httputil.BadRequest(c, "INVALID_DATE", "from and to are required as YYYY-MM-DD")
+			return
+		}
+	}
+	if from > to {
+		httputil.BadRequest(c, "INVALID_DATE", "from is after to")
+		return
+	}
+
+	report, err := h.repo.GetDunningReport(c.Request.Context(), tenantID, from, to)
+	if err != nil {
+		slog.Error("failed to get dunning report", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, report)
+}
+
+// CreateRecoveryLink returns the link the customer of a past_due
+// subscription can pay at, e.g. to send it again by hand. Repeated calls
+// return the same link.
+func (h *Handler) CreateRecoveryLink(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	subID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid subscription id")
+		return
+	}
+
+	ep, err := h.repo.GetPastDueEpisode(c.Request.Context(), tenantID, subID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			httputil.NotFound(c, "subscription not found")
+		case errors.Is(err, ErrNotPastDue):
+			httputil.Conflict(c, "NOT_PAST_DUE", "subscription is not past_due")
+		default:
+			slog.Error("failed to get past_due subscription", "error", err, "tenant_id", tenantID)
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	policy, err := h.repo.GetDunningPolicy(c.Request.Context(), tenantID)
+	if err != nil {
+		slog.Error("failed to get dunning policy", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	mpClient := h.tenantClient(c, tenantID)
+	if mpClient == nil {
+		return
+	}
+
+	link, err := recoveryLink(c.Request.Context(), mpClient, h.repo, ep, graceEnd(ep, policy))
+	if err != nil {
+		slog.Error("failed to create recovery link", "error", err, "tenant_id", tenantID, "subscription_id", subID)
+		httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not create the payment link in Mercado Pago")
+		return
+	}
+
+	httputil.OK(c, RecoveryLinkResponse{SubscriptionID: subID, CheckoutURL: link})
+}
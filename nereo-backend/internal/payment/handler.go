@@ -1,37 +1,43 @@
 package payment
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/membership"
 	"github.com/nereo-ar/backend/internal/middleware"
 	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/money"
+	"github.com/nereo-ar/backend/pkg/phone"
 )
 
 type Handler struct {
-	mpClient      *MercadoPagoClient
-	repo          *Repository
-	webhookSecret string
+	clients     *Clients
+	repo        *Repository
+	memberships *membership.Service
+	reconciler  *Reconciler // admin-triggered runs; repo must use the owner pool
 }
 
-func NewHandler(mpClient *MercadoPagoClient, repo *Repository, webhookSecret string) *Handler {
+func NewHandler(clients *Clients, repo *Repository, memberships *membership.Service) *Handler {
 	return &Handler{
-		mpClient:      mpClient,
-		repo:          repo,
-		webhookSecret: webhookSecret,
+		clients:     clients,
+		repo:        repo,
+		memberships: memberships,
+		reconciler:  NewReconciler(NewWebhookProcessor(clients, repo)),
 	}
 }
 
 // CreatePreference creates a Checkout Pro preference for a one-time payment
 func (h *Handler) CreatePreference(c *gin.Context) {
 	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
 
 	var req CreatePreferenceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,62 +45,107 @@ func (h *Handler) CreatePreference(c *gin.Context) {
 		return
 	}
 
+	idem, done := h.beginMPRequest(c, tenantID, mpOperationPreference, req)
+	if done {
+		return
+	}
+
+	// After the replay check: a replay needs no account.
+	mpClient := h.tenantClient(c, tenantID)
+	if mpClient == nil {
+		return
+	}
+
 	plan, err := h.repo.GetPlanWithTenant(c.Request.Context(), tenantID, req.PlanID)
 	if err != nil {
 		httputil.NotFound(c, "plan not found or inactive")
 		return
 	}
 
-	// Create a subscription record first to use as external_reference
-	subID := uuid.New()
 	notifURL := req.NotificationURL
 
 	// Fetch customer info for payer fields (improves MP approval rate)
-	customerEmail, _ := h.repo.GetCustomerEmail(c.Request.Context(), req.CustomerID)
-	customerName, customerPhone := h.repo.GetCustomerInfo(c.Request.Context(), req.CustomerID)
+	customerName, customerPhone, err := h.repo.GetCustomerInfo(c.Request.Context(), tenantID, req.CustomerID)
+	if err != nil {
+		if errors.Is(err, ErrCustomerNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	// Pending payment until the webhook reports the payment approved; its ID
+	// is the external_reference.
+	sub, err := h.memberships.CreateCheckoutSubscription(c.Request.Context(), tenantID, userID, checkoutSubscriptionID(idem), membership.CreateSubscriptionRequest{
+		CustomerID: req.CustomerID,
+		PlanID:     req.PlanID,
+	})
+	if err != nil {
+		if errors.Is(err, membership.ErrPlanNotFound) {
+			httputil.NotFound(c, "plan not found or inactive")
+			return
+		}
+		slog.Error("failed to create checkout subscription", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+	customerEmail, _ := h.repo.GetCustomerEmail(c.Request.Context(), tenantID, req.CustomerID)
+	areaCode, phoneNumber := phone.SplitAR(customerPhone)
 
 	mpReq := &PreferenceRequest{
 		Items: []PreferenceItem{
 			{
 				Title:      fmt.Sprintf("%s - %s", plan.PlanName, plan.TenantName),
 				Quantity:   1,
-				UnitPrice:  float64(plan.PriceCents) / 100.0,
-				CurrencyID: "ARS",
+				UnitPrice:  money.FromCents(plan.PriceCents),
+				CurrencyID: plan.Currency,
 				CategoryID: "services",
 			},
 		},
 		Payer: &PreferencePayer{
 			Email:   customerEmail,
 			Name:    customerName,
-			Phone:   &PreferencePhone{Number: customerPhone},
+			Phone:   &PreferencePhone{AreaCode: areaCode, Number: phoneNumber},
 		},
 		StatementDescriptor: plan.TenantName,
 		NotificationURL:     notifURL,
-		ExternalReference:   subID.String(),
+		ExternalReference:   sub.ID.String(),
 		Metadata: map[string]string{
 			"tenant_id":   tenantID.String(),
 			"customer_id": req.CustomerID.String(),
 			"plan_id":     req.PlanID.String(),
 		},
+		// Not payable once the checkout cron cancels the subscription
+		Expires:          true,
+		ExpirationDateTo: sub.CreatedAt.Add(h.clients.cfg.CheckoutTTL).Format(mpTimeFormat),
 	}
 
-	mpResp, err := h.mpClient.CreatePreference(c.Request.Context(), mpReq)
+	// A failure rolls back the pending subscription with the request
+	mpResp, err := mpClient.CreatePreference(c.Request.Context(), mpReq, mpIdempotencyKey(mpOperationPreference, sub.ID))
 	if err != nil {
 		slog.Error("failed to create MP preference", "error", err, "tenant_id", tenantID)
-		httputil.InternalError(c)
+		httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not create the checkout in Mercado Pago")
 		return
 	}
 
-	httputil.Created(c, CreatePreferenceResponse{
+	resp := CreatePreferenceResponse{
+		SubscriptionID:   sub.ID,
 		PreferenceID:     mpResp.ID,
 		InitPoint:        mpResp.InitPoint,
 		SandboxInitPoint: mpResp.SandboxInitPoint,
-	})
+	}
+	if !h.completeMPRequest(c, idem, sub.ID, mpResp.ID, resp) {
+		return
+	}
+
+	httputil.Created(c, resp)
 }
 
 // CreateSubscriptionMP creates a recurring subscription via MP Preapproval
 func (h *Handler) CreateSubscriptionMP(c *gin.Context) {
 	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
 
 	var req CreateSubscriptionMPRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -102,14 +153,41 @@ func (h *Handler) CreateSubscriptionMP(c *gin.Context) {
 		return
 	}
 
+	idem, done := h.beginMPRequest(c, tenantID, mpOperationPreapproval, req)
+	if done {
+		return
+	}
+
+	// After the replay check: a replay needs no account.
+	mpClient := h.tenantClient(c, tenantID)
+	if mpClient == nil {
+		return
+	}
+
 	plan, err := h.repo.GetPlanWithTenant(c.Request.Context(), tenantID, req.PlanID)
 	if err != nil {
 		httputil.NotFound(c, "plan not found or inactive")
 		return
 	}
 
-	// Create subscription record
-	subID := uuid.New()
+	// Pending until the payer authorizes the preapproval (webhook)
+	sub, err := h.memberships.CreatePendingSubscription(c.Request.Context(), tenantID, userID, checkoutSubscriptionID(idem), membership.CreateSubscriptionRequest{
+		CustomerID: req.CustomerID,
+		PlanID:     req.PlanID,
+	})
+	if err != nil {
+		if errors.Is(err, membership.ErrCustomerNotFound) {
+			httputil.NotFound(c, "customer not found")
+			return
+		}
+		if errors.Is(err, membership.ErrPlanNotFound) {
+			httputil.NotFound(c, "plan not found or inactive")
+			return
+		}
+		slog.Error("failed to create pending subscription", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
 
 	frequencyType := "months"
 	if plan.Interval == "weekly" {
@@ -125,26 +203,39 @@ func (h *Handler) CreateSubscriptionMP(c *gin.Context) {
 		AutoRecurring: AutoRecurring{
 			Frequency:         frequency,
 			FrequencyType:     frequencyType,
-			TransactionAmount: float64(plan.PriceCents) / 100.0,
-			CurrencyID:        "ARS",
+			TransactionAmount: money.FromCents(plan.PriceCents),
+			CurrencyID:        plan.Currency,
 		},
 		PayerEmail:        req.PayerEmail,
-		ExternalReference: subID.String(),
+		ExternalReference: sub.ID.String(),
 	}
 
-	mpResp, err := h.mpClient.CreatePreapproval(c.Request.Context(), mpReq)
+	// A failure rolls back the pending subscription with the request
+	mpResp, err := mpClient.CreatePreapproval(c.Request.Context(), mpReq, mpIdempotencyKey(mpOperationPreapproval, sub.ID))
 	if err != nil {
 		slog.Error("failed to create MP preapproval", "error", err, "tenant_id", tenantID)
+		httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not create the subscription in Mercado Pago")
+		return
+	}
+
+	if err := h.repo.UpdateSubscriptionMPID(c.Request.Context(), sub.ID, mpResp.ID); err != nil {
+		slog.Error("failed to store MP preapproval id", "error", err, "subscription_id", sub.ID)
 		httputil.InternalError(c)
 		return
 	}
 
-	httputil.Created(c, CreateSubscriptionMPResponse{
+	resp := CreateSubscriptionMPResponse{
+		SubscriptionID:   sub.ID,
 		PreapprovalID:    mpResp.ID,
 		InitPoint:        mpResp.InitPoint,
 		SandboxInitPoint: mpResp.SandboxInitPoint,
 		Status:           mpResp.Status,
-	})
+	}
+	if !h.completeMPRequest(c, idem, sub.ID, mpResp.ID, resp) {
+		return
+	}
+
+	httputil.Created(c, resp)
 }
 
 // HandleWebhook processes incoming Mercado Pago webhook notifications
@@ -154,120 +245,53 @@ func (h *Handler) HandleWebhook(c *gin.Context) {
 	xRequestID := c.GetHeader("x-request-id")
 	dataID := c.Query("data.id")
 
-	if err := VerifyWebhookSignature(xSignature, xRequestID, dataID, h.webhookSecret); err != nil {
+	if err := h.clients.VerifyWebhook(xSignature, xRequestID, dataID); err != nil {
 		slog.Warn("webhook signature verification failed", "error", err)
 		c.Status(http.StatusUnauthorized)
 		return
 	}
 
-	var body WebhookBody
-	if err := c.ShouldBindJSON(&body); err != nil {
-		// MP sometimes sends form-encoded or query params
-		body.Type = c.Query("type")
-		body.Data.ID = c.Query("data.id")
-	}
-
-	// Return 200 immediately, process async
-	c.Status(http.StatusOK)
-
-	// Process in background
-	go h.processWebhook(body)
-}
-
-func (h *Handler) processWebhook(body WebhookBody) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	switch body.Type {
-	case "payment":
-		h.processPaymentWebhook(ctx, body.Data.ID)
-	case "subscription_preapproval":
-		h.processPreapprovalWebhook(ctx, body.Data.ID)
-	default:
-		slog.Info("ignoring webhook type", "type", body.Type)
-	}
-}
-
-func (h *Handler) processPaymentWebhook(ctx context.Context, paymentID string) {
-	logger := slog.Default().With("mp_payment_id", paymentID)
-
-	// Check idempotency
-	existing, _ := h.repo.GetPaymentEventByMPID(ctx, paymentID)
-	if existing != nil {
-		logger.Info("payment already processed, skipping")
-		return
-	}
-
-	// Fetch payment details from MP
-	payment, err := h.mpClient.GetPayment(ctx, paymentID)
+	raw, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		logger.Error("failed to get payment from MP", "error", err)
+		c.Status(http.StatusBadRequest)
 		return
 	}
 
-	// Parse external_reference as subscription ID
-	subID, err := uuid.Parse(payment.ExternalReference)
-	if err != nil {
-		logger.Error("invalid external_reference", "ref", payment.ExternalReference)
-		return
+	var body WebhookBody
+	if err := json.Unmarshal(raw, &body); err != nil {
+		// MP sometimes sends form-encoded or query params
+		body.Type = c.Query("type")
+		body.Data.ID = c.Query("data.id")
+		raw = []byte("{}")
 	}
-
-	// Get tenant from subscription
-	tenantID, err := h.repo.GetSubscriptionTenantID(ctx, subID)
-	if err != nil {
-		logger.Error("failed to get subscription tenant", "error", err)
+	if body.Type == "" || body.Data.ID == "" {
+		slog.Info("ignoring webhook without type or data.id", "type", body.Type)
+		c.Status(http.StatusOK)
 		return
 	}
 
-	// Record payment event
-	rawPayload, _ := json.Marshal(payment)
-	mpID := fmt.Sprintf("%d", payment.ID)
-	event := &PaymentEvent{
-		TenantID:       tenantID,
-		SubscriptionID: &subID,
-		Source:         "mercadopago",
-		MpPaymentID:    &mpID,
-		Status:         payment.Status,
-		AmountCents:    int(payment.TransactionAmount * 100),
-		RawPayload:     rawPayload,
+	delivery := &WebhookDelivery{
+		Provider:   "mercadopago",
+		Topic:      body.Type,
+		ResourceID: body.Data.ID,
+		Payload:    raw,
 	}
-
-	if err := h.repo.CreatePaymentEvent(ctx, event); err != nil {
-		if errors.Is(err, ErrPaymentAlreadyProcessed) {
-			logger.Info("payment already processed (race condition)")
-			return
-		}
-		logger.Error("failed to record payment event", "error", err)
-		return
+	if body.Action != "" {
+		delivery.Action = &body.Action
 	}
-
-	// Update subscription status based on payment status
-	switch payment.Status {
-	case "approved":
-		if err := h.repo.UpdateSubscriptionStatus(ctx, subID, "active"); err != nil {
-			logger.Error("failed to activate subscription", "error", err)
-		}
-		logger.Info("subscription activated via payment")
-	case "rejected":
-		if err := h.repo.UpdateSubscriptionStatus(ctx, subID, "past_due"); err != nil {
-			logger.Error("failed to mark subscription past_due", "error", err)
-		}
-		logger.Warn("payment rejected, subscription marked past_due")
-	case "pending", "in_process":
-		logger.Info("payment pending", "status", payment.Status)
+	if xRequestID != "" {
+		delivery.RequestID = &xRequestID
 	}
-}
 
-func (h *Handler) processPreapprovalWebhook(ctx context.Context, preapprovalID string) {
-	logger := slog.Default().With("mp_preapproval_id", preapprovalID)
-
-	info, err := h.mpClient.GetPreapproval(ctx, preapprovalID)
-	if err != nil {
-		logger.Error("failed to get preapproval from MP", "error", err)
+	// Only acknowledge once stored: on error MP redelivers it later.
+	if err := h.repo.EnqueueWebhook(c.Request.Context(), delivery); err != nil {
+		slog.Error("failed to store webhook", "error", err, "type", body.Type, "data_id", body.Data.ID)
+		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	logger.Info("preapproval status update", "status", info.Status)
+	// Processed by the webhook workers
+	c.Status(http.StatusOK)
 }
 
 // RegisterManualPayment records a cash/transfer payment and activates the subscription
@@ -281,14 +305,25 @@ func (h *Handler) RegisterManualPayment(c *gin.Context) {
 		return
 	}
 
-	// Verify subscription belongs to this tenant
-	sub, err := h.repo.GetSubscriptionWithPlan(c.Request.Context(), req.SubscriptionID)
+	// Scoped by tenant: another tenant's subscription is simply not found
+	sub, err := h.repo.GetSubscriptionWithPlan(c.Request.Context(), tenantID, req.SubscriptionID)
 	if err != nil {
-		httputil.NotFound(c, "subscription not found")
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			httputil.NotFound(c, "subscription not found")
+			return
+		}
+		httputil.InternalError(c)
 		return
 	}
-	if sub.TenantID != tenantID {
-		httputil.Forbidden(c, "subscription does not belong to this tenant")
+	amount := money.New(req.AmountCents, sub.Currency)
+	if req.Currency != "" {
+		if err := amount.Check(money.Currency(req.Currency)); err != nil {
+			httputil.BadRequest(c, "CURRENCY_MISMATCH", err.Error())
+			return
+		}
+	}
+
+	if !h.reactivate(c, tenantID, req.SubscriptionID, userID) {
 		return
 	}
 
@@ -299,7 +334,8 @@ func (h *Handler) RegisterManualPayment(c *gin.Context) {
 		SubscriptionID: &req.SubscriptionID,
 		Source:         "manual",
 		Status:         "approved",
-		AmountCents:    req.AmountCents,
+		AmountCents:    amount.Cents,
+		Currency:       amount.Currency,
 		Notes:          &notes,
 		RecordedBy:     &userID,
 		RawPayload:     []byte("{}"),
@@ -313,15 +349,9 @@ func (h *Handler) RegisterManualPayment(c *gin.Context) {
 
 	// Activate/renew subscription
 	now := time.Now()
-	var periodEnd time.Time
-	switch sub.Interval {
-	case "weekly":
-		periodEnd = now.AddDate(0, 0, 7)
-	default:
-		periodEnd = now.AddDate(0, 1, 0)
-	}
+	periodEnd := nextPeriodEnd(now, sub.Interval)
 
-	if err := h.repo.RenewSubscription(c.Request.Context(), req.SubscriptionID, now, periodEnd); err != nil {
+	if err := h.repo.RenewSubscription(c.Request.Context(), tenantID, req.SubscriptionID, now, periodEnd); err != nil {
 		slog.Error("failed to renew subscription", "error", err)
 		httputil.InternalError(c)
 		return
@@ -347,13 +377,17 @@ func (h *Handler) RenewManual(c *gin.Context) {
 		req = RenewManualRequest{}
 	}
 
-	sub, err := h.repo.GetSubscriptionWithPlan(c.Request.Context(), subID)
+	sub, err := h.repo.GetSubscriptionWithPlan(c.Request.Context(), tenantID, subID)
 	if err != nil {
-		httputil.NotFound(c, "subscription not found")
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			httputil.NotFound(c, "subscription not found")
+			return
+		}
+		httputil.InternalError(c)
 		return
 	}
-	if sub.TenantID != tenantID {
-		httputil.Forbidden(c, "subscription does not belong to this tenant")
+
+	if !h.reactivate(c, tenantID, subID, userID) {
 		return
 	}
 
@@ -368,6 +402,7 @@ func (h *Handler) RenewManual(c *gin.Context) {
 		Source:         "manual",
 		Status:         "approved",
 		AmountCents:    sub.PriceCents,
+		Currency:       sub.Currency,
 		Notes:          &notes,
 		RecordedBy:     &userID,
 		RawPayload:     []byte("{}"),
@@ -380,15 +415,9 @@ func (h *Handler) RenewManual(c *gin.Context) {
 	}
 
 	now := time.Now()
-	var periodEnd time.Time
-	switch sub.Interval {
-	case "weekly":
-		periodEnd = now.AddDate(0, 0, 7)
-	default:
-		periodEnd = now.AddDate(0, 1, 0)
-	}
+	periodEnd := nextPeriodEnd(now, sub.Interval)
 
-	if err := h.repo.RenewSubscription(c.Request.Context(), subID, now, periodEnd); err != nil {
+	if err := h.repo.RenewSubscription(c.Request.Context(), tenantID, subID, now, periodEnd); err != nil {
 		slog.Error("failed to renew subscription", "error", err)
 		httputil.InternalError(c)
 		return
@@ -396,3 +425,102 @@ func (h *Handler) RenewManual(c *gin.Context) {
 
 	httputil.OK(c, gin.H{"status": "renewed", "new_period_end": periodEnd.Format(time.RFC3339)})
 }
+
+// reactivate moves a subscription to active for a manual payment. It writes
+// the error response and returns false when the state machine refuses it,
+// e.g. for a cancelled or paused subscription.
+func (h *Handler) reactivate(c *gin.Context, tenantID, subID, userID uuid.UUID) bool {
+	_, err := h.repo.TransitionSubscription(c.Request.Context(), &membership.StatusChange{
+		TenantID:       tenantID,
+		SubscriptionID: subID,
+		ToStatus:       membership.StatusActive,
+		Reason:         membership.ReasonManualPayment,
+		ActorType:      membership.ActorUser,
+		ActorID:        &userID,
+	})
+	if err != nil {
+		if errors.Is(err, membership.ErrInvalidTransition) {
+			httputil.Conflict(c, "INVALID_STATUS_TRANSITION", "subscription cannot be reactivated from its current status")
+			return false
+		}
+		slog.Error("failed to reactivate subscription", "error", err, "subscription_id", subID)
+		httputil.InternalError(c)
+		return false
+	}
+	return true
+}
+
+// ============================================================
+// Webhook inbox (platform admin)
+// ============================================================
+
+// ListWebhooks pages through the webhook inbox, optionally filtered by
+// ?status=pending|processing|processed|failed|dead.
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", WebhookPending, WebhookProcessing, WebhookProcessed, WebhookFailed, WebhookDead:
+	default:
+		httputil.BadRequest(c, "INVALID_STATUS", "invalid webhook status")
+		return
+	}
+	page, perPage := httputil.ParsePagination(c)
+
+	deliveries, total, err := h.repo.ListWebhooks(c.Request.Context(), status, page, perPage)
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+
+	httputil.Paginated(c, deliveries, page, perPage, total)
+}
+
+func (h *Handler) GetWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid webhook id")
+		return
+	}
+
+	delivery, err := h.repo.GetWebhook(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			httputil.NotFound(c, "webhook delivery not found")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, delivery)
+}
+
+// ReplayWebhook queues a failed or dead delivery again with a fresh attempt
+// budget.
+func (h *Handler) ReplayWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid webhook id")
+		return
+	}
+
+	delivery, err := h.repo.ReplayWebhook(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrWebhookNotFound):
+			httputil.NotFound(c, "webhook delivery not found")
+		case errors.Is(err, ErrWebhookNotReplayable):
+			httputil.Conflict(c, "NOT_REPLAYABLE", "only failed or dead deliveries can be replayed")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	slog.Info("webhook delivery replayed", "webhook_id", id)
+	httputil.OK(c, delivery)
+}
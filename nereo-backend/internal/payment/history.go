@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+)
+
+// ListPayments pages through the tenant's payment events, newest first.
+// Filters: ?from= and ?to= (YYYY-MM-DD in the tenant's timezone, inclusive),
+// ?source=mercadopago|manual, ?type=charge|refund|chargeback, ?status=,
+// ?subscription_id=, ?customer_id= and ?recorded_by=. The totals cover
+// every matching event, not only the page.
+func (h *Handler) ListPayments(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	page, perPage := httputil.ParsePagination(c)
+
+	f := PaymentFilter{
+		From:      c.Query("from"),
+		To:        c.Query("to"),
+		Source:    c.Query("source"),
+		EventType: c.Query("type"),
+		Status:    c.Query("status"),
+		Page:      page,
+		PerPage:   perPage,
+	}
+	for _, date := range []string{f.From, f.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			httputil.BadRequest(c, "INVALID_DATE", "from and to must be YYYY-MM-DD")
+			return
+		}
+	}
+	switch f.Source {
+	case "", "mercadopago", "manual":
+	default:
+		httputil.BadRequest(c, "INVALID_SOURCE", "source must be mercadopago or manual")
+		return
+	}
+	switch f.EventType {
+	case "", EventCharge, EventRefund, EventChargeback:
+	default:
+		httputil.BadRequest(c, "INVALID_TYPE", "type must be charge, refund or chargeback")
+		return
+	}
+
+	var ok bool
+	if f.SubscriptionID, ok = queryUUID(c, "subscription_id"); !ok {
+		return
+	}
+	if f.CustomerID, ok = queryUUID(c, "customer_id"); !ok {
+		return
+	}
+	if f.RecordedBy, ok = queryUUID(c, "recorded_by"); !ok {
+		return
+	}
+
+	payments, totals, total, err := h.repo.ListPayments(c.Request.Context(), tenantID, f)
+	if err != nil {
+		slog.Error("failed to list payments", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	if payments == nil {
+		payments = []PaymentListItem{}
+	}
+
+	httputil.Paginated(c, PaymentList{Payments: payments, Totals: totals}, page, perPage, total)
+}
+
+// GetPayment returns a payment event with the payload MP sent (empty for
+// manual payments) and its refunds and chargebacks.
+func (h *Handler) GetPayment(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid payment id")
+		return
+	}
+
+	payment, err := h.repo.GetPayment(c.Request.Context(), tenantID, id)
+	if err != nil {
+		if errors.Is(err, ErrPaymentNotFound) {
+			httputil.NotFound(c, "payment not found")
+			return
+		}
+		slog.Error("failed to get payment", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, payment)
+}
+
+// queryUUID parses an optional UUID query parameter. It writes the error
+// response and returns false when the value is not a UUID.
+func queryUUID(c *gin.Context, name string) (*uuid.UUID, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid "+name)
+		return nil, false
+	}
+	return &id, true
+}
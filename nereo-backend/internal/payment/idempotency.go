@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/pkg/httputil"
+)
+
+// Operations stored in mp_requests. They also prefix the X-Idempotency-Key
+// sent to MP, which is derived from the subscription the object is for (see
+// checkoutSubscriptionID).
+const (
+	mpOperationPreference  = "preference"
+	mpOperationPreapproval = "preapproval"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// mpIdempotencyKey is the key sent to MP for creating the object of an
+// operation for a subscription; retries and replays reuse it.
+func mpIdempotencyKey(operation string, subID uuid.UUID) string {
+	return operation + "-" + subID.String()
+}
+
+// checkoutSubscriptionID is the ID of the subscription a checkout creates.
+// With an Idempotency-Key it is derived from the tenant, the key and the
+// body: a failed request rolls its subscription and reservation back, and
+// the retry must send MP the same X-Idempotency-Key to get the object MP
+// may have created before the failure, whose external_reference is that
+// same ID.
+func checkoutSubscriptionID(req *MPRequest) uuid.UUID {
+	if req == nil {
+		return uuid.New()
+	}
+	return uuid.NewSHA1(req.TenantID, []byte(req.Operation+"\n"+req.IdempotencyKey+"\n"+req.RequestHash))
+}
+
+// beginMPRequest handles the Idempotency-Key header of a checkout creation.
+// Without the header it returns (nil, false) and the request proceeds as
+// usual. When the key was used before it writes the original response (or a
+// conflict if the body differs) and returns done = true.
+func (h *Handler) beginMPRequest(c *gin.Context, tenantID uuid.UUID, operation string, body any) (req *MPRequest, done bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return nil, false
+	}
+	if len(key) > 255 {
+		httputil.BadRequest(c, "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key must be at most 255 characters")
+		return nil, true
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		httputil.InternalError(c)
+		return nil, true
+	}
+	sum := sha256.Sum256(raw)
+
+	req = &MPRequest{
+		TenantID:       tenantID,
+		Operation:      operation,
+		IdempotencyKey: key,
+		RequestHash:    hex.EncodeToString(sum[:]),
+	}
+	existing, err := h.repo.ReserveMPRequest(c.Request.Context(), req)
+	if err != nil {
+		slog.Error("failed to reserve idempotency key", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return nil, true
+	}
+	if existing == nil {
+		return req, false
+	}
+
+	if existing.RequestHash != req.RequestHash {
+		httputil.Conflict(c, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used with a different request")
+		return nil, true
+	}
+	slog.Info("replaying idempotent MP request", "operation", operation, "mp_id", existing.MPID, "tenant_id", tenantID)
+	httputil.Created(c, existing.Response)
+	return nil, true
+}
+
+// completeMPRequest stores the response of a request begun with a key. It
+// writes an error response and returns false when that fails.
+func (h *Handler) completeMPRequest(c *gin.Context, req *MPRequest, subID uuid.UUID, mpID string, response any) bool {
+	if req == nil {
+		return true
+	}
+
+	raw, err := json.Marshal(response)
+	if err != nil {
+		httputil.InternalError(c)
+		return false
+	}
+	mpKey := mpIdempotencyKey(req.Operation, subID)
+	req.MPIdempotencyKey = &mpKey
+	req.SubscriptionID = &subID
+	req.MPID = &mpID
+	req.Response = raw
+
+	if err := h.repo.CompleteMPRequest(c.Request.Context(), req); err != nil {
+		slog.Error("failed to store idempotent MP response", "error", err, "tenant_id", req.TenantID)
+		httputil.InternalError(c)
+		return false
+	}
+	return true
+}
@@ -9,9 +9,13 @@ import (
 	"log/slog"
 	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nereo-ar/backend/internal/config"
+	"github.com/nereo-ar/backend/pkg/money"
 )
 
 type MercadoPagoClient struct {
@@ -20,6 +24,12 @@ type MercadoPagoClient struct {
 	token      string
 	backURLs   BackURLs
 	maxRetries int
+	// webhookSecret signs the notifications of every account, connected
+	// ones included: they belong to the same MP application.
+	webhookSecret string
+	// feePercent is the platform's marketplace_fee on preferences created
+	// for a connected tenant account; see Clients.
+	feePercent float64
 }
 
 type BackURLs struct {
@@ -40,10 +50,19 @@ func NewMercadoPagoClient(cfg config.MercadoPagoConfig) *MercadoPagoClient {
 			Failure: cfg.BackURLFailure,
 			Pending: cfg.BackURLPending,
 		},
-		maxRetries: 3,
+		maxRetries:    3,
+		webhookSecret: cfg.WebhookSecret,
 	}
 }
 
+// withToken returns a client that acts on behalf of another MP account.
+func (c *MercadoPagoClient) withToken(token string, feePercent float64) *MercadoPagoClient {
+	clone := *c
+	clone.token = token
+	clone.feePercent = feePercent
+	return &clone
+}
+
 // ============================================================
 // Checkout Pro — Preferences
 // ============================================================
@@ -56,6 +75,10 @@ type PreferenceRequest struct {
 	ExternalReference   string              `json:"external_reference,omitempty"`
 	StatementDescriptor string              `json:"statement_descriptor,omitempty"`
 	Metadata            map[string]string   `json:"metadata,omitempty"`
+	MarketplaceFee      money.Decimal       `json:"marketplace_fee,omitempty"` // set by CreatePreference
+	// MP refuses to pay the preference after ExpirationDateTo when Expires.
+	Expires          bool   `json:"expires,omitempty"`
+	ExpirationDateTo string `json:"expiration_date_to,omitempty"` // mpTimeFormat
 }
 
 type PreferencePayer struct {
@@ -71,11 +94,11 @@ type PreferencePhone struct {
 }
 
 type PreferenceItem struct {
-	Title      string  `json:"title"`
-	Quantity   int     `json:"quantity"`
-	UnitPrice  float64 `json:"unit_price"`
-	CurrencyID string  `json:"currency_id"`
-	CategoryID string  `json:"category_id,omitempty"`
+	Title      string         `json:"title"`
+	Quantity   int            `json:"quantity"`
+	UnitPrice  money.Decimal  `json:"unit_price"`
+	CurrencyID money.Currency `json:"currency_id"`
+	CategoryID string         `json:"category_id,omitempty"`
 }
 
 type PreferenceBackURLs struct {
@@ -90,7 +113,9 @@ type PreferenceResponse struct {
 	SandboxInitPoint string `json:"sandbox_init_point"`
 }
 
-func (c *MercadoPagoClient) CreatePreference(ctx context.Context, req *PreferenceRequest) (*PreferenceResponse, error) {
+// CreatePreference sends idempotencyKey to MP: calling it again with the same
+// key returns the preference created the first time.
+func (c *MercadoPagoClient) CreatePreference(ctx context.Context, req *PreferenceRequest, idempotencyKey string) (*PreferenceResponse, error) {
 	if req.BackURLs == nil && c.backURLs.Success != "" {
 		req.BackURLs = &PreferenceBackURLs{
 			Success: c.backURLs.Success,
@@ -98,9 +123,16 @@ func (c *MercadoPagoClient) CreatePreference(ctx context.Context, req *Preferenc
 			Pending: c.backURLs.Pending,
 		}
 	}
+	if c.feePercent > 0 {
+		var total money.Amount
+		for _, item := range req.Items {
+			total.Cents += item.UnitPrice.Cents() * item.Quantity
+		}
+		req.MarketplaceFee = total.Percent(c.feePercent).Decimal()
+	}
 
 	var resp PreferenceResponse
-	err := c.doRequest(ctx, http.MethodPost, "/checkout/preferences", req, &resp)
+	err := c.doRequest(ctx, http.MethodPost, "/checkout/preferences", idempotencyKey, req, &resp)
 	if err != nil {
 		return nil, fmt.Errorf("create preference: %w", err)
 	}
@@ -121,10 +153,10 @@ type PreapprovalRequest struct {
 }
 
 type AutoRecurring struct {
-	Frequency         int     `json:"frequency"`
-	FrequencyType     string  `json:"frequency_type"`
-	TransactionAmount float64 `json:"transaction_amount"`
-	CurrencyID        string  `json:"currency_id"`
+	Frequency         int            `json:"frequency"`
+	FrequencyType     string         `json:"frequency_type"`
+	TransactionAmount money.Decimal  `json:"transaction_amount"`
+	CurrencyID        money.Currency `json:"currency_id"`
 }
 
 type PreapprovalResponse struct {
@@ -134,13 +166,15 @@ type PreapprovalResponse struct {
 	SandboxInitPoint string `json:"sandbox_init_point"`
 }
 
-func (c *MercadoPagoClient) CreatePreapproval(ctx context.Context, req *PreapprovalRequest) (*PreapprovalResponse, error) {
+// CreatePreapproval sends idempotencyKey to MP: calling it again with the
+// same key returns the preapproval created the first time.
+func (c *MercadoPagoClient) CreatePreapproval(ctx context.Context, req *PreapprovalRequest, idempotencyKey string) (*PreapprovalResponse, error) {
 	if req.BackURL == "" && c.backURLs.Success != "" {
 		req.BackURL = c.backURLs.Success
 	}
 
 	var resp PreapprovalResponse
-	err := c.doRequest(ctx, http.MethodPost, "/preapproval", req, &resp)
+	err := c.doRequest(ctx, http.MethodPost, "/preapproval", idempotencyKey, req, &resp)
 	if err != nil {
 		return nil, fmt.Errorf("create preapproval: %w", err)
 	}
@@ -153,44 +187,220 @@ func (c *MercadoPagoClient) CreatePreapproval(ctx context.Context, req *Preappro
 // ============================================================
 
 type PaymentInfo struct {
-	ID                int     `json:"id"`
-	Status            string  `json:"status"`
-	StatusDetail      string  `json:"status_detail"`
-	TransactionAmount float64 `json:"transaction_amount"`
-	CurrencyID        string  `json:"currency_id"`
-	ExternalReference string  `json:"external_reference"`
-	PayerEmail        string  `json:"payer_email,omitempty"`
+	ID                int                    `json:"id"`
+	Status            string                 `json:"status"`
+	StatusDetail      string                 `json:"status_detail"`
+	TransactionAmount money.Decimal          `json:"transaction_amount"`
+	CurrencyID        money.Currency         `json:"currency_id"`
+	ExternalReference string                 `json:"external_reference"`
+	PayerEmail        string                 `json:"payer_email,omitempty"`
 	Metadata          map[string]interface{} `json:"metadata"`
+	Refunds           []RefundInfo           `json:"refunds"`
 }
 
 func (c *MercadoPagoClient) GetPayment(ctx context.Context, paymentID string) (*PaymentInfo, error) {
 	var resp PaymentInfo
-	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/v1/payments/%s", paymentID), nil, &resp)
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/v1/payments/%s", paymentID), "", nil, &resp)
 	if err != nil {
 		return nil, fmt.Errorf("get payment: %w", err)
 	}
 	return &resp, nil
 }
 
+// PaymentSearchResult is a page of GET /v1/payments/search.
+type PaymentSearchResult struct {
+	Paging  SearchPaging  `json:"paging"`
+	Results []PaymentInfo `json:"results"`
+}
+
+type SearchPaging struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// SearchPayments lists the payments created between begin and end (both
+// inclusive), oldest first.
+func (c *MercadoPagoClient) SearchPayments(ctx context.Context, begin, end time.Time, offset, limit int) (*PaymentSearchResult, error) {
+	q := url.Values{}
+	q.Set("range", "date_created")
+	q.Set("begin_date", begin.Format(mpTimeFormat))
+	q.Set("end_date", end.Format(mpTimeFormat))
+	q.Set("sort", "date_created")
+	q.Set("criteria", "asc")
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(limit))
+
+	var resp PaymentSearchResult
+	if err := c.doRequest(ctx, http.MethodGet, "/v1/payments/search?"+q.Encode(), "", nil, &resp); err != nil {
+		return nil, fmt.Errorf("search payments: %w", err)
+	}
+	return &resp, nil
+}
+
+// mpTimeFormat is the date format MP expects, e.g. in searches and
+// preference expirations.
+const mpTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// ============================================================
+// Refunds and chargebacks
+// ============================================================
+
+// RefundInfo is a full or partial refund of a payment. Amount is in the
+// payment currency.
+type RefundInfo struct {
+	ID        int64         `json:"id"`
+	PaymentID int64         `json:"payment_id"`
+	Amount    money.Decimal `json:"amount"`
+	Status    string        `json:"status"`
+}
+
+// RefundPayment refunds amount of a payment. Refunding the whole amount is a
+// total refund; anything less is partial and can be repeated.
+func (c *MercadoPagoClient) RefundPayment(ctx context.Context, paymentID string, amount money.Decimal, idempotencyKey string) (*RefundInfo, error) {
+	body := map[string]money.Decimal{"amount": amount}
+	var resp RefundInfo
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/v1/payments/%s/refunds", paymentID), idempotencyKey, body, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("refund payment: %w", err)
+	}
+	return &resp, nil
+}
+
+// ChargebackInfo is a dispute opened by the payer with their card issuer.
+// Payments lists the charges it reverses.
+type ChargebackInfo struct {
+	ID              string        `json:"id"`
+	Payments        []int64       `json:"payments"`
+	Amount          money.Decimal `json:"amount"`
+	CoverageApplied bool          `json:"coverage_applied"`
+}
+
+func (c *MercadoPagoClient) GetChargeback(ctx context.Context, chargebackID string) (*ChargebackInfo, error) {
+	var resp ChargebackInfo
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/v1/chargebacks/%s", chargebackID), "", nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("get chargeback: %w", err)
+	}
+	return &resp, nil
+}
+
+// ============================================================
+// OAuth (connected tenant accounts)
+// ============================================================
+
+// OAuthToken is the response of POST /oauth/token. Access tokens last
+// ExpiresIn seconds (180 days); the refresh token is replaced on each
+// refresh.
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	UserID       int64  `json:"user_id"`
+	PublicKey    string `json:"public_key"`
+	LiveMode     bool   `json:"live_mode"`
+}
+
+type oauthTokenRequest struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	GrantType    string `json:"grant_type"`
+	Code         string `json:"code,omitempty"`
+	RedirectURI  string `json:"redirect_uri,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ExchangeOAuthCode trades the code of the authorization redirect for the
+// tokens of the account that authorized the app.
+func (c *MercadoPagoClient) ExchangeOAuthCode(ctx context.Context, clientID, clientSecret, code, redirectURI string) (*OAuthToken, error) {
+	var resp OAuthToken
+	err := c.withToken("", 0).doRequest(ctx, http.MethodPost, "/oauth/token", "", &oauthTokenRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  redirectURI,
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("exchange oauth code: %w", err)
+	}
+	return &resp, nil
+}
+
+func (c *MercadoPagoClient) RefreshOAuthToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*OAuthToken, error) {
+	var resp OAuthToken
+	err := c.withToken("", 0).doRequest(ctx, http.MethodPost, "/oauth/token", "", &oauthTokenRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		GrantType:    "refresh_token",
+		RefreshToken: refreshToken,
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("refresh oauth token: %w", err)
+	}
+	return &resp, nil
+}
+
 // ============================================================
 // Preapproval Queries
 // ============================================================
 
+// PreapprovalInfo.Status is pending (not authorized by the payer yet),
+// authorized, paused or cancelled.
 type PreapprovalInfo struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Reason string `json:"reason"`
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	Reason            string `json:"reason"`
+	ExternalReference string `json:"external_reference"`
+	InitPoint         string `json:"init_point"` // where the payer manages it, e.g. to change the card
 }
 
 func (c *MercadoPagoClient) GetPreapproval(ctx context.Context, preapprovalID string) (*PreapprovalInfo, error) {
 	var resp PreapprovalInfo
-	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/preapproval/%s", preapprovalID), nil, &resp)
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/preapproval/%s", preapprovalID), "", nil, &resp)
 	if err != nil {
 		return nil, fmt.Errorf("get preapproval: %w", err)
 	}
 	return &resp, nil
 }
 
+// AuthorizedPaymentInfo is one recurring charge of a preapproval. Payment is
+// nil until MP has attempted the charge.
+type AuthorizedPaymentInfo struct {
+	ID                int64                   `json:"id"`
+	PreapprovalID     string                  `json:"preapproval_id"`
+	Status            string                  `json:"status"` // scheduled, processed, recycling, cancelled
+	ExternalReference string                  `json:"external_reference"`
+	Payment           *AuthorizedPaymentEntry `json:"payment"`
+}
+
+type AuthorizedPaymentEntry struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (c *MercadoPagoClient) GetAuthorizedPayment(ctx context.Context, authorizedPaymentID string) (*AuthorizedPaymentInfo, error) {
+	var resp AuthorizedPaymentInfo
+	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/authorized_payments/%s", authorizedPaymentID), "", nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("get authorized payment: %w", err)
+	}
+	return &resp, nil
+}
+
+// UpdatePreapprovalStatus pauses ("paused"), resumes ("authorized") or
+// cancels ("cancelled") a recurring subscription.
+func (c *MercadoPagoClient) UpdatePreapprovalStatus(ctx context.Context, preapprovalID, status string) error {
+	body := map[string]string{"status": status}
+	// No caller key: pausing again later must not be answered from MP's
+	// idempotency cache. doRequest still reuses one key across retries.
+	if err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/preapproval/%s", preapprovalID), "", body, nil); err != nil {
+		return fmt.Errorf("update preapproval status: %w", err)
+	}
+	return nil
+}
+
 // ============================================================
 // HTTP with retries + exponential backoff
 // ============================================================
@@ -201,9 +411,16 @@ type mpErrorResponse struct {
 	Status  int    `json:"status"`
 }
 
-func (c *MercadoPagoClient) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+// doRequest sends X-Idempotency-Key on writes. The same key is used by every
+// retry so a timeout that did reach MP cannot create a duplicate; without an
+// idempotencyKey from the caller a random one is generated per call.
+func (c *MercadoPagoClient) doRequest(ctx context.Context, method, path, idempotencyKey string, body interface{}, result interface{}) error {
 	var lastErr error
 
+	if idempotencyKey == "" && method != http.MethodGet {
+		idempotencyKey = uuid.NewString()
+	}
+
 	for attempt := 0; attempt <= c.maxRetries; attempt++ {
 		if attempt > 0 {
 			backoff := time.Duration(math.Pow(2, float64(attempt-1))) * 500 * time.Millisecond
@@ -230,9 +447,13 @@ func (c *MercadoPagoClient) doRequest(ctx context.Context, method, path string,
 			return fmt.Errorf("create request: %w", err)
 		}
 
-		req.Header.Set("Authorization", "Bearer "+c.token)
+		if c.token != "" { // the OAuth endpoints authenticate with the client secret
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-Idempotency-Key", fmt.Sprintf("%s-%s-%d", method, path, time.Now().UnixNano()))
+		if idempotencyKey != "" {
+			req.Header.Set("X-Idempotency-Key", idempotencyKey)
+		}
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
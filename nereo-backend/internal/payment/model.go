@@ -1,23 +1,93 @@
 package payment
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/pkg/money"
 )
 
 type PaymentEvent struct {
-	ID             uuid.UUID  `json:"id"`
-	TenantID       uuid.UUID  `json:"tenant_id"`
-	SubscriptionID *uuid.UUID `json:"subscription_id,omitempty"`
-	Source         string     `json:"source"`
-	MpPaymentID    *string    `json:"mp_payment_id,omitempty"`
-	Status         string     `json:"status"`
-	AmountCents    int        `json:"amount_cents"`
-	Notes          *string    `json:"notes,omitempty"`
-	RecordedBy     *uuid.UUID `json:"recorded_by,omitempty"`
-	RawPayload     []byte     `json:"-"`
-	ProcessedAt    time.Time  `json:"processed_at"`
+	ID             uuid.UUID      `json:"id"`
+	TenantID       uuid.UUID      `json:"tenant_id"`
+	SubscriptionID *uuid.UUID     `json:"subscription_id,omitempty"`
+	Source         string         `json:"source"`
+	EventType      string         `json:"event_type"`
+	ParentEventID  *uuid.UUID     `json:"parent_event_id,omitempty"` // the charge a refund or chargeback reverses
+	MpPaymentID    *string        `json:"mp_payment_id,omitempty"`   // charges only
+	MpRefundID     *string        `json:"mp_refund_id,omitempty"`
+	MpChargebackID *string        `json:"mp_chargeback_id,omitempty"`
+	Status         string         `json:"status"`
+	AmountCents    int            `json:"amount_cents"`
+	Currency       money.Currency `json:"currency"` // the plan's; reversals inherit the charge's
+	Notes          *string        `json:"notes,omitempty"`
+	RecordedBy     *uuid.UUID     `json:"recorded_by,omitempty"`
+	RawPayload     []byte         `json:"-"`
+	ProcessedAt    time.Time      `json:"processed_at"`
+}
+
+// Payment event types. Refunds and chargebacks point to their charge through
+// ParentEventID.
+const (
+	EventCharge     = "charge"
+	EventRefund     = "refund"
+	EventChargeback = "chargeback"
+)
+
+// Statuses a charge moves to once reversed. Until then it keeps the status
+// MP reported (approved, rejected...).
+const (
+	ChargePartiallyRefunded = "partially_refunded"
+	ChargeRefunded          = "refunded"
+	ChargeChargedBack       = "charged_back"
+)
+
+// PaymentListItem is a payment event with the customer it was for.
+type PaymentListItem struct {
+	PaymentEvent
+	CustomerID   *uuid.UUID `json:"customer_id,omitempty"`
+	CustomerName *string    `json:"customer_name,omitempty"`
+}
+
+// PaymentDetail adds what support needs to look into a payment: the payload
+// MP sent and, for a charge, its refunds and chargebacks.
+type PaymentDetail struct {
+	PaymentListItem
+	Payload   json.RawMessage `json:"payload"`
+	Reversals []PaymentEvent  `json:"reversals"`
+}
+
+// PaymentTotal sums the events of one type, status and currency matched by
+// a list.
+type PaymentTotal struct {
+	EventType   string         `json:"event_type"`
+	Status      string         `json:"status"`
+	Currency    money.Currency `json:"currency"`
+	Count       int64          `json:"count"`
+	AmountCents int64          `json:"amount_cents"`
+}
+
+// PaymentList is a page of payments and the totals of every payment matching
+// the filter, not only the page.
+type PaymentList struct {
+	Payments []PaymentListItem `json:"payments"`
+	Totals   []PaymentTotal    `json:"totals"`
+}
+
+// PaymentFilter narrows ListPayments; zero values do not filter. From and To
+// are dates (YYYY-MM-DD) in the tenant's timezone, both inclusive.
+type PaymentFilter struct {
+	From           string
+	To             string
+	Source         string
+	EventType      string
+	Status         string
+	SubscriptionID *uuid.UUID
+	CustomerID     *uuid.UUID
+	RecordedBy     *uuid.UUID
+	Page           int
+	PerPage        int
 }
 
 // Request types
@@ -29,9 +99,10 @@ type CreatePreferenceRequest struct {
 }
 
 type CreatePreferenceResponse struct {
-	PreferenceID     string `json:"preference_id"`
-	InitPoint        string `json:"init_point"`
-	SandboxInitPoint string `json:"sandbox_init_point"`
+	SubscriptionID   uuid.UUID `json:"subscription_id"` // pending_payment until the payment is approved
+	PreferenceID     string    `json:"preference_id"`
+	InitPoint        string    `json:"init_point"`
+	SandboxInitPoint string    `json:"sandbox_init_point"`
 }
 
 type CreateSubscriptionMPRequest struct {
@@ -41,15 +112,19 @@ type CreateSubscriptionMPRequest struct {
 }
 
 type CreateSubscriptionMPResponse struct {
-	PreapprovalID    string `json:"preapproval_id"`
-	InitPoint        string `json:"init_point"`
-	SandboxInitPoint string `json:"sandbox_init_point"`
-	Status           string `json:"status"`
+	SubscriptionID   uuid.UUID `json:"subscription_id"` // pending until the preapproval is authorized
+	PreapprovalID    string    `json:"preapproval_id"`
+	InitPoint        string    `json:"init_point"`
+	SandboxInitPoint string    `json:"sandbox_init_point"`
+	Status           string    `json:"status"`
 }
 
+// ManualPaymentRequest records a payment in the plan's currency. Currency,
+// when given, must be the plan's.
 type ManualPaymentRequest struct {
 	SubscriptionID uuid.UUID `json:"subscription_id" binding:"required"`
 	AmountCents    int       `json:"amount_cents" binding:"required,gt=0"`
+	Currency       string    `json:"currency" binding:"omitempty,len=3"`
 	Notes          string    `json:"notes"`
 }
 
@@ -57,6 +132,13 @@ type RenewManualRequest struct {
 	Notes string `json:"notes"`
 }
 
+// RefundRequest refunds AmountCents of a charge, or all that is left of it
+// when omitted.
+type RefundRequest struct {
+	AmountCents int    `json:"amount_cents" binding:"omitempty,gt=0"`
+	Reason      string `json:"reason"`
+}
+
 type WebhookBody struct {
 	Action string      `json:"action"`
 	Type   string      `json:"type"`
@@ -66,3 +148,199 @@ type WebhookBody struct {
 type WebhookData struct {
 	ID string `json:"id"`
 }
+
+// Webhook inbox statuses.
+const (
+	WebhookPending    = "pending"
+	WebhookProcessing = "processing"
+	WebhookProcessed  = "processed"
+	WebhookFailed     = "failed" // waiting for a retry at next_attempt_at
+	WebhookDead       = "dead"   // gave up; only an admin replay brings it back
+)
+
+// WebhookDelivery is a provider notification stored in webhook_inbox before
+// it was acknowledged.
+type WebhookDelivery struct {
+	ID            uuid.UUID       `json:"id"`
+	Provider      string          `json:"provider"`
+	Topic         string          `json:"topic"`
+	Action        *string         `json:"action,omitempty"`
+	ResourceID    string          `json:"resource_id"`
+	RequestID     *string         `json:"request_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LockedAt      *time.Time      `json:"locked_at,omitempty"`
+	LastError     *string         `json:"last_error,omitempty"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	ProcessedAt   *time.Time      `json:"processed_at,omitempty"`
+}
+
+// Reconciliation issue kinds.
+const (
+	IssueMissingEvent   = "missing_event" // inserted by the reconciliation, resolved at once
+	IssueAmountMismatch = "amount_mismatch"
+	IssueStatusMismatch = "status_mismatch"
+)
+
+// ReconciliationIssue is a difference found between an MP payment and our
+// charge. The recorded_* fields are what payment_events has.
+type ReconciliationIssue struct {
+	ID                  uuid.UUID  `json:"id"`
+	TenantID            uuid.UUID  `json:"tenant_id"`
+	Kind                string     `json:"kind"`
+	MpPaymentID         string     `json:"mp_payment_id"`
+	PaymentEventID      *uuid.UUID `json:"payment_event_id,omitempty"`
+	PaymentDate         string     `json:"payment_date"` // YYYY-MM-DD
+	MpStatus            string     `json:"mp_status"`
+	RecordedStatus      *string    `json:"recorded_status,omitempty"`
+	MpAmountCents       int        `json:"mp_amount_cents"`
+	RecordedAmountCents *int       `json:"recorded_amount_cents,omitempty"`
+	DetectedAt          time.Time  `json:"detected_at"`
+	ResolvedAt          *time.Time `json:"resolved_at,omitempty"`
+}
+
+// IssueFilter narrows ListReconciliationIssues; zero values do not filter.
+// Status is open or resolved; From and To bound the payment date.
+type IssueFilter struct {
+	Kind    string
+	Status  string
+	From    string
+	To      string
+	Page    int
+	PerPage int
+}
+
+// ReconciliationSummary counts what a reconciliation of one day did.
+type ReconciliationSummary struct {
+	Date       string `json:"date"`
+	Payments   int    `json:"payments"`   // MP payments of the day
+	Inserted   int    `json:"inserted"`   // charges that were missing
+	Mismatches int    `json:"mismatches"` // amount or status issues flagged
+	Skipped    int    `json:"skipped"`    // not from a nereo checkout, or for another tenant
+}
+
+type ReconcileRequest struct {
+	Date string `json:"date" binding:"required"` // YYYY-MM-DD
+}
+
+// MPRequest is a checkout creation sent with an Idempotency-Key header.
+// Response is the body returned the first time.
+type MPRequest struct {
+	ID               uuid.UUID
+	TenantID         uuid.UUID
+	Operation        string
+	IdempotencyKey   string
+	RequestHash      string
+	MPIdempotencyKey *string
+	SubscriptionID   *uuid.UUID
+	MPID             *string
+	Response         json.RawMessage
+	CreatedAt        time.Time
+}
+
+// MPAccount is the Mercado Pago account a tenant connected through OAuth.
+// Its customers pay into it; the tokens are stored sealed.
+type MPAccount struct {
+	TenantID        uuid.UUID  `json:"tenant_id"`
+	MpUserID        int64      `json:"mp_user_id"`
+	PublicKey       *string    `json:"public_key,omitempty"`
+	LiveMode        bool       `json:"live_mode"`
+	Scope           *string    `json:"scope,omitempty"`
+	AccessTokenEnc  []byte     `json:"-"`
+	RefreshTokenEnc []byte     `json:"-"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	ConnectedAt     time.Time  `json:"connected_at"`
+	RefreshedAt     *time.Time `json:"refreshed_at,omitempty"`
+}
+
+// MPAccountStatus tells the owner where the tenant's payments go. Without
+// a connected account they go to the platform account, when there is one.
+type MPAccountStatus struct {
+	Connected bool       `json:"connected"`
+	Account   *MPAccount `json:"account,omitempty"`
+}
+
+type MPConnectResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// DunningPolicy is how a tenant handles past_due subscriptions: how long
+// they get before being cancelled, whether they can still wash meanwhile and
+// on which days the customer is reminded to pay.
+type DunningPolicy struct {
+	TenantID           uuid.UUID  `json:"tenant_id"`
+	GraceDays          int        `json:"grace_days"`
+	AllowWashesPastDue bool       `json:"allow_washes_past_due"`
+	ReminderDays       []int      `json:"reminder_days"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"` // nil while on the defaults
+}
+
+type UpdateDunningPolicyRequest struct {
+	GraceDays          int   `json:"grace_days" binding:"required,min=1,max=60"`
+	AllowWashesPastDue bool  `json:"allow_washes_past_due"`
+	ReminderDays       []int `json:"reminder_days" binding:"max=10,dive,min=1"`
+}
+
+// Dunning notification kinds.
+const (
+	DunningPaymentFailed = "payment_failed" // as soon as the subscription is past_due
+	DunningReminder      = "reminder"
+	DunningCancelled     = "cancelled" // the grace period ran out
+)
+
+// PastDueEpisode is a subscription that is past_due now, from the status
+// change that made it past_due.
+type PastDueEpisode struct {
+	ChangeID         uuid.UUID
+	TenantID         uuid.UUID
+	SubscriptionID   uuid.UUID
+	CustomerID       uuid.UUID
+	PlanID           uuid.UUID
+	PlanName         string
+	TenantName       string
+	AmountCents      int // price of the plan
+	Currency         money.Currency
+	MpSubscriptionID *string
+	Since            time.Time
+}
+
+// DunningNotification is published on the tenant's subscription:past_due
+// channel for the notification workers to deliver.
+type DunningNotification struct {
+	ID             uuid.UUID      `json:"id"`
+	TenantID       uuid.UUID      `json:"tenant_id"`
+	SubscriptionID uuid.UUID      `json:"subscription_id"`
+	CustomerID     uuid.UUID      `json:"customer_id"`
+	Kind           string         `json:"kind"`
+	Day            int            `json:"day"`
+	AmountCents    int            `json:"amount_cents"`
+	Currency       money.Currency `json:"currency"`
+	CheckoutURL    *string        `json:"checkout_url,omitempty"`
+	SentAt         time.Time      `json:"sent_at"`
+}
+
+type RecoveryLinkResponse struct {
+	SubscriptionID uuid.UUID `json:"subscription_id"`
+	CheckoutURL    string    `json:"checkout_url"`
+}
+
+// DunningOutcome adds up the past_due episodes that ended the same way.
+// AmountCents is the plan price of each subscription.
+type DunningOutcome struct {
+	Count       int `json:"count"`
+	AmountCents int `json:"amount_cents"`
+}
+
+// DunningReport covers the episodes that started between From and To:
+// recovered ones were paid, lost ones cancelled and open ones are still
+// past_due.
+type DunningReport struct {
+	From         string         `json:"from"`
+	To           string         `json:"to"`
+	Recovered    DunningOutcome `json:"recovered"`
+	Lost         DunningOutcome `json:"lost"`
+	Open         DunningOutcome `json:"open"`
+	RecoveryRate float64        `json:"recovery_rate"` // recovered / (recovered + lost), 0 when none ended
+}
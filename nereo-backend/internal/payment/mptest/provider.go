@@ -0,0 +1,505 @@
+// Package mptest provides a Mercado Pago stand-in for tests and offline
+// development: Provider keeps MP's state in memory and implements
+// payment.PaymentProvider, and Server exposes it over MP's REST API so the
+// real payment.MercadoPagoClient can be pointed at it.
+package mptest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/payment"
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+var (
+	ErrNotFound       = errors.New("mptest: resource not found")
+	ErrInvalidRequest = errors.New("mptest: invalid request")
+)
+
+const initPointBase = "https://mptest.local/checkout/"
+
+// Provider is an in-memory Mercado Pago account. What the payer does on
+// MP's pages (paying a preference, authorizing a preapproval) and what MP
+// does on its own (recurring charges, chargebacks) are methods tests call;
+// Notification builds the webhook MP would send afterwards.
+type Provider struct {
+	// WebhookSecret signs notifications; empty accepts any signature, like
+	// payment.VerifyWebhookSignature.
+	WebhookSecret string
+	// UserID is the seller the account belongs to, sent as user_id in
+	// notifications; 0 leaves it out, as for the platform account.
+	UserID int64
+	// Now is MP's clock, time.Now when nil. Tests move it forward to act
+	// after an expiration.
+	Now func() time.Time
+
+	mu           sync.Mutex
+	nextID       int64
+	preferences  map[string]*preference
+	preapprovals map[string]*preapproval
+	payments     map[int]*storedPayment
+	authorized   map[int64]*payment.AuthorizedPaymentInfo
+	chargebacks  map[string]*payment.ChargebackInfo
+	idempotent   map[string]any // X-Idempotency-Key -> first response
+}
+
+type preference struct {
+	req  payment.PreferenceRequest
+	resp payment.PreferenceResponse
+}
+
+type preapproval struct {
+	info   payment.PreapprovalInfo
+	amount money.Amount
+}
+
+type storedPayment struct {
+	info    payment.PaymentInfo
+	created time.Time
+}
+
+var _ payment.PaymentProvider = (*Provider)(nil)
+
+func NewProvider(webhookSecret string) *Provider {
+	return &Provider{
+		WebhookSecret: webhookSecret,
+		nextID:        1000,
+		preferences:   make(map[string]*preference),
+		preapprovals:  make(map[string]*preapproval),
+		payments:      make(map[int]*storedPayment),
+		authorized:    make(map[int64]*payment.AuthorizedPaymentInfo),
+		chargebacks:   make(map[string]*payment.ChargebackInfo),
+		idempotent:    make(map[string]any),
+	}
+}
+
+// id returns the next resource ID. Callers hold p.mu.
+func (p *Provider) id() int64 {
+	p.nextID++
+	return p.nextID
+}
+
+func (p *Provider) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// ============================================================
+// payment.PaymentProvider
+// ============================================================
+
+func (p *Provider) CreatePreference(ctx context.Context, req *payment.PreferenceRequest, idempotencyKey string) (*payment.PreferenceResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if resp, ok := p.idempotent["preference:"+idempotencyKey].(*payment.PreferenceResponse); ok && idempotencyKey != "" {
+		return resp, nil
+	}
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("%w: preference without items", ErrInvalidRequest)
+	}
+
+	id := fmt.Sprintf("pref-%d", p.id())
+	pref := &preference{
+		req: *req,
+		resp: payment.PreferenceResponse{
+			ID:               id,
+			InitPoint:        initPointBase + id,
+			SandboxInitPoint: initPointBase + "sandbox/" + id,
+		},
+	}
+	p.preferences[id] = pref
+	if idempotencyKey != "" {
+		p.idempotent["preference:"+idempotencyKey] = &pref.resp
+	}
+	return &pref.resp, nil
+}
+
+func (p *Provider) CreatePreapproval(ctx context.Context, req *payment.PreapprovalRequest, idempotencyKey string) (*payment.PreapprovalResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if resp, ok := p.idempotent["preapproval:"+idempotencyKey].(*payment.PreapprovalResponse); ok && idempotencyKey != "" {
+		return resp, nil
+	}
+	if req.AutoRecurring.TransactionAmount <= 0 {
+		return nil, fmt.Errorf("%w: preapproval without amount", ErrInvalidRequest)
+	}
+
+	id := fmt.Sprintf("pre-%d", p.id())
+	p.preapprovals[id] = &preapproval{
+		info: payment.PreapprovalInfo{
+			ID:                id,
+			Status:            "pending",
+			Reason:            req.Reason,
+			ExternalReference: req.ExternalReference,
+			InitPoint:         initPointBase + id,
+		},
+		amount: money.New(req.AutoRecurring.TransactionAmount.Cents(), req.AutoRecurring.CurrencyID),
+	}
+	resp := &payment.PreapprovalResponse{
+		ID:               id,
+		InitPoint:        initPointBase + id,
+		SandboxInitPoint: initPointBase + "sandbox/" + id,
+		Status:           "pending",
+	}
+	if idempotencyKey != "" {
+		p.idempotent["preapproval:"+idempotencyKey] = resp
+	}
+	return resp, nil
+}
+
+func (p *Provider) UpdatePreapprovalStatus(ctx context.Context, preapprovalID, status string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pre, ok := p.preapprovals[preapprovalID]
+	if !ok {
+		return ErrNotFound
+	}
+	switch status {
+	case "paused", "authorized", "cancelled":
+	default:
+		return fmt.Errorf("%w: preapproval status %q", ErrInvalidRequest, status)
+	}
+	if pre.info.Status == "cancelled" {
+		return fmt.Errorf("%w: preapproval is cancelled", ErrInvalidRequest)
+	}
+	pre.info.Status = status
+	return nil
+}
+
+func (p *Provider) GetPayment(ctx context.Context, paymentID string) (*payment.PaymentInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, err := strconv.Atoi(paymentID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	stored, ok := p.payments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPayment(&stored.info), nil
+}
+
+func (p *Provider) GetPreapproval(ctx context.Context, preapprovalID string) (*payment.PreapprovalInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pre, ok := p.preapprovals[preapprovalID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := pre.info
+	return &info, nil
+}
+
+func (p *Provider) GetAuthorizedPayment(ctx context.Context, authorizedPaymentID string) (*payment.AuthorizedPaymentInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, err := strconv.ParseInt(authorizedPaymentID, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	ap, ok := p.authorized[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := *ap
+	return &info, nil
+}
+
+func (p *Provider) GetChargeback(ctx context.Context, chargebackID string) (*payment.ChargebackInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cb, ok := p.chargebacks[chargebackID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := *cb
+	return &info, nil
+}
+
+// RefundPayment refunds an approved payment; the payment turns refunded
+// once nothing is left, as in MP.
+func (p *Provider) RefundPayment(ctx context.Context, paymentID string, amount money.Decimal, idempotencyKey string) (*payment.RefundInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if refund, ok := p.idempotent["refund:"+idempotencyKey].(*payment.RefundInfo); ok && idempotencyKey != "" {
+		return refund, nil
+	}
+	id, err := strconv.Atoi(paymentID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	stored, ok := p.payments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := &stored.info
+	if info.Status != "approved" {
+		return nil, fmt.Errorf("%w: payment is %s", ErrInvalidRequest, info.Status)
+	}
+
+	left := info.TransactionAmount
+	for _, r := range info.Refunds {
+		left -= r.Amount
+	}
+	if amount == 0 {
+		amount = left
+	}
+	if amount <= 0 || amount > left {
+		return nil, fmt.Errorf("%w: refund exceeds the available amount", ErrInvalidRequest)
+	}
+
+	refund := payment.RefundInfo{ID: p.id(), PaymentID: int64(id), Amount: amount, Status: "approved"}
+	info.Refunds = append(info.Refunds, refund)
+	if amount == left {
+		info.Status = "refunded"
+	}
+	if idempotencyKey != "" {
+		p.idempotent["refund:"+idempotencyKey] = &refund
+	}
+	return &refund, nil
+}
+
+// SearchPayments pages through the payments created between begin and end,
+// oldest first.
+func (p *Provider) SearchPayments(ctx context.Context, begin, end time.Time, offset, limit int) (*payment.PaymentSearchResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var matches []*storedPayment
+	for _, stored := range p.payments {
+		if !stored.created.Before(begin) && !stored.created.After(end) {
+			matches = append(matches, stored)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].info.ID < matches[j].info.ID })
+
+	result := &payment.PaymentSearchResult{
+		Paging:  payment.SearchPaging{Total: len(matches), Limit: limit, Offset: offset},
+		Results: []payment.PaymentInfo{},
+	}
+	for i := offset; i < len(matches) && i < offset+limit; i++ {
+		result.Results = append(result.Results, *copyPayment(&matches[i].info))
+	}
+	return result, nil
+}
+
+func (p *Provider) VerifyWebhook(xSignature, xRequestID, dataID string) error {
+	return payment.VerifyWebhookSignature(xSignature, xRequestID, dataID, p.WebhookSecret)
+}
+
+// Objects returns how many preferences and preapprovals were created, to
+// check that retries did not create duplicates.
+func (p *Provider) Objects() (preferences, preapprovals int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.preferences), len(p.preapprovals)
+}
+
+// ============================================================
+// Payer and MP actions
+// ============================================================
+
+// PayPreference is the payer completing a Checkout Pro preference. The
+// payment has the preference's total and external_reference. Expired
+// preferences cannot be paid.
+func (p *Provider) PayPreference(preferenceID, status string) (*payment.PaymentInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pref, ok := p.preferences[preferenceID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if pref.req.Expires {
+		expiration, err := time.Parse(time.RFC3339, pref.req.ExpirationDateTo)
+		if err == nil && p.now().After(expiration) {
+			return nil, fmt.Errorf("%w: preference expired", ErrInvalidRequest)
+		}
+	}
+	var total money.Amount
+	for _, item := range pref.req.Items {
+		total.Cents += item.UnitPrice.Cents() * item.Quantity
+		total.Currency = item.CurrencyID
+	}
+	metadata := make(map[string]interface{}, len(pref.req.Metadata))
+	for k, v := range pref.req.Metadata {
+		metadata[k] = v
+	}
+	return p.addPayment(total, pref.req.ExternalReference, status, metadata), nil
+}
+
+// AuthorizePreapproval is the payer accepting a recurring subscription.
+func (p *Provider) AuthorizePreapproval(preapprovalID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pre, ok := p.preapprovals[preapprovalID]
+	if !ok {
+		return ErrNotFound
+	}
+	if pre.info.Status != "pending" {
+		return fmt.Errorf("%w: preapproval is %s", ErrInvalidRequest, pre.info.Status)
+	}
+	pre.info.Status = "authorized"
+	return nil
+}
+
+// ChargePreapproval is MP charging a period of an authorized preapproval.
+// The returned authorized payment carries the charge.
+func (p *Provider) ChargePreapproval(preapprovalID, status string) (*payment.AuthorizedPaymentInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pre, ok := p.preapprovals[preapprovalID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if pre.info.Status != "authorized" {
+		return nil, fmt.Errorf("%w: preapproval is %s", ErrInvalidRequest, pre.info.Status)
+	}
+
+	charge := p.addPayment(pre.amount, pre.info.ExternalReference, status, nil)
+	ap := &payment.AuthorizedPaymentInfo{
+		ID:                p.id(),
+		PreapprovalID:     preapprovalID,
+		Status:            "processed",
+		ExternalReference: pre.info.ExternalReference,
+		Payment:           &payment.AuthorizedPaymentEntry{ID: int64(charge.ID), Status: charge.Status},
+	}
+	p.authorized[ap.ID] = ap
+	info := *ap
+	return &info, nil
+}
+
+// SetPaymentStatus moves a payment, e.g. a pending one to approved.
+func (p *Provider) SetPaymentStatus(paymentID int, status string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, ok := p.payments[paymentID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.info.Status = status
+	return nil
+}
+
+// OpenChargeback is the payer disputing a payment with the card issuer.
+// covered means MP's protection pays it and the seller keeps the money.
+func (p *Provider) OpenChargeback(paymentID int, covered bool) (*payment.ChargebackInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, ok := p.payments[paymentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	stored.info.Status = "charged_back"
+
+	cb := &payment.ChargebackInfo{
+		ID:              strconv.FormatInt(p.id(), 10),
+		Payments:        []int64{int64(paymentID)},
+		Amount:          stored.info.TransactionAmount,
+		CoverageApplied: covered,
+	}
+	p.chargebacks[cb.ID] = cb
+	info := *cb
+	return &info, nil
+}
+
+// addPayment stores a new payment. Callers hold p.mu.
+func (p *Provider) addPayment(amount money.Amount, externalReference, status string, metadata map[string]interface{}) *payment.PaymentInfo {
+	stored := &storedPayment{
+		info: payment.PaymentInfo{
+			ID:                int(p.id()),
+			Status:            status,
+			TransactionAmount: amount.Decimal(),
+			CurrencyID:        amount.Currency,
+			ExternalReference: externalReference,
+			Metadata:          metadata,
+		},
+		created: p.now(),
+	}
+	p.payments[stored.info.ID] = stored
+	return copyPayment(&stored.info)
+}
+
+// ============================================================
+// Webhooks
+// ============================================================
+
+// Notification is a webhook as MP sends it: the query it appends to the
+// notification URL, the JSON body and the signed headers.
+type Notification struct {
+	Query   url.Values
+	Body    []byte
+	Headers map[string]string
+}
+
+// Path is the notification URL path with MP's query appended.
+func (n *Notification) Path(path string) string {
+	return path + "?" + n.Query.Encode()
+}
+
+// Notify builds the notification MP sends when the resource of topic
+// changes, signed with the provider's webhook secret.
+func (p *Provider) Notify(topic, resourceID string) *Notification {
+	requestID := uuid.NewString()
+	payload := map[string]any{
+		"action":       topic + ".updated",
+		"api_version":  "v1",
+		"type":         topic,
+		"data":         map[string]string{"id": resourceID},
+		"date_created": time.Now().UTC().Format(time.RFC3339),
+		"live_mode":    false,
+	}
+	if p.UserID != 0 {
+		payload["user_id"] = p.UserID
+	}
+	body, _ := json.Marshal(payload)
+	return &Notification{
+		Query: url.Values{"type": {topic}, "data.id": {resourceID}},
+		Body:  body,
+		Headers: map[string]string{
+			"x-request-id": requestID,
+			"x-signature":  Sign(p.WebhookSecret, resourceID, requestID, time.Now().Unix()),
+		},
+	}
+}
+
+// Sign builds the x-signature header of a notification as MP does.
+func Sign(secret, dataID, requestID string, ts int64) string {
+	manifest := fmt.Sprintf("id:%s;request-id:%s;ts:%d;", dataID, requestID, ts)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(manifest))
+	return fmt.Sprintf("ts=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func copyPayment(info *payment.PaymentInfo) *payment.PaymentInfo {
+	c := *info
+	c.Refunds = append([]payment.RefundInfo(nil), info.Refunds...)
+	return &c
+}
@@ -0,0 +1,258 @@
+package mptest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nereo-ar/backend/internal/payment"
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+// searchTimeFormat is the date format of GET /v1/payments/search.
+const searchTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Server serves a Provider over the subset of Mercado Pago's REST API that
+// payment.MercadoPagoClient uses. Point MP_BASE_URL (config.BaseURL) at
+// URL. Every endpoint but /oauth/token requires a bearer token.
+type Server struct {
+	*httptest.Server
+	Provider *Provider
+
+	mu     sync.Mutex
+	tokens []string // bearer token of each authenticated request, in order
+	lose   bool     // answer creations with a timeout after applying them
+}
+
+func NewServer(p *Provider) *Server {
+	s := &Server{Provider: p}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /checkout/preferences", s.authenticated(s.createPreference))
+	mux.HandleFunc("POST /preapproval", s.authenticated(s.createPreapproval))
+	mux.HandleFunc("GET /preapproval/{id}", s.authenticated(s.getPreapproval))
+	mux.HandleFunc("PUT /preapproval/{id}", s.authenticated(s.updatePreapproval))
+	mux.HandleFunc("GET /authorized_payments/{id}", s.authenticated(s.getAuthorizedPayment))
+	mux.HandleFunc("GET /v1/payments/search", s.authenticated(s.searchPayments))
+	mux.HandleFunc("GET /v1/payments/{id}", s.authenticated(s.getPayment))
+	mux.HandleFunc("POST /v1/payments/{id}/refunds", s.authenticated(s.refundPayment))
+	mux.HandleFunc("GET /v1/chargebacks/{id}", s.authenticated(s.getChargeback))
+	mux.HandleFunc("POST /oauth/token", s.oauthToken)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Tokens returns the bearer tokens the server was called with, to tell
+// which account a request acted on.
+func (s *Server) Tokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+// LoseResponses makes preference and preapproval creations time out after
+// the object is created, as when MP's response never arrives, until it is
+// called again with false.
+func (s *Server) LoseResponses(lose bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lose = lose
+}
+
+// responseLost reports whether the response to a creation is lost, writing
+// the timeout in its place.
+func (s *Server) responseLost(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lose {
+		writeError(w, http.StatusGatewayTimeout, "timeout", "upstream request timeout")
+	}
+	return s.lose
+}
+
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid access token")
+			return
+		}
+		s.mu.Lock()
+		s.tokens = append(s.tokens, token)
+		s.mu.Unlock()
+		next(w, r)
+	}
+}
+
+func (s *Server) createPreference(w http.ResponseWriter, r *http.Request) {
+	var req payment.PreferenceRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	resp, err := s.Provider.CreatePreference(r.Context(), &req, r.Header.Get("X-Idempotency-Key"))
+	if err == nil && s.responseLost(w) {
+		return
+	}
+	respond(w, http.StatusCreated, resp, err)
+}
+
+func (s *Server) createPreapproval(w http.ResponseWriter, r *http.Request) {
+	var req payment.PreapprovalRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	resp, err := s.Provider.CreatePreapproval(r.Context(), &req, r.Header.Get("X-Idempotency-Key"))
+	if err == nil && s.responseLost(w) {
+		return
+	}
+	respond(w, http.StatusCreated, resp, err)
+}
+
+func (s *Server) getPreapproval(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Provider.GetPreapproval(r.Context(), r.PathValue("id"))
+	respond(w, http.StatusOK, resp, err)
+}
+
+func (s *Server) updatePreapproval(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := s.Provider.UpdatePreapprovalStatus(r.Context(), r.PathValue("id"), req.Status); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	resp, err := s.Provider.GetPreapproval(r.Context(), r.PathValue("id"))
+	respond(w, http.StatusOK, resp, err)
+}
+
+func (s *Server) getAuthorizedPayment(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Provider.GetAuthorizedPayment(r.Context(), r.PathValue("id"))
+	respond(w, http.StatusOK, resp, err)
+}
+
+func (s *Server) getPayment(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Provider.GetPayment(r.Context(), r.PathValue("id"))
+	respond(w, http.StatusOK, resp, err)
+}
+
+func (s *Server) searchPayments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	begin, err := time.Parse(searchTimeFormat, q.Get("begin_date"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid begin_date")
+		return
+	}
+	end, err := time.Parse(searchTimeFormat, q.Get("end_date"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid end_date")
+		return
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 30 // MP's default page size
+	}
+
+	resp, err := s.Provider.SearchPayments(r.Context(), begin, end, offset, limit)
+	respond(w, http.StatusOK, resp, err)
+}
+
+func (s *Server) refundPayment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount money.Decimal `json:"amount"`
+	}
+	if r.ContentLength != 0 && !decode(w, r, &req) {
+		return
+	}
+	resp, err := s.Provider.RefundPayment(r.Context(), r.PathValue("id"), req.Amount, r.Header.Get("X-Idempotency-Key"))
+	respond(w, http.StatusCreated, resp, err)
+}
+
+func (s *Server) getChargeback(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Provider.GetChargeback(r.Context(), r.PathValue("id"))
+	respond(w, http.StatusOK, resp, err)
+}
+
+// oauthToken accepts any authorization code or refresh token and issues a
+// new token pair for a seller whose user_id is derived from it, so the same
+// code always connects the same account.
+func (s *Server) oauthToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		GrantType    string `json:"grant_type"`
+		Code         string `json:"code"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.ClientID == "" || req.ClientSecret == "" {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "missing client credentials")
+		return
+	}
+
+	var seller string
+	switch req.GrantType {
+	case "authorization_code":
+		seller = req.Code
+	case "refresh_token":
+		seller, _ = strings.CutPrefix(req.RefreshToken, "TG-")
+		seller, _, _ = strings.Cut(seller, "-")
+	}
+	userID, err := strconv.ParseInt(seller, 10, 64)
+	if err != nil || userID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "invalid code or refresh token")
+		return
+	}
+
+	now := time.Now().UnixNano()
+	writeJSON(w, http.StatusOK, &payment.OAuthToken{
+		AccessToken:  fmt.Sprintf("APP_USR-%d-%d", userID, now),
+		RefreshToken: fmt.Sprintf("TG-%d-%d", userID, now),
+		ExpiresIn:    int64((180 * 24 * time.Hour).Seconds()),
+		Scope:        "offline_access read write",
+		UserID:       userID,
+		PublicKey:    fmt.Sprintf("APP_USR-public-%d", userID),
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return false
+	}
+	return true
+}
+
+// respond writes resp, or the MP error body for err.
+func respond(w http.ResponseWriter, status int, resp any, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, status, resp)
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{"message": message, "error": code, "status": status})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
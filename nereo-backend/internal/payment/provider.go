@@ -0,0 +1,33 @@
+package payment
+
+import (
+	"context"
+	"time"
+
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+// PaymentProvider is what the payment module needs from the payment
+// processor. *MercadoPagoClient is the real one; mptest.Provider is an
+// in-memory stand-in for tests and offline development.
+type PaymentProvider interface {
+	// Checkout
+	CreatePreference(ctx context.Context, req *PreferenceRequest, idempotencyKey string) (*PreferenceResponse, error)
+	CreatePreapproval(ctx context.Context, req *PreapprovalRequest, idempotencyKey string) (*PreapprovalResponse, error)
+	UpdatePreapprovalStatus(ctx context.Context, preapprovalID, status string) error
+
+	// Resources referenced by webhooks
+	GetPayment(ctx context.Context, paymentID string) (*PaymentInfo, error)
+	GetPreapproval(ctx context.Context, preapprovalID string) (*PreapprovalInfo, error)
+	GetAuthorizedPayment(ctx context.Context, authorizedPaymentID string) (*AuthorizedPaymentInfo, error)
+	GetChargeback(ctx context.Context, chargebackID string) (*ChargebackInfo, error)
+
+	RefundPayment(ctx context.Context, paymentID string, amount money.Decimal, idempotencyKey string) (*RefundInfo, error)
+	SearchPayments(ctx context.Context, begin, end time.Time, offset, limit int) (*PaymentSearchResult, error)
+
+	// VerifyWebhook checks the signature of a notification before it is
+	// stored.
+	VerifyWebhook(xSignature, xRequestID, dataID string) error
+}
+
+var _ PaymentProvider = (*MercadoPagoClient)(nil)
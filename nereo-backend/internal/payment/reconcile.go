@@ -0,0 +1,300 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+var ErrPaymentSearch = errors.New("mercado pago payment search failed")
+
+const (
+	reconcileHour     = 4 // local time in reconcileTimezone, after the day closed
+	reconcileTimezone = "America/Argentina/Buenos_Aires"
+	reconcilePageSize = 100
+	reconcileTimeout  = 30 * time.Minute
+)
+
+// Reconciler compares the payments Mercado Pago has for a day with our
+// charges: it inserts the ones a lost webhook never recorded and flags
+// amount or status differences in reconciliation_issues.
+type Reconciler struct {
+	processor *WebhookProcessor
+}
+
+func NewReconciler(processor *WebhookProcessor) *Reconciler {
+	return &Reconciler{processor: processor}
+}
+
+// StartReconciliationCron reconciles the previous day every night at
+// reconcileHour. Running it on several instances is harmless: issues are
+// upserted and charges are unique per MP payment.
+func StartReconciliationCron(r *Reconciler) {
+	go func() {
+		for {
+			next := nextReconcileRun(time.Now())
+			time.Sleep(time.Until(next))
+
+			ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+			summary, err := r.ReconcileDay(ctx, next.AddDate(0, 0, -1))
+			cancel()
+			if err != nil {
+				slog.Error("cron: reconciliation failed", "error", err)
+				continue
+			}
+			slog.Info("cron: reconciliation done", "date", summary.Date, "payments", summary.Payments,
+				"inserted", summary.Inserted, "mismatches", summary.Mismatches, "skipped", summary.Skipped)
+		}
+	}()
+
+	slog.Info("reconciliation cron started", "hour", reconcileHour, "timezone", reconcileTimezone)
+}
+
+// nextReconcileRun is the next reconcileHour after now.
+func nextReconcileRun(now time.Time) time.Time {
+	now = now.In(reconcileLocation())
+	next := time.Date(now.Year(), now.Month(), now.Day(), reconcileHour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func reconcileLocation() *time.Location {
+	if loc, err := time.LoadLocation(reconcileTimezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// ReconcileDay pages through the MP payments created on day (in
+// reconcileTimezone), in the platform account and every connected tenant
+// account, and reconciles each one. Payments of a tenant's account that
+// refer to another tenant are skipped. An account stops at its first error;
+// the others still run. Running it again is safe.
+func (r *Reconciler) ReconcileDay(ctx context.Context, day time.Time) (*ReconciliationSummary, error) {
+	day = day.In(reconcileLocation())
+	begin := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := begin.AddDate(0, 0, 1).Add(-time.Millisecond)
+	summary := &ReconciliationSummary{Date: begin.Format("2006-01-02")}
+
+	accounts, err := r.processor.clients.accounts(ctx)
+	errs := []error{err}
+	for _, account := range accounts {
+		errs = append(errs, r.reconcileAccount(ctx, account, begin, end, summary))
+	}
+	return summary, errors.Join(errs...)
+}
+
+func (r *Reconciler) reconcileAccount(ctx context.Context, account account, begin, end time.Time, summary *ReconciliationSummary) error {
+	for offset := 0; ; {
+		page, err := account.client.SearchPayments(ctx, begin, end, offset, reconcilePageSize)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPaymentSearch, err)
+		}
+		for i := range page.Results {
+			if err := r.reconcilePayment(ctx, account.tenantID, &page.Results[i], summary); err != nil {
+				return fmt.Errorf("reconcile payment %d: %w", page.Results[i].ID, err)
+			}
+		}
+		offset += len(page.Results)
+		if len(page.Results) == 0 || offset >= page.Paging.Total {
+			return nil
+		}
+	}
+}
+
+// reconcilePayment reconciles a payment of the account of accountTenant
+// (uuid.Nil for the platform account).
+func (r *Reconciler) reconcilePayment(ctx context.Context, accountTenant uuid.UUID, payment *PaymentInfo, summary *ReconciliationSummary) error {
+	repo := r.processor.repo
+	mpID := strconv.Itoa(payment.ID)
+	summary.Payments++
+
+	charge, err := repo.GetPaymentEventByMPID(ctx, mpID)
+	if err != nil {
+		return err
+	}
+	if charge == nil {
+		return r.insertMissing(ctx, accountTenant, payment, summary)
+	}
+	if err := checkAccount(accountTenant, charge.TenantID); err != nil {
+		slog.Warn("reconciliation: payment recorded for another tenant", "mp_payment_id", mpID, "error", err)
+		summary.Skipped++
+		return nil
+	}
+
+	mpCents := payment.TransactionAmount.Cents()
+	refunded := 0
+	for _, refund := range payment.Refunds {
+		if refund.Status != "rejected" && refund.Status != "cancelled" {
+			refunded += refund.Amount.Cents()
+		}
+	}
+	issue := ReconciliationIssue{
+		TenantID:            charge.TenantID,
+		MpPaymentID:         mpID,
+		PaymentEventID:      &charge.ID,
+		PaymentDate:         summary.Date,
+		MpStatus:            chargeStatus(payment.Status, mpCents, refunded),
+		RecordedStatus:      &charge.Status,
+		MpAmountCents:       mpCents,
+		RecordedAmountCents: &charge.AmountCents,
+	}
+
+	var found []string
+	recorded := money.New(charge.AmountCents, charge.Currency)
+	if issue.MpAmountCents != recorded.Cents || recorded.Check(payment.CurrencyID) != nil {
+		found = append(found, IssueAmountMismatch)
+	}
+	if issue.MpStatus != charge.Status {
+		found = append(found, IssueStatusMismatch)
+	}
+	for _, kind := range found {
+		issue.Kind = kind
+		if err := repo.UpsertReconciliationIssue(ctx, &issue); err != nil {
+			return err
+		}
+		slog.Warn("reconciliation: payment differs from MP", "kind", kind, "mp_payment_id", mpID,
+			"mp_status", issue.MpStatus, "status", charge.Status, "mp_amount_cents", mpCents, "amount_cents", charge.AmountCents)
+	}
+	summary.Mismatches += len(found)
+
+	return repo.ResolveReconciliationIssues(ctx, mpID, found)
+}
+
+// insertMissing records a payment no webhook recorded, as the webhook would
+// have, and keeps a resolved missing_event issue as a trace.
+func (r *Reconciler) insertMissing(ctx context.Context, accountTenant uuid.UUID, payment *PaymentInfo, summary *ReconciliationSummary) error {
+	if payment.ExternalReference == "" {
+		summary.Skipped++
+		return nil
+	}
+
+	event, err := r.processor.recordPayment(ctx, payment, accountTenant, "")
+	if err != nil {
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			// Another integration of the same MP account, a deleted subscription,
+			// another tenant's subscription or a payment in another currency
+			// than its plan.
+			slog.Warn("reconciliation: payment cannot be recorded", "mp_payment_id", payment.ID, "error", err)
+			summary.Skipped++
+			return nil
+		}
+		return err
+	}
+	if event == nil {
+		return nil // the webhook recorded it meanwhile
+	}
+	summary.Inserted++
+	slog.Warn("reconciliation: inserted missing payment", "mp_payment_id", payment.ID, "tenant_id", event.TenantID)
+
+	now := time.Now()
+	return r.processor.repo.UpsertReconciliationIssue(ctx, &ReconciliationIssue{
+		TenantID:       event.TenantID,
+		Kind:           IssueMissingEvent,
+		MpPaymentID:    *event.MpPaymentID,
+		PaymentEventID: &event.ID,
+		PaymentDate:    summary.Date,
+		MpStatus:       event.Status,
+		MpAmountCents:  event.AmountCents,
+		ResolvedAt:     &now,
+	})
+}
+
+// ============================================================
+// Handlers
+// ============================================================
+
+// ListReconciliationIssues is the owner's reconciliation report. Filters:
+// ?status=open|resolved, ?kind=missing_event|amount_mismatch|status_mismatch,
+// ?from= and ?to= (payment date, YYYY-MM-DD).
+func (h *Handler) ListReconciliationIssues(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	page, perPage := httputil.ParsePagination(c)
+
+	f := IssueFilter{
+		Kind:    c.Query("kind"),
+		Status:  c.Query("status"),
+		From:    c.Query("from"),
+		To:      c.Query("to"),
+		Page:    page,
+		PerPage: perPage,
+	}
+	switch f.Kind {
+	case "", IssueMissingEvent, IssueAmountMismatch, IssueStatusMismatch:
+	default:
+		httputil.BadRequest(c, "INVALID_KIND", "invalid issue kind")
+		return
+	}
+	switch f.Status {
+	case "", "open", "resolved":
+	default:
+		httputil.BadRequest(c, "INVALID_STATUS", "status must be open or resolved")
+		return
+	}
+	for _, date := range []string{f.From, f.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			httputil.BadRequest(c, "INVALID_DATE", "from and to must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	issues, total, err := h.repo.ListReconciliationIssues(c.Request.Context(), tenantID, f)
+	if err != nil {
+		slog.Error("failed to list reconciliation issues", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+
+	if issues == nil {
+		issues = []ReconciliationIssue{}
+	}
+
+	httputil.Paginated(c, issues, page, perPage, total)
+}
+
+// Reconcile runs the reconciliation of one day right away (platform admin),
+// e.g. to backfill after an outage.
+func (h *Handler) Reconcile(c *gin.Context) {
+	var req ReconcileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+	day, err := time.ParseInLocation("2006-01-02", req.Date, reconcileLocation())
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_DATE", "date must be YYYY-MM-DD")
+		return
+	}
+	if day.After(time.Now()) {
+		httputil.BadRequest(c, "INVALID_DATE", "date is in the future")
+		return
+	}
+
+	summary, err := h.reconciler.ReconcileDay(c.Request.Context(), day)
+	if err != nil {
+		slog.Error("reconciliation failed", "error", err, "date", req.Date)
+		if errors.Is(err, ErrPaymentSearch) {
+			httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not search payments in Mercado Pago")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, summary)
+}
@@ -0,0 +1,195 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/membership"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+// RefundPayment refunds a Mercado Pago charge, fully or partially. The charge
+// stays locked until the request commits, so concurrent refunds cannot add
+// up to more than was paid. If recording the refund fails after MP made it,
+// the payment webhook that follows records it.
+func (h *Handler) RefundPayment(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+	ctx := c.Request.Context()
+
+	chargeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid payment id")
+		return
+	}
+
+	var req RefundRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+			return
+		}
+	}
+
+	charge, err := h.repo.GetPaymentEventForUpdate(ctx, tenantID, chargeID)
+	if err != nil {
+		if errors.Is(err, ErrPaymentNotFound) {
+			httputil.NotFound(c, "payment not found")
+			return
+		}
+		slog.Error("failed to get payment", "error", err, "tenant_id", tenantID)
+		httputil.InternalError(c)
+		return
+	}
+	if charge.EventType != EventCharge || charge.Source != "mercadopago" || charge.MpPaymentID == nil {
+		httputil.Conflict(c, "NOT_REFUNDABLE", "only Mercado Pago charges can be refunded")
+		return
+	}
+	if charge.Status != "approved" && charge.Status != ChargePartiallyRefunded {
+		httputil.Conflict(c, "NOT_REFUNDABLE", "payment is not approved")
+		return
+	}
+
+	refunded, err := h.repo.GetRefundedCents(ctx, charge.ID)
+	if err != nil {
+		slog.Error("failed to sum refunds", "error", err, "payment_id", charge.ID)
+		httputil.InternalError(c)
+		return
+	}
+	left := charge.AmountCents - refunded
+	if left <= 0 {
+		httputil.Conflict(c, "ALREADY_REFUNDED", "payment was already fully refunded")
+		return
+	}
+	amount := req.AmountCents
+	if amount == 0 {
+		amount = left
+	}
+	if amount > left {
+		httputil.BadRequest(c, "REFUND_EXCEEDS_AMOUNT", fmt.Sprintf("at most %d cents can be refunded", left))
+		return
+	}
+
+	// Retries of this same refund reuse the key; a later refund of the same
+	// amount has refunded more by then and gets its own.
+	key := fmt.Sprintf("refund-%s-%d-%d", charge.ID, refunded, amount)
+	mpClient := h.tenantClient(c, tenantID)
+	if mpClient == nil {
+		return
+	}
+	refund, err := mpClient.RefundPayment(ctx, *charge.MpPaymentID, money.FromCents(amount), key)
+	if err != nil {
+		slog.Error("MP refund failed", "error", err, "payment_id", charge.ID)
+		httputil.BadGateway(c, "PAYMENT_PROVIDER_ERROR", "could not refund the payment in Mercado Pago")
+		return
+	}
+
+	event := refundEvent(charge, refund)
+	event.AmountCents = amount
+	event.RecordedBy = &userID
+	if req.Reason != "" {
+		event.Notes = &req.Reason
+	}
+	if err := h.repo.CreatePaymentEvent(ctx, event); err != nil {
+		slog.Error("failed to record refund", "error", err, "payment_id", charge.ID, "mp_refund_id", refund.ID)
+		httputil.InternalError(c)
+		return
+	}
+
+	status := chargeStatus("approved", charge.AmountCents, refunded+amount)
+	if err := h.repo.UpdatePaymentEventStatus(ctx, charge.ID, status); err != nil {
+		slog.Error("failed to update refunded payment", "error", err, "payment_id", charge.ID)
+		httputil.InternalError(c)
+		return
+	}
+	if status == ChargeRefunded {
+		err := pastDueAfterRefund(ctx, h.repo, charge, membership.ActorUser, &userID)
+		if err != nil {
+			slog.Error("failed to update subscription after refund", "error", err, "payment_id", charge.ID)
+			httputil.InternalError(c)
+			return
+		}
+	}
+
+	httputil.Created(c, event)
+}
+
+// refundEvent is the payment event of an MP refund of charge.
+func refundEvent(charge *PaymentEvent, refund *RefundInfo) *PaymentEvent {
+	rawPayload, _ := json.Marshal(refund)
+	mpRefundID := strconv.FormatInt(refund.ID, 10)
+	return &PaymentEvent{
+		TenantID:       charge.TenantID,
+		SubscriptionID: charge.SubscriptionID,
+		Source:         "mercadopago",
+		EventType:      EventRefund,
+		ParentEventID:  &charge.ID,
+		MpRefundID:     &mpRefundID,
+		Status:         refund.Status,
+		AmountCents:    refund.Amount.Cents(),
+		Currency:       charge.Currency,
+		RawPayload:     rawPayload,
+	}
+}
+
+// chargeStatus is the status of a charge MP reports as mpStatus once
+// refundedCents of it were refunded. MP keeps partially refunded payments
+// approved; we tell them apart.
+func chargeStatus(mpStatus string, amountCents, refundedCents int) string {
+	if refundedCents > 0 && (mpStatus == "approved" || mpStatus == ChargeRefunded) {
+		if refundedCents >= amountCents {
+			return ChargeRefunded
+		}
+		return ChargePartiallyRefunded
+	}
+	return mpStatus
+}
+
+// pastDueAfterRefund moves the subscription of a fully refunded charge to
+// past_due, unless a later payment already covers the current period.
+func pastDueAfterRefund(ctx context.Context, repo *Repository, charge *PaymentEvent, actorType string, actorID *uuid.UUID) error {
+	if charge.SubscriptionID == nil {
+		return nil
+	}
+	latest, err := repo.IsLatestCharge(ctx, charge)
+	if err != nil || !latest {
+		return err
+	}
+	return pastDueAfterReversal(ctx, repo, charge, membership.ReasonPaymentRefunded, actorType, actorID)
+}
+
+// pastDueAfterReversal moves the subscription a reversed charge paid for to
+// past_due. Subscriptions that cannot go there (paused, cancelled) are left
+// as they are.
+func pastDueAfterReversal(ctx context.Context, repo *Repository, charge *PaymentEvent, reason, actorType string, actorID *uuid.UUID) error {
+	if charge.SubscriptionID == nil {
+		return nil
+	}
+	changed, err := repo.TransitionSubscription(ctx, &membership.StatusChange{
+		TenantID:       charge.TenantID,
+		SubscriptionID: *charge.SubscriptionID,
+		ToStatus:       membership.StatusPastDue,
+		Reason:         reason,
+		ActorType:      actorType,
+		ActorID:        actorID,
+	})
+	if err != nil {
+		if errors.Is(err, membership.ErrInvalidTransition) {
+			slog.Warn("ignoring subscription status change from reversed payment", "error", err, "payment_id", charge.ID)
+			return nil
+		}
+		return err
+	}
+	if changed {
+		slog.Info("subscription moved to past_due", "subscription_id", *charge.SubscriptionID, "reason", reason)
+	}
+	return nil
+}
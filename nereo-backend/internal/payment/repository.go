@@ -8,10 +8,25 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/membership"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/money"
 )
 
-var ErrPaymentAlreadyProcessed = errors.New("payment already processed")
+var (
+	ErrPaymentAlreadyProcessed = errors.New("payment already processed")
+	ErrPaymentNotFound         = errors.New("payment not found")
+	ErrSubscriptionNotFound    = errors.New("subscription not found")
+	ErrCustomerNotFound        = errors.New("customer not found")
+	ErrWebhookNotFound         = errors.New("webhook delivery not found")
+	ErrWebhookNotReplayable    = errors.New("webhook delivery is not failed or dead")
+	ErrAccountNotFound         = errors.New("mercado pago account not found")
+	ErrAccountInUse            = errors.New("mercado pago account connected to another tenant")
+	ErrAccountHasSubscriptions = errors.New("mercado pago account has live subscriptions")
+	ErrNotPastDue              = errors.New("subscription is not past_due")
+)
 
 type Repository struct {
 	db *pgxpool.Pool
@@ -21,19 +36,34 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
+const paymentEventColumns = `id, tenant_id, subscription_id, source, event_type, parent_event_id, mp_payment_id,
+	mp_refund_id, mp_chargeback_id, status, amount_cents, currency, notes, recorded_by, processed_at`
+
+// CreatePaymentEvent stores a charge, refund or chargeback (EventCharge when
+// EventType is empty). An event whose MP payment, refund or chargeback was
+// already recorded returns ErrPaymentAlreadyProcessed.
 func (r *Repository) CreatePaymentEvent(ctx context.Context, e *PaymentEvent) error {
 	query := `
-		INSERT INTO payment_events (id, tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, notes, recorded_by, raw_payload)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		ON CONFLICT (mp_payment_id) DO NOTHING
+		INSERT INTO payment_events (id, tenant_id, subscription_id, source, event_type, parent_event_id, mp_payment_id,
+			mp_refund_id, mp_chargeback_id, status, amount_cents, currency, notes, recorded_by, raw_payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT DO NOTHING
 		RETURNING processed_at`
 
 	id := uuid.New()
 	e.ID = id
+	if e.EventType == "" {
+		e.EventType = EventCharge
+	}
 
-	err := r.db.QueryRow(ctx, query,
-		e.ID, e.TenantID, e.SubscriptionID, e.Source, e.MpPaymentID,
-		e.Status, e.AmountCents, e.Notes, e.RecordedBy, e.RawPayload,
+	err := r.conn(ctx).QueryRow(ctx, query,
+		e.ID, e.TenantID, e.SubscriptionID, e.Source, e.EventType, e.ParentEventID, e.MpPaymentID,
+		e.MpRefundID, e.MpChargebackID, e.Status, e.AmountCents, e.Currency, e.Notes, e.RecordedBy, e.RawPayload,
 	).Scan(&e.ProcessedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -45,16 +75,12 @@ func (r *Repository) CreatePaymentEvent(ctx context.Context, e *PaymentEvent) er
 	return nil
 }
 
+// GetPaymentEventByMPID returns the charge of an MP payment, or nil when it
+// was not recorded yet.
 func (r *Repository) GetPaymentEventByMPID(ctx context.Context, mpPaymentID string) (*PaymentEvent, error) {
-	query := `
-		SELECT id, tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, notes, recorded_by, processed_at
-		FROM payment_events WHERE mp_payment_id = $1`
+	query := `SELECT ` + paymentEventColumns + ` FROM payment_events WHERE mp_payment_id = $1`
 
-	e := &PaymentEvent{}
-	err := r.db.QueryRow(ctx, query, mpPaymentID).Scan(
-		&e.ID, &e.TenantID, &e.SubscriptionID, &e.Source, &e.MpPaymentID,
-		&e.Status, &e.AmountCents, &e.Notes, &e.RecordedBy, &e.ProcessedAt,
-	)
+	e, err := scanPaymentEvent(r.conn(ctx).QueryRow(ctx, query, mpPaymentID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -64,106 +90,319 @@ func (r *Repository) GetPaymentEventByMPID(ctx context.Context, mpPaymentID stri
 	return e, nil
 }
 
-func (r *Repository) ListPaymentEvents(ctx context.Context, tenantID uuid.UUID, subscriptionID *uuid.UUID) ([]PaymentEvent, error) {
+// GetPaymentEventForUpdate locks a payment event of the tenant, so that
+// concurrent refunds of the same charge are applied one after the other.
+func (r *Repository) GetPaymentEventForUpdate(ctx context.Context, tenantID, id uuid.UUID) (*PaymentEvent, error) {
+	query := `SELECT ` + paymentEventColumns + ` FROM payment_events WHERE id = $1 AND tenant_id = $2 FOR UPDATE`
+
+	e, err := scanPaymentEvent(r.conn(ctx).QueryRow(ctx, query, id, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("get payment event: %w", err)
+	}
+	return e, nil
+}
+
+// GetRefundedCents returns how much of a charge was refunded so far. Refunds
+// MP reports as rejected or cancelled do not count.
+func (r *Repository) GetRefundedCents(ctx context.Context, chargeID uuid.UUID) (int, error) {
 	query := `
-		SELECT id, tenant_id, subscription_id, source, mp_payment_id, status, amount_cents, notes, recorded_by, processed_at
-		FROM payment_events WHERE tenant_id = $1`
+		SELECT COALESCE(SUM(amount_cents), 0) FROM payment_events
+		WHERE parent_event_id = $1 AND event_type = 'refund' AND status NOT IN ('rejected', 'cancelled')`
 
+	var cents int
+	if err := r.conn(ctx).QueryRow(ctx, query, chargeID).Scan(&cents); err != nil {
+		return 0, fmt.Errorf("sum refunds: %w", err)
+	}
+	return cents, nil
+}
+
+// UpdatePaymentEventStatus records a later status of a charge, e.g. refunded.
+func (r *Repository) UpdatePaymentEventStatus(ctx context.Context, id uuid.UUID, status string) error {
+	_, err := r.conn(ctx).Exec(ctx, "UPDATE payment_events SET status = $1 WHERE id = $2", status, id)
+	if err != nil {
+		return fmt.Errorf("update payment event status: %w", err)
+	}
+	return nil
+}
+
+// IsLatestCharge reports whether no payment of the charge's subscription came
+// after it, i.e. whether it paid for the current period.
+func (r *Repository) IsLatestCharge(ctx context.Context, charge *PaymentEvent) (bool, error) {
+	query := `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM payment_events
+			WHERE subscription_id = $1 AND event_type = 'charge' AND id <> $2
+			  AND processed_at > $3 AND status IN ('approved', 'partially_refunded')
+		)`
+
+	var latest bool
+	err := r.conn(ctx).QueryRow(ctx, query, charge.SubscriptionID, charge.ID, charge.ProcessedAt).Scan(&latest)
+	if err != nil {
+		return false, fmt.Errorf("check latest charge: %w", err)
+	}
+	return latest, nil
+}
+
+const paymentListColumns = `pe.id, pe.tenant_id, pe.subscription_id, pe.source, pe.event_type, pe.parent_event_id,
+	pe.mp_payment_id, pe.mp_refund_id, pe.mp_chargeback_id, pe.status, pe.amount_cents, pe.currency, pe.notes,
+	pe.recorded_by, pe.processed_at, s.customer_id, c.full_name`
+
+const paymentListFrom = `
+	FROM payment_events pe
+	JOIN tenants t ON t.id = pe.tenant_id
+	LEFT JOIN subscriptions s ON s.id = pe.subscription_id
+	LEFT JOIN customers c ON c.id = s.customer_id`
+
+// ListPayments returns a page of the tenant's payment events, newest first,
+// with the totals per type and status of all the events matching f.
+func (r *Repository) ListPayments(ctx context.Context, tenantID uuid.UUID, f PaymentFilter) ([]PaymentListItem, []PaymentTotal, int64, error) {
+	where := " WHERE pe.tenant_id = $1"
 	args := []interface{}{tenantID}
-	if subscriptionID != nil {
-		query += " AND subscription_id = $2"
-		args = append(args, *subscriptionID)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where += fmt.Sprintf(" AND "+cond, len(args))
+	}
+
+	// Days start at midnight in the tenant's timezone.
+	if f.From != "" {
+		add("pe.processed_at >= ($%d::date::timestamp AT TIME ZONE t.timezone)", f.From)
+	}
+	if f.To != "" {
+		add("pe.processed_at < (($%d::date + 1)::timestamp AT TIME ZONE t.timezone)", f.To)
+	}
+	if f.Source != "" {
+		add("pe.source = $%d", f.Source)
+	}
+	if f.EventType != "" {
+		add("pe.event_type = $%d", f.EventType)
+	}
+	if f.Status != "" {
+		add("pe.status = $%d", f.Status)
+	}
+	if f.SubscriptionID != nil {
+		add("pe.subscription_id = $%d", *f.SubscriptionID)
+	}
+	if f.CustomerID != nil {
+		add("s.customer_id = $%d", *f.CustomerID)
+	}
+	if f.RecordedBy != nil {
+		add("pe.recorded_by = $%d", *f.RecordedBy)
 	}
-	query += " ORDER BY processed_at DESC"
 
-	rows, err := r.db.Query(ctx, query, args...)
+	totals, total, err := r.paymentTotals(ctx, where, args)
 	if err != nil {
-		return nil, fmt.Errorf("list payment events: %w", err)
+		return nil, nil, 0, err
+	}
+
+	args = append(args, f.PerPage, (f.Page-1)*f.PerPage)
+	query := `SELECT ` + paymentListColumns + paymentListFrom + where +
+		fmt.Sprintf(" ORDER BY pe.processed_at DESC, pe.id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("list payments: %w", err)
 	}
 	defer rows.Close()
 
-	var events []PaymentEvent
+	var items []PaymentListItem
 	for rows.Next() {
-		var e PaymentEvent
-		if err := rows.Scan(
-			&e.ID, &e.TenantID, &e.SubscriptionID, &e.Source, &e.MpPaymentID,
-			&e.Status, &e.AmountCents, &e.Notes, &e.RecordedBy, &e.ProcessedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan payment event: %w", err)
+		item, err := scanPaymentListItem(rows)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("scan payment: %w", err)
+		}
+		items = append(items, *item)
+	}
+
+	return items, totals, total, rows.Err()
+}
+
+// paymentTotals groups the events matching a ListPayments filter. Their
+// counts add up to the number of events matched.
+func (r *Repository) paymentTotals(ctx context.Context, where string, args []interface{}) ([]PaymentTotal, int64, error) {
+	query := `SELECT pe.event_type, pe.status, pe.currency, COUNT(*), COALESCE(SUM(pe.amount_cents), 0)` + paymentListFrom + where +
+		` GROUP BY pe.event_type, pe.status, pe.currency ORDER BY pe.event_type, pe.status, pe.currency`
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("payment totals: %w", err)
+	}
+	defer rows.Close()
+
+	totals := []PaymentTotal{}
+	var count int64
+	for rows.Next() {
+		var t PaymentTotal
+		if err := rows.Scan(&t.EventType, &t.Status, &t.Currency, &t.Count, &t.AmountCents); err != nil {
+			return nil, 0, fmt.Errorf("scan payment total: %w", err)
 		}
-		events = append(events, e)
+		totals = append(totals, t)
+		count += t.Count
 	}
 
-	return events, nil
+	return totals, count, rows.Err()
+}
+
+// GetPayment returns a payment event of the tenant with its payload and, for
+// a charge, its refunds and chargebacks.
+func (r *Repository) GetPayment(ctx context.Context, tenantID, id uuid.UUID) (*PaymentDetail, error) {
+	query := `SELECT ` + paymentListColumns + `, pe.raw_payload` + paymentListFrom + ` WHERE pe.id = $1 AND pe.tenant_id = $2`
+
+	d := &PaymentDetail{}
+	e := &d.PaymentEvent
+	err := r.conn(ctx).QueryRow(ctx, query, id, tenantID).Scan(
+		&e.ID, &e.TenantID, &e.SubscriptionID, &e.Source, &e.EventType, &e.ParentEventID,
+		&e.MpPaymentID, &e.MpRefundID, &e.MpChargebackID, &e.Status, &e.AmountCents, &e.Currency, &e.Notes, &e.RecordedBy,
+		&e.ProcessedAt, &d.CustomerID, &d.CustomerName, &d.Payload,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("get payment: %w", err)
+	}
+
+	rows, err := r.conn(ctx).Query(ctx,
+		`SELECT `+paymentEventColumns+` FROM payment_events WHERE parent_event_id = $1 AND tenant_id = $2 ORDER BY processed_at`,
+		id, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list reversals: %w", err)
+	}
+	defer rows.Close()
+
+	d.Reversals = []PaymentEvent{}
+	for rows.Next() {
+		rev, err := scanPaymentEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan reversal: %w", err)
+		}
+		d.Reversals = append(d.Reversals, *rev)
+	}
+
+	return d, rows.Err()
+}
+
+func scanPaymentListItem(row pgx.Row) (*PaymentListItem, error) {
+	item := &PaymentListItem{}
+	e := &item.PaymentEvent
+	err := row.Scan(
+		&e.ID, &e.TenantID, &e.SubscriptionID, &e.Source, &e.EventType, &e.ParentEventID,
+		&e.MpPaymentID, &e.MpRefundID, &e.MpChargebackID, &e.Status, &e.AmountCents, &e.Currency, &e.Notes, &e.RecordedBy,
+		&e.ProcessedAt, &item.CustomerID, &item.CustomerName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func scanPaymentEvent(row pgx.Row) (*PaymentEvent, error) {
+	e := &PaymentEvent{}
+	err := row.Scan(
+		&e.ID, &e.TenantID, &e.SubscriptionID, &e.Source, &e.EventType, &e.ParentEventID, &e.MpPaymentID,
+		&e.MpRefundID, &e.MpChargebackID, &e.Status, &e.AmountCents, &e.Currency, &e.Notes, &e.RecordedBy, &e.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
 }
 
 // GetSubscriptionTenantID retrieves the tenant_id for a subscription (needed for webhook processing)
 func (r *Repository) GetSubscriptionTenantID(ctx context.Context, subscriptionID uuid.UUID) (uuid.UUID, error) {
 	var tenantID uuid.UUID
-	err := r.db.QueryRow(ctx,
+	err := r.conn(ctx).QueryRow(ctx,
 		"SELECT tenant_id FROM subscriptions WHERE id = $1", subscriptionID,
 	).Scan(&tenantID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, ErrSubscriptionNotFound
+		}
 		return uuid.Nil, fmt.Errorf("get subscription tenant: %w", err)
 	}
 	return tenantID, nil
 }
 
-// UpdateSubscriptionStatus updates a subscription's status
-func (r *Repository) UpdateSubscriptionStatus(ctx context.Context, subscriptionID uuid.UUID, status string) error {
-	_, err := r.db.Exec(ctx,
-		"UPDATE subscriptions SET status = $1, updated_at = NOW() WHERE id = $2",
-		status, subscriptionID,
-	)
-	return err
+// GetSubscriptionByPreapproval finds the subscription billed through a
+// Mercado Pago preapproval.
+func (r *Repository) GetSubscriptionByPreapproval(ctx context.Context, preapprovalID string) (*SubscriptionRef, error) {
+	ref := &SubscriptionRef{}
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT id, tenant_id FROM subscriptions WHERE mp_subscription_id = $1", preapprovalID,
+	).Scan(&ref.ID, &ref.TenantID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("get subscription by preapproval: %w", err)
+	}
+	return ref, nil
+}
+
+// TransitionSubscription changes a subscription's status through the
+// membership state machine, which rejects moves such as cancelled -> active.
+func (r *Repository) TransitionSubscription(ctx context.Context, change *membership.StatusChange) (bool, error) {
+	return membership.TransitionStatus(ctx, r.db, change)
 }
 
 // UpdateSubscriptionMPID stores the Mercado Pago subscription ID
 func (r *Repository) UpdateSubscriptionMPID(ctx context.Context, subscriptionID uuid.UUID, mpSubID string) error {
-	_, err := r.db.Exec(ctx,
+	_, err := r.conn(ctx).Exec(ctx,
 		"UPDATE subscriptions SET mp_subscription_id = $1, updated_at = NOW() WHERE id = $2",
 		mpSubID, subscriptionID,
 	)
 	return err
 }
 
-// RenewSubscription resets the subscription period
-func (r *Repository) RenewSubscription(ctx context.Context, subscriptionID uuid.UUID, periodStart, periodEnd interface{}) error {
-	_, err := r.db.Exec(ctx,
-		`UPDATE subscriptions SET status = 'active', current_period_start = $1, current_period_end = $2, washes_used = 0, updated_at = NOW() WHERE id = $3`,
-		periodStart, periodEnd, subscriptionID,
+// RenewSubscription resets the subscription period. Reactivating it is up to
+// the caller, through TransitionSubscription.
+func (r *Repository) RenewSubscription(ctx context.Context, tenantID, subscriptionID uuid.UUID, periodStart, periodEnd interface{}) error {
+	tag, err := r.conn(ctx).Exec(ctx,
+		`UPDATE subscriptions SET current_period_start = $1, current_period_end = $2, washes_used = 0, updated_at = NOW() WHERE id = $3 AND tenant_id = $4`,
+		periodStart, periodEnd, subscriptionID, tenantID,
 	)
-	return err
+	if err != nil {
+		return fmt.Errorf("renew subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
 }
 
 // GetSubscriptionWithPlan returns subscription + plan price for payment processing
 type SubscriptionWithPlan struct {
-	SubscriptionID uuid.UUID
-	TenantID       uuid.UUID
-	CustomerID     uuid.UUID
-	PlanID         uuid.UUID
-	PlanName       string
-	PriceCents     int
-	Interval       string
-	Status         string
-	PaymentMethod  string
+	SubscriptionID   uuid.UUID
+	TenantID         uuid.UUID
+	CustomerID       uuid.UUID
+	PlanID           uuid.UUID
+	PlanName         string
+	PriceCents       int
+	Currency         money.Currency
+	Interval         string
+	Status           string
+	PaymentMethod    string
+	MpSubscriptionID *string // set when billed through a preapproval
 }
 
-func (r *Repository) GetSubscriptionWithPlan(ctx context.Context, subscriptionID uuid.UUID) (*SubscriptionWithPlan, error) {
+func (r *Repository) GetSubscriptionWithPlan(ctx context.Context, tenantID, subscriptionID uuid.UUID) (*SubscriptionWithPlan, error) {
 	query := `
-		SELECT s.id, s.tenant_id, s.customer_id, s.plan_id, p.name, p.price_cents, p.interval, s.status, s.payment_method
+		SELECT s.id, s.tenant_id, s.customer_id, s.plan_id, p.name, p.price_cents, p.currency, p.interval, s.status,
+			s.payment_method, s.mp_subscription_id
 		FROM subscriptions s
 		JOIN membership_plans p ON p.id = s.plan_id
-		WHERE s.id = $1`
+		WHERE s.id = $1 AND s.tenant_id = $2`
 
 	sp := &SubscriptionWithPlan{}
-	err := r.db.QueryRow(ctx, query, subscriptionID).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, subscriptionID, tenantID).Scan(
 		&sp.SubscriptionID, &sp.TenantID, &sp.CustomerID, &sp.PlanID,
-		&sp.PlanName, &sp.PriceCents, &sp.Interval, &sp.Status, &sp.PaymentMethod,
+		&sp.PlanName, &sp.PriceCents, &sp.Currency, &sp.Interval, &sp.Status, &sp.PaymentMethod, &sp.MpSubscriptionID,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("subscription not found")
+			return nil, ErrSubscriptionNotFound
 		}
 		return nil, fmt.Errorf("get subscription with plan: %w", err)
 	}
@@ -177,19 +416,20 @@ type PlanWithTenant struct {
 	TenantName string
 	PlanName   string
 	PriceCents int
+	Currency   money.Currency
 	Interval   string
 }
 
 func (r *Repository) GetPlanWithTenant(ctx context.Context, tenantID, planID uuid.UUID) (*PlanWithTenant, error) {
 	query := `
-		SELECT p.id, p.tenant_id, t.name, p.name, p.price_cents, p.interval
+		SELECT p.id, p.tenant_id, t.name, p.name, p.price_cents, p.currency, p.interval
 		FROM membership_plans p
 		JOIN tenants t ON t.id = p.tenant_id
 		WHERE p.id = $1 AND p.tenant_id = $2 AND p.active = true`
 
 	pt := &PlanWithTenant{}
-	err := r.db.QueryRow(ctx, query, planID, tenantID).Scan(
-		&pt.PlanID, &pt.TenantID, &pt.TenantName, &pt.PlanName, &pt.PriceCents, &pt.Interval,
+	err := r.conn(ctx).QueryRow(ctx, query, planID, tenantID).Scan(
+		&pt.PlanID, &pt.TenantID, &pt.TenantName, &pt.PlanName, &pt.PriceCents, &pt.Currency, &pt.Interval,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -201,9 +441,11 @@ func (r *Repository) GetPlanWithTenant(ctx context.Context, tenantID, planID uui
 }
 
 // GetCustomerEmail returns the customer email for MP preapproval
-func (r *Repository) GetCustomerEmail(ctx context.Context, customerID uuid.UUID) (string, error) {
+func (r *Repository) GetCustomerEmail(ctx context.Context, tenantID, customerID uuid.UUID) (string, error) {
 	var email *string
-	err := r.db.QueryRow(ctx, "SELECT email FROM customers WHERE id = $1", customerID).Scan(&email)
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT email FROM customers WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", customerID, tenantID,
+	).Scan(&email)
 	if err != nil {
 		return "", fmt.Errorf("get customer email: %w", err)
 	}
@@ -214,31 +456,689 @@ func (r *Repository) GetCustomerEmail(ctx context.Context, customerID uuid.UUID)
 }
 
 // GetCustomerInfo returns the customer name and phone for MP payer fields
-func (r *Repository) GetCustomerInfo(ctx context.Context, customerID uuid.UUID) (string, string) {
+func (r *Repository) GetCustomerInfo(ctx context.Context, tenantID, customerID uuid.UUID) (string, string, error) {
 	var name, phone string
-	_ = r.db.QueryRow(ctx, "SELECT full_name, phone FROM customers WHERE id = $1", customerID).Scan(&name, &phone)
-	return name, phone
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT full_name, phone FROM customers WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", customerID, tenantID,
+	).Scan(&name, &phone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", ErrCustomerNotFound
+		}
+		return "", "", fmt.Errorf("get customer info: %w", err)
+	}
+	return name, phone, nil
+}
+
+// SubscriptionRef identifies a subscription picked up by a background job.
+type SubscriptionRef struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+const pastDueEpisodeColumns = `h.id, s.tenant_id, s.id, s.customer_id, s.plan_id, p.name, t.name, p.price_cents,
+	p.currency, s.mp_subscription_id, h.changed_at`
+
+// pastDueEpisodeFrom joins each past_due subscription with the latest
+// status change that made it past_due, which starts its episode.
+const pastDueEpisodeFrom = `
+	FROM subscriptions s
+	JOIN membership_plans p ON p.id = s.plan_id
+	JOIN tenants t ON t.id = s.tenant_id
+	JOIN LATERAL (
+		SELECT id, changed_at FROM subscription_status_history
+		WHERE subscription_id = s.id AND to_status = 'past_due'
+		ORDER BY changed_at DESC LIMIT 1
+	) h ON true
+	WHERE s.status = 'past_due'`
+
+// ListPastDueEpisodes returns every past_due subscription, oldest episode
+// first. Background jobs only: it is not filtered by tenant.
+func (r *Repository) ListPastDueEpisodes(ctx context.Context) ([]PastDueEpisode, error) {
+	rows, err := r.conn(ctx).Query(ctx, `SELECT `+pastDueEpisodeColumns+pastDueEpisodeFrom+` ORDER BY h.changed_at`)
+	if err != nil {
+		return nil, fmt.Errorf("list past due subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var episodes []PastDueEpisode
+	for rows.Next() {
+		ep, err := scanPastDueEpisode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan past due subscription: %w", err)
+		}
+		episodes = append(episodes, *ep)
+	}
+	return episodes, rows.Err()
+}
+
+// GetPastDueEpisode returns the current episode of a subscription, or
+// ErrNotPastDue when it is not past_due.
+func (r *Repository) GetPastDueEpisode(ctx context.Context, tenantID, subscriptionID uuid.UUID) (*PastDueEpisode, error) {
+	query := `SELECT ` + pastDueEpisodeColumns + pastDueEpisodeFrom + ` AND s.id = $1 AND s.tenant_id = $2`
+	ep, err := scanPastDueEpisode(r.conn(ctx).QueryRow(ctx, query, subscriptionID, tenantID))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("get past due subscription: %w", err)
+		}
+		owner, err := r.GetSubscriptionTenantID(ctx, subscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		if owner != tenantID {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, ErrNotPastDue
+	}
+	return ep, nil
+}
+
+func scanPastDueEpisode(row pgx.Row) (*PastDueEpisode, error) {
+	ep := &PastDueEpisode{}
+	err := row.Scan(&ep.ChangeID, &ep.TenantID, &ep.SubscriptionID, &ep.CustomerID, &ep.PlanID, &ep.PlanName,
+		&ep.TenantName, &ep.AmountCents, &ep.Currency, &ep.MpSubscriptionID, &ep.Since)
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+// ============================================================
+// Webhook inbox
+// ============================================================
+
+const webhookColumns = `id, provider, topic, action, resource_id, request_id, payload, status, attempts,
+	next_attempt_at, locked_at, last_error, received_at, processed_at`
+
+// EnqueueWebhook stores a notification before it is acknowledged. A
+// redelivery with the same x-request-id is not stored twice.
+func (r *Repository) EnqueueWebhook(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_inbox (provider, topic, action, resource_id, request_id, payload)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider, request_id) WHERE request_id IS NOT NULL DO NOTHING`
+
+	_, err := r.conn(ctx).Exec(ctx, query, d.Provider, d.Topic, d.Action, d.ResourceID, d.RequestID, d.Payload)
+	if err != nil {
+		return fmt.Errorf("enqueue webhook: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhook locks the next due delivery for a worker and counts the
+// attempt. Deliveries left in processing for longer than lockTimeout (e.g. by
+// a crashed worker) are claimed again. Returns ErrWebhookNotFound when the
+// queue is empty.
+func (r *Repository) ClaimWebhook(ctx context.Context, lockTimeout time.Duration) (*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_inbox SET status = 'processing', attempts = attempts + 1, locked_at = NOW()
+		WHERE id = (
+			SELECT id FROM webhook_inbox
+			WHERE (status IN ('pending', 'failed') AND next_attempt_at <= NOW())
+				OR (status = 'processing' AND locked_at < NOW() - $1::interval)
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + webhookColumns
+
+	d, err := scanWebhook(r.conn(ctx).QueryRow(ctx, query, lockTimeout.String()))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("claim webhook: %w", err)
+	}
+	return d, nil
+}
+
+func (r *Repository) MarkWebhookProcessed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.conn(ctx).Exec(ctx,
+		`UPDATE webhook_inbox SET status = 'processed', processed_at = NOW(), locked_at = NULL, last_error = NULL WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("mark webhook processed: %w", err)
+	}
+	return nil
+}
+
+// MarkWebhookFailed schedules another attempt at nextAttempt.
+func (r *Repository) MarkWebhookFailed(ctx context.Context, id uuid.UUID, nextAttempt time.Time, lastErr string) error {
+	_, err := r.conn(ctx).Exec(ctx,
+		`UPDATE webhook_inbox SET status = 'failed', next_attempt_at = $2, locked_at = NULL, last_error = $3 WHERE id = $1`,
+		id, nextAttempt, lastErr,
+	)
+	if err != nil {
+		return fmt.Errorf("mark webhook failed: %w", err)
+	}
+	return nil
+}
+
+// MarkWebhookDead stops retrying a delivery.
+func (r *Repository) MarkWebhookDead(ctx context.Context, id uuid.UUID, lastErr string) error {
+	_, err := r.conn(ctx).Exec(ctx,
+		`UPDATE webhook_inbox SET status = 'dead', locked_at = NULL, last_error = $2 WHERE id = $1`,
+		id, lastErr,
+	)
+	if err != nil {
+		return fmt.Errorf("mark webhook dead: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetWebhook(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_inbox WHERE id = $1`
+
+	d, err := scanWebhook(r.conn(ctx).QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("get webhook: %w", err)
+	}
+	return d, nil
+}
+
+// ListWebhooks returns deliveries newest first, optionally filtered by status.
+func (r *Repository) ListWebhooks(ctx context.Context, status string, page, perPage int) ([]WebhookDelivery, int64, error) {
+	where := ""
+	args := []interface{}{}
+	if status != "" {
+		where = " WHERE status = $1"
+		args = append(args, status)
+	}
+
+	var total int64
+	if err := r.conn(ctx).QueryRow(ctx, `SELECT COUNT(*) FROM webhook_inbox`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count webhooks: %w", err)
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM webhook_inbox%s ORDER BY received_at DESC LIMIT $%d OFFSET $%d`,
+		webhookColumns, where, len(args)+1, len(args)+2)
+	args = append(args, perPage, (page-1)*perPage)
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhook(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan webhook: %w", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, total, nil
+}
+
+// ReplayWebhook puts a failed or dead delivery back in the queue with a fresh
+// attempt budget.
+func (r *Repository) ReplayWebhook(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_inbox SET status = 'pending', attempts = 0, next_attempt_at = NOW(), locked_at = NULL
+		WHERE id = $1 AND status IN ('failed', 'dead')
+		RETURNING ` + webhookColumns
+
+	d, err := scanWebhook(r.conn(ctx).QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if _, getErr := r.GetWebhook(ctx, id); getErr != nil {
+				return nil, getErr
+			}
+			return nil, ErrWebhookNotReplayable
+		}
+		return nil, fmt.Errorf("replay webhook: %w", err)
+	}
+	return d, nil
+}
+
+func scanWebhook(row pgx.Row) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	err := row.Scan(
+		&d.ID, &d.Provider, &d.Topic, &d.Action, &d.ResourceID, &d.RequestID, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LockedAt, &d.LastError, &d.ReceivedAt, &d.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ============================================================
+// Idempotent MP requests
+// ============================================================
+
+// ReserveMPRequest claims the idempotency key for a new request. When the
+// key was already used it returns the stored request instead. A concurrent
+// request with the same key waits here until the first one commits or
+// rolls back.
+func (r *Repository) ReserveMPRequest(ctx context.Context, req *MPRequest) (existing *MPRequest, err error) {
+	err = r.conn(ctx).QueryRow(ctx, `
+		INSERT INTO mp_requests (tenant_id, operation, idempotency_key, request_hash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, operation, idempotency_key) DO NOTHING
+		RETURNING id, created_at`,
+		req.TenantID, req.Operation, req.IdempotencyKey, req.RequestHash,
+	).Scan(&req.ID, &req.CreatedAt)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("reserve mp request: %w", err)
+	}
+
+	existing = &MPRequest{}
+	err = r.conn(ctx).QueryRow(ctx, `
+		SELECT id, tenant_id, operation, idempotency_key, request_hash, mp_idempotency_key, subscription_id, mp_id, response, created_at
+		FROM mp_requests WHERE tenant_id = $1 AND operation = $2 AND idempotency_key = $3`,
+		req.TenantID, req.Operation, req.IdempotencyKey,
+	).Scan(
+		&existing.ID, &existing.TenantID, &existing.Operation, &existing.IdempotencyKey, &existing.RequestHash,
+		&existing.MPIdempotencyKey, &existing.SubscriptionID, &existing.MPID, &existing.Response, &existing.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get mp request: %w", err)
+	}
+	return existing, nil
+}
+
+// CompleteMPRequest stores what MP returned for a reserved request.
+func (r *Repository) CompleteMPRequest(ctx context.Context, req *MPRequest) error {
+	_, err := r.conn(ctx).Exec(ctx, `
+		UPDATE mp_requests SET mp_idempotency_key = $2, subscription_id = $3, mp_id = $4, response = $5
+		WHERE id = $1 AND tenant_id = $6`,
+		req.ID, req.MPIdempotencyKey, req.SubscriptionID, req.MPID, req.Response, req.TenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("complete mp request: %w", err)
+	}
+	return nil
+}
+
+// ============================================================
+// Reconciliation
+// ============================================================
+
+const issueColumns = `id, tenant_id, kind, mp_payment_id, payment_event_id, to_char(payment_date, 'YYYY-MM-DD'),
+	mp_status, recorded_status, mp_amount_cents, recorded_amount_cents, detected_at, resolved_at`
+
+// UpsertReconciliationIssue records an issue, or refreshes it when the same
+// payment already had one of that kind. A resolved issue found again is
+// reopened unless the new one is resolved too.
+func (r *Repository) UpsertReconciliationIssue(ctx context.Context, issue *ReconciliationIssue) error {
+	query := `
+		INSERT INTO reconciliation_issues (tenant_id, kind, mp_payment_id, payment_event_id, payment_date,
+			mp_status, recorded_status, mp_amount_cents, recorded_amount_cents, resolved_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (mp_payment_id, kind) DO UPDATE SET
+			mp_status = EXCLUDED.mp_status,
+			recorded_status = EXCLUDED.recorded_status,
+			mp_amount_cents = EXCLUDED.mp_amount_cents,
+			recorded_amount_cents = EXCLUDED.recorded_amount_cents,
+			detected_at = NOW(),
+			resolved_at = EXCLUDED.resolved_at
+		RETURNING id, detected_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		issue.TenantID, issue.Kind, issue.MpPaymentID, issue.PaymentEventID, issue.PaymentDate,
+		issue.MpStatus, issue.RecordedStatus, issue.MpAmountCents, issue.RecordedAmountCents, issue.ResolvedAt,
+	).Scan(&issue.ID, &issue.DetectedAt)
+	if err != nil {
+		return fmt.Errorf("upsert reconciliation issue: %w", err)
+	}
+	return nil
+}
+
+// ResolveReconciliationIssues closes the open issues of a payment except
+// those of the kinds still found.
+func (r *Repository) ResolveReconciliationIssues(ctx context.Context, mpPaymentID string, stillFound []string) error {
+	query := `
+		UPDATE reconciliation_issues SET resolved_at = NOW()
+		WHERE mp_payment_id = $1 AND resolved_at IS NULL AND NOT (kind = ANY($2))`
+
+	if stillFound == nil {
+		stillFound = []string{}
+	}
+	if _, err := r.conn(ctx).Exec(ctx, query, mpPaymentID, stillFound); err != nil {
+		return fmt.Errorf("resolve reconciliation issues: %w", err)
+	}
+	return nil
+}
+
+// ListReconciliationIssues returns the tenant's issues, latest payments first.
+func (r *Repository) ListReconciliationIssues(ctx context.Context, tenantID uuid.UUID, f IssueFilter) ([]ReconciliationIssue, int64, error) {
+	where := " WHERE tenant_id = $1"
+	args := []interface{}{tenantID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where += fmt.Sprintf(" AND "+cond, len(args))
+	}
+
+	if f.Kind != "" {
+		add("kind = $%d", f.Kind)
+	}
+	switch f.Status {
+	case "open":
+		where += " AND resolved_at IS NULL"
+	case "resolved":
+		where += " AND resolved_at IS NOT NULL"
+	}
+	if f.From != "" {
+		add("payment_date >= $%d::date", f.From)
+	}
+	if f.To != "" {
+		add("payment_date <= $%d::date", f.To)
+	}
+
+	var total int64
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM reconciliation_issues"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count reconciliation issues: %w", err)
+	}
+
+	args = append(args, f.PerPage, (f.Page-1)*f.PerPage)
+	query := `SELECT ` + issueColumns + ` FROM reconciliation_issues` + where +
+		fmt.Sprintf(" ORDER BY payment_date DESC, detected_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list reconciliation issues: %w", err)
+	}
+	defer rows.Close()
+
+	var issues []ReconciliationIssue
+	for rows.Next() {
+		var i ReconciliationIssue
+		if err := rows.Scan(
+			&i.ID, &i.TenantID, &i.Kind, &i.MpPaymentID, &i.PaymentEventID, &i.PaymentDate,
+			&i.MpStatus, &i.RecordedStatus, &i.MpAmountCents, &i.RecordedAmountCents, &i.DetectedAt, &i.ResolvedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("scan reconciliation issue: %w", err)
+		}
+		issues = append(issues, i)
+	}
+
+	return issues, total, rows.Err()
+}
+
+// ============================================================
+// Mercado Pago accounts
+// ============================================================
+
+const mpAccountColumns = `tenant_id, mp_user_id, public_key, live_mode, scope, access_token_enc,
+	refresh_token_enc, expires_at, connected_at, refreshed_at`
+
+// UpsertMPAccount stores the account a tenant connected, replacing the one
+// it had. ErrAccountInUse means another tenant already connected it.
+func (r *Repository) UpsertMPAccount(ctx context.Context, a *MPAccount) error {
+	query := `
+		INSERT INTO mp_accounts (tenant_id, mp_user_id, public_key, live_mode, scope, access_token_enc,
+			refresh_token_enc, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			mp_user_id = EXCLUDED.mp_user_id,
+			public_key = EXCLUDED.public_key,
+			live_mode = EXCLUDED.live_mode,
+			scope = EXCLUDED.scope,
+			access_token_enc = EXCLUDED.access_token_enc,
+			refresh_token_enc = EXCLUDED.refresh_token_enc,
+			expires_at = EXCLUDED.expires_at,
+			connected_at = NOW(),
+			refreshed_at = NULL
+		RETURNING connected_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		a.TenantID, a.MpUserID, a.PublicKey, a.LiveMode, a.Scope, a.AccessTokenEnc,
+		a.RefreshTokenEnc, a.ExpiresAt,
+	).Scan(&a.ConnectedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrAccountInUse
+		}
+		return fmt.Errorf("upsert mp account: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetMPAccount(ctx context.Context, tenantID uuid.UUID) (*MPAccount, error) {
+	return r.getMPAccount(ctx, `SELECT `+mpAccountColumns+` FROM mp_accounts WHERE tenant_id = $1`, tenantID)
+}
+
+// GetMPAccountForUpdate locks the account until the transaction ends, so
+// only one instance refreshes its tokens.
+func (r *Repository) GetMPAccountForUpdate(ctx context.Context, tenantID uuid.UUID) (*MPAccount, error) {
+	return r.getMPAccount(ctx, `SELECT `+mpAccountColumns+` FROM mp_accounts WHERE tenant_id = $1 FOR UPDATE`, tenantID)
+}
+
+// GetMPAccountByUserID finds the tenant of a webhook by the MP user_id it
+// carries.
+func (r *Repository) GetMPAccountByUserID(ctx context.Context, mpUserID int64) (*MPAccount, error) {
+	return r.getMPAccount(ctx, `SELECT `+mpAccountColumns+` FROM mp_accounts WHERE mp_user_id = $1`, mpUserID)
+}
+
+func (r *Repository) getMPAccount(ctx context.Context, query string, arg any) (*MPAccount, error) {
+	var a MPAccount
+	err := r.conn(ctx).QueryRow(ctx, query, arg).Scan(
+		&a.TenantID, &a.MpUserID, &a.PublicKey, &a.LiveMode, &a.Scope, &a.AccessTokenEnc,
+		&a.RefreshTokenEnc, &a.ExpiresAt, &a.ConnectedAt, &a.RefreshedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, fmt.Errorf("get mp account: %w", err)
+	}
+	return &a, nil
 }
 
-// GetPastDueSubscriptions returns subscriptions that have been past_due for more than the given duration
-func (r *Repository) GetPastDueSubscriptions(ctx context.Context, olderThan time.Duration) ([]uuid.UUID, error) {
+// UpdateMPAccountTokens stores the tokens of a refresh.
+func (r *Repository) UpdateMPAccountTokens(ctx context.Context, a *MPAccount) error {
 	query := `
-		SELECT id FROM subscriptions
-		WHERE status = 'past_due' AND updated_at < NOW() - $1::interval`
+		UPDATE mp_accounts SET access_token_enc = $2, refresh_token_enc = $3, expires_at = $4, refreshed_at = NOW()
+		WHERE tenant_id = $1`
 
-	rows, err := r.db.Query(ctx, query, olderThan.String())
+	if _, err := r.conn(ctx).Exec(ctx, query, a.TenantID, a.AccessTokenEnc, a.RefreshTokenEnc, a.ExpiresAt); err != nil {
+		return fmt.Errorf("update mp account tokens: %w", err)
+	}
+	return nil
+}
+
+// DeleteMPAccount forgets the tenant's account. It refuses while the tenant
+// has preapprovals that are not cancelled: their charges, refunds and
+// cancellation need the account's tokens.
+func (r *Repository) DeleteMPAccount(ctx context.Context, tenantID uuid.UUID) error {
+	var live bool
+	err := r.conn(ctx).QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM subscriptions
+			WHERE tenant_id = $1 AND mp_subscription_id IS NOT NULL AND status <> 'cancelled'
+		)`, tenantID).Scan(&live)
 	if err != nil {
-		return nil, fmt.Errorf("get past due subscriptions: %w", err)
+		return fmt.Errorf("check mp subscriptions: %w", err)
+	}
+	if live {
+		return ErrAccountHasSubscriptions
+	}
+
+	tag, err := r.conn(ctx).Exec(ctx, `DELETE FROM mp_accounts WHERE tenant_id = $1`, tenantID)
+	if err != nil {
+		return fmt.Errorf("delete mp account: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+// ListMPAccountTenants returns the tenants with a connected account whose
+// token expires before the given time (all of them for the zero time).
+func (r *Repository) ListMPAccountTenants(ctx context.Context, expiringBefore time.Time) ([]uuid.UUID, error) {
+	query := `SELECT tenant_id FROM mp_accounts`
+	args := []interface{}{}
+	if !expiringBefore.IsZero() {
+		query += ` WHERE expires_at < $1`
+		args = append(args, expiringBefore)
+	}
+	query += ` ORDER BY connected_at`
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list mp accounts: %w", err)
 	}
 	defer rows.Close()
 
-	var ids []uuid.UUID
+	var tenants []uuid.UUID
 	for rows.Next() {
 		var id uuid.UUID
 		if err := rows.Scan(&id); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("scan mp account: %w", err)
+		}
+		tenants = append(tenants, id)
+	}
+	return tenants, rows.Err()
+}
+
+// ============================================================
+// Dunning
+// ============================================================
+
+// Defaults for tenants that never saved a dunning policy, as in the
+// dunning_policies column defaults.
+const defaultGraceDays = 7
+
+var defaultReminderDays = []int{1, 3, 5}
+
+// GetDunningPolicy returns the tenant's policy, or the defaults.
+func (r *Repository) GetDunningPolicy(ctx context.Context, tenantID uuid.UUID) (*DunningPolicy, error) {
+	query := `
+		SELECT tenant_id, grace_days, allow_washes_past_due, reminder_days, updated_at
+		FROM dunning_policies WHERE tenant_id = $1`
+
+	p := &DunningPolicy{}
+	err := r.conn(ctx).QueryRow(ctx, query, tenantID).Scan(
+		&p.TenantID, &p.GraceDays, &p.AllowWashesPastDue, &p.ReminderDays, &p.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &DunningPolicy{
+				TenantID:     tenantID,
+				GraceDays:    defaultGraceDays,
+				ReminderDays: append([]int(nil), defaultReminderDays...),
+			}, nil
 		}
-		ids = append(ids, id)
+		return nil, fmt.Errorf("get dunning policy: %w", err)
+	}
+	return p, nil
+}
+
+// UpsertDunningPolicy saves the tenant's policy.
+func (r *Repository) UpsertDunningPolicy(ctx context.Context, p *DunningPolicy) error {
+	query := `
+		INSERT INTO dunning_policies (tenant_id, grace_days, allow_washes_past_due, reminder_days)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			grace_days = EXCLUDED.grace_days,
+			allow_washes_past_due = EXCLUDED.allow_washes_past_due,
+			reminder_days = EXCLUDED.reminder_days,
+			updated_at = NOW()
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query, p.TenantID, p.GraceDays, p.AllowWashesPastDue, p.ReminderDays).Scan(&p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("upsert dunning policy: %w", err)
+	}
+	return nil
+}
+
+// RecordDunningNotification stores a notification of a past_due episode.
+// It returns false, and stores nothing, when that step was already sent.
+func (r *Repository) RecordDunningNotification(ctx context.Context, changeID uuid.UUID, n *DunningNotification) (bool, error) {
+	query := `
+		INSERT INTO dunning_notifications (tenant_id, subscription_id, past_due_change_id, kind, day, checkout_url)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (past_due_change_id, kind, day) DO NOTHING
+		RETURNING id, sent_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		n.TenantID, n.SubscriptionID, changeID, n.Kind, n.Day, n.CheckoutURL,
+	).Scan(&n.ID, &n.SentAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("record dunning notification: %w", err)
+	}
+	return true, nil
+}
+
+// DunningNotificationSent reports whether a step of a past_due episode was
+// already sent.
+func (r *Repository) DunningNotificationSent(ctx context.Context, changeID uuid.UUID, kind string, day int) (bool, error) {
+	var sent bool
+	err := r.conn(ctx).QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM dunning_notifications WHERE past_due_change_id = $1 AND kind = $2 AND day = $3)`,
+		changeID, kind, day,
+	).Scan(&sent)
+	if err != nil {
+		return false, fmt.Errorf("check dunning notification: %w", err)
+	}
+	return sent, nil
+}
+
+// GetDunningReport adds up the past_due episodes that started between from
+// and to (YYYY-MM-DD in the tenant's timezone, inclusive) by how they ended:
+// the next status change after past_due is active when the subscription was
+// paid and cancelled when it was lost.
+func (r *Repository) GetDunningReport(ctx context.Context, tenantID uuid.UUID, from, to string) (*DunningReport, error) {
+	query := `
+		SELECT CASE (SELECT n.to_status FROM subscription_status_history n
+		             WHERE n.subscription_id = h.subscription_id AND n.changed_at > h.changed_at
+		             ORDER BY n.changed_at LIMIT 1)
+		           WHEN 'active' THEN 'recovered'
+		           WHEN 'cancelled' THEN 'lost'
+		           ELSE 'open' END AS outcome,
+		       COUNT(*), COALESCE(SUM(p.price_cents), 0)
+		FROM subscription_status_history h
+		JOIN tenants t ON t.id = h.tenant_id
+		JOIN subscriptions s ON s.id = h.subscription_id
+		JOIN membership_plans p ON p.id = s.plan_id
+		WHERE h.tenant_id = $1 AND h.to_status = 'past_due'
+			AND h.changed_at >= ($2::date::timestamp AT TIME ZONE t.timezone)
+			AND h.changed_at < (($3::date + 1)::timestamp AT TIME ZONE t.timezone)
+		GROUP BY outcome`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("dunning report: %w", err)
+	}
+	defer rows.Close()
+
+	report := &DunningReport{From: from, To: to}
+	for rows.Next() {
+		var outcome string
+		var o DunningOutcome
+		if err := rows.Scan(&outcome, &o.Count, &o.AmountCents); err != nil {
+			return nil, fmt.Errorf("scan dunning report: %w", err)
+		}
+		switch outcome {
+		case "recovered":
+			report.Recovered = o
+		case "lost":
+			report.Lost = o
+		default:
+			report.Open = o
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if ended := report.Recovered.Count + report.Lost.Count; ended > 0 {
+		report.RecoveryRate = float64(report.Recovered.Count) / float64(ended)
 	}
-	return ids, nil
+	return report, nil
 }
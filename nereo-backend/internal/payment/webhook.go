@@ -40,6 +40,11 @@ func VerifyWebhookSignature(xSignature, xRequestID, dataID, secret string) error
 	return nil
 }
 
+// VerifyWebhook checks a notification with the application's webhook secret.
+func (c *MercadoPagoClient) VerifyWebhook(xSignature, xRequestID, dataID string) error {
+	return VerifyWebhookSignature(xSignature, xRequestID, dataID, c.webhookSecret)
+}
+
 func parseSignatureHeader(header string) map[string]string {
 	result := make(map[string]string)
 	for _, part := range strings.Split(header, ",") {
@@ -0,0 +1,594 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/membership"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/money"
+)
+
+const (
+	webhookPollInterval = 2 * time.Second
+	webhookTimeout      = 30 * time.Second
+	// A delivery still processing after this long belongs to a worker that
+	// died; it is claimed again.
+	webhookLockTimeout = 5 * time.Minute
+	// 30s, 1m, 2m ... capped at 6h: ten attempts span roughly 8 hours.
+	webhookMaxAttempts = 10
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+// permanentError marks a delivery that will never succeed (e.g. a payment
+// without a valid external_reference): it goes to dead without retries.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// WebhookProcessor applies the notifications stored in webhook_inbox. repo
+// must use the owner pool: the tenant is only known after fetching the
+// resource from Mercado Pago.
+type WebhookProcessor struct {
+	clients *Clients
+	repo    *Repository
+	members *membership.Repository // pauses of subscriptions paused or resumed from MP
+}
+
+func NewWebhookProcessor(clients *Clients, repo *Repository) *WebhookProcessor {
+	return &WebhookProcessor{clients: clients, repo: repo, members: membership.NewRepository(repo.db)}
+}
+
+// StartWebhookWorkers runs workers goroutines that drain the webhook inbox.
+// They use SKIP LOCKED, so several API instances can run them at once.
+func StartWebhookWorkers(processor *WebhookProcessor, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				if !processor.processNext() {
+					time.Sleep(webhookPollInterval)
+				}
+			}
+		}()
+	}
+
+	slog.Info("webhook workers started", "workers", workers, "max_attempts", webhookMaxAttempts)
+}
+
+// processNext handles one due delivery. It returns false when there was
+// nothing to do (or the queue could not be read) so the worker backs off.
+func (p *WebhookProcessor) processNext() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	d, err := p.repo.ClaimWebhook(ctx, webhookLockTimeout)
+	if err != nil {
+		if !errors.Is(err, ErrWebhookNotFound) {
+			slog.Error("webhook worker: failed to claim delivery", "error", err)
+		}
+		return false
+	}
+
+	logger := slog.Default().With("webhook_id", d.ID, "topic", d.Topic, "resource_id", d.ResourceID, "attempt", d.Attempts)
+
+	procErr := p.Process(ctx, d)
+
+	// The processing context may have timed out; the outcome is still saved.
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer saveCancel()
+
+	var perm *permanentError
+	switch {
+	case procErr == nil:
+		err = p.repo.MarkWebhookProcessed(saveCtx, d.ID)
+	case errors.As(procErr, &perm) || d.Attempts >= webhookMaxAttempts:
+		logger.Error("webhook delivery moved to dead letter", "error", procErr)
+		err = p.repo.MarkWebhookDead(saveCtx, d.ID, procErr.Error())
+	default:
+		next := time.Now().Add(webhookBackoff(d.Attempts))
+		logger.Warn("webhook delivery failed, retrying", "error", procErr, "next_attempt_at", next)
+		err = p.repo.MarkWebhookFailed(saveCtx, d.ID, next, procErr.Error())
+	}
+	if err != nil {
+		// Left in processing: claimed again once the lock times out.
+		logger.Error("webhook worker: failed to save delivery outcome", "error", err)
+	}
+	return true
+}
+
+// webhookBackoff is the delay before the attempt after the given one.
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// Process applies one delivery. A nil error means it is done, including
+// notifications that are deliberately ignored. The resource is fetched with
+// the account the notification's user_id belongs to, and may only touch the
+// data of that account's tenant.
+func (p *WebhookProcessor) Process(ctx context.Context, d *WebhookDelivery) error {
+	var body struct {
+		UserID json.Number `json:"user_id"` // a number, or a string in some notifications
+	}
+	_ = json.Unmarshal(d.Payload, &body)
+	mpUserID, _ := body.UserID.Int64()
+
+	client, accountTenant, err := p.clients.ForMPUser(ctx, mpUserID)
+	if err != nil {
+		return fmt.Errorf("get MP client of user %d: %w", mpUserID, err)
+	}
+
+	switch d.Topic {
+	case "payment":
+		return p.processPayment(ctx, client, accountTenant, d.ResourceID, "")
+	case "subscription_preapproval":
+		return p.processPreapproval(ctx, client, accountTenant, d.ResourceID)
+	case "subscription_authorized_payment":
+		return p.processAuthorizedPayment(ctx, client, accountTenant, d.ResourceID)
+	case "chargebacks":
+		return p.processChargeback(ctx, client, accountTenant, d.ResourceID)
+	default:
+		slog.Info("ignoring webhook type", "type", d.Topic)
+		return nil
+	}
+}
+
+// checkAccount returns a permanent error when a resource fetched from a
+// tenant's connected account points at another tenant's data, e.g. a
+// payment whose external_reference is another tenant's subscription. The
+// platform account (uuid.Nil) is ours and serves every tenant.
+func checkAccount(accountTenant, tenantID uuid.UUID) error {
+	if accountTenant != uuid.Nil && accountTenant != tenantID {
+		return permanent(fmt.Errorf("resource of tenant %s's account refers to tenant %s", accountTenant, tenantID))
+	}
+	return nil
+}
+
+// processPayment records a payment and moves the subscription accordingly.
+// preapprovalID is set for recurring charges, whose subscription is found
+// by mp_subscription_id when external_reference is missing. Notifications
+// of a payment already recorded update it instead.
+func (p *WebhookProcessor) processPayment(ctx context.Context, client PaymentProvider, accountTenant uuid.UUID, paymentID, preapprovalID string) error {
+	// Fetch payment details from MP
+	payment, err := client.GetPayment(ctx, paymentID)
+	if err != nil {
+		return fmt.Errorf("get payment from MP: %w", err)
+	}
+
+	existing, err := p.repo.GetPaymentEventByMPID(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if err := checkAccount(accountTenant, existing.TenantID); err != nil {
+			return err
+		}
+		return p.updateCharge(ctx, existing, payment)
+	}
+
+	_, err = p.recordPayment(ctx, payment, accountTenant, preapprovalID)
+	return err
+}
+
+// recordPayment stores the charge of an MP payment not recorded yet and
+// applies it to its subscription. It returns nil, nil when it was recorded
+// concurrently. accountTenant is the tenant of the account the payment was
+// fetched from, uuid.Nil for the platform account.
+func (p *WebhookProcessor) recordPayment(ctx context.Context, payment *PaymentInfo, accountTenant uuid.UUID, preapprovalID string) (*PaymentEvent, error) {
+	logger := slog.Default().With("mp_payment_id", payment.ID)
+
+	sub, err := p.findSubscription(ctx, accountTenant, payment.ExternalReference, preapprovalID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A payment in another currency cannot pay for the plan: its amount
+	// would be added to the plan's totals as if it were in theirs.
+	plan, err := p.repo.GetSubscriptionWithPlan(ctx, sub.TenantID, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := money.New(plan.PriceCents, plan.Currency).Check(payment.CurrencyID); err != nil {
+		return nil, permanent(fmt.Errorf("payment %d for subscription %s: %w", payment.ID, sub.ID, err))
+	}
+
+	rawPayload, _ := json.Marshal(payment)
+	mpID := fmt.Sprintf("%d", payment.ID)
+	event := &PaymentEvent{
+		TenantID:       sub.TenantID,
+		SubscriptionID: &sub.ID,
+		Source:         "mercadopago",
+		EventType:      EventCharge,
+		MpPaymentID:    &mpID,
+		Status:         payment.Status,
+		AmountCents:    payment.TransactionAmount.Cents(),
+		Currency:       plan.Currency,
+		RawPayload:     rawPayload,
+	}
+
+	if payment.Status == "pending" || payment.Status == "in_process" {
+		logger.Info("payment pending", "status", payment.Status)
+	}
+
+	// The event, the status change and the renewal commit together: a retry
+	// after a failed transition must not find the event and skip the payment.
+	err = database.RunInTx(ctx, p.repo.db, func(ctx context.Context) error {
+		if err := p.repo.CreatePaymentEvent(ctx, event); err != nil {
+			return err
+		}
+		return p.applyChargeStatus(ctx, sub, payment.Status)
+	})
+	if err != nil {
+		if errors.Is(err, ErrPaymentAlreadyProcessed) {
+			logger.Info("payment already processed (race condition)")
+			return nil, nil
+		}
+		return nil, err
+	}
+	return event, nil
+}
+
+// applyChargeStatus moves the subscription a charge is for: approved ->
+// active with a new period, rejected -> past_due. Other statuses leave it
+// as it is, and so does a rejection of an unpaid checkout: the payer can
+// try again until it expires.
+func (p *WebhookProcessor) applyChargeStatus(ctx context.Context, sub *SubscriptionRef, status string) error {
+	change := &membership.StatusChange{
+		TenantID:       sub.TenantID,
+		SubscriptionID: sub.ID,
+		ActorType:      membership.ActorMercadoPago,
+	}
+	switch status {
+	case "approved":
+		change.ToStatus, change.Reason = membership.StatusActive, membership.ReasonPaymentApproved
+	case "rejected":
+		change.ToStatus, change.Reason = membership.StatusPastDue, membership.ReasonPaymentRejected
+	default:
+		return nil
+	}
+
+	changed, err := p.repo.TransitionSubscription(ctx, change)
+	if err != nil {
+		if errors.Is(err, membership.ErrInvalidTransition) {
+			// e.g. a late approval for a subscription cancelled meanwhile
+			slog.Warn("ignoring subscription status change from payment", "error", err, "subscription_id", sub.ID)
+			return nil
+		}
+		return err
+	}
+	if changed {
+		slog.Info("subscription status updated via payment", "subscription_id", sub.ID, "to", change.ToStatus)
+	}
+
+	if change.ToStatus != membership.StatusActive {
+		return nil
+	}
+	if changed && (*change.FromStatus == membership.StatusPendingPayment || *change.FromStatus == membership.StatusPastDue) {
+		// The first period starts when the checkout is paid, not when it was
+		// created; a recovered subscription starts a new one when it is paid,
+		// with or without a preapproval.
+		return p.renewPeriod(ctx, sub)
+	}
+	return p.renewPreapprovalPeriod(ctx, sub)
+}
+
+// updateCharge applies a later notification of a recorded charge: a pending
+// payment that got approved or rejected, or refunds, whether made through
+// RefundPayment or from the MP panel.
+func (p *WebhookProcessor) updateCharge(ctx context.Context, recorded *PaymentEvent, payment *PaymentInfo) error {
+	logger := slog.Default().With("mp_payment_id", *recorded.MpPaymentID)
+
+	return database.RunInTx(ctx, p.repo.db, func(ctx context.Context) error {
+		// Waits for a RefundPayment request of this charge to commit.
+		charge, err := p.repo.GetPaymentEventForUpdate(ctx, recorded.TenantID, recorded.ID)
+		if err != nil {
+			return err
+		}
+
+		for i := range payment.Refunds {
+			err := p.repo.CreatePaymentEvent(ctx, refundEvent(charge, &payment.Refunds[i]))
+			if err != nil && !errors.Is(err, ErrPaymentAlreadyProcessed) {
+				return err
+			}
+		}
+		refunded, err := p.repo.GetRefundedCents(ctx, charge.ID)
+		if err != nil {
+			return err
+		}
+
+		status := chargeStatus(payment.Status, charge.AmountCents, refunded)
+		if status == charge.Status {
+			logger.Info("payment already processed, skipping")
+			return nil
+		}
+		if err := p.repo.UpdatePaymentEventStatus(ctx, charge.ID, status); err != nil {
+			return err
+		}
+		logger.Info("payment status updated", "from", charge.Status, "to", status)
+
+		switch {
+		case charge.SubscriptionID == nil:
+			return nil
+		case charge.Status == "pending" || charge.Status == "in_process":
+			return p.applyChargeStatus(ctx, &SubscriptionRef{ID: *charge.SubscriptionID, TenantID: charge.TenantID}, status)
+		case status == ChargeRefunded:
+			return pastDueAfterRefund(ctx, p.repo, charge, membership.ActorMercadoPago, nil)
+		}
+		return nil
+	})
+}
+
+// processChargeback records a chargeback against each charge it disputes and
+// moves their subscriptions to past_due. Chargebacks MP covers (the seller
+// keeps the money) are recorded without consequences.
+func (p *WebhookProcessor) processChargeback(ctx context.Context, client PaymentProvider, accountTenant uuid.UUID, chargebackID string) error {
+	logger := slog.Default().With("mp_chargeback_id", chargebackID)
+
+	info, err := client.GetChargeback(ctx, chargebackID)
+	if err != nil {
+		return fmt.Errorf("get chargeback from MP: %w", err)
+	}
+
+	charges := make([]*PaymentEvent, 0, len(info.Payments))
+	for _, paymentID := range info.Payments {
+		charge, err := p.repo.GetPaymentEventByMPID(ctx, fmt.Sprintf("%d", paymentID))
+		if err != nil {
+			return err
+		}
+		if charge == nil {
+			// The payment notification may still be on its way.
+			return fmt.Errorf("chargeback of payment %d, which is not recorded", paymentID)
+		}
+		if err := checkAccount(accountTenant, charge.TenantID); err != nil {
+			return err
+		}
+		charges = append(charges, charge)
+	}
+
+	rawPayload, _ := json.Marshal(info)
+	status := "opened"
+	if info.CoverageApplied {
+		status = "covered"
+	}
+
+	return database.RunInTx(ctx, p.repo.db, func(ctx context.Context) error {
+		for _, charge := range charges {
+			event := &PaymentEvent{
+				TenantID:       charge.TenantID,
+				SubscriptionID: charge.SubscriptionID,
+				Source:         "mercadopago",
+				EventType:      EventChargeback,
+				ParentEventID:  &charge.ID,
+				MpChargebackID: &info.ID,
+				Status:         status,
+				AmountCents:    charge.AmountCents,
+				Currency:       charge.Currency,
+				RawPayload:     rawPayload,
+			}
+			if err := p.repo.CreatePaymentEvent(ctx, event); err != nil {
+				if errors.Is(err, ErrPaymentAlreadyProcessed) {
+					logger.Info("chargeback already processed, skipping", "payment_id", charge.ID)
+					continue
+				}
+				return err
+			}
+			if err := p.repo.UpdatePaymentEventStatus(ctx, charge.ID, ChargeChargedBack); err != nil {
+				return err
+			}
+			logger.Info("chargeback recorded", "payment_id", charge.ID, "coverage_applied", info.CoverageApplied)
+
+			if info.CoverageApplied {
+				continue
+			}
+			err := pastDueAfterReversal(ctx, p.repo, charge, membership.ReasonChargeback, membership.ActorMercadoPago, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// renewPreapprovalPeriod starts a new period when an approved payment is a
+// recurring charge of the subscription's preapproval. Both the payment and
+// the subscription_authorized_payment notifications lead here; the payment
+// event makes sure only the first one renews.
+func (p *WebhookProcessor) renewPreapprovalPeriod(ctx context.Context, ref *SubscriptionRef) error {
+	sub, err := p.repo.GetSubscriptionWithPlan(ctx, ref.TenantID, ref.ID)
+	if err != nil {
+		return err
+	}
+	if sub.MpSubscriptionID == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := p.repo.RenewSubscription(ctx, ref.TenantID, ref.ID, now, nextPeriodEnd(now, sub.Interval)); err != nil {
+		return err
+	}
+	slog.Info("subscription period renewed via preapproval", "subscription_id", ref.ID)
+	return nil
+}
+
+// renewPeriod starts a new period of the subscription now, for a paid
+// checkout or a paid past_due subscription.
+func (p *WebhookProcessor) renewPeriod(ctx context.Context, ref *SubscriptionRef) error {
+	sub, err := p.repo.GetSubscriptionWithPlan(ctx, ref.TenantID, ref.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := p.repo.RenewSubscription(ctx, ref.TenantID, ref.ID, now, nextPeriodEnd(now, sub.Interval)); err != nil {
+		return err
+	}
+	slog.Info("subscription period started via payment", "subscription_id", ref.ID)
+	return nil
+}
+
+// processPreapproval mirrors the preapproval status on the subscription:
+// authorized -> active, paused -> paused, cancelled -> cancelled.
+func (p *WebhookProcessor) processPreapproval(ctx context.Context, client PaymentProvider, accountTenant uuid.UUID, preapprovalID string) error {
+	logger := slog.Default().With("mp_preapproval_id", preapprovalID)
+
+	info, err := client.GetPreapproval(ctx, preapprovalID)
+	if err != nil {
+		return fmt.Errorf("get preapproval from MP: %w", err)
+	}
+
+	ref, err := p.findSubscription(ctx, accountTenant, info.ExternalReference, preapprovalID)
+	if err != nil {
+		return err
+	}
+
+	change := &membership.StatusChange{
+		TenantID:       ref.TenantID,
+		SubscriptionID: ref.ID,
+		ActorType:      membership.ActorMercadoPago,
+	}
+	switch info.Status {
+	case "authorized":
+		change.ToStatus, change.Reason = membership.StatusActive, membership.ReasonPreapprovalAuthorized
+	case "paused":
+		change.ToStatus, change.Reason = membership.StatusPaused, membership.ReasonPreapprovalPaused
+	case "cancelled":
+		change.ToStatus, change.Reason = membership.StatusCancelled, membership.ReasonPreapprovalCancelled
+	default:
+		logger.Info("preapproval status update", "status", info.Status)
+	}
+
+	var changed bool
+	err = database.RunInTx(ctx, p.repo.db, func(ctx context.Context) error {
+		sub, err := p.members.GetSubscriptionForUpdate(ctx, ref.TenantID, ref.ID)
+		if err != nil {
+			return err
+		}
+		if sub.MpSubscriptionID == nil || *sub.MpSubscriptionID != preapprovalID {
+			if err := p.repo.UpdateSubscriptionMPID(ctx, sub.ID, preapprovalID); err != nil {
+				return fmt.Errorf("store preapproval id: %w", err)
+			}
+		}
+		if change.ToStatus == "" {
+			return nil
+		}
+
+		// Resumed from MP: close our pause so the period is extended as if
+		// it had been resumed here.
+		if change.ToStatus == membership.StatusActive && sub.Status == membership.StatusPaused {
+			if err := p.resumeOpenPause(ctx, sub); err != nil {
+				return err
+			}
+		}
+
+		changed, err = p.repo.TransitionSubscription(ctx, change)
+		if err != nil {
+			if errors.Is(err, membership.ErrInvalidTransition) {
+				logger.Warn("ignoring subscription status change from preapproval", "error", err)
+				return nil
+			}
+			return err
+		}
+
+		if change.ToStatus == membership.StatusCancelled {
+			return p.members.EndOpenPause(ctx, sub.TenantID, sub.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		logger.Info("subscription status updated via preapproval", "status", info.Status, "to", change.ToStatus)
+	}
+	return nil
+}
+
+func (p *WebhookProcessor) resumeOpenPause(ctx context.Context, sub *membership.Subscription) error {
+	pause, err := p.members.GetOpenPause(ctx, sub.TenantID, sub.ID)
+	if err != nil {
+		if errors.Is(err, membership.ErrPauseNotFound) {
+			return nil // paused from MP, there is no pause of ours
+		}
+		return err
+	}
+	if _, err := p.members.ResumeFromPause(ctx, sub.TenantID, pause.ID, nil); err != nil {
+		return fmt.Errorf("resume pause: %w", err)
+	}
+	return nil
+}
+
+// processAuthorizedPayment handles a recurring charge of a preapproval. The
+// charge itself is applied as a payment of that subscription.
+func (p *WebhookProcessor) processAuthorizedPayment(ctx context.Context, client PaymentProvider, accountTenant uuid.UUID, authorizedPaymentID string) error {
+	info, err := client.GetAuthorizedPayment(ctx, authorizedPaymentID)
+	if err != nil {
+		return fmt.Errorf("get authorized payment from MP: %w", err)
+	}
+	if info.Payment == nil || info.Payment.ID == 0 {
+		// Scheduled but not charged yet: MP notifies again once it is.
+		slog.Info("authorized payment without charge yet", "mp_authorized_payment_id", authorizedPaymentID, "status", info.Status)
+		return nil
+	}
+
+	return p.processPayment(ctx, client, accountTenant, fmt.Sprintf("%d", info.Payment.ID), info.PreapprovalID)
+}
+
+// findSubscription resolves the subscription of a notification, by
+// preapproval when known and otherwise by external_reference (our
+// subscription ID). Unknown subscriptions, and subscriptions of a tenant
+// other than the one of the account the notification came from, are
+// permanent failures.
+func (p *WebhookProcessor) findSubscription(ctx context.Context, accountTenant uuid.UUID, externalReference, preapprovalID string) (*SubscriptionRef, error) {
+	if preapprovalID != "" {
+		ref, err := p.repo.GetSubscriptionByPreapproval(ctx, preapprovalID)
+		if err == nil {
+			return ref, checkAccount(accountTenant, ref.TenantID)
+		}
+		if !errors.Is(err, ErrSubscriptionNotFound) {
+			return nil, err
+		}
+	}
+
+	subID, err := uuid.Parse(externalReference)
+	if err != nil {
+		return nil, permanent(fmt.Errorf("invalid external_reference %q", externalReference))
+	}
+	tenantID, err := p.repo.GetSubscriptionTenantID(ctx, subID)
+	if err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			return nil, permanent(fmt.Errorf("subscription %s: %w", subID, err))
+		}
+		return nil, err
+	}
+	if err := checkAccount(accountTenant, tenantID); err != nil {
+		return nil, err
+	}
+	return &SubscriptionRef{ID: subID, TenantID: tenantID}, nil
+}
+
+// nextPeriodEnd is the end of a billing period starting at start.
+func nextPeriodEnd(start time.Time, interval string) time.Time {
+	if interval == "weekly" {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 1, 0)
+}
@@ -1,7 +1,9 @@
 package tenant
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -9,12 +11,18 @@ import (
 	"github.com/nereo-ar/backend/pkg/httputil"
 )
 
+// EmailVerifier mails a user the link that verifies their email.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, userID uuid.UUID, email string) error
+}
+
 type Handler struct {
-	service *Service
+	service  *Service
+	verifier EmailVerifier
 }
 
-func NewHandler(service *Service) *Handler {
-	return &Handler{service: service}
+func NewHandler(service *Service, verifier EmailVerifier) *Handler {
+	return &Handler{service: service, verifier: verifier}
 }
 
 func (h *Handler) Register(c *gin.Context) {
@@ -34,6 +42,12 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
+	// The tenant exists either way: a failed mail is sent again from
+	// POST /auth/verify-email/resend.
+	if err := h.verifier.SendVerification(c.Request.Context(), resp.UserID, req.OwnerEmail); err != nil {
+		slog.Error("failed to send email verification", "error", err, "tenant_id", resp.Tenant.ID)
+	}
+
 	httputil.Created(c, resp)
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
 )
 
 var ErrNotFound = errors.New("tenant not found")
@@ -22,6 +23,11 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
 func (r *Repository) Create(ctx context.Context, t *Tenant) error {
 	settingsJSON, err := json.Marshal(t.Settings)
 	if err != nil {
@@ -33,7 +39,7 @@ func (r *Repository) Create(ctx context.Context, t *Tenant) error {
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING created_at, updated_at`
 
-	err = r.db.QueryRow(ctx, query,
+	err = r.conn(ctx).QueryRow(ctx, query,
 		t.ID, t.Name, t.Slug, t.OwnerEmail, t.Plan, t.Timezone, settingsJSON, t.BufferBetweenSlots, t.Active,
 	).Scan(&t.CreatedAt, &t.UpdatedAt)
 	if err != nil {
@@ -53,7 +59,7 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*Tenant, error)
 
 	t := &Tenant{}
 	var settingsJSON []byte
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, id).Scan(
 		&t.ID, &t.Name, &t.Slug, &t.OwnerEmail, &t.Plan, &t.Timezone,
 		&settingsJSON, &t.BufferBetweenSlots, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 	)
@@ -78,7 +84,7 @@ func (r *Repository) GetBySlug(ctx context.Context, slug string) (*Tenant, error
 
 	t := &Tenant{}
 	var settingsJSON []byte
-	err := r.db.QueryRow(ctx, query, slug).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, slug).Scan(
 		&t.ID, &t.Name, &t.Slug, &t.OwnerEmail, &t.Plan, &t.Timezone,
 		&settingsJSON, &t.BufferBetweenSlots, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 	)
@@ -119,7 +125,7 @@ func (r *Repository) UpdateSettings(ctx context.Context, id uuid.UUID, req Updat
 		WHERE id = $3
 		RETURNING updated_at`
 
-	err = r.db.QueryRow(ctx, query, settingsJSON, t.BufferBetweenSlots, id).Scan(&t.UpdatedAt)
+	err = r.conn(ctx).QueryRow(ctx, query, settingsJSON, t.BufferBetweenSlots, id).Scan(&t.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("update tenant settings: %w", err)
 	}
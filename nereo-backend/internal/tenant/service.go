@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nereo-ar/backend/internal/auth"
+	"github.com/nereo-ar/backend/pkg/database"
 )
 
 type Service struct {
@@ -22,12 +23,6 @@ func NewService(db *pgxpool.Pool) *Service {
 }
 
 func (s *Service) CreateTenantWithOwner(ctx context.Context, req CreateTenantRequest) (*CreateTenantResponse, error) {
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-	defer tx.Rollback(ctx)
-
 	tenantID := uuid.New()
 	t := &Tenant{
 		ID:                 tenantID,
@@ -41,12 +36,6 @@ func (s *Service) CreateTenantWithOwner(ctx context.Context, req CreateTenantReq
 		Active:             true,
 	}
 
-	// Insert tenant
-	err = s.repo.Create(ctx, t)
-	if err != nil {
-		return nil, err
-	}
-
 	// Hash password and create owner user
 	hash, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -54,17 +43,29 @@ func (s *Service) CreateTenantWithOwner(ctx context.Context, req CreateTenantReq
 	}
 
 	userID := uuid.New()
-	userQuery := `
-		INSERT INTO users (id, tenant_id, email, full_name, password_hash, role, active)
-		VALUES ($1, $2, $3, $4, $5, 'owner', true)`
+	err = database.RunInTx(ctx, s.db, func(ctx context.Context) error {
+		// Insert tenant
+		if err := s.repo.Create(ctx, t); err != nil {
+			return err
+		}
 
-	_, err = tx.Exec(ctx, userQuery, userID, tenantID, req.OwnerEmail, req.OwnerName, hash)
-	if err != nil {
-		return nil, fmt.Errorf("insert owner user: %w", err)
-	}
+		// users is under RLS: scope the transaction to the new tenant
+		tx, _ := database.TxFromContext(ctx)
+		if err := database.SetTenant(ctx, tx, tenantID); err != nil {
+			return err
+		}
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, fmt.Errorf("commit tx: %w", err)
+		userQuery := `
+			INSERT INTO users (id, tenant_id, email, full_name, password_hash, role, active)
+			VALUES ($1, $2, $3, $4, $5, 'owner', true)`
+
+		if _, err := tx.Exec(ctx, userQuery, userID, tenantID, req.OwnerEmail, req.OwnerName, hash); err != nil {
+			return fmt.Errorf("insert owner user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &CreateTenantResponse{Tenant: *t, UserID: userID}, nil
@@ -0,0 +1,156 @@
+package user
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nereo-ar/backend/internal/middleware"
+	"github.com/nereo-ar/backend/pkg/httputil"
+	"github.com/nereo-ar/backend/pkg/phone"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// List returns the tenant's staff. ?include_inactive=true adds deactivated
+// users.
+func (h *Handler) List(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	page, perPage := httputil.ParsePagination(c)
+
+	users, total, err := h.service.List(c.Request.Context(), tenantID, ListFilter{
+		IncludeInactive: c.Query("include_inactive") == "true",
+		Page:            page,
+		PerPage:         perPage,
+	})
+	if err != nil {
+		httputil.InternalError(c)
+		return
+	}
+
+	if users == nil {
+		users = []User{}
+	}
+
+	httputil.Paginated(c, users, page, perPage, total)
+}
+
+func (h *Handler) Invite(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID := c.MustGet(middleware.ContextUserID).(uuid.UUID)
+
+	var req InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	invitation, err := h.service.Invite(c.Request.Context(), tenantID, userID, req)
+	if err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			httputil.Conflict(c, "EMAIL_TAKEN", "a user with this email already exists")
+			return
+		}
+		if errors.Is(err, ErrInvitationNotSent) {
+			slog.Error("failed to send invitation", "error", err, "tenant_id", tenantID)
+			httputil.BadGateway(c, "MAIL_ERROR", "could not send the invitation email")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.Created(c, invitation)
+}
+
+// AcceptInvitation is public: the token identifies the tenant and the role.
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user, err := h.service.AcceptInvitation(c.Request.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, phone.ErrInvalidPhone):
+			httputil.BadRequest(c, "INVALID_PHONE", "phone must be a valid Argentine number")
+		case errors.Is(err, ErrInvitationNotFound):
+			httputil.NotFound(c, "invitation not found")
+		case errors.Is(err, ErrInvitationExpired):
+			httputil.BadRequest(c, "INVITATION_EXPIRED", "the invitation has expired, ask for a new one")
+		case errors.Is(err, ErrInvitationAccepted):
+			httputil.Conflict(c, "INVITATION_ACCEPTED", "the invitation has already been used")
+		case errors.Is(err, ErrEmailTaken):
+			httputil.Conflict(c, "EMAIL_TAKEN", "a user with this email already exists")
+		default:
+			httputil.InternalError(c)
+		}
+		return
+	}
+
+	httputil.Created(c, user)
+}
+
+func (h *Handler) ChangeRole(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid user id")
+		return
+	}
+
+	var req ChangeRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		httputil.BadRequest(c, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user, err := h.service.ChangeRole(c.Request.Context(), tenantID, userID, req)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.NotFound(c, "user not found")
+			return
+		}
+		if errors.Is(err, ErrLastOwner) {
+			httputil.Conflict(c, "LAST_OWNER", "the tenant must keep at least one active owner")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.OK(c, user)
+}
+
+func (h *Handler) Deactivate(c *gin.Context) {
+	tenantID := c.MustGet(middleware.ContextTenantID).(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		httputil.BadRequest(c, "INVALID_ID", "invalid user id")
+		return
+	}
+
+	if err := h.service.Deactivate(c.Request.Context(), tenantID, userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.NotFound(c, "user not found")
+			return
+		}
+		if errors.Is(err, ErrLastOwner) {
+			httputil.Conflict(c, "LAST_OWNER", "the tenant must keep at least one active owner")
+			return
+		}
+		httputil.InternalError(c)
+		return
+	}
+
+	httputil.NoContent(c)
+}
@@ -0,0 +1,65 @@
+package user
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles of the user_role enum.
+const (
+	RoleOwner    = "owner"
+	RoleManager  = "manager"
+	RoleEmployee = "employee"
+)
+
+// User is a staff member of a tenant. The password hash never leaves the
+// repository.
+type User struct {
+	ID              uuid.UUID  `json:"id"`
+	TenantID        uuid.UUID  `json:"tenant_id"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Phone           *string    `json:"phone,omitempty"`
+	FullName        string     `json:"full_name"`
+	Role            string     `json:"role"`
+	Active          bool       `json:"active"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Invitation lets someone join a tenant's staff with a given role.
+type Invitation struct {
+	ID         uuid.UUID  `json:"id"`
+	TenantID   uuid.UUID  `json:"tenant_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  uuid.UUID  `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	UserID     *uuid.UUID `json:"user_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+	Role  string `json:"role" binding:"required,oneof=owner manager employee"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string  `json:"token" binding:"required"`
+	FullName string  `json:"full_name" binding:"required,min=2,max=255"`
+	Password string  `json:"password" binding:"required,min=8"`
+	Phone    *string `json:"phone" binding:"omitempty,min=6,max=30"`
+}
+
+type ChangeRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner manager employee"`
+}
+
+// ListFilter narrows down GET /users.
+type ListFilter struct {
+	IncludeInactive bool
+	Page            int
+	PerPage         int
+}
@@ -0,0 +1,268 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/pkg/database"
+)
+
+var (
+	ErrNotFound           = errors.New("user not found")
+	ErrEmailTaken         = errors.New("email already registered for this tenant")
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+const userColumns = `id, tenant_id, email, email_verified_at, phone, full_name, role, active, created_at, updated_at`
+
+const invitationColumns = `id, tenant_id, email, role, invited_by, expires_at, accepted_at, user_id, created_at`
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// conn returns the request transaction from ctx, or the pool outside a request.
+func (r *Repository) conn(ctx context.Context) database.DBTX {
+	return database.Conn(ctx, r.db)
+}
+
+// ============================================================
+// Users
+// ============================================================
+
+func (r *Repository) CreateUser(ctx context.Context, u *User, passwordHash string) error {
+	query := `
+		INSERT INTO users (id, tenant_id, email, email_verified_at, phone, password_hash, full_name, role, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING created_at, updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query,
+		u.ID, u.TenantID, u.Email, u.EmailVerifiedAt, u.Phone, passwordHash, u.FullName, u.Role, u.Active,
+	).Scan(&u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("insert user: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetUser(ctx context.Context, tenantID, userID uuid.UUID) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND tenant_id = $2`
+
+	u, err := scanUser(r.conn(ctx).QueryRow(ctx, query, userID, tenantID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return u, nil
+}
+
+// EmailRegistered reports whether the tenant already has a user with email,
+// in any case.
+func (r *Repository) EmailRegistered(ctx context.Context, tenantID uuid.UUID, email string) (bool, error) {
+	var exists bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1 AND LOWER(email) = LOWER($2))",
+		tenantID, email,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check user email: %w", err)
+	}
+	return exists, nil
+}
+
+func (r *Repository) ListUsers(ctx context.Context, tenantID uuid.UUID, f ListFilter) ([]User, int64, error) {
+	where := " WHERE tenant_id = $1"
+	if !f.IncludeInactive {
+		where += " AND active = true"
+	}
+
+	var total int64
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM users"+where, tenantID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count users: %w", err)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users` + where + `
+		ORDER BY full_name ASC, created_at ASC LIMIT $2 OFFSET $3`
+
+	rows, err := r.conn(ctx).Query(ctx, query, tenantID, f.PerPage, (f.Page-1)*f.PerPage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+// LockActiveOwners locks the tenant's active owners until the end of the
+// transaction and returns their IDs, so two concurrent requests cannot each
+// demote or deactivate one of the last two owners.
+func (r *Repository) LockActiveOwners(ctx context.Context, tenantID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.conn(ctx).Query(ctx,
+		"SELECT id FROM users WHERE tenant_id = $1 AND role = 'owner' AND active = true ORDER BY id FOR UPDATE",
+		tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lock owners: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan owner: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *Repository) UpdateRole(ctx context.Context, u *User) error {
+	query := `
+		UPDATE users SET role = $1, updated_at = NOW()
+		WHERE id = $2 AND tenant_id = $3
+		RETURNING updated_at`
+
+	err := r.conn(ctx).QueryRow(ctx, query, u.Role, u.ID, u.TenantID).Scan(&u.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("update user role: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) Deactivate(ctx context.Context, tenantID, userID uuid.UUID) error {
+	tag, err := r.conn(ctx).Exec(ctx,
+		"UPDATE users SET active = false, updated_at = NOW() WHERE id = $1 AND tenant_id = $2",
+		userID, tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("deactivate user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ============================================================
+// Invitations
+// ============================================================
+
+// CreateInvitation stores inv, replacing any pending invitation of the same
+// email so only the newest token works.
+func (r *Repository) CreateInvitation(ctx context.Context, inv *Invitation, tokenHash string) error {
+	_, err := r.conn(ctx).Exec(ctx,
+		"DELETE FROM user_invitations WHERE tenant_id = $1 AND LOWER(email) = LOWER($2) AND accepted_at IS NULL",
+		inv.TenantID, inv.Email,
+	)
+	if err != nil {
+		return fmt.Errorf("replace pending invitation: %w", err)
+	}
+
+	query := `
+		INSERT INTO user_invitations (id, tenant_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+
+	err = r.conn(ctx).QueryRow(ctx, query,
+		inv.ID, inv.TenantID, inv.Email, inv.Role, tokenHash, inv.InvitedBy, inv.ExpiresAt,
+	).Scan(&inv.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert invitation: %w", err)
+	}
+	return nil
+}
+
+// TenantName returns the name the invitation mail shows.
+func (r *Repository) TenantName(ctx context.Context, tenantID uuid.UUID) (string, error) {
+	var name string
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT name FROM tenants WHERE id = $1", tenantID).Scan(&name); err != nil {
+		return "", fmt.Errorf("get tenant name: %w", err)
+	}
+	return name, nil
+}
+
+// GetInvitationByTokenHash finds an invitation from its token alone, before
+// the tenant is known: it must run on the owner pool. With forUpdate the row
+// is locked until the end of the transaction in ctx.
+func (r *Repository) GetInvitationByTokenHash(ctx context.Context, tokenHash string, forUpdate bool) (*Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM user_invitations WHERE token_hash = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	inv, err := scanInvitation(r.conn(ctx).QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, fmt.Errorf("get invitation: %w", err)
+	}
+	return inv, nil
+}
+
+func (r *Repository) MarkInvitationAccepted(ctx context.Context, inv *Invitation, userID uuid.UUID) error {
+	err := r.conn(ctx).QueryRow(ctx,
+		"UPDATE user_invitations SET accepted_at = NOW(), user_id = $1 WHERE id = $2 AND tenant_id = $3 RETURNING accepted_at",
+		userID, inv.ID, inv.TenantID,
+	).Scan(&inv.AcceptedAt)
+	if err != nil {
+		return fmt.Errorf("accept invitation: %w", err)
+	}
+	inv.UserID = &userID
+	return nil
+}
+
+func scanUser(row pgx.Row) (*User, error) {
+	u := &User{}
+	err := row.Scan(
+		&u.ID, &u.TenantID, &u.Email, &u.EmailVerifiedAt, &u.Phone, &u.FullName,
+		&u.Role, &u.Active, &u.CreatedAt, &u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func scanInvitation(row pgx.Row) (*Invitation, error) {
+	inv := &Invitation{}
+	err := row.Scan(
+		&inv.ID, &inv.TenantID, &inv.Email, &inv.Role, &inv.InvitedBy,
+		&inv.ExpiresAt, &inv.AcceptedAt, &inv.UserID, &inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
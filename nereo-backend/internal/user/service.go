@@ -0,0 +1,252 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nereo-ar/backend/internal/auth"
+	"github.com/nereo-ar/backend/pkg/database"
+	"github.com/nereo-ar/backend/pkg/mail"
+	"github.com/nereo-ar/backend/pkg/phone"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLastOwner          = errors.New("the tenant must keep at least one active owner")
+	ErrInvitationExpired  = errors.New("invitation expired")
+	ErrInvitationAccepted = errors.New("invitation already accepted")
+	ErrInvitationNotSent  = errors.New("invitation email not sent")
+)
+
+// invitationTTL is how long an invitation token can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+type Service struct {
+	repo   *Repository
+	system *Repository // owner pool, for invitation lookups before the tenant is known
+	db     *pgxpool.Pool
+	redis  *goredis.Client
+	mail   mail.Sender
+	appURL string // front-end base URL of the invitation links
+}
+
+func NewService(db, systemDB *pgxpool.Pool, redis *goredis.Client, sender mail.Sender, appURL string) *Service {
+	return &Service{
+		repo:   NewRepository(db),
+		system: NewRepository(systemDB),
+		db:     db,
+		redis:  redis,
+		mail:   sender,
+		appURL: appURL,
+	}
+}
+
+func (s *Service) List(ctx context.Context, tenantID uuid.UUID, f ListFilter) ([]User, int64, error) {
+	return s.repo.ListUsers(ctx, tenantID, f)
+}
+
+// ============================================================
+// Invitations
+// ============================================================
+
+// Invite mails email a one-time link to join the tenant with role. Only the
+// token's hash is stored, and it never goes through the inviter.
+func (s *Service) Invite(ctx context.Context, tenantID, invitedBy uuid.UUID, req InviteRequest) (*Invitation, error) {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	registered, err := s.repo.EmailRegistered(ctx, tenantID, email)
+	if err != nil {
+		return nil, err
+	}
+	if registered {
+		return nil, ErrEmailTaken
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Invitation{
+		ID:        uuid.New(),
+		TenantID:  tenantID,
+		Email:     email,
+		Role:      req.Role,
+		InvitedBy: invitedBy,
+		ExpiresAt: time.Now().Add(invitationTTL),
+	}
+	if err := s.repo.CreateInvitation(ctx, inv, hashToken(token)); err != nil {
+		return nil, err
+	}
+
+	tenantName, err := s.repo.TenantName(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Te invitaron a " + tenantName + " en Nereo",
+		Body: fmt.Sprintf("Hola,\n\n"+
+			"Te invitaron a sumarte al equipo de %s en Nereo.\n\n"+
+			"Elegí tu nombre y contraseña desde este link, que vence en 7 días:\n%s/accept-invitation?token=%s\n\n"+
+			"Si no esperabas esta invitación, ignorá este mail.\n",
+			tenantName, s.appURL, token),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvitationNotSent, err)
+	}
+
+	return inv, nil
+}
+
+// AcceptInvitation creates the invited user with the name and password they
+// chose. The invitation row is locked, so a token can only be used once. The
+// token was mailed to the invitee, so the email is verified.
+func (s *Service) AcceptInvitation(ctx context.Context, req AcceptInvitationRequest) (*User, error) {
+	var normalizedPhone *string
+	if req.Phone != nil {
+		normalized, err := phone.NormalizePhoneAR(*req.Phone)
+		if err != nil {
+			return nil, err
+		}
+		normalizedPhone = &normalized
+	}
+
+	tokenHash := hashToken(req.Token)
+	found, err := s.system.GetInvitationByTokenHash(ctx, tokenHash, false)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	u := &User{
+		ID:       uuid.New(),
+		TenantID: found.TenantID,
+		Phone:    normalizedPhone,
+		FullName: strings.TrimSpace(req.FullName),
+		Active:   true,
+	}
+	err = database.RunInTenantTx(ctx, s.db, found.TenantID, func(ctx context.Context) error {
+		inv, err := s.repo.GetInvitationByTokenHash(ctx, tokenHash, true)
+		if err != nil {
+			return err
+		}
+		if inv.AcceptedAt != nil {
+			return ErrInvitationAccepted
+		}
+		if time.Now().After(inv.ExpiresAt) {
+			return ErrInvitationExpired
+		}
+
+		now := time.Now()
+		u.Email = inv.Email
+		u.EmailVerifiedAt = &now
+		u.Role = inv.Role
+		if err := s.repo.CreateUser(ctx, u, hash); err != nil {
+			return err
+		}
+		return s.repo.MarkInvitationAccepted(ctx, inv, u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// ============================================================
+// Roles and deactivation
+// ============================================================
+
+// ChangeRole moves a user to another role. Refresh tokens carry the role, so
+// all the user's sessions are revoked: they log in again to get the new one.
+func (s *Service) ChangeRole(ctx context.Context, tenantID, userID uuid.UUID, req ChangeRoleRequest) (*User, error) {
+	var u *User
+	err := database.RunInTx(ctx, s.db, func(ctx context.Context) error {
+		owners, err := s.repo.LockActiveOwners(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+
+		u, err = s.repo.GetUser(ctx, tenantID, userID)
+		if err != nil {
+			return err
+		}
+		if u.Role == req.Role {
+			return nil
+		}
+		if isLastOwner(u, owners) {
+			return ErrLastOwner
+		}
+
+		u.Role = req.Role
+		if err := s.repo.UpdateRole(ctx, u); err != nil {
+			return err
+		}
+		return auth.RevokeAllSessions(ctx, s.redis, u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Deactivate disables a user's login and revokes all their sessions, so
+// they are signed out once their current access token expires. Deactivating
+// an inactive user is a no-op.
+func (s *Service) Deactivate(ctx context.Context, tenantID, userID uuid.UUID) error {
+	return database.RunInTx(ctx, s.db, func(ctx context.Context) error {
+		owners, err := s.repo.LockActiveOwners(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+
+		u, err := s.repo.GetUser(ctx, tenantID, userID)
+		if err != nil {
+			return err
+		}
+		if !u.Active {
+			return nil
+		}
+		if isLastOwner(u, owners) {
+			return ErrLastOwner
+		}
+
+		if err := s.repo.Deactivate(ctx, tenantID, userID); err != nil {
+			return err
+		}
+		return auth.RevokeAllSessions(ctx, s.redis, u.ID)
+	})
+}
+
+// isLastOwner reports whether u is the only active owner left.
+func isLastOwner(u *User, activeOwners []uuid.UUID) bool {
+	return u.Role == RoleOwner && u.Active && len(activeOwners) <= 1
+}
+
+func newInvitationToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate invitation token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
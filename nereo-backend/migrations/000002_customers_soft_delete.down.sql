@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS idx_customers_plate;
+DROP INDEX IF EXISTS idx_customers_tenant_active;
+DROP INDEX IF EXISTS idx_customers_phone;
+
+-- Phones become unique among all customers again. Deleted customers whose
+-- phone was registered again cannot be removed (bookings and payments point
+-- to them): their phone gets a suffix instead. The active customer, or else
+-- the most recently deleted one, keeps it.
+UPDATE customers c
+SET phone = LEFT(c.phone, 21) || '~' || LEFT(c.id::text, 8)
+FROM (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY tenant_id, phone ORDER BY deleted_at DESC NULLS FIRST, created_at DESC
+    ) AS position
+    FROM customers
+) ranked
+WHERE c.id = ranked.id AND ranked.position > 1;
+
+ALTER TABLE customers DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE customers ADD CONSTRAINT customers_tenant_id_phone_key UNIQUE (tenant_id, phone);
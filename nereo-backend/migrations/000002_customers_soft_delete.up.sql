@@ -0,0 +1,11 @@
+-- ============================================================
+-- CUSTOMERS: soft-delete + search indexes
+-- ============================================================
+ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- A deleted customer's phone can be registered again.
+ALTER TABLE customers DROP CONSTRAINT customers_tenant_id_phone_key;
+CREATE UNIQUE INDEX idx_customers_phone ON customers(tenant_id, phone) WHERE deleted_at IS NULL;
+
+CREATE INDEX idx_customers_tenant_active ON customers(tenant_id, created_at DESC) WHERE deleted_at IS NULL;
+CREATE INDEX idx_customers_plate ON customers(tenant_id, vehicle_plate);
@@ -0,0 +1,7 @@
+ALTER DEFAULT PRIVILEGES IN SCHEMA public
+    REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM nereo_app;
+
+REVOKE SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public FROM nereo_app;
+REVOKE USAGE ON SCHEMA public FROM nereo_app;
+
+DROP ROLE IF EXISTS nereo_app;
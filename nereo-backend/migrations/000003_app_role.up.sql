@@ -0,0 +1,22 @@
+-- ============================================================
+-- APPLICATION ROLE
+-- ============================================================
+-- RLS policies are not applied to the table owner. The API connects with a
+-- login role that is a member of nereo_app (see APP_DATABASE_URL), which owns
+-- nothing, so every query is filtered by app.current_tenant.
+-- Migrations, webhooks and crons keep using DATABASE_URL (the owner).
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'nereo_app') THEN
+        CREATE ROLE nereo_app NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT USAGE ON SCHEMA public TO nereo_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO nereo_app;
+REVOKE ALL ON schema_migrations FROM nereo_app;
+
+-- Tables created by later migrations are granted automatically
+ALTER DEFAULT PRIVILEGES IN SCHEMA public
+    GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO nereo_app;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS bookings;
+DROP TABLE IF EXISTS wash_box_services;
+DROP TABLE IF EXISTS wash_boxes;
+DROP TABLE IF EXISTS services;
+
+DROP TYPE IF EXISTS booking_status;
@@ -0,0 +1,87 @@
+-- ============================================================
+-- SERVICES (catalog of wash services per tenant)
+-- ============================================================
+CREATE TABLE services (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id        UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name             VARCHAR(255) NOT NULL,
+    description      TEXT,
+    duration_minutes INTEGER NOT NULL CHECK (duration_minutes > 0),
+    base_price_cents INTEGER NOT NULL CHECK (base_price_cents >= 0),
+    active           BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE services ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON services
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_services_tenant ON services(tenant_id);
+
+-- ============================================================
+-- WASH BOXES
+-- ============================================================
+CREATE TABLE wash_boxes (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id  UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name       VARCHAR(100) NOT NULL,
+    active     BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE wash_boxes ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON wash_boxes
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_wash_boxes_tenant ON wash_boxes(tenant_id);
+
+-- ============================================================
+-- WASH BOX SERVICES (which services each box offers)
+-- ============================================================
+-- tenant_id is denormalized so the table can be covered by RLS too.
+CREATE TABLE wash_box_services (
+    tenant_id  UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    box_id     UUID NOT NULL REFERENCES wash_boxes(id) ON DELETE CASCADE,
+    service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    PRIMARY KEY (box_id, service_id)
+);
+
+ALTER TABLE wash_box_services ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON wash_box_services
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+-- ============================================================
+-- BOOKINGS
+-- ============================================================
+CREATE TYPE booking_status AS ENUM ('confirmed', 'in_progress', 'completed', 'cancelled', 'no_show');
+
+CREATE TABLE bookings (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id       UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    customer_id     UUID NOT NULL REFERENCES customers(id),
+    box_id          UUID NOT NULL REFERENCES wash_boxes(id),
+    service_id      UUID NOT NULL REFERENCES services(id),
+    subscription_id UUID REFERENCES subscriptions(id),
+    starts_at       TIMESTAMPTZ NOT NULL,
+    ends_at         TIMESTAMPTZ NOT NULL, -- starts_at + duration_minutes + buffer_between_slots
+    status          booking_status NOT NULL DEFAULT 'confirmed',
+    notes           TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at),
+    -- Anti-overbooking: the range already includes the buffer. Cancelled and
+    -- no-show bookings release their slot.
+    CONSTRAINT bookings_no_overlap EXCLUDE USING gist (
+        box_id WITH =,
+        tstzrange(starts_at, ends_at) WITH &&
+    ) WHERE (status NOT IN ('cancelled', 'no_show'))
+);
+
+ALTER TABLE bookings ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON bookings
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_bookings_box_time ON bookings(tenant_id, box_id, starts_at, ends_at);
+CREATE INDEX idx_bookings_customer ON bookings(tenant_id, customer_id);
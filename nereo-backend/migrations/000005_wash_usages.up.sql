@@ -0,0 +1,32 @@
+-- ============================================================
+-- WASH USAGES (ledger of washes consumed by a subscription)
+-- ============================================================
+-- subscriptions.washes_used is the counter for the current period; this table
+-- is the audit trail behind it. Voided rows are kept and give the wash back
+-- only if they belong to the current period.
+CREATE TABLE wash_usages (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id       UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+    customer_id     UUID NOT NULL REFERENCES customers(id),
+    booking_id      UUID REFERENCES bookings(id),
+    box_id          UUID REFERENCES wash_boxes(id),
+    vehicle_plate   VARCHAR(20),
+    notes           TEXT,
+    period_start    TIMESTAMPTZ NOT NULL, -- subscription period the wash counted against
+    recorded_by     UUID REFERENCES users(id),
+    used_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    voided_at       TIMESTAMPTZ,
+    voided_by       UUID REFERENCES users(id),
+    void_reason     TEXT
+);
+
+ALTER TABLE wash_usages ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON wash_usages
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_wash_usages_subscription ON wash_usages(tenant_id, subscription_id, used_at DESC);
+
+-- A booking can be checked in only once
+CREATE UNIQUE INDEX idx_wash_usages_booking ON wash_usages(booking_id)
+    WHERE booking_id IS NOT NULL AND voided_at IS NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS qr_signing_keys;
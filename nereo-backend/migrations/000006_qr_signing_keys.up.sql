@@ -0,0 +1,21 @@
+-- ============================================================
+-- QR SIGNING KEYS (per-tenant Ed25519 keys for membership cards)
+-- ============================================================
+-- Only the version is stored: the key pair is derived from QR_SIGNING_SECRET,
+-- the tenant ID and the version, so no private key material lives in the
+-- database. Rotating inserts a new version and retires the previous one.
+CREATE TABLE qr_signing_keys (
+    tenant_id  UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    version    INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    retired_at TIMESTAMPTZ,
+    PRIMARY KEY (tenant_id, version)
+);
+
+ALTER TABLE qr_signing_keys ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON qr_signing_keys
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+-- At most one active key per tenant
+CREATE UNIQUE INDEX idx_qr_signing_keys_active ON qr_signing_keys(tenant_id)
+    WHERE retired_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS subscription_pauses;
+ALTER TABLE membership_plans DROP COLUMN IF EXISTS max_pause_days;
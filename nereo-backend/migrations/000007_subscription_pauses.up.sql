@@ -0,0 +1,32 @@
+-- ============================================================
+-- SUBSCRIPTION PAUSES
+-- ============================================================
+-- 0 disables pausing for the plan.
+ALTER TABLE membership_plans ADD COLUMN max_pause_days INTEGER NOT NULL DEFAULT 30
+    CHECK (max_pause_days >= 0);
+
+-- One row per pause. While resumed_at is NULL the subscription is paused;
+-- on resume current_period_end is pushed forward by the paused time, capped
+-- at resume_by.
+CREATE TABLE subscription_pauses (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id       UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+    reason          TEXT,
+    paused_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resume_by       TIMESTAMPTZ NOT NULL, -- paused_at + the allowed days; the cron resumes it then
+    resumed_at      TIMESTAMPTZ,
+    paused_by       UUID REFERENCES users(id),
+    resumed_by      UUID REFERENCES users(id), -- NULL when resumed by the cron
+    CHECK (resume_by > paused_at)
+);
+
+ALTER TABLE subscription_pauses ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON subscription_pauses
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_subscription_pauses_subscription ON subscription_pauses(tenant_id, subscription_id, paused_at DESC);
+
+-- At most one open pause per subscription
+CREATE UNIQUE INDEX idx_subscription_pauses_open ON subscription_pauses(subscription_id)
+    WHERE resumed_at IS NULL;
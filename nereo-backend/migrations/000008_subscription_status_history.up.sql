@@ -0,0 +1,28 @@
+-- ============================================================
+-- SUBSCRIPTION STATUS HISTORY
+-- ============================================================
+-- Every status change goes through membership.TransitionStatus, which checks
+-- the state machine and appends a row here in the same transaction.
+CREATE TABLE subscription_status_history (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id       UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+    from_status     subscription_status, -- NULL when the subscription is created
+    to_status       subscription_status NOT NULL,
+    reason          VARCHAR(50) NOT NULL,
+    actor_type      VARCHAR(20) NOT NULL, -- user | system | mercadopago
+    actor_id        UUID REFERENCES users(id),
+    changed_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE subscription_status_history ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON subscription_status_history
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+CREATE INDEX idx_subscription_status_history_subscription
+    ON subscription_status_history(tenant_id, subscription_id, changed_at DESC);
+
+-- Existing subscriptions start their history with the status they have now.
+INSERT INTO subscription_status_history (tenant_id, subscription_id, from_status, to_status, reason, actor_type, changed_at)
+SELECT tenant_id, id, NULL, status, 'backfill', 'system', updated_at
+FROM subscriptions;
@@ -0,0 +1,36 @@
+-- ============================================================
+-- WEBHOOK INBOX (durable queue of provider notifications)
+-- ============================================================
+-- Notifications are stored here before Mercado Pago gets its 200 and are
+-- processed by a worker pool with retries. Not tenant-scoped: the tenant is
+-- only known once the notification is processed, so only the owner role
+-- (webhooks, workers, admin endpoints) can touch it.
+CREATE TABLE webhook_inbox (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider        VARCHAR(30) NOT NULL DEFAULT 'mercadopago',
+    topic           VARCHAR(100) NOT NULL,          -- payment, subscription_preapproval, ...
+    action          VARCHAR(100),                   -- payment.created, payment.updated, ...
+    resource_id     VARCHAR(255) NOT NULL,          -- data.id
+    request_id      VARCHAR(255),                   -- x-request-id, repeated when MP redelivers
+    payload         JSONB NOT NULL DEFAULT '{}',
+    status          VARCHAR(20) NOT NULL DEFAULT 'pending'
+                    CHECK (status IN ('pending', 'processing', 'processed', 'failed', 'dead')),
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_at       TIMESTAMPTZ,
+    last_error      TEXT,
+    received_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at    TIMESTAMPTZ
+);
+
+-- Redeliveries of the same notification are stored once
+CREATE UNIQUE INDEX idx_webhook_inbox_request ON webhook_inbox(provider, request_id)
+    WHERE request_id IS NOT NULL;
+
+-- Work queue: what the workers poll
+CREATE INDEX idx_webhook_inbox_due ON webhook_inbox(next_attempt_at)
+    WHERE status IN ('pending', 'failed', 'processing');
+
+CREATE INDEX idx_webhook_inbox_status ON webhook_inbox(status, received_at DESC);
+
+REVOKE ALL ON webhook_inbox FROM nereo_app;
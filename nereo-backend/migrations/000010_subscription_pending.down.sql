@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_subscriptions_mp_subscription;
+
+-- Enum values cannot be dropped: pending subscriptions are cancelled and the
+-- value stays unused in subscription_status.
+UPDATE subscriptions SET status = 'cancelled', updated_at = NOW() WHERE status = 'pending';
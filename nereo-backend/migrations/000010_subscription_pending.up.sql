@@ -0,0 +1,11 @@
+-- ============================================================
+-- PENDING SUBSCRIPTIONS (Mercado Pago preapproval not authorized yet)
+-- ============================================================
+-- Subscriptions created through a preapproval start as pending and become
+-- active when the payer authorizes it. ADD VALUE cannot be used in the same
+-- transaction, so nothing else in this migration refers to 'pending'.
+ALTER TYPE subscription_status ADD VALUE IF NOT EXISTS 'pending' BEFORE 'active';
+
+-- Preapproval webhooks look the subscription up by its preapproval ID
+CREATE UNIQUE INDEX idx_subscriptions_mp_subscription ON subscriptions(mp_subscription_id)
+    WHERE mp_subscription_id IS NOT NULL;
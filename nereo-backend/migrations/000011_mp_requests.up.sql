@@ -0,0 +1,25 @@
+-- ============================================================
+-- MERCADO PAGO REQUESTS (idempotent checkout creation)
+-- ============================================================
+-- A POST /payments/preference or /payments/subscription sent with an
+-- Idempotency-Key header is stored here with the MP object it created, so a
+-- repeated request from the front end gets the same object back instead of
+-- a second preference or preapproval. The row is inserted before calling MP
+-- and completed in the same request transaction.
+CREATE TABLE mp_requests (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id          UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    operation          VARCHAR(30) NOT NULL,  -- preference | preapproval
+    idempotency_key    VARCHAR(255) NOT NULL, -- Idempotency-Key sent by our client
+    request_hash       VARCHAR(64) NOT NULL,  -- SHA-256 of the request body
+    mp_idempotency_key VARCHAR(255),          -- X-Idempotency-Key sent to MP
+    subscription_id    UUID,                  -- external_reference
+    mp_id              VARCHAR(255),
+    response           JSONB,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, operation, idempotency_key)
+);
+
+ALTER TABLE mp_requests ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON mp_requests
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
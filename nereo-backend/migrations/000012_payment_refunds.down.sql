@@ -0,0 +1,12 @@
+DELETE FROM payment_events WHERE event_type <> 'charge';
+
+DROP INDEX IF EXISTS idx_payment_events_mp_chargeback;
+DROP INDEX IF EXISTS idx_payment_events_mp_refund;
+DROP INDEX IF EXISTS idx_payment_events_parent;
+
+ALTER TABLE payment_events
+    DROP CONSTRAINT IF EXISTS payment_events_parent_check,
+    DROP COLUMN IF EXISTS mp_chargeback_id,
+    DROP COLUMN IF EXISTS mp_refund_id,
+    DROP COLUMN IF EXISTS parent_event_id,
+    DROP COLUMN IF EXISTS event_type;
@@ -0,0 +1,23 @@
+-- ============================================================
+-- REFUNDS AND CHARGEBACKS
+-- ============================================================
+-- Refunds and chargebacks are payment_events of their own, linked to the
+-- charge they reverse. mp_payment_id stays on the charge only (it is unique);
+-- the reversals carry the MP refund or chargeback ID instead.
+ALTER TABLE payment_events
+    ADD COLUMN event_type       VARCHAR(20) NOT NULL DEFAULT 'charge'
+                                CHECK (event_type IN ('charge', 'refund', 'chargeback')),
+    ADD COLUMN parent_event_id  UUID REFERENCES payment_events(id),
+    ADD COLUMN mp_refund_id     VARCHAR(255),
+    ADD COLUMN mp_chargeback_id VARCHAR(255),
+    ADD CONSTRAINT payment_events_parent_check
+        CHECK ((event_type = 'charge') = (parent_event_id IS NULL));
+
+CREATE INDEX idx_payment_events_parent ON payment_events(parent_event_id)
+    WHERE parent_event_id IS NOT NULL;
+
+-- Redelivered webhooks must not record the same reversal twice
+CREATE UNIQUE INDEX idx_payment_events_mp_refund ON payment_events(mp_refund_id)
+    WHERE mp_refund_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_payment_events_mp_chargeback ON payment_events(mp_chargeback_id, parent_event_id)
+    WHERE mp_chargeback_id IS NOT NULL;
@@ -0,0 +1,29 @@
+-- ============================================================
+-- RECONCILIATION ISSUES
+-- ============================================================
+-- The nightly reconciliation compares each MP payment of the day with its
+-- payment_events charge. Charges it had to insert (lost webhooks) are
+-- recorded already resolved; amount or status mismatches stay open until a
+-- later run finds them matching.
+CREATE TABLE reconciliation_issues (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id             UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    kind                  VARCHAR(30) NOT NULL
+                          CHECK (kind IN ('missing_event', 'amount_mismatch', 'status_mismatch')),
+    mp_payment_id         VARCHAR(255) NOT NULL,
+    payment_event_id      UUID REFERENCES payment_events(id),
+    payment_date          DATE NOT NULL,         -- day of the MP payment that was reconciled
+    mp_status             VARCHAR(50) NOT NULL,  -- refunds taken into account, like payment_events.status
+    recorded_status       VARCHAR(50),
+    mp_amount_cents       INTEGER NOT NULL,
+    recorded_amount_cents INTEGER,
+    detected_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at           TIMESTAMPTZ,
+    UNIQUE (mp_payment_id, kind)
+);
+
+CREATE INDEX idx_reconciliation_issues_tenant ON reconciliation_issues(tenant_id, payment_date DESC);
+
+ALTER TABLE reconciliation_issues ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON reconciliation_issues
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
@@ -0,0 +1,24 @@
+-- ============================================================
+-- MERCADO PAGO ACCOUNTS (per-tenant OAuth, marketplace mode)
+-- ============================================================
+-- Each tenant connects its own MP account so its customers pay into it.
+-- Tokens are stored encrypted with AES-256-GCM (pkg/sealbox); access tokens
+-- last 180 days and are refreshed before they expire. mp_user_id is unique:
+-- webhooks are routed to the tenant by the user_id they carry.
+CREATE TABLE mp_accounts (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id         UUID NOT NULL UNIQUE REFERENCES tenants(id) ON DELETE CASCADE,
+    mp_user_id        BIGINT NOT NULL UNIQUE,
+    public_key        VARCHAR(255),
+    live_mode         BOOLEAN NOT NULL DEFAULT FALSE,
+    scope             VARCHAR(255),
+    access_token_enc  BYTEA NOT NULL,
+    refresh_token_enc BYTEA NOT NULL,
+    expires_at        TIMESTAMPTZ NOT NULL,
+    connected_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    refreshed_at      TIMESTAMPTZ
+);
+
+ALTER TABLE mp_accounts ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON mp_accounts
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
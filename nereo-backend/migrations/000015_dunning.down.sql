@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dunning_notifications;
+DROP TABLE IF EXISTS dunning_policies;
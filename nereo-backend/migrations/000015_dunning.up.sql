@@ -0,0 +1,40 @@
+-- ============================================================
+-- DUNNING (failed recurring charges)
+-- ============================================================
+-- A tenant's policy for past_due subscriptions. Tenants without a row get
+-- the defaults, which match the previous fixed behaviour: cancelled after
+-- 7 days, no washes meanwhile.
+CREATE TABLE dunning_policies (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id             UUID NOT NULL UNIQUE REFERENCES tenants(id) ON DELETE CASCADE,
+    grace_days            INTEGER NOT NULL DEFAULT 7 CHECK (grace_days BETWEEN 1 AND 60),
+    allow_washes_past_due BOOLEAN NOT NULL DEFAULT FALSE,
+    reminder_days         INTEGER[] NOT NULL DEFAULT '{1,3,5}', -- days after the charge failed
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE dunning_policies ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON dunning_policies
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
+
+-- Notifications sent for a past_due episode, which is identified by the
+-- status change that made the subscription past_due. The unique key keeps
+-- the hourly dunning cron from sending the same step twice.
+CREATE TABLE dunning_notifications (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id          UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    subscription_id    UUID NOT NULL REFERENCES subscriptions(id),
+    past_due_change_id UUID NOT NULL REFERENCES subscription_status_history(id),
+    kind               VARCHAR(20) NOT NULL
+                       CHECK (kind IN ('payment_failed', 'reminder', 'cancelled')),
+    day                INTEGER NOT NULL DEFAULT 0, -- days past_due when it was due
+    checkout_url       TEXT,
+    sent_at            TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (past_due_change_id, kind, day)
+);
+
+CREATE INDEX idx_dunning_notifications_subscription ON dunning_notifications(tenant_id, subscription_id, sent_at DESC);
+
+ALTER TABLE dunning_notifications ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON dunning_notifications
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
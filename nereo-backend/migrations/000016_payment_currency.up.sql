@@ -0,0 +1,14 @@
+-- ============================================================
+-- PAYMENT CURRENCY
+-- ============================================================
+-- Amounts are cents of a currency: a payment records the currency it was
+-- made in, which must be its plan's. Existing payments were all made in
+-- their plan's currency.
+ALTER TABLE payment_events
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'ARS';
+
+UPDATE payment_events pe
+SET currency = p.currency
+FROM subscriptions s
+JOIN membership_plans p ON p.id = s.plan_id
+WHERE s.id = pe.subscription_id AND pe.currency <> p.currency;
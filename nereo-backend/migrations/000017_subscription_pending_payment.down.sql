@@ -0,0 +1,3 @@
+-- Enum values cannot be dropped: unpaid checkouts are cancelled and the value
+-- stays unused in subscription_status.
+UPDATE subscriptions SET status = 'cancelled', updated_at = NOW() WHERE status = 'pending_payment';
@@ -0,0 +1,9 @@
+-- ============================================================
+-- PENDING PAYMENT SUBSCRIPTIONS (Checkout Pro preference not paid yet)
+-- ============================================================
+-- Subscriptions paid through a one-time Checkout Pro preference start as
+-- pending_payment and become active when the payment webhook reports it
+-- approved. Checkouts abandoned for MP_CHECKOUT_TTL are cancelled. ADD VALUE
+-- cannot be used in the same transaction, so nothing else in this migration
+-- refers to 'pending_payment'.
+ALTER TYPE subscription_status ADD VALUE IF NOT EXISTS 'pending_payment' BEFORE 'active';
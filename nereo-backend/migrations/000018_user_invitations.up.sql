@@ -0,0 +1,26 @@
+-- ============================================================
+-- STAFF INVITATIONS
+-- ============================================================
+-- An owner invites managers and employees by email. The invitee gets a
+-- one-time token and sets their name and password when accepting it; only the
+-- SHA-256 of the token is stored. Inviting the same email again replaces the
+-- pending invitation.
+CREATE TABLE user_invitations (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id   UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    email       VARCHAR(255) NOT NULL,
+    role        user_role NOT NULL,
+    token_hash  VARCHAR(64) NOT NULL UNIQUE,
+    invited_by  UUID NOT NULL REFERENCES users(id),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    user_id     UUID REFERENCES users(id), -- set when accepted
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_user_invitations_pending ON user_invitations(tenant_id, LOWER(email))
+    WHERE accepted_at IS NULL;
+
+ALTER TABLE user_invitations ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_invitations
+    USING (tenant_id = current_setting('app.current_tenant')::UUID);
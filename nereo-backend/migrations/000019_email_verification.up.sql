@@ -0,0 +1,10 @@
+-- ============================================================
+-- EMAIL VERIFICATION
+-- ============================================================
+-- Set when the user follows the verification link sent at sign-up, resets
+-- their password or accepts an invitation (both prove they read the inbox).
+-- Sensitive owner actions require it. Users created before verification
+-- existed are considered verified.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+UPDATE users SET email_verified_at = created_at;
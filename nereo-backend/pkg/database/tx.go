@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DBTX is the subset of pgx shared by *pgxpool.Pool and pgx.Tx that
+// repositories use to run queries.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type (
+	txKey    struct{}
+	hooksKey struct{}
+)
+
+// WithTx returns a copy of ctx that carries tx. Repositories pick it up
+// through Conn so every query of a request runs on the same connection.
+func WithTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction stored in ctx, if any.
+func TxFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// Conn returns the request-scoped transaction when there is one and falls
+// back to the given pool otherwise (background jobs, public endpoints).
+func Conn(ctx context.Context, fallback DBTX) DBTX {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	return fallback
+}
+
+// RollbackHooks collects the actions that undo side effects outside the
+// database (e.g. Redis keys) when a transaction rolls back.
+type RollbackHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// WithRollbackHooks returns a copy of ctx that collects OnRollback actions.
+// Whoever settles the transaction calls Run if it does not commit.
+func WithRollbackHooks(ctx context.Context) (context.Context, *RollbackHooks) {
+	hooks := &RollbackHooks{}
+	return context.WithValue(ctx, hooksKey{}, hooks), hooks
+}
+
+// OnRollback registers fn to run if the transaction of ctx rolls back. It
+// does nothing when ctx has no hooks, i.e. when nothing will roll back.
+func OnRollback(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(hooksKey{}).(*RollbackHooks); ok {
+		hooks.mu.Lock()
+		hooks.fns = append(hooks.fns, fn)
+		hooks.mu.Unlock()
+	}
+}
+
+// Run calls the registered actions, most recent first, once.
+func (h *RollbackHooks) Run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+// SetTenant scopes the RLS policies of tx to tenantID. set_config with
+// is_local = true is the parameterized equivalent of SET LOCAL, so the
+// setting lives exactly as long as the transaction.
+func SetTenant(ctx context.Context, tx pgx.Tx, tenantID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, "SELECT set_config('app.current_tenant', $1, true)", tenantID.String()); err != nil {
+		return fmt.Errorf("set tenant context: %w", err)
+	}
+	return nil
+}
+
+// RunInTx runs fn inside a transaction. If ctx already carries one, fn
+// runs in a savepoint of it; otherwise a new transaction is started on pool.
+// The context passed to fn carries the transaction.
+func RunInTx(ctx context.Context, pool *pgxpool.Pool, fn func(ctx context.Context) error) (err error) {
+	var tx pgx.Tx
+	parent, nested := TxFromContext(ctx)
+	if nested {
+		tx, err = parent.Begin(ctx)
+	} else {
+		tx, err = pool.Begin(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// A savepoint shares the hooks of its transaction.
+	if !nested {
+		var hooks *RollbackHooks
+		ctx, hooks = WithRollbackHooks(ctx)
+		defer func() {
+			if err != nil {
+				hooks.Run()
+			}
+		}()
+	}
+
+	if err := fn(WithTx(ctx, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// RunInTenantTx is RunInTx for code running outside an HTTP request
+// (webhooks, crons) that must act on behalf of a single tenant.
+func RunInTenantTx(ctx context.Context, pool *pgxpool.Pool, tenantID uuid.UUID, fn func(ctx context.Context) error) error {
+	return RunInTx(ctx, pool, func(ctx context.Context) error {
+		tx, _ := TxFromContext(ctx)
+		if err := SetTenant(ctx, tx, tenantID); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+}
+
+// WithoutTx returns a copy of ctx that no longer carries a transaction, for
+// writes that must commit even if the request transaction rolls back.
+func WithoutTx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, txKey{}, nil)
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRollbackHooks(t *testing.T) {
+	ctx, hooks := WithRollbackHooks(context.Background())
+
+	var calls []string
+	OnRollback(ctx, func() { calls = append(calls, "first") })
+	OnRollback(ctx, func() { calls = append(calls, "second") })
+
+	hooks.Run()
+	if want := []string{"second", "first"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	// They run once.
+	hooks.Run()
+	if len(calls) != 2 {
+		t.Errorf("%d calls after running twice, want 2", len(calls))
+	}
+}
+
+func TestOnRollbackWithoutHooks(t *testing.T) {
+	// Nothing rolls back: the action is dropped.
+	OnRollback(context.Background(), func() { t.Error("action ran without a transaction") })
+}
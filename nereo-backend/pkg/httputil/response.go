@@ -2,10 +2,16 @@ package httputil
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
 type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
@@ -38,6 +44,37 @@ func Created(c *gin.Context, data interface{}) {
 	c.JSON(http.StatusCreated, Response{Success: true, Data: data})
 }
 
+func Paginated(c *gin.Context, data interface{}, page, perPage int, total int64) {
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Success: true,
+		Data:    data,
+		Meta: PaginationMeta{
+			Page:       page,
+			PerPage:    perPage,
+			Total:      total,
+			TotalPages: totalPages,
+		},
+	})
+}
+
+// ParsePagination reads ?page= and ?per_page= from the query string,
+// falling back to sane defaults for missing or out-of-range values.
+func ParsePagination(c *gin.Context) (page, perPage int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err = strconv.Atoi(c.Query("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = DefaultPerPage
+	}
+	if perPage > MaxPerPage {
+		perPage = MaxPerPage
+	}
+	return page, perPage
+}
+
 func NoContent(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
@@ -77,6 +114,14 @@ func Conflict(c *gin.Context, code, message string) {
 	})
 }
 
+// BadGateway reports that an upstream provider (e.g. Mercado Pago) failed.
+func BadGateway(c *gin.Context, code, message string) {
+	c.JSON(http.StatusBadGateway, Response{
+		Success: false,
+		Error:   &ErrorBody{Code: code, Message: message},
+	})
+}
+
 func InternalError(c *gin.Context) {
 	c.JSON(http.StatusInternalServerError, Response{
 		Success: false,
@@ -0,0 +1,82 @@
+// Package mail sends transactional emails (password resets, email
+// verification, staff invitations) through a pluggable Sender: SMTP in
+// production, files or the log in development and tests.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// ============================================================
+// File / log sender
+// ============================================================
+
+// FileSender writes every message to dir as an .eml file, or only logs it
+// when dir is empty. Nothing leaves the machine.
+type FileSender struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+func NewFileSender(dir, from string) *FileSender {
+	return &FileSender{dir: dir, from: from}
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	if s.dir == "" {
+		slog.Info("mail not sent (no SMTP configured)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+
+	// <time>-<seq>-<recipient>.eml sorts in sending order.
+	name := fmt.Sprintf("%d-%04d-%s.eml", time.Now().UnixNano(), s.seq.Add(1), sanitizeFileName(msg.To))
+	if err := os.WriteFile(filepath.Join(s.dir, name), format(s.from, msg), 0o600); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	return nil
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, s)
+}
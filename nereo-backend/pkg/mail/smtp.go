@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPSender delivers through an SMTP relay. smtp.SendMail upgrades the
+// connection with STARTTLS when the server offers it (port 587); servers
+// that only accept implicit TLS (port 465) are not supported.
+type SMTPSender struct {
+	addr     string
+	auth     smtp.Auth
+	from     string // From header, may carry a display name
+	envelope string // bare address of from, for MAIL FROM
+}
+
+// NewSMTPSender builds a sender for host:port. Without a username the relay
+// is used unauthenticated. from is an address, optionally with a display
+// name: "Nereo <no-reply@nereo.ar>".
+func NewSMTPSender(host string, port int, username, password, from string) (*SMTPSender, error) {
+	addr, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("parse mail from address: %w", err)
+	}
+
+	s := &SMTPSender{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		from:     from,
+		envelope: addr.Address,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(s.addr, s.auth, s.envelope, []string{msg.To}, format(s.from, msg)); err != nil {
+		return fmt.Errorf("send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Package money keeps amounts as integer cents tagged with their currency.
+//
+// Mercado Pago takes and returns amounts as decimal numbers of the currency
+// unit (199.99). Decimal encodes and decodes them exactly, without a float64
+// in between, so an amount never loses a cent on the way: int(199.99 * 100)
+// is 19998.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrInvalidCurrency  = errors.New("unsupported currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// Currency is an ISO 4217 code of a currency Mercado Pago operates in.
+type Currency string
+
+const (
+	ARS Currency = "ARS"
+	BRL Currency = "BRL"
+	CLP Currency = "CLP"
+	COP Currency = "COP"
+	MXN Currency = "MXN"
+	PEN Currency = "PEN"
+	USD Currency = "USD"
+	UYU Currency = "UYU"
+)
+
+// DefaultCurrency is the currency of plans created without one.
+const DefaultCurrency = ARS
+
+var currencies = map[Currency]bool{ARS: true, BRL: true, CLP: true, COP: true, MXN: true, PEN: true, USD: true, UYU: true}
+
+// ParseCurrency validates an ISO 4217 code, in any case.
+func ParseCurrency(code string) (Currency, error) {
+	c := Currency(strings.ToUpper(code))
+	if !currencies[c] {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+	return c, nil
+}
+
+// ============================================================
+// Amount
+// ============================================================
+
+// Amount is a number of cents of a currency. Cents are hundredths of the
+// currency unit for every currency, including those MP charges in whole
+// units (CLP): their amounts are multiples of 100.
+type Amount struct {
+	Cents    int      `json:"amount_cents"`
+	Currency Currency `json:"currency"`
+}
+
+func New(cents int, currency Currency) Amount {
+	return Amount{Cents: cents, Currency: currency}
+}
+
+// Decimal is the amount as MP writes it.
+func (a Amount) Decimal() Decimal {
+	return Decimal(a.Cents)
+}
+
+// Add sums two amounts of the same currency.
+func (a Amount) Add(b Amount) (Amount, error) {
+	if err := a.Check(b.Currency); err != nil {
+		return Amount{}, err
+	}
+	return New(a.Cents+b.Cents, a.Currency), nil
+}
+
+// Sub subtracts an amount of the same currency.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if err := a.Check(b.Currency); err != nil {
+		return Amount{}, err
+	}
+	return New(a.Cents-b.Cents, a.Currency), nil
+}
+
+// Percent returns p percent of the amount, rounded to the nearest cent
+// (half away from zero).
+func (a Amount) Percent(p float64) Amount {
+	return New(int(math.Round(float64(a.Cents)*p/100)), a.Currency)
+}
+
+// Check returns ErrCurrencyMismatch unless currency, as an ISO code in any
+// case, is the amount's currency.
+func (a Amount) Check(currency Currency) error {
+	if !strings.EqualFold(string(currency), string(a.Currency)) {
+		return fmt.Errorf("%w: %s, expected %s", ErrCurrencyMismatch, currency, a.Currency)
+	}
+	return nil
+}
+
+func (a Amount) String() string {
+	return string(a.Currency) + " " + a.Decimal().String()
+}
+
+// ============================================================
+// Decimal
+// ============================================================
+
+// Decimal is an amount in cents that is written in JSON as a decimal number
+// of currency units, the way Mercado Pago expects it: 19999 is 199.99.
+type Decimal int64
+
+// FromCents is the decimal form of an amount in cents.
+func FromCents(cents int) Decimal {
+	return Decimal(cents)
+}
+
+func (d Decimal) Cents() int {
+	return int(d)
+}
+
+// String writes the amount in units with no trailing zeros: 199.99, 199.9,
+// 1000, -0.5.
+func (d Decimal) String() string {
+	sign := ""
+	v := int64(d)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	units, cents := v/100, v%100
+	switch {
+	case cents == 0:
+		return sign + strconv.FormatInt(units, 10)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number (or a string holding one) exactly.
+// null leaves the amount unchanged.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// ParseDecimal reads a decimal number of units, e.g. "199.99" or "1.5e2",
+// rounding to the nearest cent (half away from zero). Fractions ("1/2") and
+// hexadecimal numbers, which big.Rat also reads, are rejected.
+func ParseDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsFunc(s, notDecimal) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r.Mul(r, big.NewRat(100, 1))
+
+	// Round |r| half up, then put the sign back.
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Lsh(m, 1).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("%w: %q out of range", ErrInvalidAmount, s)
+	}
+	return Decimal(q.Int64()), nil
+}
+
+func notDecimal(r rune) bool {
+	return !strings.ContainsRune("0123456789.eE+-", r)
+}
@@ -0,0 +1,145 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Decimal
+	}{
+		{"199.99", 19999},
+		{"199.9", 19990},
+		{"1000", 100000},
+		{"0", 0},
+		{"0.01", 1},
+		{"1.5e2", 15000},
+		{"1.9999E2", 19999},
+		{"15e-3", 2},
+
+		// Half away from zero
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"1.235", 124},
+		{"1.245", 125},
+		{"-0.005", -1},
+		{"-1.245", -125},
+		{"-0.0049", 0},
+
+		// Negative amounts
+		{"-199.99", -19999},
+		{"-0.5", -50},
+
+		// The largest amount that fits
+		{"92233720368547758.07", 9223372036854775807},
+		{"-92233720368547758.08", -9223372036854775808},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDecimal(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseDecimalRejects(t *testing.T) {
+	for _, in := range []string{"", "abc", "1/2", "-1/2", "1,5", "0x10", "92233720368547758.08", "1e30", "-1e30"} {
+		if got, err := ParseDecimal(in); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseDecimal(%q) = %d, %v; want ErrInvalidAmount", in, got, err)
+		}
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{19999, "199.99"},
+		{19990, "199.9"},
+		{100000, "1000"},
+		{5, "0.05"},
+		{50, "0.5"},
+		{0, "0"},
+		{-50, "-0.5"},
+		{-5, "-0.05"},
+		{-19999, "-199.99"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("Decimal(%d).String() = %q, want %q", int64(tt.d), got, tt.want)
+		}
+		back, err := ParseDecimal(tt.want)
+		if err != nil || back != tt.d {
+			t.Errorf("ParseDecimal(%q) = %d, %v; want %d back", tt.want, back, err, tt.d)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	raw, err := json.Marshal(struct {
+		Amount Decimal `json:"amount"`
+	}{FromCents(19999)})
+	if err != nil || string(raw) != `{"amount":199.99}` {
+		t.Errorf("marshal 19999 cents = %s, %v", raw, err)
+	}
+
+	tests := []struct {
+		in   string
+		want Decimal
+	}{
+		{`199.99`, 19999},
+		{`"199.99"`, 19999},
+		{`1.5e2`, 15000},
+		{`-0.5`, -50},
+		{`null`, 42}, // left unchanged
+	}
+	for _, tt := range tests {
+		d := Decimal(42)
+		if err := json.Unmarshal([]byte(tt.in), &d); err != nil || d != tt.want {
+			t.Errorf("unmarshal %s = %d, %v; want %d", tt.in, d, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{`"abc"`, `"1/2"`, `1e30`} {
+		var d Decimal
+		if err := json.Unmarshal([]byte(in), &d); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("unmarshal %s = %d, %v; want ErrInvalidAmount", in, d, err)
+		}
+	}
+}
+
+func TestAmountCheck(t *testing.T) {
+	a := New(19999, ARS)
+	for _, currency := range []Currency{"ARS", "ars", "Ars"} {
+		if err := a.Check(currency); err != nil {
+			t.Errorf("Check(%q) on an ARS amount: %v", currency, err)
+		}
+	}
+	for _, currency := range []Currency{"USD", "usd", ""} {
+		if err := a.Check(currency); !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("Check(%q) on an ARS amount = %v, want ErrCurrencyMismatch", currency, err)
+		}
+	}
+
+	if _, err := a.Add(New(1, USD)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("ARS + USD = %v, want ErrCurrencyMismatch", err)
+	}
+	if sum, err := a.Add(New(1, ARS)); err != nil || sum != New(20000, ARS) {
+		t.Errorf("ARS 199.99 + 0.01 = %v, %v", sum, err)
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	if c, err := ParseCurrency("usd"); err != nil || c != USD {
+		t.Errorf("ParseCurrency(usd) = %q, %v", c, err)
+	}
+	if _, err := ParseCurrency("XYZ"); !errors.Is(err, ErrInvalidCurrency) {
+		t.Errorf("ParseCurrency(XYZ) = %v, want ErrInvalidCurrency", err)
+	}
+}
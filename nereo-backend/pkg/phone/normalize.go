@@ -0,0 +1,164 @@
+// Package phone normalizes Argentine phone numbers to E.164.
+//
+// Every phone stored in customers.phone, users.phone or received from an
+// inbound channel (WhatsApp, forms) must go through NormalizePhoneAR so that
+// lookups by phone are exact matches.
+package phone
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+const (
+	countryCodeAR = "54"
+	mobilePrefix  = "9"
+	// nationalLength is the length of an Argentine national number
+	// (area code + subscriber number) without trunk or mobile prefixes.
+	nationalLength = 10
+)
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9]\d{10,14}$`)
+
+// areaCodes lists the 2- and 3-digit Argentine area codes. 11 is the only
+// one starting with 1; any other area code starts with 2 or 3 and is 4
+// digits long (e.g. 2901 Ushuaia, 3496 Esperanza).
+var areaCodes = map[string]struct{}{
+	"11":  {},
+	"220": {}, "221": {}, "223": {}, "230": {}, "236": {}, "237": {}, "249": {},
+	"260": {}, "261": {}, "263": {}, "264": {}, "266": {},
+	"280": {}, "291": {}, "294": {}, "297": {}, "298": {}, "299": {},
+	"336": {}, "341": {}, "342": {}, "343": {}, "345": {}, "348": {},
+	"351": {}, "353": {}, "358": {},
+	"362": {}, "364": {}, "370": {}, "376": {}, "379": {},
+	"380": {}, "381": {}, "383": {}, "385": {}, "387": {}, "388": {},
+}
+
+// NormalizePhoneAR converts a raw Argentine phone into E.164: +54 + area
+// code + number for landlines, with the mobile "9" after +54 for mobiles.
+// A number is a mobile when it is written with the "9" after the country
+// code or the "15" after the area code; nothing else tells them apart.
+//
+//	"1155667788"      → "+541155667788"
+//	"01155667788"     → "+541155667788"
+//	"011 15 5566-7788"→ "+5491155667788"
+//	"+5491155667788"  → "+5491155667788"
+//	"261 555 1234"    → "+542615551234"
+//
+// Numbers with a country code other than +54 are accepted as-is if they are
+// valid E.164.
+func NormalizePhoneAR(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrInvalidPhone
+	}
+
+	international := strings.HasPrefix(raw, "+")
+	digits := onlyDigits(raw)
+	if !international && strings.HasPrefix(digits, "00") {
+		international = true
+		digits = digits[2:]
+	}
+
+	// No area code starts with 9, so a 9 right after +54 is the mobile prefix.
+	var mobile bool
+	if international {
+		if !strings.HasPrefix(digits, countryCodeAR) {
+			candidate := "+" + digits
+			if !e164Pattern.MatchString(candidate) {
+				return "", ErrInvalidPhone
+			}
+			return candidate, nil
+		}
+		digits = strings.TrimPrefix(digits, countryCodeAR)
+		digits, mobile = strings.CutPrefix(digits, mobilePrefix)
+	} else if len(digits) > nationalLength+1 && strings.HasPrefix(digits, countryCodeAR) && !strings.HasPrefix(digits, "0") {
+		// "5491155667788" typed without the plus sign
+		digits = strings.TrimPrefix(digits, countryCodeAR)
+		digits, mobile = strings.CutPrefix(digits, mobilePrefix)
+	}
+
+	digits = strings.TrimPrefix(digits, "0")
+	digits, with15 := stripMobile15(digits)
+	mobile = mobile || with15
+
+	if len(digits) != nationalLength {
+		return "", ErrInvalidPhone
+	}
+	// A mobile written without its area code ("15 5566 7788") has the
+	// right length but no area code; local numbers never start with 0 or 1.
+	n := areaCodeLength(digits)
+	if n == 0 || digits[n] == '0' || digits[n] == '1' {
+		return "", ErrInvalidPhone
+	}
+
+	normalized := "+" + countryCodeAR + digits
+	if mobile {
+		normalized = "+" + countryCodeAR + mobilePrefix + digits
+	}
+	if !e164Pattern.MatchString(normalized) {
+		return "", ErrInvalidPhone
+	}
+
+	return normalized, nil
+}
+
+// SplitAR splits a normalized Argentine number into area code and local
+// number (without the mobile "9"), as expected by Mercado Pago payer phones.
+// Non-Argentine numbers return an empty area code and the digits unchanged.
+func SplitAR(e164 string) (areaCode, number string) {
+	digits := onlyDigits(e164)
+	if !strings.HasPrefix(digits, countryCodeAR) {
+		return "", digits
+	}
+	national := strings.TrimPrefix(strings.TrimPrefix(digits, countryCodeAR), mobilePrefix)
+	if len(national) != nationalLength {
+		return "", national
+	}
+	n := areaCodeLength(national)
+	if n == 0 {
+		return "", national
+	}
+	return national[:n], national[n:]
+}
+
+// stripMobile15 removes the local mobile prefix "15" that people write
+// between the area code and the number ("11 15 5566-7788"), and reports
+// whether it was there.
+func stripMobile15(national string) (string, bool) {
+	if len(national) != nationalLength+2 {
+		return national, false
+	}
+	n := areaCodeLength(national)
+	if n == 0 || national[n:n+2] != "15" {
+		return national, false
+	}
+	return national[:n] + national[n+2:], true
+}
+
+// areaCodeLength is the length of the area code national starts with, or 0
+// when it cannot start with an Argentine area code.
+func areaCodeLength(national string) int {
+	for _, n := range []int{2, 3} {
+		if _, ok := areaCodes[national[:n]]; ok {
+			return n
+		}
+	}
+	if national[0] != '2' && national[0] != '3' {
+		return 0
+	}
+	return 4
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
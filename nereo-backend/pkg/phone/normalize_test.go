@@ -0,0 +1,111 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizePhoneAR(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // "" for ErrInvalidPhone
+	}{
+		// Documented formats
+		{"1155667788", "+541155667788"},
+		{"01155667788", "+541155667788"},
+		{"011 15 5566-7788", "+5491155667788"},
+		{"+5491155667788", "+5491155667788"},
+		{"261 555 1234", "+542615551234"},
+		{"(011) 5566-7788", "+541155667788"},
+		{"  11 5566-7788  ", "+541155667788"},
+
+		// Country and trunk prefixes
+		{"+541155667788", "+541155667788"},
+		{"+54 9 11 5566-7788", "+5491155667788"},
+		{"5491155667788", "+5491155667788"},
+		{"541155667788", "+541155667788"},
+		{"005491155667788", "+5491155667788"},
+		{"00541155667788", "+541155667788"},
+		{"0261 555-1234", "+542615551234"},
+
+		// Mobile prefix 15 after the area code
+		{"11 15 5566-7788", "+5491155667788"},
+		{"+54 9 11 15 5566 7788", "+5491155667788"},
+		{"+54 11 15 5566 7788", "+5491155667788"},
+		{"0261 15 555 1234", "+5492615551234"},
+		{"0351 15 423 4567", "+5493514234567"},
+		{"2966 15 42 3456", "+5492966423456"},
+
+		// 4-digit area codes
+		{"3496 42-1234", "+543496421234"},
+		{"02901 42 1234", "+542901421234"},
+		{"+54 9 2901 42 1234", "+5492901421234"},
+
+		// Other countries are kept as E.164
+		{"+1 415 555 2671", "+14155552671"},
+		{"0034 612 345 678", "+34612345678"},
+
+		// Invalid
+		{"", ""},
+		{"   ", ""},
+		{"abc", ""},
+		{"15 5566 7788", ""},          // mobile without its area code
+		{"+54 9 15 5566 7788", ""},    // same, with the country code
+		{"1255667788", ""},            // no area code starts with 12
+		{"4155667788", ""},            // nor with 4
+		{"0800 333 4444", ""},         // toll-free numbers have no area code
+		{"11 0566-7788", ""},          // local numbers do not start with 0
+		{"261 155 1234", ""},          // nor with 1
+		{"5566-7788", ""},             // too short
+		{"11 5566-77889", ""},         // too long
+		{"11 15 5566-778", ""},        // 15 does not make up for a missing digit
+		{"+1 23", ""},                 // foreign but not E.164
+		{"+54 11 5566 7788 9999", ""}, // too long after the country code
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := NormalizePhoneAR(tt.raw)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidPhone) {
+					t.Errorf("NormalizePhoneAR(%q) = %q, %v; want ErrInvalidPhone", tt.raw, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizePhoneAR(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneARIsIdempotent(t *testing.T) {
+	for _, raw := range []string{"+5491155667788", "+541155667788", "+5492615551234", "+542966423456", "+14155552671"} {
+		got, err := NormalizePhoneAR(raw)
+		if err != nil || got != raw {
+			t.Errorf("NormalizePhoneAR(%q) = %q, %v; want it unchanged", raw, got, err)
+		}
+	}
+}
+
+func TestSplitAR(t *testing.T) {
+	tests := []struct {
+		e164, areaCode, number string
+	}{
+		{"+5491155667788", "11", "55667788"},
+		{"+5492615551234", "261", "5551234"},
+		{"+5493514234567", "351", "4234567"},
+		{"+5492966423456", "2966", "423456"},
+		{"+541155667788", "11", "55667788"},
+		{"+14155552671", "", "14155552671"},
+		{"+54911", "", "11"},
+		{"+5491555667788", "", "1555667788"},
+	}
+
+	for _, tt := range tests {
+		areaCode, number := SplitAR(tt.e164)
+		if areaCode != tt.areaCode || number != tt.number {
+			t.Errorf("SplitAR(%q) = %q, %q; want %q, %q", tt.e164, areaCode, number, tt.areaCode, tt.number)
+		}
+	}
+}
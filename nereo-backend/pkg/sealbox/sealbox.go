@@ -0,0 +1,62 @@
+// Package sealbox encrypts small secrets (e.g. third-party OAuth tokens)
+// before they are stored, with AES-256-GCM.
+//
+// A sealed value is the random nonce followed by the ciphertext and its
+// authentication tag, so Open detects any tampering.
+package sealbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCiphertext = errors.New("invalid sealed value")
+
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New builds a box from secret key material of any length; the AES key is
+// its SHA-256.
+func New(secret string) (*Box, error) {
+	if secret == "" {
+		return nil, errors.New("sealbox: empty secret")
+	}
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("sealbox: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("sealbox: %w", err)
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext with a fresh random nonce.
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("sealbox: nonce: %w", err)
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value produced by Seal with the same secret.
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n+b.aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,90 @@
+package sealbox
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newBox(t *testing.T, secret string) *Box {
+	t.Helper()
+	b, err := New(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSealOpen(t *testing.T) {
+	b := newBox(t, "test-secret")
+
+	for _, plaintext := range []string{"APP_USR-1234567890-access-token", ""} {
+		sealed, err := b.Seal([]byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "" && bytes.Contains(sealed, []byte(plaintext)) {
+			t.Errorf("sealed value contains the plaintext")
+		}
+		opened, err := b.Open(sealed)
+		if err != nil || string(opened) != plaintext {
+			t.Errorf("Open(Seal(%q)) = %q, %v", plaintext, opened, err)
+		}
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	b := newBox(t, "test-secret")
+	plaintext := []byte("refresh-token")
+
+	first, err := b.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("two seals of the same plaintext are equal")
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	b := newBox(t, "test-secret")
+	sealed, err := b.Seal([]byte("access-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every byte is covered: the nonce, the ciphertext and the tag.
+	for i := range sealed {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 0x01
+		if _, err := b.Open(tampered); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("byte %d changed: Open = %v, want ErrInvalidCiphertext", i, err)
+		}
+	}
+
+	for _, short := range [][]byte{nil, {}, sealed[:12], sealed[:len(sealed)-len("access-token")-1]} {
+		if _, err := b.Open(short); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Open of %d bytes = %v, want ErrInvalidCiphertext", len(short), err)
+		}
+	}
+}
+
+func TestOpenWithAnotherSecret(t *testing.T) {
+	sealed, err := newBox(t, "test-secret").Seal([]byte("access-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newBox(t, "other-secret").Open(sealed); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Open with another secret = %v, want ErrInvalidCiphertext", err)
+	}
+}
+
+func TestNewRejectsEmptySecret(t *testing.T) {
+	if _, err := New(""); err == nil {
+		t.Error("New accepted an empty secret")
+	}
+}
@@ -0,0 +1,16 @@
+-- Runs once on a fresh Postgres volume (docker-entrypoint-initdb.d).
+-- Creates the login role the API uses at runtime (APP_DATABASE_URL).
+-- Grants are applied by migration 000003_app_role.
+--
+-- On an existing volume run it by hand:
+--   docker compose exec postgres psql -U nereo -d nereo -f /docker-entrypoint-initdb.d/init-app-role.sql
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'nereo_app') THEN
+        CREATE ROLE nereo_app NOLOGIN;
+    END IF;
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'nereo_api') THEN
+        CREATE ROLE nereo_api LOGIN PASSWORD 'nereo_api_secret' IN ROLE nereo_app;
+    END IF;
+END
+$$;